@@ -0,0 +1,214 @@
+// Package alarm watches the rate of received emails and posts to a webhook
+// when it stops or surges, so the sink can act as a canary for broken email
+// pipelines in staging.
+package alarm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+	"github.com/nathabonfim59/gargantua-sink/internal/notify"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+)
+
+// CheckInterval is how often the alarm conditions are evaluated.
+const CheckInterval = 10 * time.Second
+
+// Event is the JSON payload posted when an alarm fires or resolves.
+type Event struct {
+	Event  string    `json:"event"` // Always "alarm"
+	Alarm  string    `json:"alarm"`
+	State  string    `json:"state"` // firing or resolved
+	Reason string    `json:"reason"`
+	At     time.Time `json:"at"`
+}
+
+// bucket counts the emails received in one second.
+type bucket struct {
+	second int64
+	count  int
+}
+
+// alarm tracks the emails matching one alarm and whether it fires.
+type alarm struct {
+	name         string
+	rules        []rules.Match
+	silence      time.Duration
+	maxPerMinute int
+	webhook      *notify.Webhook
+
+	lastSeen time.Time
+	buckets  [60]bucket // Per-second counts of the last minute, indexed by second modulo 60
+	firing   bool
+}
+
+// observe counts an email received at now.
+func (alarm *alarm) observe(now time.Time) {
+	alarm.lastSeen = now
+
+	second := now.Unix()
+	slot := &alarm.buckets[second%int64(len(alarm.buckets))]
+	if slot.second != second {
+		*slot = bucket{second: second}
+	}
+	slot.count++
+}
+
+// perMinute returns the number of emails received within the minute before now.
+func (alarm *alarm) perMinute(now time.Time) int {
+	count := 0
+	second := now.Unix()
+	for _, slot := range alarm.buckets {
+		if second-slot.second < int64(len(alarm.buckets)) {
+			count += slot.count
+		}
+	}
+	return count
+}
+
+// evaluate reports whether the alarm condition holds at now, and why.
+func (alarm *alarm) evaluate(now time.Time) (bool, string) {
+	if alarm.silence > 0 {
+		idle := now.Sub(alarm.lastSeen)
+		if idle >= alarm.silence {
+			return true, fmt.Sprintf("no email received for %s", idle.Round(time.Second))
+		}
+		return false, fmt.Sprintf("email received %s ago", idle.Round(time.Second))
+	}
+
+	count := alarm.perMinute(now)
+	if count > alarm.maxPerMinute {
+		return true, fmt.Sprintf("%d emails in the last minute, above %d", count, alarm.maxPerMinute)
+	}
+	return false, fmt.Sprintf("%d emails in the last minute", count)
+}
+
+// Monitor evaluates every configured alarm. It is safe for concurrent use.
+type Monitor struct {
+	mu     sync.Mutex
+	alarms []*alarm
+	now    func() time.Time
+}
+
+// NewMonitor creates a monitor for the alarm configurations. Silence is
+// measured from the creation of the monitor.
+func NewMonitor(cfgs []config.AlarmConfig) *Monitor {
+	monitor := &Monitor{now: time.Now}
+	start := monitor.now()
+	for _, cfg := range cfgs {
+		monitor.alarms = append(monitor.alarms, &alarm{
+			name:         cfg.Name,
+			rules:        cfg.Rules,
+			silence:      cfg.Silence,
+			maxPerMinute: cfg.MaxPerMinute,
+			webhook:      notify.NewWebhook(string(cfg.URL), cfg.Format),
+			lastSeen:     start,
+		})
+	}
+	return monitor
+}
+
+// Middleware returns an ingest middleware counting stored emails.
+// It must be registered at the notify stage.
+func (monitor *Monitor) Middleware() pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			if err := next(ctx, delivery); err != nil {
+				return err
+			}
+			monitor.Observe(delivery.Message)
+			return nil
+		}
+	}
+}
+
+// Observe counts an email in every alarm whose rules it matches.
+func (monitor *Monitor) Observe(msg *message.Message) {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+
+	now := monitor.now()
+	for _, alarm := range monitor.alarms {
+		if rules.Any(alarm.rules, msg) {
+			alarm.observe(now)
+		}
+	}
+}
+
+// Run checks the alarms every CheckInterval until ctx is canceled.
+func (monitor *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			monitor.Check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Check evaluates every alarm and posts an event for each one that started
+// or stopped firing, returning those events.
+func (monitor *Monitor) Check() []Event {
+	monitor.mu.Lock()
+	now := monitor.now()
+	var events []Event
+	var webhooks []*notify.Webhook
+	for _, alarm := range monitor.alarms {
+		firing, reason := alarm.evaluate(now)
+		if firing == alarm.firing {
+			continue
+		}
+		alarm.firing = firing
+
+		state := "resolved"
+		if firing {
+			state = "firing"
+		}
+		events = append(events, Event{Event: "alarm", Alarm: alarm.name, State: state, Reason: reason, At: now})
+		webhooks = append(webhooks, alarm.webhook)
+	}
+	monitor.mu.Unlock()
+
+	for i, event := range events {
+		if event.State == "firing" {
+			slog.Warn("Alarm firing", "alarm", event.Alarm, "reason", event.Reason)
+		} else {
+			slog.Info("Alarm resolved", "alarm", event.Alarm, "reason", event.Reason)
+		}
+		text := fmt.Sprintf("Alarm %s %s: %s", event.Alarm, event.State, event.Reason)
+		if err := webhooks[i].Send(event, text); err != nil {
+			slog.Warn("Alarm notification failed", "alarm", event.Alarm, "error", err)
+		}
+	}
+	return events
+}
+
+// Collect reports whether each alarm is firing.
+func (monitor *Monitor) Collect() []metrics.Family {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+
+	firing := metrics.Family{
+		Name: "gargantua_alarm_firing",
+		Help: "Whether a received-rate alarm is firing (1) or not (0).",
+		Type: metrics.Gauge,
+	}
+	for _, alarm := range monitor.alarms {
+		firing.Samples = append(firing.Samples, metrics.Sample{
+			Labels: map[string]string{"alarm": alarm.name},
+			Value:  metrics.Bool(alarm.firing),
+		})
+	}
+	return []metrics.Family{firing}
+}
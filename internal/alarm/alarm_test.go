@@ -0,0 +1,79 @@
+package alarm
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/notify/notifytest"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+)
+
+// clock is a settable time source.
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func TestSilenceAlarm(t *testing.T) {
+	url, received := notifytest.StartWebhook(t)
+	monitor := NewMonitor([]config.AlarmConfig{{
+		Name:    "billing",
+		URL:     config.Secret(url),
+		Rules:   []rules.Match{{To: "*@billing.example.com"}},
+		Silence: 15 * time.Minute,
+	}})
+	c := &clock{now: time.Now()}
+	monitor.now = c.Now
+
+	c.now = c.now.Add(10 * time.Minute)
+	if events := monitor.Check(); len(events) != 0 {
+		t.Fatalf("Check() after 10m = %+v, want no event", events)
+	}
+
+	// Emails for other recipients do not count
+	monitor.Observe(&message.Message{Envelope: message.Envelope{To: []string{"a@other.test"}}})
+	c.now = c.now.Add(5 * time.Minute)
+	if events := monitor.Check(); len(events) != 1 || events[0].State != "firing" {
+		t.Fatalf("Check() after 15m = %+v, want firing", events)
+	}
+	var event Event
+	if err := json.Unmarshal(<-received, &event); err != nil {
+		t.Fatalf("decoding event failed: %v", err)
+	}
+	if event.Alarm != "billing" || event.State != "firing" {
+		t.Errorf("posted event %+v, want billing firing", event)
+	}
+
+	// Firing is only posted once
+	c.now = c.now.Add(time.Minute)
+	if events := monitor.Check(); len(events) != 0 {
+		t.Fatalf("second Check() = %+v, want no event", events)
+	}
+
+	monitor.Observe(&message.Message{Envelope: message.Envelope{To: []string{"invoices@billing.example.com"}}})
+	if events := monitor.Check(); len(events) != 1 || events[0].State != "resolved" {
+		t.Fatalf("Check() after an email = %+v, want resolved", events)
+	}
+}
+
+func TestRateAlarm(t *testing.T) {
+	url, _ := notifytest.StartWebhook(t)
+	monitor := NewMonitor([]config.AlarmConfig{{Name: "flood", URL: config.Secret(url), MaxPerMinute: 3}})
+	c := &clock{now: time.Now()}
+	monitor.now = c.Now
+
+	for i := 0; i < 4; i++ {
+		monitor.Observe(&message.Message{})
+		c.now = c.now.Add(10 * time.Second)
+	}
+	if events := monitor.Check(); len(events) != 1 || events[0].State != "firing" {
+		t.Fatalf("Check() after 4 emails in 40s = %+v, want firing", events)
+	}
+
+	c.now = c.now.Add(time.Minute)
+	if events := monitor.Check(); len(events) != 1 || events[0].State != "resolved" {
+		t.Fatalf("Check() a minute later = %+v, want resolved", events)
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// approvalList is the response of the approval listing endpoint.
+type approvalList struct {
+	Messages []storage.StoredEmail `json:"messages"`
+}
+
+// handleListApprovals lists the emails of the approval queue in the state
+// given by the state query parameter, pending by default, oldest first.
+func (server *Server) handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "":
+		state = storage.ApprovalPending
+	case storage.ApprovalPending, storage.ApprovalApproved, storage.ApprovalRejected:
+	default:
+		writeError(w, http.StatusBadRequest, "invalid state (want pending, approved or rejected)")
+		return
+	}
+
+	response := approvalList{Messages: []storage.StoredEmail{}}
+	for _, emailStorage := range server.storages() {
+		emails, err := emailStorage.List(storage.ListFilter{})
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		for _, email := range emails {
+			if approval := email.Metadata.Approval; approval != nil && approval.State == state {
+				response.Messages = append(response.Messages, email)
+			}
+		}
+	}
+	sort.Slice(response.Messages, func(i, j int) bool {
+		return response.Messages[i].Metadata.Approval.RequestedAt.Before(response.Messages[j].Metadata.Approval.RequestedAt)
+	})
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleApprove forwards a pending email to its original recipients. When
+// forwarding fails the email stays pending with the error recorded, so it
+// can be approved again.
+func (server *Server) handleApprove(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHoldRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	// Serialized so that concurrent approvals cannot send an email twice
+	server.approvalMu.Lock()
+	defer server.approvalMu.Unlock()
+
+	id := r.PathValue("id")
+	email, emailStorage, err := server.findMessage(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	approval := email.Metadata.Approval
+	if approval == nil || approval.State != storage.ApprovalPending {
+		writeError(w, http.StatusConflict, "email is not pending approval")
+		return
+	}
+
+	content, err := emailStorage.ReadContent(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	relayErr := server.relay.Relay(approval.From, approval.To, content)
+
+	now := time.Now()
+	metadata, err := emailStorage.UpdateMetadata(id, func(metadata *storage.Metadata) {
+		if relayErr != nil {
+			metadata.Approval.Error = relayErr.Error()
+			return
+		}
+		metadata.Approval.State = storage.ApprovalApproved
+		metadata.Approval.DecidedAt = &now
+		metadata.Approval.DecidedBy = actor(r, req)
+		metadata.Approval.Error = ""
+		metadata.AddTags(releasedTag)
+		metadata.Mark(message.StageRelayed, strings.Join(approval.To, ", "), now)
+	})
+	if relayErr != nil {
+		log.Printf("Error forwarding approved email %s: %v", id, relayErr)
+		writeError(w, http.StatusBadGateway, "forwarding email: "+relayErr.Error())
+		return
+	}
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	log.Printf("Approved email %s and forwarded it to %v", id, approval.To)
+
+	if !server.audit(w, r, "approve", "message:"+id, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+// handleReject closes a pending approval without forwarding the email.
+func (server *Server) handleReject(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHoldRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	server.approvalMu.Lock()
+	defer server.approvalMu.Unlock()
+
+	id := r.PathValue("id")
+	email, emailStorage, err := server.findMessage(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if approval := email.Metadata.Approval; approval == nil || approval.State != storage.ApprovalPending {
+		writeError(w, http.StatusConflict, "email is not pending approval")
+		return
+	}
+
+	now := time.Now()
+	metadata, err := emailStorage.UpdateMetadata(id, func(metadata *storage.Metadata) {
+		metadata.Approval.State = storage.ApprovalRejected
+		metadata.Approval.DecidedAt = &now
+		metadata.Approval.DecidedBy = actor(r, req)
+		metadata.Approval.Reason = req.Reason
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	if !server.audit(w, r, "reject", "message:"+id, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// brokenRelay fails every relay.
+type brokenRelay struct{}
+
+func (brokenRelay) Relay(from string, to []string, content []byte) error {
+	return errors.New("connection refused")
+}
+
+// queueForApproval marks an email as pending approval.
+func queueForApproval(t *testing.T, emailStorage *storage.EmailStorage, id string) {
+	t.Helper()
+
+	_, err := emailStorage.UpdateMetadata(id, func(metadata *storage.Metadata) {
+		metadata.Approval = &storage.Approval{
+			State:       storage.ApprovalPending,
+			From:        "app@example.org",
+			To:          []string{"jane@customer.com"},
+			RequestedAt: time.Now(),
+		}
+	})
+	if err != nil {
+		t.Fatalf("queueing email failed: %v", err)
+	}
+}
+
+func TestApprove(t *testing.T) {
+	relay := &fakeRelay{}
+	server, emailStorage, id := newTestAPI(t, relay)
+
+	if rec := doRequest(server, http.MethodPost, "/api/v1/approvals/"+id+"/approve", ""); rec.Code != http.StatusConflict {
+		t.Errorf("approve of unqueued email status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	queueForApproval(t, emailStorage, id)
+
+	rec := doRequest(server, http.MethodGet, "/api/v1/approvals", "")
+	var list approvalList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Messages) != 1 || list.Messages[0].ID != id {
+		t.Fatalf("pending approvals = %+v (%v), want the queued email", list, err)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/approvals/"+id+"/approve", `{"by":"alice"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("approve status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if relay.from != "app@example.org" || len(relay.to) != 1 || relay.to[0] != "jane@customer.com" {
+		t.Errorf("relayed envelope = %s %v, want the queued envelope", relay.from, relay.to)
+	}
+
+	email, err := emailStorage.Get(id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	approval := email.Metadata.Approval
+	if approval.State != storage.ApprovalApproved || approval.DecidedBy != "alice" || approval.DecidedAt == nil {
+		t.Errorf("approval = %+v, want approved by alice", approval)
+	}
+	if !email.Metadata.HasTag(releasedTag) {
+		t.Errorf("approved email tags = %v, want %s", email.Metadata.Tags, releasedTag)
+	}
+
+	if rec = doRequest(server, http.MethodPost, "/api/v1/approvals/"+id+"/approve", ""); rec.Code != http.StatusConflict {
+		t.Errorf("second approve status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	rec = doRequest(server, http.MethodGet, "/api/v1/approvals?state=approved", "")
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list.Messages) != 1 {
+		t.Errorf("approved approvals = %+v (%v), want the approved email", list, err)
+	}
+}
+
+func TestApproveRelayFailure(t *testing.T) {
+	server, emailStorage, id := newTestAPI(t, brokenRelay{})
+	queueForApproval(t, emailStorage, id)
+
+	rec := doRequest(server, http.MethodPost, "/api/v1/approvals/"+id+"/approve", "")
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("approve status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+
+	email, err := emailStorage.Get(id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if approval := email.Metadata.Approval; approval.State != storage.ApprovalPending || approval.Error == "" {
+		t.Errorf("approval = %+v, want pending with the error", approval)
+	}
+}
+
+func TestReject(t *testing.T) {
+	relay := &fakeRelay{}
+	server, emailStorage, id := newTestAPI(t, relay)
+	queueForApproval(t, emailStorage, id)
+
+	rec := doRequest(server, http.MethodPost, "/api/v1/approvals/"+id+"/reject", `{"reason":"wrong customer"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reject status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if relay.from != "" {
+		t.Errorf("rejected email was relayed from %s", relay.from)
+	}
+
+	email, err := emailStorage.Get(id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if approval := email.Metadata.Approval; approval.State != storage.ApprovalRejected || approval.Reason != "wrong customer" {
+		t.Errorf("approval = %+v, want rejected with the reason", approval)
+	}
+
+	if rec = doRequest(server, http.MethodGet, "/api/v1/approvals?state=bogus", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid state status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
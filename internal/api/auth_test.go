@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/auth"
+)
+
+func TestRoleEnforcement(t *testing.T) {
+	open, _, id := newTestAPI(t, &fakeRelay{})
+	server := NewServer("", Options{
+		Storages: open.storages,
+		Relay:    open.relay,
+		Auth: auth.NewAuthenticator([]auth.Token{
+			{Name: "viewer", Secret: "reader-token", Role: auth.RoleReader},
+			{Name: "qa", Secret: "releaser-token", Role: auth.RoleReleaser},
+			{Name: "ops", Secret: "admin-token", Role: auth.RoleAdmin},
+		}, "", "", nil),
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		target string
+		body   string
+		want   int
+	}{
+		{"anonymous_list", "", http.MethodGet, "/api/v1/messages", "", http.StatusUnauthorized},
+		{"bad_token", "nope", http.MethodGet, "/api/v1/messages", "", http.StatusUnauthorized},
+		{"reader_list", "reader-token", http.MethodGet, "/api/v1/messages", "", http.StatusOK},
+		{"reader_release", "reader-token", http.MethodPost, "/api/v1/messages/batch/release", `{"ids":["` + id + `"]}`, http.StatusForbidden},
+		{"releaser_release", "releaser-token", http.MethodPost, "/api/v1/messages/batch/release", `{"ids":["` + id + `"]}`, http.StatusOK},
+		{"releaser_delete", "releaser-token", http.MethodDelete, "/api/v1/messages/" + id, "", http.StatusForbidden},
+		{"releaser_hold", "releaser-token", http.MethodPost, "/api/v1/messages/" + id + "/hold", `{"reason":"x"}`, http.StatusForbidden},
+		{"admin_delete", "admin-token", http.MethodDelete, "/api/v1/messages/" + id, "", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			rec := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/export"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/scrub"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// releasedTag marks emails relayed to the forwarding server.
+const releasedTag = "released"
+
+// Relayer sends captured emails to a real SMTP server.
+type Relayer interface {
+	Relay(from string, to []string, content []byte) error
+}
+
+// batchRequest selects the emails a batch action applies to.
+type batchRequest struct {
+	IDs []string `json:"ids"`
+
+	// Tag action
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+
+	// Release action: overrides the original recipients when set
+	To []string `json:"to,omitempty"`
+
+	// Export action: zip (default) or jsonl, optionally anonymized (jsonl only)
+	Format    string `json:"format,omitempty"`
+	Anonymize bool   `json:"anonymize,omitempty"`
+}
+
+// batchResult reports the outcome of a batch action for one email.
+type batchResult struct {
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// batchResponse lists the outcome of a batch action for every requested email.
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// decodeBatchRequest reads and validates a batch request body.
+func decodeBatchRequest(w http.ResponseWriter, r *http.Request) (batchRequest, bool) {
+	var req batchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return req, false
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, "ids must not be empty")
+		return req, false
+	}
+	return req, true
+}
+
+// runBatch applies action to every requested email and writes the results.
+func runBatch(w http.ResponseWriter, ids []string, action func(id string) error) {
+	response := batchResponse{Results: make([]batchResult, 0, len(ids))}
+	for _, id := range ids {
+		result := batchResult{ID: id, OK: true}
+		if err := action(id); err != nil {
+			result.OK = false
+			result.Error = err.Error()
+		}
+		response.Results = append(response.Results, result)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleBatchDelete removes several emails.
+func (server *Server) handleBatchDelete(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	runBatch(w, req.IDs, server.deleteMessage)
+}
+
+// handleBatchTag adds and removes tags on several emails.
+func (server *Server) handleBatchTag(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	if len(req.Add) == 0 && len(req.Remove) == 0 {
+		writeError(w, http.StatusBadRequest, "add or remove must not be empty")
+		return
+	}
+
+	runBatch(w, req.IDs, func(id string) error {
+		_, emailStorage, err := server.findMessage(id)
+		if err != nil {
+			return err
+		}
+		_, err = emailStorage.UpdateMetadata(id, func(metadata *storage.Metadata) {
+			metadata.AddTags(req.Add...)
+			metadata.RemoveTags(req.Remove...)
+		})
+		return err
+	})
+}
+
+// handleBatchRelease relays several emails through the forwarding server and
+// tags them as released.
+func (server *Server) handleBatchRelease(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatchRequest(w, r)
+	if !ok {
+		return
+	}
+
+	runBatch(w, req.IDs, func(id string) error {
+		return server.releaseMessage(id, req.To)
+	})
+}
+
+// releaseMessage relays one email to to, or to its original recipients.
+func (server *Server) releaseMessage(id string, to []string) error {
+	email, emailStorage, err := server.findMessage(id)
+	if err != nil {
+		return err
+	}
+
+	msg, err := emailStorage.ReadMessage(id)
+	if err != nil {
+		return err
+	}
+
+	from, recipients, err := releaseEnvelope(email, msg)
+	if err != nil {
+		return err
+	}
+	if len(to) > 0 {
+		recipients = to
+	}
+
+	content, err := msg.ReadRaw()
+	if err != nil {
+		return err
+	}
+	if err := server.relay.Relay(from, recipients, content); err != nil {
+		return err
+	}
+	log.Printf("Released email %s to %v", id, recipients)
+
+	relayedAt := time.Now()
+	_, err = emailStorage.UpdateMetadata(id, func(metadata *storage.Metadata) {
+		metadata.AddTags(releasedTag)
+		metadata.Mark(message.StageRelayed, strings.Join(recipients, ", "), relayedAt)
+	})
+	return err
+}
+
+// releaseEnvelope derives the envelope of a stored email: the sender from its
+// From header and the recipients from its mailbox (IN) or headers (OUT).
+func releaseEnvelope(email storage.StoredEmail, msg *message.Message) (string, []string, error) {
+	if msg.ParseError != "" {
+		return "", nil, fmt.Errorf("parsing email: %s", msg.ParseError)
+	}
+
+	from := ""
+	if addresses, err := msg.Header.AddressList("From"); err == nil && len(addresses) > 0 {
+		from = addresses[0].Address
+	}
+
+	if email.Direction == storage.Incoming {
+		return from, []string{email.User + "@" + email.Domain}, nil
+	}
+
+	var recipients []string
+	for _, header := range []string{"To", "Cc"} {
+		addresses, err := msg.Header.AddressList(header)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			recipients = append(recipients, address.Address)
+		}
+	}
+	if len(recipients) == 0 {
+		return "", nil, errors.New("no recipients found in email headers")
+	}
+	return from, recipients, nil
+}
+
+// exportItem is an email selected for export and the storage holding it.
+type exportItem struct {
+	email   storage.StoredEmail
+	storage *storage.EmailStorage
+}
+
+// handleBatchExport streams the selected emails as a zip archive laid out as
+// domain/user/IN|OUT/id-subject.eml, or as JSON Lines with format jsonl.
+func (server *Server) handleBatchExport(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeBatchRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.Format != "" && req.Format != "zip" && req.Format != "jsonl" {
+		writeError(w, http.StatusBadRequest, "format must be zip or jsonl")
+		return
+	}
+	if req.Anonymize && req.Format != "jsonl" {
+		writeError(w, http.StatusBadRequest, "anonymize requires format jsonl")
+		return
+	}
+
+	// Resolve every email first so a missing ID fails before streaming starts
+	emails := make([]exportItem, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		email, emailStorage, err := server.findMessage(id)
+		if err != nil {
+			writeStorageError(w, fmt.Errorf("%s: %w", id, err))
+			return
+		}
+		emails = append(emails, exportItem{email: email, storage: emailStorage})
+	}
+
+	if req.Format == "jsonl" {
+		var scrubber *scrub.Scrubber
+		if req.Anonymize {
+			var err error
+			if scrubber, err = scrub.NewRandom(); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		exportJSONL(w, emails, scrubber)
+		return
+	}
+	exportZip(w, emails)
+}
+
+// exportZip streams emails as a zip archive.
+func exportZip(w http.ResponseWriter, emails []exportItem) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="gargantua-export-%s.zip"`, time.Now().Format("20060102150405")))
+
+	archive := zip.NewWriter(w)
+	for _, item := range emails {
+		content, err := item.storage.ReadContent(item.email.ID)
+		if err != nil {
+			log.Printf("Error exporting email %s: %v", item.email.ID, err)
+			continue
+		}
+
+		name := path.Join(item.email.Domain, item.email.User, item.email.Direction.String(),
+			fmt.Sprintf("%s-%s.eml", item.email.ID, item.email.Subject))
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: item.email.ReceivedAt})
+		if err != nil {
+			log.Printf("Error exporting email %s: %v", item.email.ID, err)
+			return
+		}
+		if _, err := file.Write(content); err != nil {
+			log.Printf("Error exporting email %s: %v", item.email.ID, err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Printf("Error finishing export archive: %v", err)
+	}
+}
+
+// exportJSONL streams emails as JSON Lines, one parsed email per line,
+// anonymized when scrubber is set.
+func exportJSONL(w http.ResponseWriter, emails []exportItem, scrubber *scrub.Scrubber) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="gargantua-export-%s.jsonl"`, time.Now().Format("20060102150405")))
+
+	writer := export.NewJSONLWriter(w)
+	if scrubber != nil {
+		writer.Anonymize(scrubber)
+	}
+	for _, item := range emails {
+		msg, err := item.storage.ReadMessage(item.email.ID)
+		if err != nil {
+			log.Printf("Error exporting email %s: %v", item.email.ID, err)
+			continue
+		}
+		if err := writer.Write(item.email, msg); err != nil {
+			log.Printf("Error exporting email %s: %v", item.email.ID, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/export"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// fakeRelay records relayed emails.
+type fakeRelay struct {
+	from string
+	to   []string
+}
+
+func (relay *fakeRelay) Relay(from string, to []string, content []byte) error {
+	relay.from = from
+	relay.to = to
+	return nil
+}
+
+// newTestAPI creates an API over a temporary storage holding one incoming
+// email and returns the server, storage and email ID.
+func newTestAPI(t *testing.T, relay Relayer) (*Server, *storage.EmailStorage, string) {
+	t.Helper()
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+
+	content := "From: Sender <sender@external.org>\r\nTo: john@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+	if err := emailStorage.StoreEmail(storage.Incoming, "example.com", "john", "from-sender", []byte(content)); err != nil {
+		t.Fatalf("storing email failed: %v", err)
+	}
+
+	emails, err := emailStorage.List(storage.ListFilter{})
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("listing emails failed: %v", err)
+	}
+
+	server := NewServer("", Options{
+		Storages: func() []*storage.EmailStorage { return []*storage.EmailStorage{emailStorage} },
+		Relay:    relay,
+	})
+	return server, emailStorage, emails[0].ID
+}
+
+func doRequest(server *Server, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestBatchTagAndDelete(t *testing.T) {
+	server, emailStorage, id := newTestAPI(t, nil)
+
+	rec := doRequest(server, http.MethodPost, "/api/v1/messages/batch/tag", `{"ids":["`+id+`","missing"],"add":["reviewed"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("tag status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var response batchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decoding response failed: %v", err)
+	}
+	if len(response.Results) != 2 || !response.Results[0].OK || response.Results[1].OK {
+		t.Errorf("results = %+v, want first ok and missing failed", response.Results)
+	}
+
+	rec = doRequest(server, http.MethodGet, "/api/v1/messages?tag=reviewed", "")
+	var list messageList
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decoding list failed: %v", err)
+	}
+	if list.Total != 1 {
+		t.Errorf("tagged messages = %d, want 1", list.Total)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/messages/batch/delete", `{"ids":["`+id+`"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if _, err := emailStorage.Get(id); err != storage.ErrNotFound {
+		t.Errorf("Get() after batch delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBatchRelease(t *testing.T) {
+	relay := &fakeRelay{}
+	server, emailStorage, id := newTestAPI(t, relay)
+
+	rec := doRequest(server, http.MethodPost, "/api/v1/messages/batch/release", `{"ids":["`+id+`"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("release status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if relay.from != "sender@external.org" {
+		t.Errorf("relayed from = %q, want sender@external.org", relay.from)
+	}
+	if len(relay.to) != 1 || relay.to[0] != "john@example.com" {
+		t.Errorf("relayed to = %v, want [john@example.com]", relay.to)
+	}
+
+	email, err := emailStorage.Get(id)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !email.Metadata.HasTag(releasedTag) {
+		t.Errorf("released email tags = %v, want %s", email.Metadata.Tags, releasedTag)
+	}
+}
+
+func TestBatchExport(t *testing.T) {
+	server, _, id := newTestAPI(t, nil)
+
+	rec := doRequest(server, http.MethodPost, "/api/v1/messages/batch/export", `{"ids":["`+id+`"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("reading zip failed: %v", err)
+	}
+	if len(archive.File) != 1 || !strings.HasPrefix(archive.File[0].Name, "example.com/john/IN/"+id) {
+		t.Errorf("archive files = %v, want the exported email", archive.File)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/messages/batch/export", `{"ids":["missing"]}`)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("export of missing email status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/messages/batch/export", `{"ids":["`+id+`"],"format":"jsonl"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("jsonl export status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var record export.Record
+	if err := json.Unmarshal(rec.Body.Bytes(), &record); err != nil {
+		t.Fatalf("decoding jsonl record failed: %v", err)
+	}
+	if record.ID != id || strings.TrimSpace(record.Text) != "Hello" {
+		t.Errorf("record = %+v, want the exported email and its text", record)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/messages/batch/export", `{"ids":["`+id+`"],"format":"jsonl","anonymize":true}`)
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "sender@external.org") {
+		t.Errorf("anonymized export = %d %s, want the sender hashed", rec.Code, rec.Body)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/messages/batch/export", `{"ids":["`+id+`"],"format":"csv"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown format status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
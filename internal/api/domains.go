@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+)
+
+// DomainManager changes the domains accepted by the SMTP server at runtime.
+type DomainManager interface {
+	Domains() []string
+	RemoveDomain(name string) bool
+}
+
+// handleListDomains lists the accepted domains; empty when the server
+// accepts every domain.
+func (server *Server) handleListDomains(w http.ResponseWriter, r *http.Request) {
+	domains := server.domains.Domains()
+	if domains == nil {
+		domains = []string{}
+	}
+	writeJSON(w, http.StatusOK, domains)
+}
+
+// handleRemoveDomain stops accepting mail for a domain. Its stored emails
+// are kept, and removing the last domain does not reopen the server to
+// every domain.
+func (server *Server) handleRemoveDomain(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHoldRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	name := r.PathValue("name")
+	if !server.domains.RemoveDomain(name) {
+		writeError(w, http.StatusNotFound, "domain not found")
+		return
+	}
+
+	if !server.audit(w, r, "remove", "domain:"+name, req) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
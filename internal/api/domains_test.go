@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+// fakeDomains is a set of accepted domains.
+type fakeDomains map[string]bool
+
+func (domains fakeDomains) Domains() []string {
+	var names []string
+	for name := range domains {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (domains fakeDomains) RemoveDomain(name string) bool {
+	ok := domains[name]
+	delete(domains, name)
+	return ok
+}
+
+func TestDomains(t *testing.T) {
+	server := NewServer("", Options{Domains: fakeDomains{"example.com": true, "example.org": true}})
+
+	if rec := doRequest(server, http.MethodDelete, "/api/v1/domains/example.com", `{"reason":"decommissioned"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("remove status = %d, want %d: %s", rec.Code, http.StatusNoContent, rec.Body)
+	}
+	if rec := doRequest(server, http.MethodDelete, "/api/v1/domains/example.com", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second remove status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	rec := doRequest(server, http.MethodGet, "/api/v1/domains", "")
+	var domains []string
+	if err := json.NewDecoder(rec.Body).Decode(&domains); err != nil || !slices.Equal(domains, []string{"example.org"}) {
+		t.Errorf("domains = %v (%v), want [example.org]", domains, err)
+	}
+
+	doRequest(server, http.MethodDelete, "/api/v1/domains/example.org", "")
+	if rec := doRequest(server, http.MethodGet, "/api/v1/domains", ""); rec.Body.String() != "[]\n" {
+		t.Errorf("domains after removing all = %q, want an empty list", rec.Body)
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// faultsBody is the request and response body of the storage faults
+// endpoints, in the storage.ParseFaults syntax.
+type faultsBody struct {
+	Faults string `json:"faults"`
+}
+
+// handleGetFaults reports the storage faults being injected.
+func (server *Server) handleGetFaults(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, faultsBody{Faults: server.faults.Settings().String()})
+}
+
+// handleSetFaults changes the injected storage faults at runtime, e.g. to
+// degrade storage in the middle of a load test. An empty value stops the
+// injection.
+func (server *Server) handleSetFaults(w http.ResponseWriter, r *http.Request) {
+	var req faultsBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	settings, err := storage.ParseFaults(req.Faults)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := server.faults.Set(settings); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.Info("Storage faults changed through API", "faults", settings.String(), "remote", r.RemoteAddr)
+
+	writeJSON(w, http.StatusOK, faultsBody{Faults: settings.String()})
+}
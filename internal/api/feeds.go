@@ -0,0 +1,158 @@
+package api
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// feedLimit is the number of most recent emails listed in a feed.
+const feedLimit = 50
+
+// summaryLength bounds the body excerpt of a feed entry, in runes.
+const summaryLength = 280
+
+// atomFeed is an Atom feed (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomLink is a link of an Atom feed or entry.
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+// atomEntry is one email of a feed.
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Link    atomLink    `xml:"link"`
+	Summary string      `xml:"summary,omitempty"`
+}
+
+// atomAuthor is the sender of an entry.
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+// handleMailboxFeed serves an Atom feed of the latest emails received by a
+// mailbox, /feeds/{domain}/{user}.xml, each linking to its canonical URL.
+func (server *Server) handleMailboxFeed(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+	user, ok := strings.CutSuffix(r.PathValue("file"), ".xml")
+	if !ok || user == "" {
+		writeError(w, http.StatusNotFound, "feed not found")
+		return
+	}
+
+	incoming := storage.Incoming
+	filter := storage.ListFilter{Domain: domain, User: user, Direction: &incoming, Folder: storage.FolderInbox}
+	var emails []feedEmail
+	for _, emailStorage := range server.storages() {
+		found, err := emailStorage.List(filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, email := range found {
+			emails = append(emails, feedEmail{email, emailStorage})
+		}
+	}
+	sort.SliceStable(emails, func(i, j int) bool {
+		return emails[i].ReceivedAt.After(emails[j].ReceivedAt)
+	})
+	if len(emails) > feedLimit {
+		emails = emails[:feedLimit]
+	}
+
+	baseURL := server.baseURL(r)
+	address := user + "@" + domain
+	feed := atomFeed{
+		ID:    baseURL + r.URL.Path,
+		Title: "Emails to " + address,
+		Link:  []atomLink{{Href: baseURL + r.URL.Path, Rel: "self", Type: "application/atom+xml"}},
+	}
+
+	// An empty feed is as old as the epoch, keeping readers from polling it
+	// as changed
+	updated := time.Unix(0, 0)
+	for _, email := range emails {
+		if email.ReceivedAt.After(updated) {
+			updated = email.ReceivedAt
+		}
+		feed.Entries = append(feed.Entries, feedEntry(email, baseURL))
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("Error encoding feed: %v", err)
+	}
+}
+
+// feedEmail is an email listed in a feed and the storage holding it.
+type feedEmail struct {
+	storage.StoredEmail
+	storage *storage.EmailStorage
+}
+
+// feedEntry describes email in a feed, with its full subject, sender and the
+// start of its text body when it can be read.
+func feedEntry(email feedEmail, baseURL string) atomEntry {
+	link := baseURL + "/m/" + url.PathEscape(email.ID)
+	entry := atomEntry{
+		ID:      link,
+		Title:   email.Subject,
+		Updated: email.ReceivedAt.UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: link, Rel: "alternate"},
+	}
+	if entry.Title == "" {
+		entry.Title = "(no subject)"
+	}
+
+	msg, err := email.storage.ReadMessage(email.ID)
+	if err != nil {
+		return entry
+	}
+	if subject := msg.Header.Get("Subject"); subject != "" {
+		entry.Title = subject
+	}
+	if from := msg.Header.Get("From"); from != "" {
+		entry.Author = &atomAuthor{Name: from}
+	}
+	summary := []rune(strings.Join(strings.Fields(msg.Text()), " "))
+	if len(summary) > summaryLength {
+		summary = append(summary[:summaryLength], '…')
+	}
+	entry.Summary = string(summary)
+	return entry
+}
+
+// baseURL returns the address the API is reached at: api.public_url when
+// set, otherwise the one the request was made to.
+func (server *Server) baseURL(r *http.Request) string {
+	if server.publicURL != "" {
+		return strings.TrimSuffix(server.publicURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
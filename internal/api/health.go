@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/health"
+)
+
+// HealthReporter reports the storage health of each domain.
+type HealthReporter interface {
+	Statuses() []health.Status
+	Ready() bool
+}
+
+// readiness is the response of the readiness endpoint.
+type readiness struct {
+	Ready   bool            `json:"ready"`
+	Domains []health.Status `json:"domains"`
+}
+
+// handleReadyz reports 200 while every domain storage is healthy and 503
+// otherwise, listing the domains with storage failures.
+func (server *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	ready := server.health.Ready()
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, readiness{Ready: ready, Domains: server.health.Statuses()})
+}
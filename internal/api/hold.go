@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/audit"
+	"github.com/nathabonfim59/gargantua-sink/internal/auth"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// Auditor records administrative actions.
+type Auditor interface {
+	Record(entry audit.Entry) error
+}
+
+// holdRequest is the body of the hold endpoints.
+type holdRequest struct {
+	Reason string `json:"reason"`
+	By     string `json:"by,omitempty"` // Who requested the hold or its release
+}
+
+// holdList is the response of the hold listing endpoint.
+type holdList struct {
+	Messages  []storage.StoredEmail `json:"messages"`
+	Mailboxes []storage.MailboxHold `json:"mailboxes"`
+}
+
+// decodeHoldRequest reads a hold request body, requiring a reason when
+// placing a hold. Releases may omit the body.
+func decodeHoldRequest(w http.ResponseWriter, r *http.Request, requireReason bool) (holdRequest, bool) {
+	var req holdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && (requireReason || r.ContentLength > 0) {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return req, false
+	}
+	if requireReason && req.Reason == "" {
+		writeError(w, http.StatusBadRequest, "reason must not be empty")
+		return req, false
+	}
+	return req, true
+}
+
+// handleHoldMessage places a stored email on hold.
+func (server *Server) handleHoldMessage(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHoldRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	_, emailStorage, err := server.findMessage(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	hold := &storage.Hold{Reason: req.Reason, By: actor(r, req), At: time.Now()}
+	metadata, err := emailStorage.UpdateMetadata(id, func(metadata *storage.Metadata) {
+		metadata.Hold = hold
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	if !server.audit(w, r, "hold", "message:"+id, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+// handleReleaseMessage lifts the hold of a stored email.
+func (server *Server) handleReleaseMessage(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHoldRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	email, emailStorage, err := server.findMessage(id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if email.Metadata.Hold == nil {
+		writeError(w, http.StatusNotFound, "email is not on hold")
+		return
+	}
+
+	metadata, err := emailStorage.UpdateMetadata(id, func(metadata *storage.Metadata) {
+		metadata.Hold = nil
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	if !server.audit(w, r, "release", "message:"+id, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+// handleHoldMailbox places every email of a mailbox on hold. The hold is
+// recorded in every storage, since the mailbox may span several.
+func (server *Server) handleHoldMailbox(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHoldRequest(w, r, true)
+	if !ok {
+		return
+	}
+
+	domain, user := r.PathValue("domain"), r.PathValue("user")
+	hold := storage.Hold{Reason: req.Reason, By: actor(r, req), At: time.Now()}
+	for _, emailStorage := range server.storages() {
+		if err := emailStorage.HoldMailbox(domain, user, hold); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
+
+	if !server.audit(w, r, "hold", "mailbox:"+domain+"/"+user, req) {
+		return
+	}
+	writeJSON(w, http.StatusOK, storage.MailboxHold{Domain: domain, User: user, Hold: hold})
+}
+
+// handleReleaseMailbox lifts the hold of a mailbox.
+func (server *Server) handleReleaseMailbox(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeHoldRequest(w, r, false)
+	if !ok {
+		return
+	}
+
+	domain, user := r.PathValue("domain"), r.PathValue("user")
+	released := false
+	for _, emailStorage := range server.storages() {
+		err := emailStorage.ReleaseMailbox(domain, user)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		released = true
+	}
+	if !released {
+		writeError(w, http.StatusNotFound, "mailbox is not on hold")
+		return
+	}
+
+	if !server.audit(w, r, "release", "mailbox:"+domain+"/"+user, req) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListHolds lists the emails and mailboxes on hold.
+func (server *Server) handleListHolds(w http.ResponseWriter, r *http.Request) {
+	response := holdList{Messages: []storage.StoredEmail{}, Mailboxes: []storage.MailboxHold{}}
+	seen := make(map[string]bool)
+
+	for _, emailStorage := range server.storages() {
+		emails, err := emailStorage.List(storage.ListFilter{})
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		for _, email := range emails {
+			if email.Metadata.Hold != nil {
+				response.Messages = append(response.Messages, email)
+			}
+		}
+
+		mailboxes, err := emailStorage.MailboxHolds()
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		for _, mailbox := range mailboxes {
+			if key := mailbox.Domain + "/" + mailbox.User; !seen[key] {
+				seen[key] = true
+				response.Mailboxes = append(response.Mailboxes, mailbox)
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// audit records an action in the audit log. The action has already been
+// applied, so a failure is reported to the client as an error without
+// undoing it.
+func (server *Server) audit(w http.ResponseWriter, r *http.Request, action, target string, req holdRequest) bool {
+	entry := audit.Entry{
+		Action: action,
+		Target: target,
+		Actor:  actor(r, req),
+		Remote: r.RemoteAddr,
+		Reason: req.Reason,
+	}
+	log.Printf("Audit: %s %s by %q from %s: %s", action, target, entry.Actor, r.RemoteAddr, req.Reason)
+
+	if server.auditor == nil {
+		return true
+	}
+	if err := server.auditor.Record(entry); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return false
+	}
+	return true
+}
+
+// actor returns the authenticated caller of r, or the one declared in the
+// request body when the API is open.
+func actor(r *http.Request, req holdRequest) string {
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		return principal.Name
+	}
+	return req.By
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/audit"
+)
+
+// fakeAuditor records audit entries.
+type fakeAuditor struct {
+	entries []audit.Entry
+}
+
+func (auditor *fakeAuditor) Record(entry audit.Entry) error {
+	auditor.entries = append(auditor.entries, entry)
+	return nil
+}
+
+func TestMessageHold(t *testing.T) {
+	server, _, id := newTestAPI(t, nil)
+	auditor := &fakeAuditor{}
+	server.auditor = auditor
+
+	rec := doRequest(server, http.MethodPost, "/api/v1/messages/"+id+"/hold", `{}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("hold without reason status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/messages/"+id+"/hold", `{"reason":"case 42","by":"legal"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("hold status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = doRequest(server, http.MethodDelete, "/api/v1/messages/"+id, "")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("delete of held email status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/messages/batch/delete", `{"ids":["`+id+`"]}`)
+	var response batchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("decoding response failed: %v", err)
+	}
+	if len(response.Results) != 1 || response.Results[0].OK {
+		t.Errorf("batch delete results = %+v, want held email refused", response.Results)
+	}
+
+	rec = doRequest(server, http.MethodGet, "/api/v1/holds", "")
+	var holds holdList
+	if err := json.NewDecoder(rec.Body).Decode(&holds); err != nil {
+		t.Fatalf("decoding holds failed: %v", err)
+	}
+	if len(holds.Messages) != 1 || holds.Messages[0].ID != id {
+		t.Errorf("held messages = %+v, want %s", holds.Messages, id)
+	}
+
+	rec = doRequest(server, http.MethodDelete, "/api/v1/messages/"+id+"/hold", `{"by":"legal"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("release status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	rec = doRequest(server, http.MethodDelete, "/api/v1/messages/"+id, "")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("delete after release status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	if len(auditor.entries) != 2 || auditor.entries[0].Action != "hold" || auditor.entries[1].Action != "release" {
+		t.Errorf("audit entries = %+v, want hold then release", auditor.entries)
+	}
+	if auditor.entries[0].Target != "message:"+id || auditor.entries[0].Reason != "case 42" {
+		t.Errorf("hold entry = %+v, want target and reason recorded", auditor.entries[0])
+	}
+}
+
+func TestMailboxHold(t *testing.T) {
+	server, _, id := newTestAPI(t, nil)
+
+	rec := doRequest(server, http.MethodPost, "/api/v1/mailboxes/example.com/john/hold", `{"reason":"litigation"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("hold status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = doRequest(server, http.MethodDelete, "/api/v1/messages/"+id, "")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("delete in held mailbox status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	rec = doRequest(server, http.MethodDelete, "/api/v1/mailboxes/example.com/john/hold", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("release status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	rec = doRequest(server, http.MethodDelete, "/api/v1/mailboxes/example.com/john/hold", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("second release status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/honeypot"
+)
+
+// HoneypotReporter reports the intelligence gathered about each sender.
+type HoneypotReporter interface {
+	Senders() []honeypot.Sender
+	Sender(ip string) (honeypot.Sender, bool)
+}
+
+// handleListSenders lists the senders seen by the honeypot, most recently
+// seen first.
+func (server *Server) handleListSenders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, server.honeypot.Senders())
+}
+
+// handleGetSender returns the report of one client address.
+func (server *Server) handleGetSender(w http.ResponseWriter, r *http.Request) {
+	sender, ok := server.honeypot.Sender(r.PathValue("ip"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "sender not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, sender)
+}
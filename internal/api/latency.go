@@ -0,0 +1,124 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// latencyReport is the response of the latency endpoint: how long the
+// emails of a test run took from the end of DATA to being stored, which
+// is when the API lists them.
+type latencyReport struct {
+	Messages        int            `json:"messages"`
+	WithoutTimeline int            `json:"without_timeline"` // Stored while smtp.timeline was off
+	P50             float64        `json:"p50_ms"`
+	P95             float64        `json:"p95_ms"`
+	Max             float64        `json:"max_ms"`
+	Entries         []latencyEntry `json:"entries"`
+}
+
+// latencyEntry is the latency of the copy of one recipient.
+type latencyEntry struct {
+	ID         string    `json:"id"`
+	Recipient  string    `json:"recipient"`
+	ReceivedAt time.Time `json:"received_at"`
+	StoredAt   time.Time `json:"stored_at"`
+	Latency    float64   `json:"latency_ms"`
+}
+
+// handleLatencyReport reports the delivery latency of the incoming copies
+// matching the message filter parameters, received at or after the since
+// parameter (RFC 3339), oldest first.
+func (server *Server) handleLatencyReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := parseListFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Direction == nil {
+		incoming := storage.Incoming
+		filter.Direction = &incoming
+	}
+	var since time.Time
+	if raw := query.Get("since"); raw != "" {
+		if since, err = time.Parse(time.RFC3339, raw); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since (want RFC 3339)")
+			return
+		}
+	}
+
+	report := latencyReport{Entries: []latencyEntry{}}
+	for _, emailStorage := range server.storages() {
+		emails, err := emailStorage.List(filter)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		for _, email := range emails {
+			if email.ReceivedAt.Before(since) {
+				continue
+			}
+			entry, ok := measureLatency(email)
+			if !ok {
+				report.WithoutTimeline++
+				continue
+			}
+			report.Entries = append(report.Entries, entry)
+		}
+	}
+	sort.Slice(report.Entries, func(i, j int) bool {
+		return report.Entries[i].ReceivedAt.Before(report.Entries[j].ReceivedAt)
+	})
+
+	latencies := make([]float64, len(report.Entries))
+	for i, entry := range report.Entries {
+		latencies[i] = entry.Latency
+	}
+	sort.Float64s(latencies)
+	report.Messages = len(latencies)
+	report.P50 = percentile(latencies, 50)
+	report.P95 = percentile(latencies, 95)
+	report.Max = percentile(latencies, 100)
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// measureLatency returns the latency of email from its timeline, which
+// needs the received and stored stages.
+func measureLatency(email storage.StoredEmail) (latencyEntry, bool) {
+	var received, stored time.Time
+	for _, event := range email.Metadata.Timeline {
+		switch event.Stage {
+		case message.StageReceived:
+			received = event.At
+		case message.StageStored:
+			stored = event.At
+		}
+	}
+	if received.IsZero() || stored.IsZero() {
+		return latencyEntry{}, false
+	}
+	return latencyEntry{
+		ID:         email.ID,
+		Recipient:  email.User + "@" + email.Domain,
+		ReceivedAt: received,
+		StoredAt:   stored,
+		Latency:    float64(stored.Sub(received).Microseconds()) / 1000,
+	}, true
+}
+
+// percentile returns the nearest-rank percentile p of the sorted values,
+// 0 when there are none.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestLatencyReport(t *testing.T) {
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+	server := NewServer("", Options{Storages: func() []*storage.EmailStorage { return []*storage.EmailStorage{emailStorage} }})
+
+	for _, delay := range []time.Duration{30, 10, 20} {
+		msg := &message.Message{
+			Envelope:   message.Envelope{From: "app@example.com", To: []string{"john@example.com"}},
+			Subject:    "hello",
+			ReceivedAt: time.Now(),
+			Body:       message.Bytes([]byte("Subject: hello\r\n\r\nhi\r\n")),
+			Timeline:   []message.TimelineEvent{{Stage: message.StageReceived, At: time.Now().Add(-delay * time.Millisecond)}},
+		}
+		for _, direction := range []storage.Direction{storage.Outgoing, storage.Incoming} {
+			if _, err := emailStorage.StoreMessage(direction, "example.com", "john", "hello", msg); err != nil {
+				t.Fatalf("storing email failed: %v", err)
+			}
+		}
+	}
+	if _, err := emailStorage.Store(storage.Incoming, "example.com", "jane", "untimed", []byte("Subject: untimed\r\n\r\nhi\r\n")); err != nil {
+		t.Fatalf("storing email failed: %v", err)
+	}
+
+	rec := doRequest(server, http.MethodGet, "/api/v1/latency?domain=example.com", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("latency status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var report latencyReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decoding report failed: %v", err)
+	}
+	if report.Messages != 3 || len(report.Entries) != 3 || report.WithoutTimeline != 1 {
+		t.Fatalf("report = %+v, want the 3 timed incoming copies and 1 without timeline", report)
+	}
+	if report.P50 < 20 || report.P50 >= 30 || report.Max < 30 || report.P95 != report.Max {
+		t.Errorf("p50 = %g, p95 = %g, max = %g; want about 20, 30 and 30", report.P50, report.P95, report.Max)
+	}
+	if entry := report.Entries[0]; entry.Recipient != "john@example.com" || entry.Latency < 30 {
+		t.Errorf("first entry = %+v, want the oldest, 30ms one", entry)
+	}
+
+	since := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	if rec := doRequest(server, http.MethodGet, "/api/v1/latency?since="+since, ""); !json.Valid(rec.Body.Bytes()) || rec.Code != http.StatusOK {
+		t.Fatalf("latency since status = %d: %s", rec.Code, rec.Body)
+	} else if err := json.NewDecoder(rec.Body).Decode(&report); err != nil || report.Messages != 0 || report.P50 != 0 {
+		t.Errorf("report since later = %+v (%v), want empty", report, err)
+	}
+
+	if rec := doRequest(server, http.MethodGet, "/api/v1/latency?since=yesterday", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid since status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// logLevelRequest is the body of a log level change.
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// logLevelResponse reports the active log level.
+type logLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// handleGetLogLevel reports the active log level.
+func (server *Server) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, logLevelResponse{Level: server.logLevel.Level().String()})
+}
+
+// handleSetLogLevel changes the log level at runtime.
+func (server *Server) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(req.Level)); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid level, want debug, info, warn or error")
+		return
+	}
+
+	previous := server.logLevel.Level()
+	server.logLevel.Set(level)
+	slog.Info("Log level changed through API", "from", previous.String(), "to", level.String(), "remote", r.RemoteAddr)
+
+	writeJSON(w, http.StatusOK, logLevelResponse{Level: level.String()})
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// messageList is the response of the message listing endpoint.
+type messageList struct {
+	Messages []listedMessage `json:"messages"`
+	Total    int             `json:"total"`
+}
+
+// listedMessage is a stored email in a listing.
+type listedMessage struct {
+	storage.StoredEmail
+	Held bool `json:"held,omitempty"` // On legal hold, itself or its mailbox
+}
+
+// handleListMessages lists stored emails, newest first.
+// Query parameters: domain, user, direction (IN or OUT), tag, language,
+// folder (Inbox or Junk), q (text search) and limit.
+func (server *Server) handleListMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := parseListFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit := 0
+	if raw := query.Get("limit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	var messages []listedMessage
+	for _, emailStorage := range server.storages() {
+		emails, err := emailStorage.List(filter)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, email := range emails {
+			held, err := emailStorage.OnHold(email)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			messages = append(messages, listedMessage{StoredEmail: email, Held: held})
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].ReceivedAt.After(messages[j].ReceivedAt)
+	})
+
+	total := len(messages)
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	if messages == nil {
+		messages = []listedMessage{}
+	}
+
+	writeJSON(w, http.StatusOK, messageList{Messages: messages, Total: total})
+}
+
+// parseListFilter reads the message filter from the domain, user,
+// direction, tag, language, folder and q query parameters.
+func parseListFilter(query url.Values) (storage.ListFilter, error) {
+	filter := storage.ListFilter{
+		Domain:   query.Get("domain"),
+		User:     query.Get("user"),
+		Tag:      query.Get("tag"),
+		Language: query.Get("language"),
+		Folder:   query.Get("folder"),
+		Query:    query.Get("q"),
+	}
+	if raw := query.Get("direction"); raw != "" {
+		direction, err := storage.ParseDirection(raw)
+		if err != nil {
+			return filter, err
+		}
+		filter.Direction = &direction
+	}
+	return filter, nil
+}
+
+// messageDetail is the response of the message endpoint: the stored email
+// and its parsed headers and parts.
+type messageDetail struct {
+	storage.StoredEmail
+	Message *message.Message `json:"message"`
+}
+
+// handleGetMessage returns the description of a stored email.
+func (server *Server) handleGetMessage(w http.ResponseWriter, r *http.Request) {
+	email, emailStorage, err := server.findMessage(r.PathValue("id"))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	msg, err := emailStorage.ReadMessage(email.ID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, messageDetail{StoredEmail: email, Message: msg})
+}
+
+// handleMessageLink redirects the canonical link of an email, /m/{id}, to
+// its current representation, so links posted to chat keep working as the
+// API evolves.
+func (server *Server) handleMessageLink(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, _, err := server.findMessage(id); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/api/v1/messages/"+url.PathEscape(id), http.StatusFound)
+}
+
+// handleGetRawMessage returns the raw content of a stored email.
+func (server *Server) handleGetRawMessage(w http.ResponseWriter, r *http.Request) {
+	_, emailStorage, err := server.findMessage(r.PathValue("id"))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	content, err := emailStorage.ReadContent(r.PathValue("id"))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "message/rfc822")
+	w.Write(content)
+}
+
+// handleDeleteMessage removes a stored email.
+func (server *Server) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	if err := server.deleteMessage(r.PathValue("id")); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// findMessage locates a stored email across every storage.
+func (server *Server) findMessage(id string) (storage.StoredEmail, *storage.EmailStorage, error) {
+	for _, emailStorage := range server.storages() {
+		email, err := emailStorage.Get(id)
+		if err == nil {
+			return email, emailStorage, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return storage.StoredEmail{}, nil, err
+		}
+	}
+	return storage.StoredEmail{}, nil, storage.ErrNotFound
+}
+
+// deleteMessage removes a stored email from whichever storage holds it.
+func (server *Server) deleteMessage(id string) error {
+	_, emailStorage, err := server.findMessage(id)
+	if err != nil {
+		return err
+	}
+	return emailStorage.Delete(id)
+}
+
+// writeStorageError maps storage errors to HTTP responses.
+func writeStorageError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if errors.Is(err, storage.ErrOnHold) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+)
+
+// maxBuckets bounds the callers tracked by a limiter; beyond it, callers
+// whose bucket refilled completely are forgotten.
+const maxBuckets = 10000
+
+// RateLimit sets the request rates allowed per API caller, so aggressive
+// dashboards cannot starve the SMTP side of CPU. Zero rates disable the
+// corresponding limit.
+type RateLimit struct {
+	PerIP    float64 // Requests per second of each client address without credentials
+	PerToken float64 // Requests per second of each authenticated caller
+	Burst    int     // Requests allowed at once, defaults to twice the rate
+}
+
+// tokenBucket holds the requests a caller may still make.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter enforces one request rate per key with token buckets.
+type limiter struct {
+	rate    float64
+	burst   float64
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	limited int // Rejected requests
+}
+
+// newLimiter creates a limiter allowing rate requests per second and burst
+// at once, or returns nil when rate is not positive.
+func newLimiter(rate float64, burst int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(2*rate)))
+	}
+	return &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a request from the bucket of key. When the bucket is empty
+// it returns false and how long until the next request is allowed.
+func (limiter *limiter) allow(key string) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := limiter.now()
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		if len(limiter.buckets) >= maxBuckets {
+			limiter.prune(now)
+		}
+		bucket = &tokenBucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	limiter.limited++
+	wait := time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+	return false, wait
+}
+
+// prune forgets the buckets that refilled completely. Callers hold limiter.mu.
+func (limiter *limiter) prune(now time.Time) {
+	for key, bucket := range limiter.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate >= limiter.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// rejected returns the number of requests rejected so far.
+func (limiter *limiter) rejected() int {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	return limiter.limited
+}
+
+// allow applies limiter to the request of key, answering 429 with a
+// Retry-After header and returning false when the caller is over its rate.
+// A nil limiter allows every request.
+func (server *Server) allow(w http.ResponseWriter, limiter *limiter, key string) bool {
+	if limiter == nil {
+		return true
+	}
+
+	ok, wait := limiter.allow(key)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+	}
+	return ok
+}
+
+// collectRateLimits reports the requests rejected by each limit.
+func (server *Server) collectRateLimits() []metrics.Family {
+	limited := metrics.Family{
+		Name: "gargantua_api_rate_limited_total",
+		Help: "API requests rejected with 429 per limit (ip or token).",
+		Type: metrics.Counter,
+	}
+	names := []string{"ip", "token"}
+	for i, limiter := range []*limiter{server.ipLimiter, server.tokenLimiter} {
+		if limiter != nil {
+			limited.Samples = append(limited.Samples, metrics.Sample{
+				Labels: map[string]string{"limit": names[i]},
+				Value:  float64(limiter.rejected()),
+			})
+		}
+	}
+	return []metrics.Family{limited}
+}
+
+// clientIP returns the address of the client without its port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/auth"
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+)
+
+func TestRateLimit(t *testing.T) {
+	server := NewServer("", Options{
+		Metrics:   metrics.NewRegistry(),
+		Auth:      auth.NewAuthenticator([]auth.Token{{Name: "dashboard", Secret: "token", Role: auth.RoleReader}}, "", "", nil),
+		RateLimit: RateLimit{PerIP: 1, PerToken: 1, Burst: 2},
+	})
+
+	request := func(remote, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/version", nil)
+		r.RemoteAddr = remote
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, r)
+		return rec
+	}
+
+	// The burst is allowed, then the token is limited
+	for i := 0; i < 2; i++ {
+		if rec := request("10.0.0.1:1000", "token"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i, rec.Code)
+		}
+	}
+	rec := request("10.0.0.2:1000", "token")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("request over the token limit = %d, Retry-After %q, want 429 and 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// Anonymous requests are limited per address, independently of tokens
+	for i := 0; i < 2; i++ {
+		if rec := request("10.0.0.1:1000", ""); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("anonymous request %d status = %d, want 401", i, rec.Code)
+		}
+	}
+	if rec := request("10.0.0.1:2000", ""); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("anonymous request over the limit = %d, want 429", rec.Code)
+	}
+	if rec := request("10.0.0.3:1000", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("request from another address = %d, want 401", rec.Code)
+	}
+}
+
+func TestLimiterRefills(t *testing.T) {
+	limiter := newLimiter(2, 1)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Fatal("first request rejected")
+	}
+	ok, wait := limiter.allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("allow() = %v, %s, want rejection for 500ms", ok, wait)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Error("request after the refill rejected")
+	}
+	if limiter.rejected() != 1 {
+		t.Errorf("rejected() = %d, want 1", limiter.rejected())
+	}
+}
+
+func TestRateLimitGroupUsers(t *testing.T) {
+	server := NewServer("", Options{
+		Metrics:   metrics.NewRegistry(),
+		Auth:      auth.NewAuthenticator(nil, "X-Forwarded-Groups", "X-Forwarded-User", map[string]auth.Role{"qa": auth.RoleReader}),
+		RateLimit: RateLimit{PerIP: 1, PerToken: 1, Burst: 1},
+	})
+
+	request := func(user string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/version", nil)
+		r.RemoteAddr = "10.0.0.1:1000"
+		r.Header.Set("X-Forwarded-Groups", "qa")
+		r.Header.Set("X-Forwarded-User", user)
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	// Users of the same group behind the same proxy have their own bucket
+	if code := request("alice"); code != http.StatusOK {
+		t.Fatalf("alice status = %d, want 200", code)
+	}
+	if code := request("bob"); code != http.StatusOK {
+		t.Errorf("bob status = %d, want 200", code)
+	}
+	if code := request("alice"); code != http.StatusTooManyRequests {
+		t.Errorf("alice over the limit = %d, want 429", code)
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/retention"
+)
+
+// handleRetentionReport reports what the retention policy would delete,
+// without deleting anything. The max_age query parameter tries another max
+// age for every domain instead of the configured policy.
+func (server *Server) handleRetentionReport(w http.ResponseWriter, r *http.Request) {
+	policy := server.retention
+	if value := r.URL.Query().Get("max_age"); value != "" {
+		maxAge, err := time.ParseDuration(value)
+		if err != nil || maxAge < 0 {
+			writeError(w, http.StatusBadRequest, "invalid max_age")
+			return
+		}
+		policy = retention.Policy{MaxAge: maxAge}
+	}
+
+	items, err := retention.Collect(server.storages())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	report, _ := retention.Plan(policy, items, time.Now())
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,294 @@
+// Package api implements the HTTP API of the Gargantua Sink server.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/auth"
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+	"github.com/nathabonfim59/gargantua-sink/internal/retention"
+	"github.com/nathabonfim59/gargantua-sink/internal/shadow"
+	"github.com/nathabonfim59/gargantua-sink/internal/smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// Options holds the dependencies of the API server.
+// Endpoints whose dependency is nil are not registered.
+type Options struct {
+	LogLevel  *slog.LevelVar                 // Runtime adjustable log level
+	Storages  func() []*storage.EmailStorage // Storages holding captured emails
+	Relay     Relayer                        // Forwarding server used to release emails
+	Shadow    ShadowStats                    // Dark-launch target statistics
+	Health    HealthReporter                 // Per-domain storage health
+	Metrics   *metrics.Registry              // Metrics served in the Prometheus format
+	Audit     Auditor                        // Log of hold changes, optional
+	Auth      *auth.Authenticator            // Role-based access control, nil leaves the API open
+	Faults    *storage.Faults                // Injected storage faults, only set for failure testing
+	RateLimit RateLimit                      // Request rates per caller, unlimited when zero
+	Sessions  func() []smtp.SessionInfo      // Open SMTP sessions
+	Closed    func() []smtp.SessionInfo      // Last finished SMTP sessions, only kept for diagnostics
+	Honeypot  HoneypotReporter               // Sender intelligence, only set in honeypot mode
+	Watches   Watcher                        // One-shot subscriptions to the next matching email
+	Domains   DomainManager                  // Domains accepted by the SMTP server
+	Retention retention.Policy               // Policy of the retention report, keeps everything when zero
+	PublicURL string                         // Address users reach the API at, for absolute links
+}
+
+// ShadowStats reports the statistics of the dark-launch target.
+type ShadowStats interface {
+	Stats() shadow.Stats
+}
+
+// Server represents the HTTP API server.
+type Server struct {
+	addr     string
+	mux      *http.ServeMux
+	server   *http.Server
+	logLevel *slog.LevelVar
+	storages func() []*storage.EmailStorage
+	relay    Relayer
+	shadow   ShadowStats
+	health   HealthReporter
+	metrics  *metrics.Registry
+	auditor  Auditor
+	auth     *auth.Authenticator
+	faults   *storage.Faults
+	sessions func() []smtp.SessionInfo
+	closed   func() []smtp.SessionInfo
+	honeypot HoneypotReporter
+	watches  Watcher
+	domains  DomainManager
+
+	retention retention.Policy
+
+	approvalMu sync.Mutex // Serializes approval decisions
+
+	publicURL string // Base of absolute links, the request host when empty
+
+	ipLimiter    *limiter
+	tokenLimiter *limiter
+}
+
+// NewServer creates a new API server listening on addr.
+func NewServer(addr string, opts Options) *Server {
+	server := &Server{
+		addr:     addr,
+		mux:      http.NewServeMux(),
+		logLevel: opts.LogLevel,
+		storages: opts.Storages,
+		relay:    opts.Relay,
+		shadow:   opts.Shadow,
+		health:   opts.Health,
+		metrics:  opts.Metrics,
+		auditor:  opts.Audit,
+		auth:     opts.Auth,
+		faults:   opts.Faults,
+		sessions: opts.Sessions,
+		closed:   opts.Closed,
+		honeypot: opts.Honeypot,
+		watches:  opts.Watches,
+		domains:  opts.Domains,
+
+		retention: opts.Retention,
+
+		publicURL: opts.PublicURL,
+
+		ipLimiter:    newLimiter(opts.RateLimit.PerIP, opts.RateLimit.Burst),
+		tokenLimiter: newLimiter(opts.RateLimit.PerToken, opts.RateLimit.Burst),
+	}
+	if server.metrics != nil && (server.ipLimiter != nil || server.tokenLimiter != nil) {
+		server.metrics.Register(server.collectRateLimits)
+	}
+	server.routes()
+	return server
+}
+
+// routes registers every API endpoint with the role it requires. Probes
+// and metrics are always open.
+func (server *Server) routes() {
+	server.handle("GET /api/v1/version", auth.RoleReader, server.handleVersion)
+
+	if server.logLevel != nil {
+		server.handle("GET /api/v1/loglevel", auth.RoleReader, server.handleGetLogLevel)
+		server.handle("PUT /api/v1/loglevel", auth.RoleAdmin, server.handleSetLogLevel)
+	}
+
+	if server.storages != nil {
+		server.handle("GET /api/v1/messages", auth.RoleReader, server.handleListMessages)
+		server.handle("GET /api/v1/messages/{id}", auth.RoleReader, server.handleGetMessage)
+		server.handle("GET /m/{id}", auth.RoleReader, server.handleMessageLink)
+		server.handle("GET /api/v1/messages/{id}/raw", auth.RoleReader, server.handleGetRawMessage)
+		server.handle("DELETE /api/v1/messages/{id}", auth.RoleAdmin, server.handleDeleteMessage)
+		server.handle("POST /api/v1/messages/batch/delete", auth.RoleAdmin, server.handleBatchDelete)
+		server.handle("POST /api/v1/messages/batch/tag", auth.RoleReleaser, server.handleBatchTag)
+		server.handle("POST /api/v1/messages/batch/export", auth.RoleReader, server.handleBatchExport)
+		server.handle("POST /api/v1/messages/{id}/hold", auth.RoleAdmin, server.handleHoldMessage)
+		server.handle("DELETE /api/v1/messages/{id}/hold", auth.RoleAdmin, server.handleReleaseMessage)
+		server.handle("POST /api/v1/mailboxes/{domain}/{user}/hold", auth.RoleAdmin, server.handleHoldMailbox)
+		server.handle("DELETE /api/v1/mailboxes/{domain}/{user}/hold", auth.RoleAdmin, server.handleReleaseMailbox)
+		server.handle("GET /api/v1/holds", auth.RoleReader, server.handleListHolds)
+		server.handle("GET /api/v1/retention", auth.RoleReader, server.handleRetentionReport)
+		server.handle("GET /api/v1/latency", auth.RoleReader, server.handleLatencyReport)
+		server.handle("GET /feeds/{domain}/{file}", auth.RoleReader, server.handleMailboxFeed)
+
+		if server.relay != nil {
+			server.handle("POST /api/v1/messages/batch/release", auth.RoleReleaser, server.handleBatchRelease)
+			server.handle("GET /api/v1/approvals", auth.RoleReader, server.handleListApprovals)
+			server.handle("POST /api/v1/approvals/{id}/approve", auth.RoleReleaser, server.handleApprove)
+			server.handle("POST /api/v1/approvals/{id}/reject", auth.RoleReleaser, server.handleReject)
+		}
+	}
+
+	if server.faults != nil {
+		server.handle("GET /api/v1/storage/faults", auth.RoleReader, server.handleGetFaults)
+		server.handle("PUT /api/v1/storage/faults", auth.RoleAdmin, server.handleSetFaults)
+	}
+
+	if server.sessions != nil {
+		server.handle("GET /api/v1/sessions", auth.RoleReader, server.handleListSessions)
+	}
+	if server.closed != nil {
+		server.handle("GET /api/v1/sessions/closed", auth.RoleReader, server.handleListClosedSessions)
+	}
+
+	if server.watches != nil {
+		server.handle("POST /api/v1/watches", auth.RoleReleaser, server.handleAddWatch)
+		server.handle("GET /api/v1/watches", auth.RoleReader, server.handleListWatches)
+		server.handle("DELETE /api/v1/watches/{id}", auth.RoleReleaser, server.handleCancelWatch)
+	}
+
+	if server.domains != nil {
+		server.handle("GET /api/v1/domains", auth.RoleReader, server.handleListDomains)
+		server.handle("DELETE /api/v1/domains/{name}", auth.RoleAdmin, server.handleRemoveDomain)
+	}
+
+	if server.honeypot != nil {
+		server.handle("GET /api/v1/honeypot/senders", auth.RoleReader, server.handleListSenders)
+		server.handle("GET /api/v1/honeypot/senders/{ip}", auth.RoleReader, server.handleGetSender)
+	}
+
+	if server.shadow != nil {
+		server.handle("GET /api/v1/shadow/stats", auth.RoleReader, server.handleShadowStats)
+	}
+
+	if server.health != nil {
+		server.mux.HandleFunc("GET /readyz", server.handleReadyz)
+	}
+	if server.metrics != nil {
+		server.mux.Handle("GET /metrics", server.metrics.Handler())
+	}
+}
+
+// handle registers an endpoint restricted to callers with at least role.
+func (server *Server) handle(pattern string, role auth.Role, handler http.HandlerFunc) {
+	server.mux.Handle(pattern, server.authorize(role, handler))
+}
+
+// authorize rejects requests without credentials (401) or with a role
+// below role (403), and passes the caller on in the request context.
+// Callers over their rate limit get 429: authenticated callers are limited
+// per token or proxy user, every other request per client address.
+func (server *Server) authorize(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	if server.auth == nil {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if server.allow(w, server.ipLimiter, clientIP(r)) {
+				next(w, r)
+			}
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, err := server.auth.Authenticate(r)
+		if err != nil {
+			if !server.allow(w, server.ipLimiter, clientIP(r)) {
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="gargantua-sink"`)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if !server.allow(w, server.tokenLimiter, limitKey(principal, r)) {
+			return
+		}
+		if principal.Role < role {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s role required", role))
+			return
+		}
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	}
+}
+
+// limitKey returns the rate limit key of principal. Proxy group principals
+// are shared by every user of the group, so they are keyed by the forwarded
+// user or, without one, by the client address.
+func limitKey(principal auth.Principal, r *http.Request) string {
+	if !strings.HasPrefix(principal.Name, "group:") {
+		return principal.Name
+	}
+	if principal.User != "" {
+		return principal.Name + "/user:" + principal.User
+	}
+	return principal.Name + "/ip:" + clientIP(r)
+}
+
+// Handler returns the HTTP handler serving the API.
+func (server *Server) Handler() http.Handler {
+	return server.mux
+}
+
+// Start begins serving the API and blocks until the server stops.
+func (server *Server) Start() error {
+	listener, err := net.Listen("tcp", server.addr)
+	if err != nil {
+		return err
+	}
+	return server.Serve(listener)
+}
+
+// Serve serves the API on listener, which may be inherited from a previous
+// process, and blocks until the server stops.
+func (server *Server) Serve(listener net.Listener) error {
+	server.server = &http.Server{
+		Addr:              server.addr,
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("Starting HTTP API on %s", listener.Addr())
+	if err := server.server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the API server.
+func (server *Server) Shutdown(ctx context.Context) error {
+	if server.server != nil {
+		return server.server.Shutdown(ctx)
+	}
+	return nil
+}
+
+// writeJSON encodes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding API response: %v", err)
+	}
+}
+
+// writeError sends a JSON error response.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
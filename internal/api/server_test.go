@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/health"
+	"github.com/nathabonfim59/gargantua-sink/internal/version"
+)
+
+func TestVersionEndpoint(t *testing.T) {
+	server := NewServer("", Options{})
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/version", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var info version.Info
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatalf("decoding response failed: %v", err)
+	}
+	if info.Version != version.Version {
+		t.Errorf("version = %q, want %q", info.Version, version.Version)
+	}
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("go_version = %q, want %q", info.GoVersion, runtime.Version())
+	}
+}
+
+func TestLogLevelEndpoint(t *testing.T) {
+	level := new(slog.LevelVar)
+	server := NewServer("", Options{LogLevel: level})
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantLevel  slog.Level
+	}{
+		{name: "raise_to_debug", body: `{"level":"debug"}`, wantStatus: http.StatusOK, wantLevel: slog.LevelDebug},
+		{name: "invalid_level", body: `{"level":"verbose"}`, wantStatus: http.StatusBadRequest, wantLevel: slog.LevelDebug},
+		{name: "drop_to_warn", body: `{"level":"WARN"}`, wantStatus: http.StatusOK, wantLevel: slog.LevelWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/loglevel", strings.NewReader(tt.body))
+			server.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if level.Level() != tt.wantLevel {
+				t.Errorf("level = %s, want %s", level.Level(), tt.wantLevel)
+			}
+		})
+	}
+}
+
+// fakeHealth reports a fixed set of domain statuses.
+type fakeHealth []health.Status
+
+func (statuses fakeHealth) Statuses() []health.Status { return statuses }
+
+func (statuses fakeHealth) Ready() bool {
+	for _, status := range statuses {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadyzEndpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   fakeHealth
+		wantStatus int
+	}{
+		{name: "healthy", statuses: fakeHealth{{Domain: "a.com", Healthy: true}}, wantStatus: http.StatusOK},
+		{name: "failing_domain", statuses: fakeHealth{{Domain: "a.com", Healthy: true}, {Domain: "b.com", Error: "disk full"}}, wantStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer("", Options{Health: tt.statuses})
+
+			rec := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestMessageLink(t *testing.T) {
+	server, _, id := newTestAPI(t, nil)
+
+	rec := doRequest(server, http.MethodGet, "/m/"+id, "")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/api/v1/messages/"+id {
+		t.Errorf("link = %d to %q, want %d to the message", rec.Code, rec.Header().Get("Location"), http.StatusFound)
+	}
+
+	rec = doRequest(server, http.MethodGet, "/m/missing", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing link status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestMailboxFeed(t *testing.T) {
+	server, _, id := newTestAPI(t, nil)
+
+	rec := doRequest(server, http.MethodGet, "/feeds/example.com/john.xml", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("feed status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var feed atomFeed
+	if err := xml.NewDecoder(rec.Body).Decode(&feed); err != nil {
+		t.Fatalf("decoding feed failed: %v", err)
+	}
+	if len(feed.Entries) != 1 {
+		t.Fatalf("feed entries = %d, want 1", len(feed.Entries))
+	}
+	entry := feed.Entries[0]
+	if entry.Title != "Hi" || entry.Link.Href != "http://example.com/m/"+id || entry.Summary != "Hello" {
+		t.Errorf("entry = %+v, want Hi linking to the message", entry)
+	}
+	if entry.Author == nil || entry.Author.Name != "Sender <sender@external.org>" {
+		t.Errorf("entry author = %+v, want the sender", entry.Author)
+	}
+
+	rec = doRequest(server, http.MethodGet, "/feeds/example.com/jane.xml", "")
+	feed = atomFeed{}
+	if err := xml.NewDecoder(rec.Body).Decode(&feed); err != nil || len(feed.Entries) != 0 {
+		t.Errorf("empty feed = %d entries, %v, want none", len(feed.Entries), err)
+	}
+
+	rec = doRequest(server, http.MethodGet, "/feeds/example.com/john", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("feed without extension status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,17 @@
+package api
+
+import (
+	"net/http"
+)
+
+// handleListSessions lists the open SMTP sessions with their negotiated
+// TLS parameters.
+func (server *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, server.sessions())
+}
+
+// handleListClosedSessions lists the last finished SMTP sessions with their
+// pipelining diagnostics, most recent first.
+func (server *Server) handleListClosedSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, server.closed())
+}
@@ -0,0 +1,10 @@
+package api
+
+import (
+	"net/http"
+)
+
+// handleShadowStats reports how the dark-launch target handled mirrored emails.
+func (server *Server) handleShadowStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, server.shadow.Stats())
+}
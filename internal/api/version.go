@@ -0,0 +1,12 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/version"
+)
+
+// handleVersion reports the build information of the running server.
+func (server *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, version.Get())
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/watch"
+)
+
+// Watcher manages the one-shot subscriptions to the next matching email.
+type Watcher interface {
+	Add(req watch.Request) (watch.Watch, error)
+	List() []watch.Watch
+	Cancel(id string) bool
+}
+
+// watchRequest is the body of the watch creation endpoint.
+type watchRequest struct {
+	rules.Match
+	URL       string `json:"url"`
+	ExpiresIn int    `json:"expires_in"` // Seconds, 60 when omitted
+}
+
+// handleAddWatch registers a watch posting the next matching email to a
+// callback URL.
+func (server *Server) handleAddWatch(w http.ResponseWriter, r *http.Request) {
+	var req watchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	added, err := server.watches.Add(watch.Request{
+		Match: req.Match,
+		URL:   req.URL,
+		TTL:   time.Duration(req.ExpiresIn) * time.Second,
+	})
+	if errors.Is(err, watch.ErrTooManyWatches) {
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, added)
+}
+
+// handleListWatches lists the watches still waiting, oldest first.
+func (server *Server) handleListWatches(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, server.watches.List())
+}
+
+// handleCancelWatch removes a waiting watch.
+func (server *Server) handleCancelWatch(w http.ResponseWriter, r *http.Request) {
+	if !server.watches.Cancel(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "watch not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
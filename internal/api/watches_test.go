@@ -0,0 +1,44 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/watch"
+)
+
+func TestWatches(t *testing.T) {
+	server := NewServer("", Options{Watches: watch.NewRegistry("")})
+
+	rec := doRequest(server, http.MethodPost, "/api/v1/watches", `{"to":"john@example.com","url":"ftp://hooks"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("watch with invalid URL status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = doRequest(server, http.MethodPost, "/api/v1/watches", `{"to":"john@example.com","url":"http://127.0.0.1:1/hook","expires_in":30}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("watch status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	var added watch.Watch
+	if err := json.NewDecoder(rec.Body).Decode(&added); err != nil {
+		t.Fatalf("decoding watch failed: %v", err)
+	}
+	if added.Match.To != "john@example.com" || added.ExpiresAt.Sub(added.CreatedAt) != 30*time.Second {
+		t.Errorf("watch = %+v, want john@example.com expiring in 30s", added)
+	}
+
+	rec = doRequest(server, http.MethodGet, "/api/v1/watches", "")
+	var list []watch.Watch
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil || len(list) != 1 || list[0].ID != added.ID {
+		t.Errorf("watches = %+v (%v), want the added watch", list, err)
+	}
+
+	if rec = doRequest(server, http.MethodDelete, "/api/v1/watches/"+added.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("cancel status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec = doRequest(server, http.MethodDelete, "/api/v1/watches/"+added.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second cancel status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
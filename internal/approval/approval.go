@@ -0,0 +1,86 @@
+// Package approval holds back selected emails until a person decides
+// whether they reach their real recipients. It lets a semi-production
+// environment capture everything while still delivering the few emails
+// that must genuinely go out, such as a reply to a real customer.
+//
+// The middleware only marks matching emails as pending; they are forwarded
+// when approved through the API.
+package approval
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// Queue selects the emails needing approval.
+type Queue struct {
+	rules []rules.Match
+}
+
+// NewQueue creates a queue holding back the emails matching the rules of cfg.
+func NewQueue(cfg config.ApprovalConfig) *Queue {
+	return &Queue{rules: cfg.Rules}
+}
+
+// Middleware returns an ingest middleware marking matching emails as
+// pending approval. It must be registered at the notify stage.
+func (queue *Queue) Middleware() pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			if err := next(ctx, delivery); err != nil {
+				return err
+			}
+			queue.Hold(delivery)
+			return nil
+		}
+	}
+}
+
+// Hold records a pending approval on one stored copy of a matching email,
+// with the envelope it will be forwarded with. Holding a single copy keeps
+// the email from being forwarded once per recipient mailbox.
+func (queue *Queue) Hold(delivery *pipeline.Delivery) {
+	msg := delivery.Message
+	if !rules.Any(queue.rules, msg) {
+		return
+	}
+	item, ok := queueCopy(delivery.Stored)
+	if !ok {
+		return
+	}
+
+	approval := &storage.Approval{
+		State:       storage.ApprovalPending,
+		From:        msg.Envelope.From,
+		To:          msg.Envelope.To,
+		RequestedAt: time.Now(),
+	}
+	_, err := item.Storage.UpdateMetadata(item.ID, func(metadata *storage.Metadata) {
+		metadata.Approval = approval
+	})
+	if err != nil {
+		slog.Warn("Holding email for approval failed", "id", item.ID, "error", err)
+		return
+	}
+	slog.Info("Email awaits approval", "id", item.ID, "from", approval.From, "to", approval.To)
+}
+
+// queueCopy returns the sender copy, or the first recipient copy when the
+// sender copy was not stored.
+func queueCopy(stored []pipeline.StoredCopy) (pipeline.StoredCopy, bool) {
+	for _, item := range stored {
+		if item.Direction == storage.Outgoing {
+			return item, true
+		}
+	}
+	if len(stored) > 0 {
+		return stored[0], true
+	}
+	return pipeline.StoredCopy{}, false
+}
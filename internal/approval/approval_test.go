@@ -0,0 +1,74 @@
+package approval
+
+import (
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestHold(t *testing.T) {
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+	content := []byte("From: app@example.org\r\nSubject: Your invoice\r\n\r\nHello\r\n")
+	queue := NewQueue(config.ApprovalConfig{Rules: []rules.Match{{To: "*@customer.com"}}})
+
+	tests := []struct {
+		name     string
+		to       []string
+		pending  bool
+		outgoing bool
+	}{
+		{"matching", []string{"qa@example.com", "jane@customer.com"}, true, true},
+		{"not_matching", []string{"qa@example.com"}, false, true},
+		{"no_sender_copy", []string{"jane@customer.com"}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &message.Message{
+				Envelope: message.Envelope{From: "app@example.org", To: tt.to},
+				Subject:  "Your invoice",
+			}
+			delivery := &pipeline.Delivery{Message: msg}
+			if tt.outgoing {
+				id, err := emailStorage.Store(storage.Outgoing, "example.org", "app", msg.Subject, content)
+				if err != nil {
+					t.Fatalf("storing email failed: %v", err)
+				}
+				delivery.Stored = append(delivery.Stored, pipeline.StoredCopy{Storage: emailStorage, ID: id, Direction: storage.Outgoing})
+			}
+			id, err := emailStorage.Store(storage.Incoming, "example.com", "qa", msg.Subject, content)
+			if err != nil {
+				t.Fatalf("storing email failed: %v", err)
+			}
+			delivery.Stored = append(delivery.Stored, pipeline.StoredCopy{Storage: emailStorage, ID: id, Direction: storage.Incoming})
+
+			queue.Hold(delivery)
+
+			for i, item := range delivery.Stored {
+				email, err := emailStorage.Get(item.ID)
+				if err != nil {
+					t.Fatalf("reading email failed: %v", err)
+				}
+				approval := email.Metadata.Approval
+				if !tt.pending || i > 0 {
+					if approval != nil {
+						t.Errorf("copy %d approval = %+v, want none", i, approval)
+					}
+					continue
+				}
+				if approval == nil || approval.State != storage.ApprovalPending {
+					t.Fatalf("copy %d approval = %+v, want pending", i, approval)
+				}
+				if approval.From != "app@example.org" || len(approval.To) != len(tt.to) {
+					t.Errorf("approval envelope = %s %v, want the original envelope", approval.From, approval.To)
+				}
+			}
+		})
+	}
+}
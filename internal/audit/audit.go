@@ -0,0 +1,56 @@
+// Package audit records administrative actions in an append-only JSON Lines file.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is one recorded action.
+type Entry struct {
+	At     time.Time `json:"at"`
+	Action string    `json:"action"` // e.g. hold, release
+	Target string    `json:"target"` // e.g. message:<id> or mailbox:<domain>/<user>
+	Actor  string    `json:"actor,omitempty"`
+	Remote string    `json:"remote,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Log appends entries to a file. It is safe for concurrent use.
+type Log struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewLog creates a log appending to the file at path.
+func NewLog(path string) *Log {
+	return &Log{path: path}
+}
+
+// Record appends an entry, setting its time when empty.
+func (log *Log) Record(entry Entry) error {
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding audit entry: %w", err)
+	}
+
+	log.mu.Lock()
+	defer log.mu.Unlock()
+
+	file, err := os.OpenFile(log.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,42 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log := NewLog(path)
+
+	for _, action := range []string{"hold", "release"} {
+		if err := log.Record(Entry{Action: action, Target: "message:1"}); err != nil {
+			t.Fatalf("Record() failed: %v", err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening audit log failed: %v", err)
+	}
+	defer file.Close()
+
+	var actions []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("decoding entry failed: %v", err)
+		}
+		if entry.At.IsZero() {
+			t.Error("entry time was not set")
+		}
+		actions = append(actions, entry.Action)
+	}
+	if len(actions) != 2 || actions[0] != "hold" || actions[1] != "release" {
+		t.Errorf("actions = %v, want [hold release]", actions)
+	}
+}
@@ -0,0 +1,141 @@
+// Package auth authenticates API requests and assigns them a role.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Role grants access to a set of API operations. Each role includes the
+// operations of the roles below it.
+type Role int
+
+const (
+	RoleNone     Role = iota
+	RoleReader        // Read emails, holds and statistics
+	RoleReleaser      // Also tag emails and release them to the forwarding server
+	RoleAdmin         // Also delete and purge emails, manage holds and runtime settings
+)
+
+// roleNames maps configuration names to roles.
+var roleNames = map[string]Role{
+	"reader":   RoleReader,
+	"releaser": RoleReleaser,
+	"admin":    RoleAdmin,
+}
+
+// ParseRole parses reader, releaser or admin.
+func ParseRole(name string) (Role, error) {
+	role, ok := roleNames[strings.ToLower(name)]
+	if !ok {
+		return RoleNone, fmt.Errorf("invalid role %q (want reader, releaser or admin)", name)
+	}
+	return role, nil
+}
+
+// String returns the configuration name of the role.
+func (role Role) String() string {
+	for name, r := range roleNames {
+		if r == role {
+			return name
+		}
+	}
+	return "none"
+}
+
+// ErrUnauthenticated is returned for requests without valid credentials.
+var ErrUnauthenticated = errors.New("missing or invalid credentials")
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Name string
+	Role Role
+	User string // User forwarded by the proxy for group principals, if known
+}
+
+// Token grants a role to the bearer of a secret.
+type Token struct {
+	Name   string
+	Secret string
+	Role   Role
+}
+
+// Authenticator identifies requests by bearer token or, behind an
+// authenticating proxy such as an OIDC gateway, by the groups the proxy
+// sets in a trusted header.
+type Authenticator struct {
+	tokens      map[[sha256.Size]byte]Principal
+	groupHeader string
+	userHeader  string
+	groups      map[string]Role
+}
+
+// NewAuthenticator creates an authenticator accepting tokens and, when
+// groupHeader is set, the groups listed in that header. userHeader, when
+// set, names the header in which the proxy passes the user identity.
+func NewAuthenticator(tokens []Token, groupHeader, userHeader string, groups map[string]Role) *Authenticator {
+	authenticator := &Authenticator{
+		tokens:      make(map[[sha256.Size]byte]Principal, len(tokens)),
+		groupHeader: groupHeader,
+		userHeader:  userHeader,
+		groups:      groups,
+	}
+	for _, token := range tokens {
+		// Looking up hashes keeps the comparison independent of the secret
+		authenticator.tokens[sha256.Sum256([]byte(token.Secret))] = Principal{Name: token.Name, Role: token.Role}
+	}
+	return authenticator
+}
+
+// Authenticate returns the caller of r. A bearer token takes precedence
+// over the group header; with several groups the highest role wins.
+func (authenticator *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, secret, found := strings.Cut(header, " ")
+		if !found || !strings.EqualFold(scheme, "Bearer") {
+			return Principal{}, ErrUnauthenticated
+		}
+		principal, ok := authenticator.tokens[sha256.Sum256([]byte(strings.TrimSpace(secret)))]
+		if !ok {
+			return Principal{}, ErrUnauthenticated
+		}
+		return principal, nil
+	}
+
+	if authenticator.groupHeader == "" {
+		return Principal{}, ErrUnauthenticated
+	}
+
+	principal := Principal{}
+	for _, group := range strings.Split(r.Header.Get(authenticator.groupHeader), ",") {
+		group = strings.TrimSpace(group)
+		if role := authenticator.groups[group]; role > principal.Role {
+			principal = Principal{Name: "group:" + group, Role: role}
+		}
+	}
+	if principal.Role == RoleNone {
+		return Principal{}, ErrUnauthenticated
+	}
+	if authenticator.userHeader != "" {
+		principal.User = strings.TrimSpace(r.Header.Get(authenticator.userHeader))
+	}
+	return principal, nil
+}
+
+// principalKey is the context key of the authenticated principal.
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated caller.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the authenticated caller carried by ctx.
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+	authenticator := NewAuthenticator(
+		[]Token{{Name: "ci", Secret: "s3cret", Role: RoleReader}},
+		"X-Forwarded-Groups",
+		"X-Forwarded-User",
+		map[string]Role{"qa": RoleReleaser, "platform": RoleAdmin},
+	)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    Principal
+		wantErr bool
+	}{
+		{name: "token", headers: map[string]string{"Authorization": "Bearer s3cret"}, want: Principal{Name: "ci", Role: RoleReader}},
+		{name: "wrong_token", headers: map[string]string{"Authorization": "Bearer nope"}, wantErr: true},
+		{name: "basic_scheme", headers: map[string]string{"Authorization": "Basic s3cret"}, wantErr: true},
+		{name: "group", headers: map[string]string{"X-Forwarded-Groups": "staff, qa"}, want: Principal{Name: "group:qa", Role: RoleReleaser}},
+		{name: "highest_group", headers: map[string]string{"X-Forwarded-Groups": "qa,platform"}, want: Principal{Name: "group:platform", Role: RoleAdmin}},
+		{name: "group_user", headers: map[string]string{"X-Forwarded-Groups": "qa", "X-Forwarded-User": "alice"}, want: Principal{Name: "group:qa", Role: RoleReleaser, User: "alice"}},
+		{name: "unknown_group", headers: map[string]string{"X-Forwarded-Groups": "staff"}, wantErr: true},
+		{name: "token_wins", headers: map[string]string{"Authorization": "Bearer s3cret", "X-Forwarded-Groups": "platform"}, want: Principal{Name: "ci", Role: RoleReader}},
+		{name: "anonymous", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+
+			got, err := authenticator.Authenticate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Authenticate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	for _, name := range []string{"reader", "releaser", "Admin"} {
+		role, err := ParseRole(name)
+		if err != nil {
+			t.Fatalf("ParseRole(%q) failed: %v", name, err)
+		}
+		if role.String() == "none" {
+			t.Errorf("ParseRole(%q) = %v", name, role)
+		}
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("ParseRole(root) succeeded")
+	}
+}
@@ -0,0 +1,379 @@
+// Package calendar answers the meeting invitations captured by the sink
+// with iCalendar replies (RFC 5546 METHOD:REPLY), so calendar-invite
+// workflows can be tested end to end: the organizer receives an accept,
+// decline or tentative answer from every invited test mailbox.
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/worker"
+)
+
+// Replies are relayed by relayWorkers goroutines; up to queueSize more wait
+// for one, and later ones are dropped.
+const (
+	relayWorkers = 4
+	queueSize    = 1000
+)
+
+// Relayer sends the replies to the organizers.
+type Relayer interface {
+	Relay(from string, to []string, content []byte) error
+}
+
+// Invitation is the event of a METHOD:REQUEST calendar object.
+type Invitation struct {
+	UID           string
+	Sequence      string
+	RecurrenceID  string // Full RECURRENCE-ID property, for one occurrence of a series
+	Summary       string
+	Organizer     string   // Address of the organizer
+	Attendees     []string // Addresses of the attendees, lowercased
+	organizerLine string   // ORGANIZER property as received
+}
+
+// partStats maps the configured responses to attendee participation
+// statuses and the subject prefix of the reply.
+var partStats = map[string]struct{ status, subject string }{
+	"accept":    {"ACCEPTED", "Accepted"},
+	"decline":   {"DECLINED", "Declined"},
+	"tentative": {"TENTATIVE", "Tentative"},
+}
+
+// reply answers the invitations matching rules with response.
+type reply struct {
+	response string
+	rules    []rules.Match
+}
+
+// Responder answers invitations according to the configured replies.
+type Responder struct {
+	replies []reply
+	relay   Relayer
+	now     func() time.Time
+	pool    *worker.Pool
+}
+
+// NewResponder creates a responder sending the replies of cfg through relay.
+func NewResponder(cfg config.CalendarConfig, relay Relayer) *Responder {
+	responder := &Responder{relay: relay, now: time.Now, pool: worker.NewPool(relayWorkers, queueSize)}
+	for _, r := range cfg.Replies {
+		responder.replies = append(responder.replies, reply{response: r.Response, rules: r.Rules})
+	}
+	return responder
+}
+
+// Middleware returns an ingest middleware answering the invitations among
+// the stored emails. It must be registered at the notify stage.
+func (responder *Responder) Middleware() pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			if err := next(ctx, delivery); err != nil {
+				return err
+			}
+			responder.Respond(delivery.Message)
+			return nil
+		}
+	}
+}
+
+// Respond queues the reply of every invited recipient of msg matched by a
+// configured reply, once per attendee however its address is cased.
+// Replies are dropped while the queue is full.
+func (responder *Responder) Respond(msg *message.Message) {
+	invitation, ok := FindInvitation(msg)
+	if !ok {
+		return
+	}
+
+	answered := make(map[string]bool)
+	for _, recipient := range msg.Envelope.To {
+		attendee := strings.ToLower(recipient)
+		if answered[attendee] || !invited(invitation, attendee) {
+			continue
+		}
+		response, ok := responder.responseFor(msg, recipient)
+		if !ok {
+			continue
+		}
+		answered[attendee] = true
+
+		content := Reply(invitation, attendee, response, msg.Header.Get("Message-ID"), responder.now())
+		queued := responder.pool.Submit(func() {
+			if err := responder.relay.Relay(attendee, []string{invitation.Organizer}, content); err != nil {
+				slog.Warn("Calendar reply failed", "uid", invitation.UID, "attendee", attendee, "error", err)
+				return
+			}
+			slog.Info("Calendar reply sent", "uid", invitation.UID, "attendee", attendee, "response", response)
+		})
+		if !queued {
+			slog.Debug("Calendar reply dropped, queue full", "uid", invitation.UID, "attendee", attendee)
+		}
+	}
+}
+
+// responseFor returns the response of the first reply whose rules select
+// msg as if recipient were its only recipient.
+func (responder *Responder) responseFor(msg *message.Message, recipient string) (string, bool) {
+	single := *msg
+	single.Envelope.To = []string{recipient}
+	for _, reply := range responder.replies {
+		if rules.Any(reply.rules, &single) {
+			return reply.response, true
+		}
+	}
+	return "", false
+}
+
+// Wait blocks until the queued replies are sent or ctx expires.
+func (responder *Responder) Wait(ctx context.Context) error {
+	return responder.pool.Wait(ctx)
+}
+
+// Collect returns the calendar reply metrics.
+func (responder *Responder) Collect() []metrics.Family {
+	return []metrics.Family{{
+		Name:    "gargantua_calendar_replies_dropped_total",
+		Help:    "Calendar replies dropped because the relay queue was full.",
+		Type:    metrics.Counter,
+		Samples: []metrics.Sample{{Value: float64(responder.pool.Dropped())}},
+	}}
+}
+
+// invited reports whether attendee, lowercased, is listed in invitation.
+func invited(invitation *Invitation, attendee string) bool {
+	for _, address := range invitation.Attendees {
+		if address == attendee {
+			return true
+		}
+	}
+	return false
+}
+
+// FindInvitation returns the invitation carried by a text/calendar part of
+// msg, if any.
+func FindInvitation(msg *message.Message) (*Invitation, bool) {
+	for _, part := range msg.Parts {
+		if part.ContentType != "text/calendar" && part.ContentType != "application/ics" {
+			continue
+		}
+		if invitation, ok := ParseInvitation(part.Content); ok {
+			return invitation, true
+		}
+	}
+	return nil, false
+}
+
+// ParseInvitation reads the first event of a calendar object with
+// METHOD:REQUEST, which needs a UID and an organizer to be answered.
+func ParseInvitation(content []byte) (*Invitation, bool) {
+	var (
+		invitation Invitation
+		method     string
+		inEvent    bool
+		events     int
+	)
+	for _, line := range unfold(content) {
+		name, value := parseProperty(line)
+		switch {
+		case name == "METHOD" && !inEvent:
+			method = strings.ToUpper(value)
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			inEvent = true
+			events++
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			inEvent = false
+		case !inEvent || events > 1:
+		case name == "UID":
+			invitation.UID = value
+		case name == "SEQUENCE":
+			invitation.Sequence = value
+		case name == "RECURRENCE-ID":
+			invitation.RecurrenceID = line
+		case name == "SUMMARY":
+			invitation.Summary = unescape(value)
+		case name == "ORGANIZER":
+			invitation.Organizer = calAddress(value)
+			invitation.organizerLine = line
+		case name == "ATTENDEE":
+			if address := calAddress(value); address != "" {
+				invitation.Attendees = append(invitation.Attendees, strings.ToLower(address))
+			}
+		}
+	}
+
+	if method != "REQUEST" || invitation.UID == "" || invitation.Organizer == "" {
+		return nil, false
+	}
+	return &invitation, true
+}
+
+// Reply builds the email answering invitation on behalf of attendee with
+// response (accept, decline or tentative), threaded under the invitation
+// with Message-ID inReplyTo when set.
+func Reply(invitation *Invitation, attendee, response, inReplyTo string, now time.Time) []byte {
+	stat := partStats[response]
+
+	var ics bytes.Buffer
+	writeLine := func(line string) { ics.WriteString(fold(line)) }
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("PRODID:-//Gargantua Sink//Calendar Responder//EN")
+	writeLine("VERSION:2.0")
+	writeLine("METHOD:REPLY")
+	writeLine("BEGIN:VEVENT")
+	writeLine("UID:" + invitation.UID)
+	if invitation.Sequence != "" {
+		writeLine("SEQUENCE:" + invitation.Sequence)
+	}
+	if invitation.RecurrenceID != "" {
+		writeLine(invitation.RecurrenceID)
+	}
+	writeLine("DTSTAMP:" + now.UTC().Format("20060102T150405Z"))
+	writeLine(invitation.organizerLine)
+	writeLine("ATTENDEE;PARTSTAT=" + stat.status + ":mailto:" + attendee)
+	if invitation.Summary != "" {
+		writeLine("SUMMARY:" + escape(invitation.Summary))
+	}
+	writeLine("END:VEVENT")
+	writeLine("END:VCALENDAR")
+
+	subject := stat.subject
+	if invitation.Summary != "" {
+		subject += ": " + invitation.Summary
+	}
+
+	var email bytes.Buffer
+	fmt.Fprintf(&email, "From: %s\r\n", attendee)
+	fmt.Fprintf(&email, "To: %s\r\n", invitation.Organizer)
+	fmt.Fprintf(&email, "Subject: %s\r\n", encodeHeader(subject))
+	fmt.Fprintf(&email, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&email, "Message-ID: <%s@gargantua-sink>\r\n", randomID())
+	if inReplyTo != "" {
+		fmt.Fprintf(&email, "In-Reply-To: %s\r\n", inReplyTo)
+	}
+	email.WriteString("MIME-Version: 1.0\r\n")
+	email.WriteString("Content-Type: text/calendar; method=REPLY; charset=UTF-8\r\n")
+	email.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	email.WriteString("\r\n")
+	email.Write(ics.Bytes())
+	return email.Bytes()
+}
+
+// unfold splits calendar content into logical lines, joining the
+// continuation lines that start with a space or a tab.
+func unfold(content []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// fold ends line with CRLF, breaking it into continuation lines of at most
+// 75 octets without splitting UTF-8 sequences.
+func fold(line string) string {
+	var folded strings.Builder
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		folded.WriteString(line[:cut])
+		folded.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74 // The leading space counts
+	}
+	folded.WriteString(line)
+	folded.WriteString("\r\n")
+	return folded.String()
+}
+
+// parseProperty splits a content line into its upper-cased name and its
+// value, dropping the parameters. Colons within quoted parameter values do
+// not end them.
+func parseProperty(line string) (name, value string) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if quoted {
+				continue
+			}
+			name, _, _ = strings.Cut(line[:i], ";")
+			return strings.ToUpper(name), line[i+1:]
+		}
+	}
+	return strings.ToUpper(line), ""
+}
+
+// calAddress returns the email address of a CAL-ADDRESS value such as
+// mailto:alice@example.com.
+func calAddress(value string) string {
+	if len(value) >= 7 && strings.EqualFold(value[:7], "mailto:") {
+		value = value[7:]
+	}
+	if address, err := mail.ParseAddress(value); err == nil {
+		return address.Address
+	}
+	return ""
+}
+
+// unescaper decodes the escaped characters of TEXT values.
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// escaper encodes the special characters of TEXT values.
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// unescape decodes a TEXT value.
+func unescape(value string) string {
+	return unescaper.Replace(value)
+}
+
+// escape encodes a TEXT value.
+func escape(value string) string {
+	return escaper.Replace(value)
+}
+
+// encodeHeader encodes a header value as an RFC 2047 word when it is not
+// plain ASCII, and removes line breaks.
+func encodeHeader(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return mime.QEncoding.Encode("UTF-8", value)
+		}
+	}
+	return value
+}
+
+// randomID returns a random hexadecimal identifier for Message-ID headers.
+func randomID() string {
+	var data [12]byte
+	rand.Read(data[:])
+	return hex.EncodeToString(data[:])
+}
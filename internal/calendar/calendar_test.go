@@ -0,0 +1,135 @@
+package calendar
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+)
+
+// invite is a meeting invitation as sent by common calendar clients.
+const invite = "BEGIN:VCALENDAR\r\n" +
+	"PRODID:-//Example//EN\r\n" +
+	"VERSION:2.0\r\n" +
+	"METHOD:REQUEST\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Berlin\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting-42@example.org\r\n" +
+	"SEQUENCE:2\r\n" +
+	"DTSTART;TZID=Europe/Berlin:20261020T100000\r\n" +
+	"SUMMARY:Quarterly review\\, Q4\r\n" +
+	"ORGANIZER;CN=\"Boss: Office\":mailto:boss@example.org\r\n" +
+	"ATTENDEE;CN=Alice;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:Alice@example.com\r\n" +
+	"ATTENDEE;CN=Bob;PARTSTAT=NEEDS-ACTION;RSVP=TRUE:mailto:bob@exa\r\n" +
+	" mple.com\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+// fakeRelay records the emails relayed.
+type fakeRelay struct {
+	mu    sync.Mutex
+	sent  map[string]string // Content by sender
+	count int
+}
+
+func (relay *fakeRelay) Relay(from string, to []string, content []byte) error {
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	relay.sent[from] = strings.Join(to, ",") + "\n" + string(content)
+	relay.count++
+	return nil
+}
+
+func TestParseInvitation(t *testing.T) {
+	invitation, ok := ParseInvitation([]byte(invite))
+	if !ok {
+		t.Fatal("ParseInvitation() found no invitation")
+	}
+	if invitation.UID != "meeting-42@example.org" || invitation.Sequence != "2" || invitation.Summary != "Quarterly review, Q4" {
+		t.Errorf("invitation = %+v, want meeting-42 sequence 2", invitation)
+	}
+	if invitation.Organizer != "boss@example.org" {
+		t.Errorf("organizer = %q, want boss@example.org", invitation.Organizer)
+	}
+	if strings.Join(invitation.Attendees, ",") != "alice@example.com,bob@example.com" {
+		t.Errorf("attendees = %v, want alice and bob", invitation.Attendees)
+	}
+
+	reply := strings.Replace(invite, "METHOD:REQUEST", "METHOD:REPLY", 1)
+	if _, ok := ParseInvitation([]byte(reply)); ok {
+		t.Error("ParseInvitation() accepted a reply")
+	}
+}
+
+func TestResponder(t *testing.T) {
+	relay := &fakeRelay{sent: make(map[string]string)}
+	responder := NewResponder(config.CalendarConfig{Replies: []config.CalendarReplyConfig{
+		{Response: "decline", Rules: []rules.Match{{To: "bob@*"}}},
+		{Response: "accept"},
+	}}, relay)
+	responder.now = func() time.Time { return time.Date(2026, 10, 15, 8, 0, 0, 0, time.UTC) }
+
+	msg := &message.Message{
+		Envelope: message.Envelope{From: "boss@example.org", To: []string{"alice@example.com", "bob@example.com", "Alice@Example.com", "carol@example.com"}},
+		Header:   map[string][]string{"Message-Id": {"<invite@example.org>"}},
+		Parts: []message.Part{
+			{ContentType: "text/plain", Content: []byte("You are invited")},
+			{ContentType: "text/calendar", Content: []byte(invite)},
+		},
+	}
+	responder.Respond(msg)
+	if err := responder.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() failed: %v", err)
+	}
+
+	if len(relay.sent) != 2 || relay.count != 2 {
+		t.Fatalf("relayed %d replies, want 2 (alice once, carol is not invited)", relay.count)
+	}
+	tests := []struct {
+		attendee string
+		want     []string
+	}{
+		{"alice@example.com", []string{
+			"boss@example.org\n",
+			"Subject: Accepted: Quarterly review, Q4\r\n",
+			"In-Reply-To: <invite@example.org>\r\n",
+			"METHOD:REPLY\r\n",
+			"SEQUENCE:2\r\n",
+			"DTSTAMP:20261015T080000Z\r\n",
+			"ORGANIZER;CN=\"Boss: Office\":mailto:boss@example.org\r\n",
+			"ATTENDEE;PARTSTAT=ACCEPTED:mailto:alice@example.com\r\n",
+		}},
+		{"bob@example.com", []string{
+			"Subject: Declined: Quarterly review, Q4\r\n",
+			"ATTENDEE;PARTSTAT=DECLINED:mailto:bob@example.com\r\n",
+		}},
+	}
+	for _, tt := range tests {
+		content := relay.sent[tt.attendee]
+		for _, want := range tt.want {
+			if !strings.Contains(content, want) {
+				t.Errorf("reply of %s lacks %q:\n%s", tt.attendee, want, content)
+			}
+		}
+	}
+}
+
+func TestFold(t *testing.T) {
+	line := "SUMMARY:" + strings.Repeat("é", 60)
+	folded := fold(line)
+	for _, physical := range strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n") {
+		if len(physical) > 75 {
+			t.Errorf("folded line of %d octets, want at most 75", len(physical))
+		}
+	}
+	if got := strings.Join(unfold([]byte(folded)), ""); got != line {
+		t.Errorf("unfold(fold()) = %q, want %q", got, line)
+	}
+}
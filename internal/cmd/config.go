@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// showEffective prints the merged configuration instead of the file alone
+	showEffective bool
+
+	configCmd = &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the server configuration",
+	}
+
+	configShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Print the configuration with secrets redacted",
+		Long: `Print the configuration with secrets redacted.
+
+Without flags only the values from the configuration file are shown. With
+--effective the output is the merged result of defaults, the configuration
+file, GARGANTUA_* environment variables and command-line flags, exactly as
+the server would use it.`,
+		Args: cobra.NoArgs,
+		RunE: runConfigShow,
+	}
+)
+
+func init() {
+	configShowCmd.Flags().BoolVar(&showEffective, "effective", false, "Show the merged configuration (defaults, file, environment and flags)")
+
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+// runConfigShow prints the file or effective configuration.
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	var cfg *config.Config
+
+	if showEffective {
+		var err error
+		if cfg, err = loadConfig(cmd); err != nil {
+			return err
+		}
+	} else {
+		if configPath == "" {
+			return errors.New("no configuration file given; use --config or --effective")
+		}
+
+		cfg = &config.Config{}
+		if err := config.LoadFile(cfg, configPath); err != nil {
+			return err
+		}
+	}
+
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		return fmt.Errorf("rendering configuration: %w", err)
+	}
+
+	_, err = cmd.OutOrStdout().Write(out)
+	return err
+}
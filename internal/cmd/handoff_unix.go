@@ -0,0 +1,40 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/listen"
+)
+
+// handoffOnSignal starts a new instance of the binary inheriting listeners
+// when the process receives SIGUSR2, then calls stop so this process drains
+// its open sessions and exits. Until then, the new process accepts new
+// connections on the same sockets.
+func handoffOnSignal(ctx context.Context, listeners map[string]net.Listener, stop context.CancelFunc) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	defer signal.Stop(signals)
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-signals:
+	}
+
+	process, err := listen.Handoff(listeners)
+	if err != nil {
+		log.Printf("Error handing over listeners: %v", err)
+		return
+	}
+
+	log.Printf("Handed over listeners to new process %d, draining open sessions", process.Pid)
+	process.Release()
+	stop()
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package cmd
+
+import (
+	"context"
+	"net"
+)
+
+// handoffOnSignal is a no-op on Windows, which has no SIGUSR2 and cannot
+// pass sockets to a child process.
+func handoffOnSignal(ctx context.Context, listeners map[string]net.Listener, stop context.CancelFunc) {
+}
@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Listing filters and output
+	listFilter listFilterFlags
+	listLimit  int
+	listJSON   bool
+
+	// Search filters and output
+	searchFilter listFilterFlags
+	searchLimit  int
+	searchJSON   bool
+
+	// showRaw prints the raw content instead of a summary
+	showRaw bool
+
+	listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List stored emails, newest first",
+		Args:  cobra.NoArgs,
+		RunE:  runList,
+
+		SilenceUsage: true,
+	}
+
+	searchCmd = &cobra.Command{
+		Use:   "search <text>",
+		Short: "Find emails whose subject, addresses or text body contain text",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runSearch,
+
+		SilenceUsage: true,
+	}
+
+	showCmd = &cobra.Command{
+		Use:   "show <id>",
+		Short: "Print the headers, attachments and text body of an email",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runShow,
+
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	listFilter.register(listCmd)
+	listCmd.Flags().IntVarP(&listLimit, "limit", "n", 0, "Maximum number of emails (0 for all)")
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "Print the emails as JSON")
+	addSourceFlags(listCmd)
+
+	searchFilter.register(searchCmd)
+	searchCmd.Flags().IntVarP(&searchLimit, "limit", "n", 0, "Maximum number of emails (0 for all)")
+	searchCmd.Flags().BoolVar(&searchJSON, "json", false, "Print the emails as JSON")
+	addSourceFlags(searchCmd)
+
+	showCmd.Flags().BoolVar(&showRaw, "raw", false, "Print the raw .eml content")
+	addSourceFlags(showCmd)
+
+	rootCmd.AddCommand(listCmd, searchCmd, showCmd)
+}
+
+// options converts the flags to API list options.
+func (flags *listFilterFlags) options() client.ListOptions {
+	return client.ListOptions{
+		Domain:    flags.domain,
+		User:      flags.user,
+		Direction: strings.ToUpper(flags.direction),
+		Tag:       flags.tag,
+		Language:  flags.language,
+		Folder:    flags.folder,
+	}
+}
+
+// runList prints the emails matching the filters.
+func runList(cmd *cobra.Command, args []string) error {
+	opts := listFilter.options()
+	opts.Limit = listLimit
+	return printMessages(cmd, opts, listJSON)
+}
+
+// runSearch prints the emails matching the filters and the search text.
+func runSearch(cmd *cobra.Command, args []string) error {
+	opts := searchFilter.options()
+	opts.Query = args[0]
+	opts.Limit = searchLimit
+	return printMessages(cmd, opts, searchJSON)
+}
+
+// printMessages lists emails from the source as a table or JSON.
+func printMessages(cmd *cobra.Command, opts client.ListOptions, asJSON bool) error {
+	source, err := openSource(cmd)
+	if err != nil {
+		return err
+	}
+	messages, err := source.List(cmd.Context(), opts)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		if messages == nil {
+			messages = []client.Message{}
+		}
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(messages)
+	}
+	return writeMessageTable(cmd.OutOrStdout(), messages)
+}
+
+// writeMessageTable prints a table with one email per line.
+func writeMessageTable(w io.Writer, messages []client.Message) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tDIR\tMAILBOX\tRECEIVED\tSIZE\tSUBJECT\tTAGS")
+	for _, msg := range messages {
+		fmt.Fprintf(table, "%s\t%s\t%s@%s\t%s\t%d\t%s\t%s\n",
+			msg.ID, msg.Direction, msg.User, msg.Domain,
+			msg.ReceivedAt.Local().Format(time.DateTime), msg.Size,
+			msg.Subject, strings.Join(msg.Metadata.Tags, ","))
+	}
+	return table.Flush()
+}
+
+// runShow prints an email.
+func runShow(cmd *cobra.Command, args []string) error {
+	source, err := openSource(cmd)
+	if err != nil {
+		return err
+	}
+	raw, err := source.Raw(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if showRaw {
+		_, err := out.Write(raw)
+		return err
+	}
+
+	msg := message.Parse(message.Envelope{}, message.Bytes(raw))
+	for _, header := range []string{"From", "To", "Cc", "Date"} {
+		if value := msg.Header.Get(header); value != "" {
+			fmt.Fprintf(out, "%s: %s\n", header, value)
+		}
+	}
+	fmt.Fprintf(out, "Subject: %s\n", msg.Subject)
+	if link := source.URL(args[0]); link != "" {
+		fmt.Fprintf(out, "URL: %s\n", link)
+	}
+	for _, attachment := range msg.Attachments() {
+		fmt.Fprintf(out, "Attachment: %s (%s, %d bytes)\n", attachment.Filename, attachment.ContentType, attachment.Size)
+	}
+	if msg.ParseError != "" {
+		fmt.Fprintf(out, "Parse error: %s\n", msg.ParseError)
+	}
+
+	_, err = fmt.Fprintf(out, "\n%s\n", strings.TrimRight(msg.Text(), "\r\n"))
+	return err
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Purge filters and safety switches
+	purgeFilter listFilterFlags
+	purgeAll    bool
+	purgeDryRun bool
+
+	purgeCmd = &cobra.Command{
+		Use:   "purge",
+		Short: "Delete the stored emails matching the filters",
+		Long: `Delete the stored emails matching the filters.
+
+At least one filter is required, or --all to delete every email. Emails on
+legal hold are skipped and reported. Use --dry-run to list what would be
+deleted; held emails are only counted.`,
+		Args: cobra.NoArgs,
+		RunE: runPurge,
+	}
+)
+
+func init() {
+	purgeFilter.register(purgeCmd)
+	purgeCmd.Flags().BoolVar(&purgeAll, "all", false, "Delete every email when no filter is given")
+	purgeCmd.Flags().BoolVar(&purgeDryRun, "dry-run", false, "List the emails that would be deleted")
+	addSourceFlags(purgeCmd)
+	rootCmd.AddCommand(purgeCmd)
+}
+
+// runPurge deletes the selected emails.
+func runPurge(cmd *cobra.Command, args []string) error {
+	opts := purgeFilter.options()
+	if opts.Domain == "" && opts.User == "" && opts.Direction == "" && opts.Tag == "" && !purgeAll {
+		return errors.New("no filter given; use --domain, --user, --direction, --tag or --all")
+	}
+
+	source, err := openSource(cmd)
+	if err != nil {
+		return err
+	}
+	messages, err := source.List(cmd.Context(), opts)
+	if err != nil {
+		return err
+	}
+
+	return purge(cmd.Context(), source, messages, purgeDryRun, cmd.OutOrStdout(), cmd.ErrOrStderr())
+}
+
+// purge deletes messages from source, skipping the ones on legal hold, or
+// with dryRun lists the ones that would be deleted and counts the held ones.
+func purge(ctx context.Context, source messageSource, messages []client.Message, dryRun bool, out, errOut io.Writer) error {
+	var deletable, held []client.Message
+	for _, msg := range messages {
+		if msg.Held {
+			held = append(held, msg)
+		} else {
+			deletable = append(deletable, msg)
+		}
+	}
+
+	if dryRun {
+		if err := writeMessageTable(out, deletable); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(out, "\n%d email(s) would be deleted, %d on hold would be skipped\n", len(deletable), len(held))
+		return err
+	}
+
+	deleted, skipped := 0, 0
+	for _, msg := range held {
+		fmt.Fprintf(errOut, "Skipped %s: %v\n", msg.ID, storage.ErrOnHold)
+		skipped++
+	}
+	for _, msg := range deletable {
+		if err := source.Delete(ctx, msg.ID); err != nil {
+			fmt.Fprintf(errOut, "Skipped %s: %v\n", msg.ID, err)
+			skipped++
+			continue
+		}
+		deleted++
+	}
+
+	_, err := fmt.Fprintf(out, "Deleted %d email(s), skipped %d\n", deleted, skipped)
+	return err
+}
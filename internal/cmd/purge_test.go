@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+)
+
+func TestPurgeSkipsHeld(t *testing.T) {
+	emailStorage, john, jane := newHeldStorage(t)
+	source := localSource{storages: []*storage.EmailStorage{emailStorage}}
+	ctx := context.Background()
+
+	messages, err := source.List(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+
+	var out, errOut bytes.Buffer
+	if err := purge(ctx, source, messages, true, &out, &errOut); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if !strings.Contains(out.String(), john) || strings.Contains(out.String(), jane) {
+		t.Errorf("dry run listed:\n%s\nwant only %s", out.String(), john)
+	}
+	if !strings.Contains(out.String(), "1 email(s) would be deleted, 1 on hold would be skipped") {
+		t.Errorf("dry run summary:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := purge(ctx, source, messages, false, &out, &errOut); err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if got := out.String(); got != "Deleted 1 email(s), skipped 1\n" {
+		t.Errorf("purge output = %q", got)
+	}
+	if !strings.Contains(errOut.String(), "Skipped "+jane) {
+		t.Errorf("purge errors = %q, want the held email reported", errOut.String())
+	}
+	if _, err := emailStorage.Get(john); err != storage.ErrNotFound {
+		t.Errorf("Get() of the purged email error = %v, want ErrNotFound", err)
+	}
+	if _, err := emailStorage.Get(jane); err != nil {
+		t.Errorf("Get() of the held email failed: %v", err)
+	}
+}
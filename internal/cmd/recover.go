@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/spool"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// recoveryAge is how old leftovers must be before the scan touches them
+// while a previous instance may still be draining, leaving its files alone.
+const recoveryAge = 10 * time.Minute
+
+// recoverStorages repairs what a crash left behind in every storage and
+// the spool directory, and logs a summary so operators know whether mail
+// was lost. When another instance may still be draining, after a handoff
+// or with reuse_port, only leftovers older than recoveryAge are repaired
+// and the scan runs again once the skipped ones are old enough; otherwise,
+// as after a crash, every leftover is repaired at once.
+func recoverStorages(storages []*storage.EmailStorage, spoolDir string, draining bool) error {
+	if !draining {
+		return scanLeftovers(storages, spoolDir, 0)
+	}
+	if err := scanLeftovers(storages, spoolDir, recoveryAge); err != nil {
+		return err
+	}
+	time.AfterFunc(recoveryAge, func() {
+		if err := scanLeftovers(storages, spoolDir, recoveryAge); err != nil {
+			log.Printf("Error rescanning for leftovers of the previous instance: %v", err)
+		}
+	})
+	return nil
+}
+
+// scanLeftovers repairs the leftovers last modified more than olderThan ago
+// and logs a summary.
+func scanLeftovers(storages []*storage.EmailStorage, spoolDir string, olderThan time.Duration) error {
+	clean := true
+	for _, emailStorage := range storages {
+		report, err := emailStorage.Recover(olderThan)
+		if err != nil {
+			return err
+		}
+		if report.Clean() {
+			continue
+		}
+		clean = false
+
+		log.Printf("Recovery scan of %s: %d incomplete file(s) quarantined, %d metadata update(s) completed, %d probe file(s) removed, %d recent file(s) skipped",
+			emailStorage.Root(), len(report.Quarantined), report.Completed, report.Removed, report.Skipped)
+		if len(report.Quarantined) > 0 {
+			log.Printf("Quarantined in %s: %s", emailStorage.QuarantinePath(), strings.Join(report.Quarantined, ", "))
+			log.Printf("Incomplete emails were never acknowledged to their senders, which retry them; no accepted mail was lost")
+		}
+	}
+
+	removed, err := spool.Sweep(spoolDir, olderThan)
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Printf("Recovery scan: removed %d spool file(s) of interrupted transactions, which were never acknowledged", removed)
+	} else if clean {
+		log.Printf("Recovery scan found no leftovers of an interrupted run")
+	}
+	return nil
+}
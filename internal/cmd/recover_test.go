@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestRecoverStorages(t *testing.T) {
+	for _, draining := range []bool{false, true} {
+		emailStorage, err := storage.NewEmailStorage(t.TempDir())
+		if err != nil {
+			t.Fatalf("creating storage failed: %v", err)
+		}
+		dir := filepath.Join(emailStorage.Root(), "example.com", "john", "IN")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("creating mailbox failed: %v", err)
+		}
+
+		// Left seconds ago by a crash, as a supervisor restarts at once
+		leftover := filepath.Join(dir, "20240101000000-deadbeef-x.eml.tmp")
+		if err := os.WriteFile(leftover, []byte("partial"), 0644); err != nil {
+			t.Fatalf("writing leftover failed: %v", err)
+		}
+
+		if err := recoverStorages([]*storage.EmailStorage{emailStorage}, t.TempDir(), draining); err != nil {
+			t.Fatalf("recoverStorages() failed: %v", err)
+		}
+		if _, err := os.Stat(leftover); os.IsNotExist(err) == draining {
+			t.Errorf("recent leftover present = %v with draining = %v", err == nil, draining)
+		}
+	}
+}
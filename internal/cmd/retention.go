@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/retention"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Retention policy override and safety switch
+	retentionMaxAge time.Duration
+	retentionApply  bool
+
+	retentionCmd = &cobra.Command{
+		Use:   "retention",
+		Short: "Report what the retention policy would delete",
+		Long: `Report what the retention policy would delete, per domain: how many
+emails and bytes, and the oldest and newest of them. Nothing is deleted
+unless --apply is given.
+
+The policy is storage.retention from the configuration, or the policy of
+the server with --server. --max-age tries another max age for every domain.
+Emails on legal hold or pending approval are never deleted and are
+counted apart as held.`,
+		Args: cobra.NoArgs,
+		RunE: runRetention,
+	}
+)
+
+func init() {
+	retentionCmd.Flags().DurationVar(&retentionMaxAge, "max-age", 0, "Try this max age for every domain instead of the configured policy, e.g. 720h")
+	retentionCmd.Flags().BoolVar(&retentionApply, "apply", false, "Delete the expired emails (storage directories only)")
+	addSourceFlags(retentionCmd)
+	rootCmd.AddCommand(retentionCmd)
+}
+
+// runRetention prints the retention report and applies it when asked.
+func runRetention(cmd *cobra.Command, args []string) error {
+	if serverURL != "" {
+		if retentionApply {
+			return errors.New("--apply works on the storage directories only; run it without --server")
+		}
+		report, err := newAPIClient().Retention(cmd.Context(), retentionMaxAge)
+		if err != nil {
+			return err
+		}
+		return writeRetentionReport(cmd.OutOrStdout(), report, false)
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	storages, err := openStorages(cfg)
+	if err != nil {
+		return err
+	}
+
+	policy := retention.NewPolicy(cfg.Storage.Retention)
+	if retentionMaxAge > 0 {
+		policy = retention.Policy{MaxAge: retentionMaxAge}
+	}
+	items, err := retention.Collect(storages)
+	if err != nil {
+		return err
+	}
+	report, expired := retention.Plan(policy, items, time.Now())
+
+	out := cmd.OutOrStdout()
+	if err := writeRetentionReport(out, toClientReport(report), retentionApply); err != nil {
+		return err
+	}
+	if !retentionApply {
+		return nil
+	}
+
+	source := localSource{storages: storages}
+	deleted, skipped := 0, 0
+	for _, id := range expired {
+		if err := source.Delete(cmd.Context(), id); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "Skipped %s: %v\n", id, err)
+			skipped++
+			continue
+		}
+		deleted++
+	}
+
+	_, err = fmt.Fprintf(out, "\nDeleted %d email(s), skipped %d\n", deleted, skipped)
+	return err
+}
+
+// writeRetentionReport prints one line per domain and the totals.
+func writeRetentionReport(w io.Writer, report client.RetentionReport, applying bool) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "DOMAIN\tMAX AGE\tEMAILS\tBYTES\tOLDEST\tNEWEST\tHELD\tKEPT")
+	for _, domain := range report.Domains {
+		maxAge := domain.MaxAge
+		if maxAge == "" {
+			maxAge = "forever"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%s\t%s\t%d\t%d\n",
+			domain.Domain, maxAge, domain.Messages, domain.Bytes,
+			formatReportTime(domain.Oldest), formatReportTime(domain.Newest),
+			domain.Held, domain.Kept)
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+
+	verb := "would be"
+	if applying {
+		verb = "will be"
+	}
+	_, err := fmt.Fprintf(w, "\n%d email(s), %d byte(s) %s deleted\n", report.Messages, report.Bytes, verb)
+	return err
+}
+
+// formatReportTime formats an optional report date.
+func formatReportTime(at *time.Time) string {
+	if at == nil {
+		return "-"
+	}
+	return at.Local().Format(time.DateTime)
+}
+
+// toClientReport describes a retention report like the API does.
+func toClientReport(report retention.Report) client.RetentionReport {
+	converted := client.RetentionReport{
+		GeneratedAt: report.GeneratedAt,
+		Messages:    report.Messages,
+		Bytes:       report.Bytes,
+	}
+	for _, domain := range report.Domains {
+		converted.Domains = append(converted.Domains, client.RetentionDomain(domain))
+	}
+	return converted
+}
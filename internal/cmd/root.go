@@ -2,17 +2,49 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
-	"github.com/spf13/cobra"
+	"github.com/nathabonfim59/gargantua-sink/internal/alarm"
+	"github.com/nathabonfim59/gargantua-sink/internal/api"
+	"github.com/nathabonfim59/gargantua-sink/internal/approval"
+	"github.com/nathabonfim59/gargantua-sink/internal/audit"
+	"github.com/nathabonfim59/gargantua-sink/internal/auth"
+	"github.com/nathabonfim59/gargantua-sink/internal/calendar"
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/dns"
+	"github.com/nathabonfim59/gargantua-sink/internal/honeypot"
+	"github.com/nathabonfim59/gargantua-sink/internal/junk"
+	"github.com/nathabonfim59/gargantua-sink/internal/lang"
+	"github.com/nathabonfim59/gargantua-sink/internal/listen"
+	"github.com/nathabonfim59/gargantua-sink/internal/logging"
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+	"github.com/nathabonfim59/gargantua-sink/internal/notify"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/retention"
+	"github.com/nathabonfim59/gargantua-sink/internal/shadow"
 	"github.com/nathabonfim59/gargantua-sink/internal/smtp"
 	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/internal/watch"
+	"github.com/spf13/cobra"
 )
 
 var (
 	// Configuration flags
-	serverPort  int
-	storagePath string
+	configPath    string
+	containerMode bool
+	serverPort    int
+	storagePath   string
+	apiAddr       string
+	storageFaults string
+	honeypotMode  bool
 
 	rootCmd = &cobra.Command{
 		Use:   "gargantua-sink",
@@ -25,9 +57,13 @@ and inspect emails during application development.`,
 )
 
 func init() {
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", "", "Path to the YAML configuration file")
+	rootCmd.PersistentFlags().BoolVar(&containerMode, "container", false, "Container preset: env-only config, JSON logs on stdout, storage in /data")
 	rootCmd.PersistentFlags().IntVarP(&serverPort, "port", "p", 2525, "SMTP server listening port")
 	rootCmd.PersistentFlags().StringVarP(&storagePath, "storage-path", "s", "", "Directory path for email storage")
-	rootCmd.MarkPersistentFlagRequired("storage-path")
+	rootCmd.PersistentFlags().StringVar(&apiAddr, "api-addr", ":8080", "HTTP API listening address (empty disables the API)")
+	rootCmd.Flags().BoolVar(&honeypotMode, "honeypot", false, "Spam-trap mode: accept every email, never bounce and fingerprint senders")
+	rootCmd.Flags().StringVar(&storageFaults, "storage-faults", "", `Inject storage latency and errors for failure testing, e.g. "latency=200ms,jitter=50ms,error_rate=0.1"`)
 }
 
 // Execute starts the root command.
@@ -35,16 +71,360 @@ func Execute() error {
 	return rootCmd.Execute()
 }
 
-// runServer initializes and starts the SMTP server.
+// loadConfig merges defaults, the configuration file, the environment and
+// any flags explicitly set on the command line. In container mode the
+// configuration file is not read.
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	flags := cmd.Flags()
+
+	container, err := isContainerMode(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg *config.Config
+	if container {
+		if configPath != "" {
+			return nil, errors.New("--config cannot be used in container mode; configure through GARGANTUA_* environment variables")
+		}
+		cfg, err = config.LoadContainer()
+	} else {
+		cfg, err = config.Load(configPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if flags.Changed("port") {
+		cfg.SMTP.Port = serverPort
+	}
+	if flags.Changed("storage-path") {
+		cfg.Storage.Path = storagePath
+	}
+	if flags.Changed("api-addr") {
+		cfg.API.Addr = apiAddr
+	}
+	if flags.Changed("storage-faults") {
+		cfg.Storage.Faults = storageFaults
+	}
+	if flags.Changed("honeypot") {
+		cfg.Honeypot = honeypotMode
+	}
+
+	return cfg, nil
+}
+
+// isContainerMode reports whether the container preset was requested by
+// flag or through GARGANTUA_CONTAINER.
+func isContainerMode(cmd *cobra.Command) (bool, error) {
+	if cmd.Flags().Changed("container") {
+		return containerMode, nil
+	}
+
+	raw, ok := os.LookupEnv("GARGANTUA_CONTAINER")
+	if !ok || raw == "" {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("parsing GARGANTUA_CONTAINER: %w", err)
+	}
+	return enabled, nil
+}
+
+// runServer initializes and starts the SMTP server, shutting it down
+// gracefully on SIGINT or SIGTERM. On SIGUSR2 the listeners are handed to a
+// new instance of the binary before shutting down.
 func runServer(cmd *cobra.Command, args []string) error {
-	emailStorage, err := storage.NewEmailStorage(storagePath)
+	cfg, err := loadConfig(cmd)
 	if err != nil {
 		return err
 	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	logLevel, err := logging.Setup(cfg.Log)
+	if err != nil {
+		return err
+	}
+
+	emailStorage, err := storage.NewEmailStorage(cfg.Storage.Path)
+	if err != nil {
+		return err
+	}
+
+	resolver, err := dns.New(cfg.DNS)
+	if err != nil {
+		return err
+	}
+	if cfg.DNS.Fixtures != "" {
+		log.Printf("Answering DNS lookups from fixtures %s", cfg.DNS.Fixtures)
+	}
+
+	server := smtp.NewServerFromConfig(cfg.SMTP, emailStorage)
+	server.SetResolver(resolver)
+	for _, domain := range cfg.Domains {
+		if err := server.AddDomain(domain.Name, domain.StoragePath); err != nil {
+			return err
+		}
+	}
+
+	for _, route := range cfg.Storage.SizeRoutes {
+		if err := server.AddSizeRoute(route.MinBytes, route.Path); err != nil {
+			return err
+		}
+		log.Printf("Emails of %d bytes or more will be stored in: %s", route.MinBytes, route.Path)
+	}
+
+	if err := recoverStorages(server.Storages(), cfg.SMTP.SpoolDir, listen.Inherited() || cfg.ReusePort); err != nil {
+		return err
+	}
+
+	var faults *storage.Faults
+	if cfg.Storage.Faults != "" {
+		settings, _ := storage.ParseFaults(cfg.Storage.Faults) // Checked by Validate
+		if faults, err = storage.NewFaults(settings); err != nil {
+			return err
+		}
+		server.InjectStorageFaults(faults)
+		log.Printf("WARNING: injecting storage faults (%s), for failure testing only", settings)
+	}
+
+	log.Printf("Starting Gargantua Sink SMTP server on port %d", cfg.SMTP.Port)
+	log.Printf("Emails will be stored in: %s", cfg.Storage.Path)
+	if len(cfg.Domains) > 0 {
+		log.Printf("Accepting mail for %d configured domain(s)", len(cfg.Domains))
+	}
+
+	server.Use(pipeline.StageEnrich, lang.Middleware())
+	if cfg.Junk.Enabled {
+		server.Use(pipeline.StageEnrich, junk.NewClassifier(cfg.Junk).Middleware())
+		log.Printf("Filing emails scoring %g or more in the Junk folder", cfg.Junk.Threshold)
+	}
 
-	server := smtp.NewServer(serverPort, emailStorage)
-	log.Printf("Starting Gargantua Sink SMTP server on port %d", serverPort)
-	log.Printf("Emails will be stored in: %s", storagePath)
-	
-	return server.Start()
+	var trap *honeypot.Tracker
+	if cfg.Honeypot {
+		trap = honeypot.NewTracker(resolver)
+		server.EnableHoneypot(trap.ObserveSession)
+		server.Use(pipeline.StageNotify, trap.Middleware())
+		log.Printf("WARNING: honeypot mode, accepting every email and fingerprinting senders")
+	}
+
+	var mirror *shadow.Mirror
+	if cfg.Shadow.Addr != "" {
+		mirror = shadow.NewMirror(cfg.Shadow)
+		server.SetShadow(mirror)
+		if cfg.Shadow.SampleRate < 1 || len(cfg.Shadow.Rules) > 0 {
+			log.Printf("Mirroring %g%% of emails matching %d rule(s) to shadow server %s", cfg.Shadow.SampleRate*100, len(cfg.Shadow.Rules), cfg.Shadow.Addr)
+		} else {
+			log.Printf("Mirroring every email to shadow server %s", cfg.Shadow.Addr)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go logging.ToggleOnSignal(ctx, logLevel)
+
+	notifiers := make([]*notify.Notifier, 0, len(cfg.Notify.Webhooks))
+	for _, webhook := range cfg.Notify.Webhooks {
+		notifier := notify.New(webhook, cfg.API.PublicURL)
+		server.Use(pipeline.StageNotify, notifier.Middleware())
+		go notifier.Run(ctx)
+		notifiers = append(notifiers, notifier)
+	}
+	if len(notifiers) > 0 {
+		log.Printf("Notifying %d webhook(s) about captured emails", len(notifiers))
+	}
+
+	// Watches are registered through the API only
+	var watches *watch.Registry
+	if cfg.API.Addr != "" {
+		watches = watch.NewRegistry(cfg.API.PublicURL)
+		server.Use(pipeline.StageNotify, watches.Middleware())
+	}
+
+	var relay *smtp.Client
+	if cfg.Forward.Addr != "" {
+		relay = smtp.NewClient(emailStorage, &smtp.ClientConfig{
+			ForwardTo:   cfg.Forward.Addr,
+			ForwardUser: cfg.Forward.Username,
+			ForwardPass: string(cfg.Forward.Password),
+			ForwardHost: cfg.Forward.Host,
+		})
+	}
+
+	var responder *calendar.Responder
+	if len(cfg.Calendar.Replies) > 0 {
+		responder = calendar.NewResponder(cfg.Calendar, relay) // Forward is required by Validate
+		server.Use(pipeline.StageNotify, responder.Middleware())
+		log.Printf("Answering meeting invitations with %d calendar reply rule(s)", len(cfg.Calendar.Replies))
+	}
+
+	if len(cfg.Approval.Rules) > 0 {
+		queue := approval.NewQueue(cfg.Approval)
+		server.Use(pipeline.StageNotify, queue.Middleware())
+		log.Printf("Holding emails matching %d approval rule(s) for review", len(cfg.Approval.Rules))
+	}
+
+	var alarms *alarm.Monitor
+	if len(cfg.Alarms) > 0 {
+		alarms = alarm.NewMonitor(cfg.Alarms)
+		server.Use(pipeline.StageNotify, alarms.Middleware())
+		go alarms.Run(ctx)
+		log.Printf("Watching the received rate with %d alarm(s)", len(cfg.Alarms))
+	}
+
+	if cfg.DomainsDir != "" {
+		server.RestrictDomains()
+		watcher := config.NewDomainWatcher(cfg.DomainsDir, cfg.DomainsPollInterval, cfg.Domains, func(changed []config.DomainConfig, removed []string) {
+			applyDomainChanges(server, changed, removed)
+		})
+		if err := watcher.Scan(); err != nil {
+			return err
+		}
+
+		log.Printf("Watching %s for domain changes every %s", cfg.DomainsDir, cfg.DomainsPollInterval)
+		go watcher.Run(ctx)
+	}
+
+	listeners := make(map[string]net.Listener)
+	smtpListener, err := listen.Listen("smtp", fmt.Sprintf(":%d", cfg.SMTP.Port), cfg.ReusePort)
+	if err != nil {
+		return err
+	}
+	listeners["smtp"] = smtpListener
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- server.Serve(smtpListener) }()
+
+	var apiServer *api.Server
+	if cfg.API.Addr != "" {
+		registry := metrics.NewRegistry()
+		registry.Register(server.Health().Collect)
+		registry.Register(server.Collect)
+		if len(notifiers) > 0 {
+			registry.Register(func() []metrics.Family { return notify.Collect(notifiers) })
+		}
+		if responder != nil {
+			registry.Register(responder.Collect)
+		}
+		if mirror != nil {
+			registry.Register(mirror.Collect)
+		}
+		if alarms != nil {
+			registry.Register(alarms.Collect)
+		}
+
+		opts := api.Options{
+			LogLevel:  logLevel,
+			Storages:  server.Storages,
+			Health:    server.Health(),
+			Metrics:   registry,
+			Audit:     audit.NewLog(cfg.Storage.AuditLogPath()),
+			Auth:      newAuthenticator(cfg.API),
+			Faults:    faults,
+			Sessions:  server.Sessions,
+			Watches:   watches,
+			Domains:   server,
+			Retention: retention.NewPolicy(cfg.Storage.Retention),
+			PublicURL: cfg.API.PublicURL,
+			RateLimit: api.RateLimit{
+				PerIP:    cfg.API.RateLimit.PerIP,
+				PerToken: cfg.API.RateLimit.PerToken,
+				Burst:    cfg.API.RateLimit.Burst,
+			},
+		}
+		if opts.Auth == nil {
+			log.Printf("API authentication disabled: every caller has the admin role")
+		}
+		if mirror != nil {
+			opts.Shadow = mirror
+		}
+		if trap != nil {
+			opts.Honeypot = trap
+		}
+		if cfg.SMTP.DiagnosePipelining {
+			opts.Closed = server.ClosedSessions
+		}
+		if relay != nil {
+			opts.Relay = relay
+		}
+
+		apiListener, err := listen.Listen("api", cfg.API.Addr, cfg.ReusePort)
+		if err != nil {
+			return err
+		}
+		listeners["api"] = apiListener
+
+		apiServer = api.NewServer(cfg.API.Addr, opts)
+		go func() { errCh <- apiServer.Serve(apiListener) }()
+	}
+
+	go handoffOnSignal(ctx, listeners, stop)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for open sessions", cfg.SMTP.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.SMTP.ShutdownTimeout)
+	defer cancel()
+
+	errs := []error{server.Shutdown(shutdownCtx)}
+	if apiServer != nil {
+		errs = append(errs, apiServer.Shutdown(shutdownCtx))
+	}
+	for _, notifier := range notifiers {
+		errs = append(errs, notifier.Wait(shutdownCtx))
+	}
+	if responder != nil {
+		errs = append(errs, responder.Wait(shutdownCtx))
+	}
+	if watches != nil {
+		errs = append(errs, watches.Wait(shutdownCtx))
+	}
+	return errors.Join(errs...)
+}
+
+// newAuthenticator builds the API access control from the configuration,
+// or returns nil when no token or group header is configured. Roles were
+// checked by Validate.
+func newAuthenticator(cfg config.APIConfig) *auth.Authenticator {
+	if len(cfg.Tokens) == 0 && cfg.GroupHeader == "" {
+		return nil
+	}
+
+	tokens := make([]auth.Token, 0, len(cfg.Tokens))
+	for _, token := range cfg.Tokens {
+		role, _ := auth.ParseRole(token.Role)
+		tokens = append(tokens, auth.Token{Name: token.Name, Secret: string(token.Token), Role: role})
+	}
+
+	groups := make(map[string]auth.Role, len(cfg.Groups))
+	for group, name := range cfg.Groups {
+		groups[group], _ = auth.ParseRole(name)
+	}
+	return auth.NewAuthenticator(tokens, cfg.GroupHeader, cfg.UserHeader, groups)
+}
+
+// applyDomainChanges updates the accepted domains after a domains directory
+// change. Changed domains are updated in place, so mail for them is never
+// refused in between.
+func applyDomainChanges(server *smtp.Server, changed []config.DomainConfig, removed []string) {
+	for _, domain := range changed {
+		if err := server.AddDomain(domain.Name, domain.StoragePath); err != nil {
+			log.Printf("Error adding domain %s: %v", domain.Name, err)
+			continue
+		}
+		log.Printf("Added domain %s", domain.Name)
+	}
+
+	for _, name := range removed {
+		if server.RemoveDomain(name) {
+			log.Printf("Removed domain %s", name)
+		}
+	}
 }
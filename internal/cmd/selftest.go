@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/selftest"
+	"github.com/nathabonfim59/gargantua-sink/internal/smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+// Limits of the server started by selftest, small so every check stays fast.
+const (
+	selftestMaxMessageBytes = 64 * 1024
+	selftestReadTimeout     = 2 * time.Second
+)
+
+var (
+	// selftestJSON prints the report as JSON
+	selftestJSON bool
+
+	selftestCmd = &cobra.Command{
+		Use:   "selftest",
+		Short: "Run SMTP conformance checks against a temporary server",
+		Long: `Start the SMTP server on a random local port with a temporary storage and
+run protocol-level checks against it: greeting and EHLO, delivery,
+pipelining, STARTTLS, size limits, malformed commands, overlong lines and
+idle timeouts. A conformance report is printed and the command fails when
+any check fails, catching regressions in the supported extensions.
+
+The server uses the built-in defaults with a 64KB size limit, a 2s idle
+timeout and an ephemeral self-signed certificate for STARTTLS; the
+configuration file is not read.`,
+		Args: cobra.NoArgs,
+		RunE: runSelftest,
+	}
+)
+
+func init() {
+	selftestCmd.Flags().BoolVar(&selftestJSON, "json", false, "Print the report as JSON")
+	rootCmd.AddCommand(selftestCmd)
+}
+
+// runSelftest starts a temporary server, checks it and prints the report.
+func runSelftest(cmd *cobra.Command, args []string) error {
+	dir, err := os.MkdirTemp("", "gargantua-selftest-*")
+	if err != nil {
+		return fmt.Errorf("creating self-test storage: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	emailStorage, err := storage.NewEmailStorage(dir)
+	if err != nil {
+		return err
+	}
+
+	cfg := config.Default().SMTP
+	cfg.MaxMessageBytes = selftestMaxMessageBytes
+	cfg.ReadTimeout = selftestReadTimeout
+	cfg.SpoolDir = dir
+	if cfg.TLS.CertFile, cfg.TLS.KeyFile, err = writeSelftestCert(dir); err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	// The server logs every connection; keep the report readable
+	logOutput := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(logOutput)
+
+	server := smtp.NewServerFromConfig(cfg, emailStorage)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	report := selftest.Run(selftest.Target{
+		Addr:            listener.Addr().String(),
+		Recipient:       "selftest@example.com",
+		MaxMessageBytes: cfg.MaxMessageBytes,
+		ReadTimeout:     cfg.ReadTimeout,
+		Confirm: func() error {
+			emails, err := emailStorage.List(storage.ListFilter{User: "selftest"})
+			if err == nil && len(emails) == 0 {
+				err = errors.New("no email in storage")
+			}
+			return err
+		},
+	})
+
+	if selftestJSON {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else if err := report.WriteText(cmd.OutOrStdout()); err != nil {
+		return err
+	}
+
+	if !report.Passed() {
+		cmd.SilenceUsage = true
+		return errors.New("self-test failed")
+	}
+	return nil
+}
+
+// writeSelftestCert writes an ephemeral self-signed certificate for
+// localhost and its key in PEM format under dir, returning their paths.
+func writeSelftestCert(dir string) (certFile, keyFile string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("generating self-test key: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "gargantua-sink selftest"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", fmt.Errorf("creating self-test certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("encoding self-test key: %w", err)
+	}
+
+	certFile = filepath.Join(dir, "selftest.pem")
+	keyFile = filepath.Join(dir, "selftest.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return "", "", fmt.Errorf("writing self-test certificate: %w", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", fmt.Errorf("writing self-test key: %w", err)
+	}
+	return certFile, keyFile, nil
+}
@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestWriteSelftestCert(t *testing.T) {
+	certFile, keyFile, err := writeSelftestCert(t.TempDir())
+	if err != nil {
+		t.Fatalf("writeSelftestCert() failed: %v", err)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Errorf("loading the self-test certificate failed: %v", err)
+	}
+}
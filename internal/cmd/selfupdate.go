@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/update"
+	"github.com/nathabonfim59/gargantua-sink/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Self-update flags
+	updateCheckOnly  bool
+	updateForce      bool
+	updatePublicKey  string
+	updateRepository string
+
+	selfUpdateCmd = &cobra.Command{
+		Use:   "self-update",
+		Short: "Replace this binary with the latest GitHub release",
+		Long: `Check GitHub for the latest release and, when it is newer than the running
+version, download the binary for this platform, verify it against the release
+checksums and replace the current executable in place.
+
+With --public-key the checksums file must also carry a valid ed25519
+signature (checksums.txt.sig) made with the matching private key.`,
+		Args: cobra.NoArgs,
+		RunE: runSelfUpdate,
+	}
+)
+
+func init() {
+	selfUpdateCmd.Flags().BoolVar(&updateCheckOnly, "check", false, "Only report whether an update is available")
+	selfUpdateCmd.Flags().BoolVar(&updateForce, "force", false, "Install the latest release even if it is not newer")
+	selfUpdateCmd.Flags().StringVar(&updatePublicKey, "public-key", "", "Base64 ed25519 key required to sign the release checksums")
+	selfUpdateCmd.Flags().StringVar(&updateRepository, "repository", update.DefaultRepository, "GitHub repository to fetch releases from")
+	rootCmd.AddCommand(selfUpdateCmd)
+}
+
+// runSelfUpdate checks for and installs the latest release.
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	updater := update.NewUpdater(updateRepository)
+	if updatePublicKey != "" {
+		key, err := update.ParsePublicKey(updatePublicKey)
+		if err != nil {
+			return err
+		}
+		updater.PublicKey = key
+	}
+
+	release, err := updater.Latest(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if !update.IsNewer(release.TagName, version.Version) && !updateForce {
+		fmt.Fprintf(out, "Already up to date (%s, latest release %s)\n", version.Version, release.TagName)
+		return nil
+	}
+	if updateCheckOnly {
+		fmt.Fprintf(out, "Update available: %s -> %s\n", version.Version, release.TagName)
+		return nil
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locating current binary: %w", err)
+	}
+	if exePath, err = filepath.EvalSymlinks(exePath); err != nil {
+		return fmt.Errorf("resolving current binary: %w", err)
+	}
+
+	if err := updater.Apply(cmd.Context(), release, exePath); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Updated %s from %s to %s\n", exePath, version.Version, release.TagName)
+	return nil
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// serverURL selects remote mode for the message commands
+	serverURL string
+	// serverToken authenticates to the API in remote mode
+	serverToken string
+)
+
+// messageSource gives the message commands access to stored emails, either
+// directly on the storage directories or through the API of a running server.
+type messageSource interface {
+	List(ctx context.Context, opts client.ListOptions) ([]client.Message, error)
+	Raw(ctx context.Context, id string) ([]byte, error)
+	Delete(ctx context.Context, id string) error
+	// URL returns the canonical link to an email, or nothing when the
+	// address of the API is unknown
+	URL(id string) string
+}
+
+// addSourceFlags registers the remote mode flags on a message command.
+func addSourceFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&serverURL, "server", "", "Use the API of a running server, e.g. http://sink:8080, instead of the storage directory")
+	cmd.Flags().StringVar(&serverToken, "token", "", "API token for --server (default $GARGANTUA_API_TOKEN)")
+}
+
+// openSource returns the remote source when --server is set, or the local
+// storages of the configuration.
+func openSource(cmd *cobra.Command) (messageSource, error) {
+	if serverURL != "" {
+		return remoteSource{newAPIClient()}, nil
+	}
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return nil, err
+	}
+	storages, err := openStorages(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return localSource{storages: storages, publicURL: cfg.API.PublicURL}, nil
+}
+
+// newAPIClient returns a client for the API at --server.
+func newAPIClient() *client.Client {
+	apiClient := client.New(serverURL)
+	apiClient.Token = serverToken
+	if apiClient.Token == "" {
+		// Not a flag default, which would show the token in --help
+		apiClient.Token = os.Getenv("GARGANTUA_API_TOKEN")
+	}
+	return apiClient
+}
+
+// remoteSource reads emails through the HTTP API.
+type remoteSource struct {
+	client *client.Client
+}
+
+func (source remoteSource) List(ctx context.Context, opts client.ListOptions) ([]client.Message, error) {
+	return source.client.ListMessages(ctx, opts)
+}
+
+func (source remoteSource) Raw(ctx context.Context, id string) ([]byte, error) {
+	return source.client.Raw(ctx, id)
+}
+
+func (source remoteSource) Delete(ctx context.Context, id string) error {
+	return source.client.Delete(ctx, id)
+}
+
+func (source remoteSource) URL(id string) string {
+	return source.client.MessageURL(id)
+}
+
+// localSource reads emails from the storage directories.
+type localSource struct {
+	storages  []*storage.EmailStorage
+	publicURL string // api.public_url, links are omitted when empty
+}
+
+func (source localSource) List(ctx context.Context, opts client.ListOptions) ([]client.Message, error) {
+	filter := storage.ListFilter{Domain: opts.Domain, User: opts.User, Tag: opts.Tag, Language: opts.Language, Folder: opts.Folder, Query: opts.Query}
+	if opts.Direction != "" {
+		direction, err := storage.ParseDirection(opts.Direction)
+		if err != nil {
+			return nil, err
+		}
+		filter.Direction = &direction
+	}
+
+	var messages []client.Message
+	for _, emailStorage := range source.storages {
+		emails, err := emailStorage.List(filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range emails {
+			msg := toClientMessage(email)
+			if msg.Held, err = emailStorage.OnHold(email); err != nil {
+				return nil, err
+			}
+			messages = append(messages, msg)
+		}
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].ReceivedAt.After(messages[j].ReceivedAt)
+	})
+	if opts.Limit > 0 && len(messages) > opts.Limit {
+		messages = messages[:opts.Limit]
+	}
+	return messages, nil
+}
+
+func (source localSource) Raw(ctx context.Context, id string) ([]byte, error) {
+	emailStorage, err := source.find(id)
+	if err != nil {
+		return nil, err
+	}
+	return emailStorage.ReadContent(id)
+}
+
+func (source localSource) Delete(ctx context.Context, id string) error {
+	emailStorage, err := source.find(id)
+	if err != nil {
+		return err
+	}
+	return emailStorage.Delete(id)
+}
+
+func (source localSource) URL(id string) string {
+	if source.publicURL == "" {
+		return ""
+	}
+	return client.MessageURL(source.publicURL, id)
+}
+
+// find returns the storage holding the email with the given ID.
+func (source localSource) find(id string) (*storage.EmailStorage, error) {
+	for _, emailStorage := range source.storages {
+		_, err := emailStorage.Get(id)
+		if err == nil {
+			return emailStorage, nil
+		}
+		if !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+// toClientMessage describes a stored email like the API does.
+func toClientMessage(email storage.StoredEmail) client.Message {
+	msg := client.Message{
+		ID:         email.ID,
+		Domain:     email.Domain,
+		User:       email.User,
+		Direction:  email.Direction.String(),
+		Subject:    email.Subject,
+		Size:       email.Size,
+		ReceivedAt: email.ReceivedAt,
+		Metadata:   client.Metadata{Tags: email.Metadata.Tags, Folder: email.Metadata.Folder},
+	}
+	for _, verdict := range email.Metadata.Verdicts {
+		msg.Metadata.Verdicts = append(msg.Metadata.Verdicts, client.Verdict(verdict))
+	}
+	return msg
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+)
+
+// newHeldStorage returns a storage with an email for john and one for jane,
+// whose mailbox is on hold.
+func newHeldStorage(t *testing.T) (*storage.EmailStorage, string, string) {
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+	content := []byte("Subject: Hi\r\n\r\nHello\r\n")
+	john, err := emailStorage.Store(storage.Incoming, "example.com", "john", "hi", content)
+	if err != nil {
+		t.Fatalf("storing email failed: %v", err)
+	}
+	jane, err := emailStorage.Store(storage.Incoming, "example.com", "jane", "hi", content)
+	if err != nil {
+		t.Fatalf("storing email failed: %v", err)
+	}
+	if err := emailStorage.HoldMailbox("example.com", "jane", storage.Hold{Reason: "litigation"}); err != nil {
+		t.Fatalf("holding mailbox failed: %v", err)
+	}
+	return emailStorage, john, jane
+}
+
+func TestLocalSource(t *testing.T) {
+	emailStorage, john, jane := newHeldStorage(t)
+	source := localSource{storages: []*storage.EmailStorage{emailStorage}, publicURL: "https://sink.example.com"}
+	ctx := context.Background()
+
+	messages, err := source.List(ctx, client.ListOptions{Domain: "example.com"})
+	if err != nil {
+		t.Fatalf("List() failed: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("List() = %d messages, want 2", len(messages))
+	}
+	for _, msg := range messages {
+		if wantHeld := msg.ID == jane; msg.Held != wantHeld {
+			t.Errorf("message %s held = %v, want %v", msg.ID, msg.Held, wantHeld)
+		}
+	}
+
+	if raw, err := source.Raw(ctx, john); err != nil || len(raw) == 0 {
+		t.Errorf("Raw() = %q, %v", raw, err)
+	}
+	if url := source.URL(john); url != client.MessageURL("https://sink.example.com", john) {
+		t.Errorf("URL() = %q", url)
+	}
+	if err := source.Delete(ctx, jane); !errors.Is(err, storage.ErrOnHold) {
+		t.Errorf("Delete() of a held email error = %v, want ErrOnHold", err)
+	}
+	if err := source.Delete(ctx, "missing"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Delete() of a missing email error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Tail filters and pacing
+	tailFilter   listFilterFlags
+	tailLines    int
+	tailInterval time.Duration
+
+	tailCmd = &cobra.Command{
+		Use:   "tail",
+		Short: "Print emails as they arrive",
+		Long: `Print the most recent emails, then poll for new ones and print them as
+they arrive until interrupted.`,
+		Args: cobra.NoArgs,
+		RunE: runTail,
+
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	tailFilter.register(tailCmd)
+	tailCmd.Flags().IntVarP(&tailLines, "lines", "n", 10, "Number of recent emails printed first")
+	tailCmd.Flags().DurationVar(&tailInterval, "interval", 2*time.Second, "Delay between polls")
+	addSourceFlags(tailCmd)
+	rootCmd.AddCommand(tailCmd)
+}
+
+// runTail prints recent emails and follows new ones.
+func runTail(cmd *cobra.Command, args []string) error {
+	if tailInterval <= 0 {
+		return fmt.Errorf("invalid interval %s", tailInterval)
+	}
+
+	source, err := openSource(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer stop()
+
+	opts := tailFilter.options()
+	seen := make(map[string]bool)
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		messages, err := source.List(ctx, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		// Listings are newest first; print the unseen ones oldest first
+		var fresh []client.Message
+		for _, msg := range messages {
+			if !seen[msg.ID] {
+				seen[msg.ID] = true
+				fresh = append(fresh, msg)
+			}
+		}
+		if first && len(fresh) > tailLines {
+			fresh = fresh[:tailLines]
+		}
+		slices.Reverse(fresh)
+		for _, msg := range fresh {
+			// Lines are printed over time, so columns cannot be aligned as a table
+			// Terminals make the link clickable when the API address is known
+			ref := msg.ID
+			if link := source.URL(msg.ID); link != "" {
+				ref = link
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%s  %-3s  %s@%s  %s  [%s]\n",
+				msg.ReceivedAt.Local().Format(time.DateTime), msg.Direction,
+				msg.User, msg.Domain, msg.Subject, ref)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
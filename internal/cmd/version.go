@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// versionJSON prints the build information as JSON
+	versionJSON bool
+
+	versionCmd = &cobra.Command{
+		Use:   "version",
+		Short: "Print version and build information",
+		Args:  cobra.NoArgs,
+		RunE:  runVersion,
+	}
+)
+
+func init() {
+	versionCmd.Flags().BoolVar(&versionJSON, "json", false, "Print the build information as JSON")
+	rootCmd.AddCommand(versionCmd)
+}
+
+// runVersion prints the build information.
+func runVersion(cmd *cobra.Command, args []string) error {
+	info := version.Get()
+
+	if versionJSON {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	_, err := fmt.Fprintln(cmd.OutOrStdout(), info)
+	return err
+}
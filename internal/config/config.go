@@ -0,0 +1,810 @@
+// Package config loads and merges the Gargantua Sink configuration.
+//
+// Values are resolved from four sources, in increasing order of precedence:
+// built-in defaults, the YAML configuration file, GARGANTUA_* environment
+// variables and command-line flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/auth"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete server configuration.
+type Config struct {
+	// Include lists glob patterns of fragment files merged in order after this file
+	Include []string `yaml:"include,omitempty"`
+
+	// Container is set by the container preset, chosen by flag or environment only
+	Container bool           `yaml:"-" env:"GARGANTUA_CONTAINER"`
+	ReusePort bool           `yaml:"reuse_port" env:"GARGANTUA_REUSE_PORT"` // Open listeners with SO_REUSEPORT
+	Honeypot  bool           `yaml:"honeypot" env:"GARGANTUA_HONEYPOT"`     // Spam-trap mode: accept every email, fingerprint senders
+	Log       LogConfig      `yaml:"log"`
+	SMTP      SMTPConfig     `yaml:"smtp"`
+	Storage   StorageConfig  `yaml:"storage"`
+	API       APIConfig      `yaml:"api"`
+	Forward   ForwardConfig  `yaml:"forward"`
+	Shadow    ShadowConfig   `yaml:"shadow"`
+	Notify    NotifyConfig   `yaml:"notify"`
+	Alarms    []AlarmConfig  `yaml:"alarms,omitempty"`
+	Calendar  CalendarConfig `yaml:"calendar"`
+	Junk      JunkConfig     `yaml:"junk"`
+	Approval  ApprovalConfig `yaml:"approval"`
+	Vault     VaultConfig    `yaml:"vault"`
+	DNS       DNSConfig      `yaml:"dns"`
+	Domains   []DomainConfig `yaml:"domains"`
+
+	// DomainsDir is watched for per-domain fragments applied without a restart
+	DomainsDir          string        `yaml:"domains_dir" env:"GARGANTUA_DOMAINS_DIR"`
+	DomainsPollInterval time.Duration `yaml:"domains_poll_interval" env:"GARGANTUA_DOMAINS_POLL_INTERVAL"`
+}
+
+// LogConfig holds the logging settings.
+type LogConfig struct {
+	Level  string `yaml:"level" env:"GARGANTUA_LOG_LEVEL"`   // debug, info, warn or error
+	Format string `yaml:"format" env:"GARGANTUA_LOG_FORMAT"` // text or json
+	Output string `yaml:"output" env:"GARGANTUA_LOG_OUTPUT"` // stderr or stdout
+}
+
+// SMTPConfig holds the SMTP listener settings.
+type SMTPConfig struct {
+	Port            int           `yaml:"port" env:"GARGANTUA_SMTP_PORT"`
+	ReadTimeout     time.Duration `yaml:"read_timeout" env:"GARGANTUA_SMTP_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"write_timeout" env:"GARGANTUA_SMTP_WRITE_TIMEOUT"`
+	MaxMessageBytes int64         `yaml:"max_message_bytes" env:"GARGANTUA_SMTP_MAX_MESSAGE_BYTES"`
+	MaxRecipients   int           `yaml:"max_recipients" env:"GARGANTUA_SMTP_MAX_RECIPIENTS"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"GARGANTUA_SMTP_SHUTDOWN_TIMEOUT"` // Grace period for open sessions on SIGTERM
+
+	// MaxHeaderFields and MaxHeaderBytes limit the header section of an
+	// email, which is rejected with 552 beyond them; 0 disables a limit
+	MaxHeaderFields int   `yaml:"max_header_fields" env:"GARGANTUA_SMTP_MAX_HEADER_FIELDS"`
+	MaxHeaderBytes  int64 `yaml:"max_header_bytes" env:"GARGANTUA_SMTP_MAX_HEADER_BYTES"`
+
+	// SpillThreshold is the per-transaction memory budget; larger messages
+	// are buffered in SpoolDir, or the system temporary directory when empty
+	SpillThreshold int64  `yaml:"spill_threshold" env:"GARGANTUA_SMTP_SPILL_THRESHOLD"`
+	SpoolDir       string `yaml:"spool_dir" env:"GARGANTUA_SMTP_SPOOL_DIR"`
+
+	// VRFY sets the answer to VRFY and EXPN: ambiguous (252, the default),
+	// disabled (502), accept (250 for any accepted domain) or strict (250
+	// only for mailboxes that already received mail)
+	VRFY string `yaml:"vrfy" env:"GARGANTUA_SMTP_VRFY"`
+
+	// DiagnosePipelining checks how clients pipeline commands and keeps
+	// the last finished sessions with their report
+	DiagnosePipelining bool `yaml:"diagnose_pipelining" env:"GARGANTUA_SMTP_DIAGNOSE_PIPELINING"`
+
+	// Timeline records when every email goes through each processing stage
+	// in its metadata, which then always has a sidecar file
+	Timeline bool `yaml:"timeline" env:"GARGANTUA_SMTP_TIMELINE"`
+
+	// SenderCheck verifies that the MAIL FROM domain can receive mail
+	SenderCheck SMTPSenderCheckConfig `yaml:"sender_check"`
+
+	// Extensions controls the capabilities offered in the EHLO reply
+	Extensions SMTPExtensionsConfig `yaml:"extensions"`
+
+	// Profile emulates a provider, one of SMTPProfiles: its greeting,
+	// extensions, limits, reply wording and throttling replace the settings
+	Profile string `yaml:"profile" env:"GARGANTUA_SMTP_PROFILE"`
+
+	// TLS enables STARTTLS when a certificate is set
+	TLS SMTPTLSConfig `yaml:"tls"`
+	// Capture records the raw traffic of every connection for debugging
+	Capture SMTPCaptureConfig `yaml:"capture"`
+}
+
+// SMTPExtensions lists the EHLO keywords the server can advertise.
+var SMTPExtensions = []string{
+	"SIZE", "8BITMIME", "PIPELINING", "ENHANCEDSTATUSCODES", "CHUNKING",
+	"STARTTLS", "AUTH", "SMTPUTF8", "DSN", "BINARYMIME", "REQUIRETLS",
+}
+
+// SMTPProfiles lists the provider emulation profiles.
+var SMTPProfiles = []string{"gmail-like", "office365-like", "strict-rfc"}
+
+// SMTPExtensionsConfig emulates the capability profile of another server.
+type SMTPExtensionsConfig struct {
+	// Advertise lists the EHLO keywords offered, in order, out of
+	// SMTPExtensions; empty keeps the defaults
+	Advertise []string `yaml:"advertise,omitempty"`
+
+	// AuthMechanisms lists the SASL mechanisms offered with AUTH: PLAIN,
+	// the default, and LOGIN
+	AuthMechanisms []string `yaml:"auth_mechanisms,omitempty"`
+}
+
+// SMTPSenderCheckConfig verifies that the domain of the MAIL FROM address
+// has MX or A records, like MTAs checking senders before accepting mail.
+type SMTPSenderCheckConfig struct {
+	// Mode is off (the default), record, which adds a verdict to every
+	// email, or enforce, which also rejects MAIL FROM when the check fails
+	Mode string `yaml:"mode" env:"GARGANTUA_SMTP_SENDER_CHECK_MODE"`
+
+	// Stub answers the lookups instead of DNS, for tests: domain to mx, a,
+	// nullmx or tempfail. Unlisted domains do not exist.
+	Stub map[string]string `yaml:"stub,omitempty"`
+}
+
+// SMTPCaptureConfig holds the settings of the per-connection packet
+// captures.
+type SMTPCaptureConfig struct {
+	Dir      string `yaml:"dir" env:"GARGANTUA_SMTP_CAPTURE_DIR"`             // Empty disables captures
+	MaxBytes int64  `yaml:"max_bytes" env:"GARGANTUA_SMTP_CAPTURE_MAX_BYTES"` // Per connection, later traffic is dropped
+}
+
+// SMTPTLSConfig holds the STARTTLS certificate files in PEM format.
+type SMTPTLSConfig struct {
+	CertFile string `yaml:"cert_file" env:"GARGANTUA_SMTP_TLS_CERT_FILE"`
+	KeyFile  string `yaml:"key_file" env:"GARGANTUA_SMTP_TLS_KEY_FILE"`
+
+	// ClientCAFile verifies the client certificates signed by these CAs
+	// (mutual TLS); clients without a certificate are still accepted
+	ClientCAFile string `yaml:"client_ca_file" env:"GARGANTUA_SMTP_TLS_CLIENT_CA_FILE"`
+
+	// KeyLogFile appends the TLS session secrets in the NSS key log format
+	// (SSLKEYLOGFILE) so captures can be decrypted; never use in production
+	KeyLogFile string `yaml:"key_log_file" env:"GARGANTUA_SMTP_TLS_KEY_LOG_FILE"`
+}
+
+// StorageConfig holds the email storage settings.
+type StorageConfig struct {
+	Path     string `yaml:"path" env:"GARGANTUA_STORAGE_PATH"`
+	AuditLog string `yaml:"audit_log" env:"GARGANTUA_STORAGE_AUDIT_LOG"` // Defaults to audit.log in the storage path
+
+	// SizeRoutes store large messages apart from their domain storage
+	SizeRoutes []SizeRoute `yaml:"size_routes,omitempty"`
+
+	// Faults injects latency and errors into email writes for failure
+	// testing, e.g. "latency=200ms,jitter=50ms,error_rate=0.1"; see storage.ParseFaults
+	Faults string `yaml:"faults,omitempty" env:"GARGANTUA_STORAGE_FAULTS"`
+
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// RetentionConfig sets how long stored emails are kept.
+type RetentionConfig struct {
+	MaxAge  time.Duration            `yaml:"max_age" env:"GARGANTUA_STORAGE_RETENTION_MAX_AGE"` // Zero keeps emails forever
+	Domains map[string]time.Duration `yaml:"domains,omitempty"`                                 // Max age by domain, zero keeps forever
+}
+
+// SizeRoute stores messages of at least MinBytes under Path.
+type SizeRoute struct {
+	MinBytes int64  `yaml:"min_bytes"`
+	Path     string `yaml:"path"`
+}
+
+// AuditLogPath returns the audit log location, defaulting to audit.log in
+// the storage path.
+func (storage StorageConfig) AuditLogPath() string {
+	if storage.AuditLog != "" {
+		return storage.AuditLog
+	}
+	return filepath.Join(storage.Path, "audit.log")
+}
+
+// APIConfig holds the HTTP API settings. Without tokens and group header
+// the API is open and every caller is an admin.
+type APIConfig struct {
+	Addr   string     `yaml:"addr" env:"GARGANTUA_API_ADDR"` // Empty disables the API
+	Tokens []APIToken `yaml:"tokens,omitempty"`
+
+	// GroupHeader names the header in which an authenticating proxy, such as
+	// an OIDC gateway, passes the comma-separated groups of the user; Groups
+	// maps those groups to roles. Only set it when the API is reachable
+	// solely through that proxy.
+	GroupHeader string            `yaml:"group_header,omitempty" env:"GARGANTUA_API_GROUP_HEADER"`
+	Groups      map[string]string `yaml:"groups,omitempty"`
+
+	// UserHeader names the header in which the proxy passes the user
+	// identity, e.g. X-Forwarded-User, so users of the same group are rate
+	// limited separately; without it they are told apart by address.
+	UserHeader string `yaml:"user_header,omitempty" env:"GARGANTUA_API_USER_HEADER"`
+
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	// PublicURL is the address users reach the API at, e.g.
+	// https://sink.example.com, used to link to emails from notifications
+	// and the CLI; links are omitted when empty
+	PublicURL string `yaml:"public_url,omitempty" env:"GARGANTUA_API_PUBLIC_URL"`
+}
+
+// RateLimitConfig limits the request rate of API callers; zero rates disable
+// the corresponding limit.
+type RateLimitConfig struct {
+	PerIP    float64 `yaml:"per_ip" env:"GARGANTUA_API_RATE_LIMIT_PER_IP"`       // Requests per second of each client address without credentials
+	PerToken float64 `yaml:"per_token" env:"GARGANTUA_API_RATE_LIMIT_PER_TOKEN"` // Requests per second of each authenticated caller
+	Burst    int     `yaml:"burst" env:"GARGANTUA_API_RATE_LIMIT_BURST"`         // Requests allowed at once, defaults to twice the rate
+}
+
+// APIToken grants a role (reader, releaser or admin) to the bearer of a token.
+type APIToken struct {
+	Name  string `yaml:"name"`
+	Token Secret `yaml:"token"`
+	Role  string `yaml:"role"`
+}
+
+// ForwardConfig holds the optional upstream SMTP relay settings.
+type ForwardConfig struct {
+	Addr     string `yaml:"addr" env:"GARGANTUA_FORWARD_ADDR"`
+	Host     string `yaml:"host" env:"GARGANTUA_FORWARD_HOST"`
+	Username string `yaml:"username" env:"GARGANTUA_FORWARD_USERNAME"`
+	Password Secret `yaml:"password" env:"GARGANTUA_FORWARD_PASSWORD"`
+}
+
+// ShadowConfig holds the optional dark-launch target receiving a copy of every email.
+type ShadowConfig struct {
+	Addr     string        `yaml:"addr" env:"GARGANTUA_SHADOW_ADDR"` // Empty disables shadowing
+	Host     string        `yaml:"host" env:"GARGANTUA_SHADOW_HOST"`
+	Username string        `yaml:"username" env:"GARGANTUA_SHADOW_USERNAME"`
+	Password Secret        `yaml:"password" env:"GARGANTUA_SHADOW_PASSWORD"`
+	Timeout  time.Duration `yaml:"timeout" env:"GARGANTUA_SHADOW_TIMEOUT"`
+
+	// SampleRate is the fraction of selected emails mirrored, from 0 to 1
+	SampleRate float64 `yaml:"sample_rate" env:"GARGANTUA_SHADOW_SAMPLE_RATE"`
+	// Rules restrict mirroring to matching emails; empty selects every email
+	Rules []rules.Match `yaml:"rules"`
+}
+
+// NotifyConfig holds the webhooks notified about captured emails.
+type NotifyConfig struct {
+	Webhooks []WebhookConfig `yaml:"webhooks,omitempty"`
+}
+
+// WebhookConfig describes one notification target.
+type WebhookConfig struct {
+	Name   string `yaml:"name"`
+	URL    Secret `yaml:"url"`    // Slack webhook URLs embed a credential
+	Format string `yaml:"format"` // json (default) or slack
+
+	// Rules restrict notifications to matching emails; empty selects every email
+	Rules []rules.Match `yaml:"rules,omitempty"`
+	// Digest batches notifications into one summary per period; zero sends
+	// one notification per email
+	Digest time.Duration `yaml:"digest,omitempty"`
+}
+
+// AlarmConfig describes an alert on the rate of received emails, posted to
+// a webhook when it fires and when it resolves. Exactly one of Silence and
+// MaxPerMinute is set.
+type AlarmConfig struct {
+	Name   string `yaml:"name"`
+	URL    Secret `yaml:"url"`
+	Format string `yaml:"format"` // json (default) or slack
+
+	// Rules restrict the emails counted; empty counts every email
+	Rules []rules.Match `yaml:"rules,omitempty"`
+	// Silence fires when no email was received for this long
+	Silence time.Duration `yaml:"silence,omitempty"`
+	// MaxPerMinute fires when more emails were received within the last minute
+	MaxPerMinute int `yaml:"max_per_minute,omitempty"`
+}
+
+// CalendarConfig holds the automatic answers to meeting invitations, sent
+// through the forward relay.
+type CalendarConfig struct {
+	// Replies are tried in order for every invited recipient; the first one
+	// whose rules match answers for it, recipients matching none stay silent
+	Replies []CalendarReplyConfig `yaml:"replies,omitempty"`
+}
+
+// CalendarReplyConfig answers the invitations matching its rules.
+type CalendarReplyConfig struct {
+	Response string `yaml:"response"` // accept, decline or tentative
+
+	// Rules select the invitations, matched against each invited recipient
+	// alone; empty selects every invitation
+	Rules []rules.Match `yaml:"rules,omitempty"`
+}
+
+// JunkConfig files the incoming emails that look like spam in the Junk
+// folder of their mailbox instead of the inbox.
+type JunkConfig struct {
+	Enabled    bool    `yaml:"enabled" env:"GARGANTUA_JUNK_ENABLED"`
+	Threshold  float64 `yaml:"threshold" env:"GARGANTUA_JUNK_THRESHOLD"`   // Score from which an email is junk
+	Heuristics bool    `yaml:"heuristics" env:"GARGANTUA_JUNK_HEURISTICS"` // Score the built-in spam traits
+
+	// Rules add their score to the emails they match, on top of the
+	// heuristics; a negative score keeps matching emails in the inbox
+	Rules []JunkRuleConfig `yaml:"rules,omitempty"`
+}
+
+// JunkRuleConfig scores the emails matching its rules.
+type JunkRuleConfig struct {
+	Name  string        `yaml:"name"` // Reported in the verdict of the emails it matched
+	Score float64       `yaml:"score"`
+	Rules []rules.Match `yaml:"rules"`
+}
+
+// ApprovalConfig holds back the emails matching its rules until a person
+// approves them, after which they are forwarded through the forward relay.
+type ApprovalConfig struct {
+	// Rules select the emails needing approval; empty disables the queue
+	Rules []rules.Match `yaml:"rules,omitempty"`
+}
+
+// DNSConfig holds the resolver of every DNS lookup: the sender domain check
+// and the reverse DNS of honeypot senders.
+type DNSConfig struct {
+	// Fixtures is a YAML file of records answering every lookup instead of
+	// DNS, so network-isolated tests are deterministic. Unlisted names do
+	// not exist.
+	Fixtures string `yaml:"fixtures" env:"GARGANTUA_DNS_FIXTURES"`
+}
+
+// DomainConfig declares a domain accepted by the server.
+// When at least one domain is configured, mail for other domains is rejected.
+type DomainConfig struct {
+	Name        string `yaml:"name"`
+	StoragePath string `yaml:"storage_path,omitempty"` // Overrides storage.path for this domain (optional)
+}
+
+// Secret is a configuration value that must never be printed.
+// It may hold a file:, env: or vault: reference resolved when loading.
+type Secret string
+
+// redactedValue replaces secrets when a configuration is displayed.
+const redactedValue = "[redacted]"
+
+// Default returns the built-in configuration defaults.
+func Default() *Config {
+	return &Config{
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+			Output: "stderr",
+		},
+		SMTP: SMTPConfig{
+			Port:            2525,
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			MaxMessageBytes: 1024 * 1024, // 1MB
+			MaxRecipients:   50,
+			ShutdownTimeout: 30 * time.Second,
+			MaxHeaderFields: 1000,
+			MaxHeaderBytes:  256 * 1024,  // 256KB
+			SpillThreshold:  1024 * 1024, // 1MB
+			Capture: SMTPCaptureConfig{
+				MaxBytes: 10 * 1024 * 1024, // 10MB
+			},
+		},
+		API: APIConfig{
+			Addr: ":8080",
+		},
+		Shadow: ShadowConfig{
+			Timeout:    30 * time.Second,
+			SampleRate: 1,
+		},
+		Junk: JunkConfig{
+			Threshold:  5,
+			Heuristics: true,
+		},
+		DomainsPollInterval: 10 * time.Second,
+	}
+}
+
+// ContainerDefault returns the defaults of the container preset: JSON logs
+// on stdout and storage under /data, so the image runs without any flags.
+func ContainerDefault() *Config {
+	cfg := Default()
+	cfg.Container = true
+	cfg.Log.Format = "json"
+	cfg.Log.Output = "stdout"
+	cfg.Storage.Path = "/data"
+	return cfg
+}
+
+// LoadFile decodes the YAML file at path on top of cfg, followed by the
+// fragments matched by its include patterns. Patterns are relative to the
+// directory of path; matches are merged in lexical order, later values
+// overriding earlier ones, except domains which are appended.
+func LoadFile(cfg *Config, path string) error {
+	if err := decodeFile(cfg, path); err != nil {
+		return err
+	}
+
+	includes := cfg.Include
+	for _, pattern := range includes {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern %q: %w", pattern, err)
+		}
+		sort.Strings(matches)
+
+		for _, match := range matches {
+			domains := cfg.Domains
+			cfg.Domains = nil
+			cfg.Include = nil
+
+			if err := decodeFile(cfg, match); err != nil {
+				return err
+			}
+			if len(cfg.Include) > 0 {
+				return fmt.Errorf("config fragment %s: include is only allowed in the main configuration file", match)
+			}
+
+			cfg.Domains = append(domains, cfg.Domains...)
+		}
+	}
+
+	cfg.Include = includes
+	return nil
+}
+
+// decodeFile decodes a single YAML file on top of cfg.
+func decodeFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Load builds a configuration from the defaults, the optional file at path
+// and the process environment. Flags are applied afterwards by the caller.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := LoadFile(cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ApplyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := ResolveSecrets(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// LoadContainer builds a configuration for the container preset from its
+// defaults and the process environment only; no configuration file is read.
+func LoadContainer() (*Config, error) {
+	cfg := ContainerDefault()
+
+	if err := ApplyEnv(cfg, os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := ResolveSecrets(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate reports configuration errors that would prevent the server from starting.
+func (cfg *Config) Validate() error {
+	var errs []error
+
+	if cfg.Storage.Path == "" {
+		errs = append(errs, errors.New("storage path is required (--storage-path, GARGANTUA_STORAGE_PATH or storage.path)"))
+	}
+	if cfg.SMTP.Port <= 0 || cfg.SMTP.Port > 65535 {
+		errs = append(errs, fmt.Errorf("invalid SMTP port %d", cfg.SMTP.Port))
+	}
+
+	switch cfg.SMTP.VRFY {
+	case "", "ambiguous", "disabled", "accept", "strict":
+	default:
+		errs = append(errs, fmt.Errorf("invalid SMTP vrfy mode %q (want ambiguous, disabled, accept or strict)", cfg.SMTP.VRFY))
+	}
+	switch cfg.SMTP.SenderCheck.Mode {
+	case "", "off", "record", "enforce":
+	default:
+		errs = append(errs, fmt.Errorf("invalid SMTP sender_check mode %q (want off, record or enforce)", cfg.SMTP.SenderCheck.Mode))
+	}
+	for domain, answer := range cfg.SMTP.SenderCheck.Stub {
+		switch answer {
+		case "mx", "a", "nullmx", "tempfail":
+		default:
+			errs = append(errs, fmt.Errorf("invalid SMTP sender_check stub %q for %s (want mx, a, nullmx or tempfail)", answer, domain))
+		}
+	}
+	if len(cfg.SMTP.SenderCheck.Stub) > 0 && cfg.DNS.Fixtures != "" {
+		errs = append(errs, errors.New("SMTP sender_check stub cannot be combined with dns fixtures; list the stubbed domains in the fixtures file"))
+	}
+	if cfg.Honeypot && cfg.SMTP.SenderCheck.Mode == "enforce" {
+		errs = append(errs, errors.New("honeypot mode accepts every email; use sender_check mode record"))
+	}
+	for _, keyword := range cfg.SMTP.Extensions.Advertise {
+		if !slices.Contains(SMTPExtensions, strings.ToUpper(keyword)) {
+			errs = append(errs, fmt.Errorf("unknown SMTP extension %q (want one of %s)", keyword, strings.Join(SMTPExtensions, ", ")))
+		}
+	}
+	advertised := func(keyword string) bool {
+		return slices.ContainsFunc(cfg.SMTP.Extensions.Advertise, func(listed string) bool { return strings.EqualFold(listed, keyword) })
+	}
+	if advertised("STARTTLS") && cfg.SMTP.TLS.CertFile == "" {
+		errs = append(errs, errors.New("SMTP extension STARTTLS requires tls cert_file and key_file"))
+	}
+	if advertised("REQUIRETLS") && !advertised("STARTTLS") {
+		errs = append(errs, errors.New("SMTP extension REQUIRETLS requires STARTTLS"))
+	}
+	for _, mechanism := range cfg.SMTP.Extensions.AuthMechanisms {
+		if !strings.EqualFold(mechanism, "PLAIN") && !strings.EqualFold(mechanism, "LOGIN") {
+			errs = append(errs, fmt.Errorf("invalid SMTP auth mechanism %q (want PLAIN or LOGIN)", mechanism))
+		}
+	}
+	if cfg.SMTP.Profile != "" {
+		if !slices.Contains(SMTPProfiles, cfg.SMTP.Profile) {
+			errs = append(errs, fmt.Errorf("unknown SMTP profile %q (want one of %s)", cfg.SMTP.Profile, strings.Join(SMTPProfiles, ", ")))
+		}
+		if len(cfg.SMTP.Extensions.Advertise) > 0 || len(cfg.SMTP.Extensions.AuthMechanisms) > 0 {
+			errs = append(errs, errors.New("SMTP profile sets the extensions; remove smtp.extensions"))
+		}
+	}
+	if (cfg.SMTP.TLS.CertFile == "") != (cfg.SMTP.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("SMTP TLS needs both cert_file and key_file"))
+	}
+	if cfg.SMTP.TLS.ClientCAFile != "" && cfg.SMTP.TLS.CertFile == "" {
+		errs = append(errs, errors.New("SMTP TLS client_ca_file requires cert_file and key_file"))
+	}
+	if cfg.SMTP.TLS.KeyLogFile != "" && cfg.SMTP.TLS.CertFile == "" {
+		errs = append(errs, errors.New("SMTP TLS key_log_file requires cert_file and key_file"))
+	}
+	if cfg.SMTP.Capture.Dir != "" && cfg.SMTP.Capture.MaxBytes <= 0 {
+		errs = append(errs, fmt.Errorf("invalid SMTP capture max_bytes %d", cfg.SMTP.Capture.MaxBytes))
+	}
+
+	if cfg.SMTP.MaxHeaderFields < 0 {
+		errs = append(errs, fmt.Errorf("invalid SMTP max header fields %d", cfg.SMTP.MaxHeaderFields))
+	}
+	if cfg.SMTP.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("invalid SMTP max header bytes %d", cfg.SMTP.MaxHeaderBytes))
+	}
+	if cfg.SMTP.SpillThreshold <= 0 {
+		errs = append(errs, fmt.Errorf("invalid SMTP spill threshold %d", cfg.SMTP.SpillThreshold))
+	}
+
+	if cfg.Honeypot && (len(cfg.Domains) > 0 || cfg.DomainsDir != "") {
+		errs = append(errs, errors.New("honeypot mode accepts every domain; remove domains and domains_dir"))
+	}
+
+	if cfg.Storage.Retention.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("invalid retention max age %s", cfg.Storage.Retention.MaxAge))
+	}
+	for domain, maxAge := range cfg.Storage.Retention.Domains {
+		if maxAge < 0 {
+			errs = append(errs, fmt.Errorf("invalid retention max age %s for domain %s", maxAge, domain))
+		}
+	}
+
+	if cfg.DomainsDir != "" && cfg.DomainsPollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("invalid domains poll interval %s", cfg.DomainsPollInterval))
+	}
+
+	if cfg.Shadow.SampleRate < 0 || cfg.Shadow.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("shadow sample rate %v must be between 0 and 1", cfg.Shadow.SampleRate))
+	}
+	for i, rule := range cfg.Shadow.Rules {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("shadow.rules[%d]: %w", i, err))
+		}
+	}
+
+	errs = append(errs, cfg.API.validate()...)
+
+	for i, route := range cfg.Storage.SizeRoutes {
+		if route.MinBytes <= 0 {
+			errs = append(errs, fmt.Errorf("storage.size_routes[%d]: invalid min_bytes %d", i, route.MinBytes))
+		}
+		if route.Path == "" {
+			errs = append(errs, fmt.Errorf("storage.size_routes[%d]: path is required", i))
+		}
+	}
+
+	if cfg.Storage.Faults != "" {
+		if _, err := storage.ParseFaults(cfg.Storage.Faults); err != nil {
+			errs = append(errs, fmt.Errorf("storage.faults: %w", err))
+		}
+	}
+
+	for i, webhook := range cfg.Notify.Webhooks {
+		if webhook.URL == "" {
+			errs = append(errs, fmt.Errorf("notify.webhooks[%d]: url is required", i))
+		}
+		if !validWebhookFormat(webhook.Format) {
+			errs = append(errs, fmt.Errorf("notify.webhooks[%d]: invalid format %q (want json or slack)", i, webhook.Format))
+		}
+		if webhook.Digest < 0 {
+			errs = append(errs, fmt.Errorf("notify.webhooks[%d]: invalid digest period %s", i, webhook.Digest))
+		}
+		for j, rule := range webhook.Rules {
+			if err := rule.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("notify.webhooks[%d].rules[%d]: %w", i, j, err))
+			}
+		}
+	}
+
+	alarms := make(map[string]bool)
+	for i, alarm := range cfg.Alarms {
+		if alarm.Name == "" {
+			errs = append(errs, fmt.Errorf("alarms[%d]: name is required", i))
+		} else if alarms[alarm.Name] {
+			errs = append(errs, fmt.Errorf("alarms[%d]: duplicate name %q", i, alarm.Name))
+		}
+		alarms[alarm.Name] = true
+
+		if alarm.URL == "" {
+			errs = append(errs, fmt.Errorf("alarms[%d]: url is required", i))
+		}
+		if !validWebhookFormat(alarm.Format) {
+			errs = append(errs, fmt.Errorf("alarms[%d]: invalid format %q (want json or slack)", i, alarm.Format))
+		}
+		if alarm.Silence < 0 || alarm.MaxPerMinute < 0 || (alarm.Silence > 0) == (alarm.MaxPerMinute > 0) {
+			errs = append(errs, fmt.Errorf("alarms[%d]: set exactly one of silence and max_per_minute", i))
+		}
+		for j, rule := range alarm.Rules {
+			if err := rule.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("alarms[%d].rules[%d]: %w", i, j, err))
+			}
+		}
+	}
+
+	if len(cfg.Calendar.Replies) > 0 && cfg.Forward.Addr == "" {
+		errs = append(errs, errors.New("calendar.replies are sent through forward; set forward.addr"))
+	}
+	for i, reply := range cfg.Calendar.Replies {
+		switch reply.Response {
+		case "accept", "decline", "tentative":
+		default:
+			errs = append(errs, fmt.Errorf("calendar.replies[%d]: invalid response %q (want accept, decline or tentative)", i, reply.Response))
+		}
+		for j, rule := range reply.Rules {
+			if err := rule.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("calendar.replies[%d].rules[%d]: %w", i, j, err))
+			}
+		}
+	}
+
+	if len(cfg.Approval.Rules) > 0 && cfg.Forward.Addr == "" {
+		errs = append(errs, errors.New("approved emails are sent through forward; set forward.addr"))
+	}
+	for i, rule := range cfg.Approval.Rules {
+		if err := rule.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("approval.rules[%d]: %w", i, err))
+		}
+	}
+
+	if cfg.Junk.Enabled && cfg.Junk.Threshold <= 0 {
+		errs = append(errs, fmt.Errorf("invalid junk threshold %g", cfg.Junk.Threshold))
+	}
+	for i, rule := range cfg.Junk.Rules {
+		if rule.Name == "" {
+			errs = append(errs, fmt.Errorf("junk.rules[%d]: name is required", i))
+		}
+		if len(rule.Rules) == 0 {
+			errs = append(errs, fmt.Errorf("junk.rules[%d]: rules are required", i))
+		}
+		for j, match := range rule.Rules {
+			if err := match.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("junk.rules[%d].rules[%d]: %w", i, j, err))
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, domain := range cfg.Domains {
+		if domain.Name == "" {
+			errs = append(errs, fmt.Errorf("domains[%d]: name is required", i))
+			continue
+		}
+		if seen[domain.Name] {
+			errs = append(errs, fmt.Errorf("domains[%d]: duplicate domain %q", i, domain.Name))
+		}
+		seen[domain.Name] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// validate reports invalid tokens and group mappings.
+func (api APIConfig) validate() []error {
+	var errs []error
+
+	names := make(map[string]bool)
+	for i, token := range api.Tokens {
+		if token.Name == "" || token.Token == "" {
+			errs = append(errs, fmt.Errorf("api.tokens[%d]: name and token are required", i))
+		}
+		if names[token.Name] {
+			errs = append(errs, fmt.Errorf("api.tokens[%d]: duplicate token name %q", i, token.Name))
+		}
+		names[token.Name] = true
+		if _, err := auth.ParseRole(token.Role); err != nil {
+			errs = append(errs, fmt.Errorf("api.tokens[%d]: %w", i, err))
+		}
+	}
+
+	if len(api.Groups) > 0 && api.GroupHeader == "" {
+		errs = append(errs, errors.New("api.groups requires api.group_header"))
+	}
+	if api.UserHeader != "" && api.GroupHeader == "" {
+		errs = append(errs, errors.New("api.user_header requires api.group_header"))
+	}
+	for group, role := range api.Groups {
+		if _, err := auth.ParseRole(role); err != nil {
+			errs = append(errs, fmt.Errorf("api.groups[%s]: %w", group, err))
+		}
+	}
+
+	limit := api.RateLimit
+	if limit.PerIP < 0 || limit.PerToken < 0 || limit.Burst < 0 {
+		errs = append(errs, errors.New("api.rate_limit values must not be negative"))
+	}
+
+	if api.PublicURL != "" {
+		if u, err := url.Parse(api.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid api.public_url %q, want an http or https URL", api.PublicURL))
+		}
+	}
+	return errs
+}
+
+// validWebhookFormat reports whether format is a supported webhook payload format.
+func validWebhookFormat(format string) bool {
+	return format == "" || format == "json" || format == "slack"
+}
+
+// Redacted returns a deep copy of the configuration with every secret masked.
+func (cfg *Config) Redacted() *Config {
+	clone := cfg.clone()
+	redactSecrets(clone)
+	return clone
+}
+
+// YAML renders the configuration as a YAML document.
+func (cfg *Config) YAML() ([]byte, error) {
+	var buf bytes.Buffer
+
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// clone returns a deep copy of the configuration.
+func (cfg *Config) clone() *Config {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		panic(fmt.Sprintf("config: marshalling for clone: %v", err))
+	}
+
+	clone := &Config{}
+	if err := yaml.Unmarshal(data, clone); err != nil {
+		panic(fmt.Sprintf("config: unmarshalling for clone: %v", err))
+	}
+	return clone
+}
@@ -0,0 +1,358 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+)
+
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("creating config directory failed: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("writing config file failed: %v", err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, t.TempDir(), "config.yaml", `
+smtp:
+  port: 2600
+  read_timeout: 30s
+storage:
+  path: /from/file
+domains:
+  - name: example.com
+`)
+
+	t.Setenv("GARGANTUA_STORAGE_PATH", "/from/env")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.SMTP.Port != 2600 {
+		t.Errorf("SMTP.Port = %d, want 2600 from file", cfg.SMTP.Port)
+	}
+	if cfg.SMTP.ReadTimeout != 30*time.Second {
+		t.Errorf("SMTP.ReadTimeout = %v, want 30s from file", cfg.SMTP.ReadTimeout)
+	}
+	if cfg.SMTP.MaxRecipients != 50 {
+		t.Errorf("SMTP.MaxRecipients = %d, want default 50", cfg.SMTP.MaxRecipients)
+	}
+	if cfg.Storage.Path != "/from/env" {
+		t.Errorf("Storage.Path = %q, want environment override", cfg.Storage.Path)
+	}
+	if len(cfg.Domains) != 1 || cfg.Domains[0].Name != "example.com" {
+		t.Errorf("Domains = %+v, want example.com", cfg.Domains)
+	}
+}
+
+func TestApplyEnvInvalidValue(t *testing.T) {
+	env := map[string]string{"GARGANTUA_SMTP_PORT": "not-a-number"}
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	err := ApplyEnv(Default(), lookup)
+	if err == nil || !strings.Contains(err.Error(), "GARGANTUA_SMTP_PORT") {
+		t.Errorf("ApplyEnv() error = %v, want error naming the variable", err)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := Default()
+	cfg.Forward.Password = "hunter2"
+
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		t.Fatalf("YAML() error = %v", err)
+	}
+
+	if strings.Contains(string(out), "hunter2") {
+		t.Error("redacted output contains the secret")
+	}
+	if !strings.Contains(string(out), redactedValue) {
+		t.Error("redacted output does not mark the secret")
+	}
+	if cfg.Forward.Password != "hunter2" {
+		t.Error("Redacted() modified the original configuration")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			modify:  func(cfg *Config) { cfg.Storage.Path = "/tmp/mail" },
+			wantErr: false,
+		},
+		{
+			name:    "missing_storage_path",
+			modify:  func(cfg *Config) {},
+			wantErr: true,
+		},
+		{
+			name: "duplicate_domain",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Domains = []DomainConfig{{Name: "example.com"}, {Name: "example.com"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative_max_header_fields",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.MaxHeaderFields = -1
+			},
+			wantErr: true,
+		},
+		{
+			name: "api_token_role",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.API.Tokens = []APIToken{{Name: "ci", Token: "secret", Role: "root"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "api_groups_without_header",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.API.Groups = map[string]string{"qa": "reader"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "webhook_format",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Notify.Webhooks = []WebhookConfig{{URL: "https://hooks.example.com/x", Format: "teams"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "alarm_without_condition",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Alarms = []AlarmConfig{{Name: "quiet", URL: "https://hooks.example.com/x"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative_public_url",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.API.PublicURL = "sink.example.com"
+			},
+			wantErr: true,
+		},
+		{
+			name: "calendar_reply_without_forward",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Calendar.Replies = []CalendarReplyConfig{{Response: "accept"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid_sender_check_stub",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.SenderCheck = SMTPSenderCheckConfig{Mode: "record", Stub: map[string]string{"example.com": "aaaa"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "sender_check_stub_with_dns_fixtures",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.SenderCheck = SMTPSenderCheckConfig{Mode: "record", Stub: map[string]string{"example.com": "mx"}}
+				cfg.DNS.Fixtures = "/tmp/dns.yaml"
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown_smtp_extension",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.Extensions.Advertise = []string{"SIZE", "XCLIENT"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "starttls_extension_without_certificate",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.Extensions.Advertise = []string{"SIZE", "STARTTLS"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown_smtp_profile",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.Profile = "yahoo-like"
+			},
+			wantErr: true,
+		},
+		{
+			name: "smtp_profile_with_extensions",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.Profile = "gmail-like"
+				cfg.SMTP.Extensions.Advertise = []string{"SIZE"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative_retention",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Storage.Retention.Domains = map[string]time.Duration{"example.com": -time.Hour}
+			},
+			wantErr: true,
+		},
+		{
+			name: "approval_without_forward",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Approval.Rules = []rules.Match{{To: "*@customer.com"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "calendar_invalid_response",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Forward.Addr = "smtp.example.com:587"
+				cfg.Calendar.Replies = []CalendarReplyConfig{{Response: "maybe"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "junk_rule_without_name",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Junk.Enabled = true
+				cfg.Junk.Rules = []JunkRuleConfig{{Score: 5, Rules: []rules.Match{{From: "*@example.com"}}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "key_log_without_certificate",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.TLS.KeyLogFile = "/tmp/keys.log"
+			},
+			wantErr: true,
+		},
+		{
+			name: "capture_without_size_limit",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.Capture = SMTPCaptureConfig{Dir: "/tmp/captures", MaxBytes: 0}
+			},
+			wantErr: true,
+		},
+		{
+			name: "honeypot_with_domains",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.Honeypot = true
+				cfg.Domains = []DomainConfig{{Name: "example.com"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls_without_key",
+			modify: func(cfg *Config) {
+				cfg.Storage.Path = "/tmp/mail"
+				cfg.SMTP.TLS.CertFile = "/etc/gargantua/cert.pem"
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadFileIncludes(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfigFile(t, dir, "config.yaml", `
+include:
+  - conf.d/*.yaml
+smtp:
+  port: 2600
+  max_recipients: 10
+domains:
+  - name: main.example
+`)
+	writeConfigFile(t, dir, "conf.d/10-team-a.yaml", `
+smtp:
+  max_recipients: 20
+domains:
+  - name: team-a.example
+`)
+	writeConfigFile(t, dir, "conf.d/20-team-b.yaml", `
+smtp:
+  max_recipients: 30
+domains:
+  - name: team-b.example
+`)
+
+	cfg := Default()
+	if err := LoadFile(cfg, path); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+
+	if cfg.SMTP.Port != 2600 {
+		t.Errorf("SMTP.Port = %d, want 2600 kept from main file", cfg.SMTP.Port)
+	}
+	if cfg.SMTP.MaxRecipients != 30 {
+		t.Errorf("SMTP.MaxRecipients = %d, want 30 from last fragment", cfg.SMTP.MaxRecipients)
+	}
+
+	var names []string
+	for _, domain := range cfg.Domains {
+		names = append(names, domain.Name)
+	}
+	want := "main.example,team-a.example,team-b.example"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("Domains = %s, want %s", got, want)
+	}
+}
+
+func TestLoadFileNestedInclude(t *testing.T) {
+	dir := t.TempDir()
+	path := writeConfigFile(t, dir, "config.yaml", "include: [conf.d/*.yaml]\n")
+	writeConfigFile(t, dir, "conf.d/nested.yaml", "include: [more/*.yaml]\n")
+
+	if err := LoadFile(Default(), path); err == nil {
+		t.Error("LoadFile() accepted a nested include")
+	}
+}
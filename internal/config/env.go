@@ -0,0 +1,154 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	secretType   = reflect.TypeOf(Secret(""))
+)
+
+// LookupFunc retrieves the value of an environment variable.
+type LookupFunc func(key string) (string, bool)
+
+// ApplyEnv overrides configuration fields tagged with `env` using the
+// variables returned by lookup. Secret fields can also be read from the file
+// named by the variable with a _FILE suffix, e.g. GARGANTUA_FORWARD_PASSWORD_FILE.
+func ApplyEnv(cfg *Config, lookup LookupFunc) error {
+	return walkFields(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, value reflect.Value) error {
+		key := field.Tag.Get("env")
+		if key == "" {
+			return nil
+		}
+
+		raw, ok := lookup(key)
+		if value.Type() == secretType {
+			if path, hasFile := lookup(key + "_FILE"); hasFile {
+				if ok {
+					return fmt.Errorf("both %s and %s_FILE are set", key, key)
+				}
+
+				secret, err := readSecretFile(path)
+				if err != nil {
+					return fmt.Errorf("parsing %s_FILE: %w", key, err)
+				}
+				raw, ok = string(secret), true
+			}
+		}
+		if !ok {
+			return nil
+		}
+
+		if err := setValue(value, raw); err != nil {
+			return fmt.Errorf("parsing %s: %w", key, err)
+		}
+		return nil
+	})
+}
+
+// EnvVars returns the names of every environment variable the configuration reads.
+func EnvVars() []string {
+	var names []string
+	walkFields(reflect.ValueOf(Default()).Elem(), func(field reflect.StructField, _ reflect.Value) error {
+		if key := field.Tag.Get("env"); key != "" {
+			names = append(names, key)
+		}
+		return nil
+	})
+	return names
+}
+
+// redactSecrets masks every non-empty Secret field in cfg.
+func redactSecrets(cfg *Config) {
+	walkFields(reflect.ValueOf(cfg).Elem(), func(_ reflect.StructField, value reflect.Value) error {
+		if value.Type() == secretType && value.String() != "" {
+			value.SetString(redactedValue)
+		}
+		return nil
+	})
+}
+
+// walkFields calls fn for every leaf field of the struct v, descending into
+// nested structs and slices of structs.
+func walkFields(v reflect.Value, fn func(reflect.StructField, reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		switch {
+		case value.Kind() == reflect.Struct:
+			if err := walkFields(value, fn); err != nil {
+				return err
+			}
+		case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+			for j := 0; j < value.Len(); j++ {
+				if err := walkFields(value.Index(j), fn); err != nil {
+					return err
+				}
+			}
+		default:
+			if err := fn(field, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setValue parses raw into the field value according to its type.
+func setValue(value reflect.Value, raw string) error {
+	if value.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(d))
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(f)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %s", value.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items).Convert(value.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
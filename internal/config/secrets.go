@@ -0,0 +1,169 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Secret value prefixes selecting where the actual value is read from.
+const (
+	secretFilePrefix  = "file:"  // file:/run/secrets/forward_password
+	secretEnvPrefix   = "env:"   // env:FORWARD_PASSWORD
+	secretVaultPrefix = "vault:" // vault:secret/data/gargantua#forward_password
+)
+
+// VaultConfig holds the optional HashiCorp Vault settings used to resolve
+// vault: secret references. Empty values fall back to VAULT_ADDR and VAULT_TOKEN.
+type VaultConfig struct {
+	Addr  string `yaml:"addr" env:"GARGANTUA_VAULT_ADDR"`
+	Token Secret `yaml:"token" env:"GARGANTUA_VAULT_TOKEN"`
+}
+
+// ResolveSecrets replaces every secret reference in cfg with the value it
+// points to. Plain values are left untouched.
+func ResolveSecrets(cfg *Config) error {
+	// The Vault token may itself come from a file or variable, but not from Vault
+	token, err := resolveLocalSecret(cfg.Vault.Token)
+	if err != nil {
+		return fmt.Errorf("resolving vault token: %w", err)
+	}
+
+	vault := newVaultClient(cfg.Vault.Addr, string(token))
+	return walkFields(reflect.ValueOf(cfg).Elem(), func(field reflect.StructField, value reflect.Value) error {
+		if value.Type() != secretType {
+			return nil
+		}
+
+		secret := Secret(value.String())
+		if strings.HasPrefix(string(secret), secretVaultPrefix) {
+			resolved, err := vault.read(strings.TrimPrefix(string(secret), secretVaultPrefix))
+			if err != nil {
+				return fmt.Errorf("resolving %s: %w", fieldName(field), err)
+			}
+			value.SetString(resolved)
+			return nil
+		}
+
+		resolved, err := resolveLocalSecret(secret)
+		if err != nil {
+			return fmt.Errorf("resolving %s: %w", fieldName(field), err)
+		}
+		value.SetString(string(resolved))
+		return nil
+	})
+}
+
+// resolveLocalSecret resolves file: and env: references.
+func resolveLocalSecret(secret Secret) (Secret, error) {
+	value := string(secret)
+
+	switch {
+	case strings.HasPrefix(value, secretFilePrefix):
+		return readSecretFile(strings.TrimPrefix(value, secretFilePrefix))
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return Secret(resolved), nil
+	default:
+		return secret, nil
+	}
+}
+
+// readSecretFile reads a secret from a file, dropping the trailing newline.
+func readSecretFile(path string) (Secret, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	return Secret(strings.TrimRight(string(data), "\r\n")), nil
+}
+
+// fieldName returns the YAML name of a configuration field for error messages.
+func fieldName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("yaml"), ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// vaultClient reads secrets from the Vault HTTP API.
+type vaultClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// newVaultClient creates a client, falling back to VAULT_ADDR and VAULT_TOKEN.
+func newVaultClient(addr, token string) *vaultClient {
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+
+	return &vaultClient{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// read fetches "path#key" from Vault. Both KV version 1 and version 2
+// responses are understood; for version 2 the path must include "data/".
+func (vault *vaultClient) read(ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("invalid vault reference %q (want path#key)", ref)
+	}
+	if vault.addr == "" {
+		return "", errors.New("vault address not configured (vault.addr or VAULT_ADDR)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, vault.addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", vault.token)
+
+	resp, err := vault.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("reading vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("reading vault secret %s: unexpected status %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding vault secret %s: %w", path, err)
+	}
+
+	data := body.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		data = nested // KV version 2
+	}
+
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string key %q", path, key)
+	}
+	return value, nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := writeConfigFile(t, dir, "forward_password", "from-file\n")
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/gargantua" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"password":"from-vault"}}}`))
+	}))
+	defer vault.Close()
+
+	t.Setenv("SINK_PASSWORD", "from-env")
+	t.Setenv("SINK_VAULT_TOKEN", "vault-token")
+
+	tests := []struct {
+		name    string
+		value   Secret
+		want    Secret
+		wantErr bool
+	}{
+		{name: "plain", value: "literal", want: "literal"},
+		{name: "file", value: Secret("file:" + secretFile), want: "from-file"},
+		{name: "env", value: "env:SINK_PASSWORD", want: "from-env"},
+		{name: "vault", value: "vault:secret/data/gargantua#password", want: "from-vault"},
+		{name: "missing_env", value: "env:SINK_UNSET", wantErr: true},
+		{name: "missing_vault_key", value: "vault:secret/data/gargantua#other", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Vault.Addr = vault.URL
+			cfg.Vault.Token = "env:SINK_VAULT_TOKEN"
+			cfg.Forward.Password = tt.value
+
+			err := ResolveSecrets(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveSecrets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg.Forward.Password != tt.want {
+				t.Errorf("Forward.Password = %q, want %q", cfg.Forward.Password, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvSecretFile(t *testing.T) {
+	secretFile := writeConfigFile(t, t.TempDir(), "password", "s3cret\n")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    Secret
+		wantErr bool
+	}{
+		{
+			name: "file_convention",
+			env:  map[string]string{"GARGANTUA_FORWARD_PASSWORD_FILE": secretFile},
+			want: "s3cret",
+		},
+		{
+			name: "both_set",
+			env: map[string]string{
+				"GARGANTUA_FORWARD_PASSWORD":      "inline",
+				"GARGANTUA_FORWARD_PASSWORD_FILE": secretFile,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := func(key string) (string, bool) {
+				value, ok := tt.env[key]
+				return value, ok
+			}
+
+			cfg := Default()
+			err := ApplyEnv(cfg, lookup)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyEnv() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && cfg.Forward.Password != tt.want {
+				t.Errorf("Forward.Password = %q, want %q", cfg.Forward.Password, tt.want)
+			}
+		})
+	}
+}
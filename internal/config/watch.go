@@ -0,0 +1,192 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DomainChangeFunc receives the domains whose settings were added or
+// changed, to be registered in place, and the names of the domains no
+// longer defined by any fragment nor by the main configuration.
+type DomainChangeFunc func(changed []DomainConfig, removed []string)
+
+// DomainWatcher polls a directory of per-domain configuration fragments,
+// such as a mounted Kubernetes ConfigMap, and reports additions and removals.
+//
+// Each *.yaml or *.yml file holds a single domain; when its name is omitted
+// the file name without extension is used (example.com.yaml -> example.com).
+// A domain of the main configuration cannot be changed by a fragment, and
+// when several fragments name the same domain the first file in name order
+// defines it.
+type DomainWatcher struct {
+	dir       string
+	interval  time.Duration
+	apply     DomainChangeFunc
+	static    []DomainConfig          // Domains of the main configuration
+	fragments map[string]DomainConfig // Keyed by fragment file name
+	domains   map[string]DomainConfig // Effective settings, keyed by lowercased name
+}
+
+// NewDomainWatcher creates a watcher for dir that calls apply on changes.
+// static are the domains of the main configuration, already registered.
+func NewDomainWatcher(dir string, interval time.Duration, static []DomainConfig, apply DomainChangeFunc) *DomainWatcher {
+	watcher := &DomainWatcher{
+		dir:       dir,
+		interval:  interval,
+		apply:     apply,
+		static:    static,
+		fragments: make(map[string]DomainConfig),
+	}
+	watcher.domains = watcher.resolve(nil)
+	return watcher
+}
+
+// Run scans the directory every interval until ctx is cancelled.
+func (watcher *DomainWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(watcher.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := watcher.Scan(); err != nil {
+				log.Printf("Error scanning domains directory %s: %v", watcher.dir, err)
+			}
+		}
+	}
+}
+
+// Scan reads the directory once and applies any difference with the previous scan.
+// A fragment that fails to parse keeps its previously loaded domain.
+func (watcher *DomainWatcher) Scan() error {
+	files, err := fragmentFiles(watcher.dir)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]DomainConfig, len(files))
+	for _, file := range files {
+		domain, err := loadDomainFragment(filepath.Join(watcher.dir, file))
+		if err != nil {
+			log.Printf("Ignoring invalid domain fragment %s: %v", file, err)
+			if previous, ok := watcher.fragments[file]; ok {
+				current[file] = previous
+			}
+			continue
+		}
+		current[file] = domain
+	}
+
+	updated := make(map[string]bool)
+	for file, domain := range current {
+		if previous, ok := watcher.fragments[file]; !ok || domain != previous {
+			updated[file] = true
+		}
+	}
+	watcher.fragments = current
+	domains := watcher.resolve(updated)
+
+	var changed []DomainConfig
+	var removed []string
+	for name, domain := range domains {
+		if previous, ok := watcher.domains[name]; !ok || domain != previous {
+			changed = append(changed, domain)
+		}
+	}
+	for name, previous := range watcher.domains {
+		if _, ok := domains[name]; !ok {
+			removed = append(removed, previous.Name)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Name < changed[j].Name })
+	sort.Strings(removed)
+
+	watcher.domains = domains
+	if len(changed) > 0 || len(removed) > 0 {
+		watcher.apply(changed, removed)
+	}
+	return nil
+}
+
+// resolve returns the effective settings of every domain: those of the main
+// configuration, then those of the fragments in file name order, the first
+// definition of a domain winning. Shadowed definitions in the updated
+// fragment files are logged.
+func (watcher *DomainWatcher) resolve(updated map[string]bool) map[string]DomainConfig {
+	domains := make(map[string]DomainConfig, len(watcher.static)+len(watcher.fragments))
+	owners := make(map[string]string, len(domains))
+	for _, domain := range watcher.static {
+		name := strings.ToLower(domain.Name)
+		domains[name], owners[name] = domain, "the configuration file"
+	}
+
+	files := make([]string, 0, len(watcher.fragments))
+	for file := range watcher.fragments {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		domain := watcher.fragments[file]
+		name := strings.ToLower(domain.Name)
+		if owner, ok := owners[name]; ok {
+			if updated[file] {
+				log.Printf("Ignoring domain fragment %s: %s is already defined by %s", file, domain.Name, owner)
+			}
+			continue
+		}
+		domains[name], owners[name] = domain, file
+	}
+	return domains
+}
+
+// fragmentFiles lists the YAML files of dir in name order, skipping hidden
+// entries such as the ..data links Kubernetes creates for ConfigMaps.
+func fragmentFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading domains directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || entry.IsDir() {
+			continue
+		}
+		if ext := filepath.Ext(name); ext == ".yaml" || ext == ".yml" {
+			files = append(files, name)
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// loadDomainFragment parses a single-domain fragment file.
+func loadDomainFragment(path string) (DomainConfig, error) {
+	var domain DomainConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return domain, err
+	}
+	if err := yaml.Unmarshal(data, &domain); err != nil {
+		return domain, err
+	}
+
+	if domain.Name == "" {
+		base := filepath.Base(path)
+		domain.Name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return domain, nil
+}
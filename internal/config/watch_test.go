@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDomainWatcherScan(t *testing.T) {
+	dir := t.TempDir()
+
+	var changed []DomainConfig
+	var removed []string
+	static := []DomainConfig{{Name: "static.net"}}
+	watcher := NewDomainWatcher(dir, 0, static, func(c []DomainConfig, r []string) {
+		changed, removed = c, r
+	})
+
+	scan := func() {
+		t.Helper()
+		changed, removed = nil, nil
+		if err := watcher.Scan(); err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+	}
+
+	// New fragments are added, the name defaults to the file name
+	writeConfigFile(t, dir, "example.com.yaml", "storage_path: /mail/example\n")
+	writeConfigFile(t, dir, "other.yaml", "name: other.org\n")
+	writeConfigFile(t, dir, "..data/ignored.yaml", "name: hidden.org\n")
+	scan()
+	if len(changed) != 2 || len(removed) != 0 {
+		t.Fatalf("first scan: changed %v, removed %v; want 2 changed", changed, removed)
+	}
+
+	// Unchanged directory reports nothing
+	scan()
+	if changed != nil || removed != nil {
+		t.Errorf("unchanged scan: changed %v, removed %v; want no changes", changed, removed)
+	}
+
+	// An invalid fragment keeps its previous domain
+	writeConfigFile(t, dir, "other.yaml", "name: [broken\n")
+	scan()
+	if changed != nil || removed != nil {
+		t.Errorf("invalid fragment: changed %v, removed %v; want no changes", changed, removed)
+	}
+
+	// A changed fragment is only reported as changed, a deleted one as removed
+	writeConfigFile(t, dir, "example.com.yaml", "storage_path: /mail/moved\n")
+	if err := os.Remove(filepath.Join(dir, "other.yaml")); err != nil {
+		t.Fatalf("removing fragment failed: %v", err)
+	}
+	scan()
+	if len(changed) != 1 || changed[0].StoragePath != "/mail/moved" {
+		t.Errorf("changed fragment: changed %v, want example.com at /mail/moved", changed)
+	}
+	if len(removed) != 1 || removed[0] != "other.org" {
+		t.Errorf("deleted fragment: removed %v, want other.org", removed)
+	}
+}
+
+func TestDomainWatcherOwnership(t *testing.T) {
+	dir := t.TempDir()
+
+	var changed []DomainConfig
+	var removed []string
+	static := []DomainConfig{{Name: "static.net", StoragePath: "/mail/static"}}
+	watcher := NewDomainWatcher(dir, 0, static, func(c []DomainConfig, r []string) {
+		changed, removed = c, r
+	})
+
+	scan := func() {
+		t.Helper()
+		changed, removed = nil, nil
+		if err := watcher.Scan(); err != nil {
+			t.Fatalf("Scan() error = %v", err)
+		}
+	}
+
+	// A fragment cannot override a static domain, the first of two
+	// fragments naming the same domain defines it
+	writeConfigFile(t, dir, "a.yaml", "name: shared.org\nstorage_path: /mail/a\n")
+	writeConfigFile(t, dir, "b.yaml", "name: Shared.org\nstorage_path: /mail/b\n")
+	writeConfigFile(t, dir, "static.yaml", "name: static.net\nstorage_path: /mail/fragment\n")
+	scan()
+	if len(changed) != 1 || changed[0].StoragePath != "/mail/a" {
+		t.Fatalf("first scan: changed %v, want shared.org at /mail/a", changed)
+	}
+
+	// Changing a shadowed fragment changes nothing
+	writeConfigFile(t, dir, "b.yaml", "name: shared.org\nstorage_path: /mail/b2\n")
+	scan()
+	if changed != nil || removed != nil {
+		t.Errorf("shadowed fragment: changed %v, removed %v; want no changes", changed, removed)
+	}
+
+	// Deleting the defining fragment hands the domain to the next one
+	if err := os.Remove(filepath.Join(dir, "a.yaml")); err != nil {
+		t.Fatalf("removing fragment failed: %v", err)
+	}
+	scan()
+	if len(changed) != 1 || changed[0].StoragePath != "/mail/b2" || removed != nil {
+		t.Errorf("deleted owner: changed %v, removed %v; want shared.org at /mail/b2", changed, removed)
+	}
+
+	// Deleting the fragment of a static domain keeps it
+	if err := os.Remove(filepath.Join(dir, "static.yaml")); err != nil {
+		t.Fatalf("removing fragment failed: %v", err)
+	}
+	scan()
+	if changed != nil || removed != nil {
+		t.Errorf("deleted static fragment: changed %v, removed %v; want no changes", changed, removed)
+	}
+
+	// The domain goes once no fragment defines it
+	if err := os.Remove(filepath.Join(dir, "b.yaml")); err != nil {
+		t.Fatalf("removing fragment failed: %v", err)
+	}
+	scan()
+	if len(removed) != 1 || removed[0] != "shared.org" {
+		t.Errorf("last fragment deleted: removed %v, want shared.org", removed)
+	}
+}
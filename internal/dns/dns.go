@@ -0,0 +1,172 @@
+// Package dns routes the DNS lookups of the sink through a single resolver,
+// either the system one or fixtures answering from a file, so features
+// depending on DNS behave deterministically in network-isolated tests.
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrorTempFail is the Error of records whose lookups all fail temporarily.
+const ErrorTempFail = "tempfail"
+
+// Resolver performs the DNS lookups of the sink. *net.Resolver implements it.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// New creates the resolver of cfg: the fixtures when configured, else the
+// system resolver.
+func New(cfg config.DNSConfig) (Resolver, error) {
+	if cfg.Fixtures == "" {
+		return net.DefaultResolver, nil
+	}
+	return LoadFixture(cfg.Fixtures)
+}
+
+// Records are the answers for one name of a fixture. The name of PTR
+// records is the address they belong to.
+type Records struct {
+	MX    []string `yaml:"mx,omitempty"`    // Exchanges in preference order; "." is a null MX
+	A     []string `yaml:"a,omitempty"`     // IPv4 and IPv6 addresses
+	PTR   []string `yaml:"ptr,omitempty"`   // Names of the address
+	TXT   []string `yaml:"txt,omitempty"`   // Text records, such as SPF policies
+	Error string   `yaml:"error,omitempty"` // tempfail fails every lookup of the name
+}
+
+// validate checks that the records can be served.
+func (records Records) validate() error {
+	if records.Error != "" && records.Error != ErrorTempFail {
+		return fmt.Errorf("invalid error %q (want %s)", records.Error, ErrorTempFail)
+	}
+	for _, addr := range records.A {
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid address %q", addr)
+		}
+	}
+	return nil
+}
+
+// Fixture answers lookups from fixed records instead of DNS. Names are
+// matched case-insensitively, with or without the trailing dot, and
+// unlisted names do not exist.
+type Fixture struct {
+	records map[string]Records
+}
+
+// NewFixture creates a fixture answering with records, by name.
+func NewFixture(records map[string]Records) *Fixture {
+	fixture := &Fixture{records: make(map[string]Records, len(records))}
+	for name, answer := range records {
+		fixture.records[normalize(name)] = answer
+	}
+	return fixture
+}
+
+// LoadFixture reads a fixture from a YAML file mapping names to records.
+func LoadFixture(path string) (*Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading DNS fixtures: %w", err)
+	}
+	var records map[string]Records
+	if err := yaml.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parsing DNS fixtures %s: %w", path, err)
+	}
+	for name, answer := range records {
+		if err := answer.validate(); err != nil {
+			return nil, fmt.Errorf("DNS fixtures %s, name %s: %w", path, name, err)
+		}
+	}
+	return NewFixture(records), nil
+}
+
+// LookupMX returns the MX records of name.
+func (fixture *Fixture) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	records, err := fixture.lookup(name, func(records Records) bool { return len(records.MX) > 0 })
+	if err != nil {
+		return nil, err
+	}
+	mxs := make([]*net.MX, len(records.MX))
+	for i, host := range records.MX {
+		if host == "." {
+			mxs[i] = &net.MX{Host: ".", Pref: 0}
+			continue
+		}
+		mxs[i] = &net.MX{Host: strings.TrimSuffix(host, ".") + ".", Pref: uint16(10 * (i + 1))}
+	}
+	return mxs, nil
+}
+
+// LookupHost returns the addresses of host.
+func (fixture *Fixture) LookupHost(ctx context.Context, host string) ([]string, error) {
+	records, err := fixture.lookup(host, func(records Records) bool { return len(records.A) > 0 })
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), records.A...), nil
+}
+
+// LookupAddr returns the names of addr, with the trailing dot.
+func (fixture *Fixture) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	records, err := fixture.lookup(addr, func(records Records) bool { return len(records.PTR) > 0 })
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(records.PTR))
+	for i, name := range records.PTR {
+		names[i] = strings.TrimSuffix(name, ".") + "."
+	}
+	return names, nil
+}
+
+// LookupTXT returns the text records of name.
+func (fixture *Fixture) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, err := fixture.lookup(name, func(records Records) bool { return len(records.TXT) > 0 })
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), records.TXT...), nil
+}
+
+// lookup returns the records of name, failing like the system resolver
+// when the name is unlisted, has none of the wanted records or is set to
+// fail temporarily.
+func (fixture *Fixture) lookup(name string, has func(Records) bool) (Records, error) {
+	records, ok := fixture.records[normalize(name)]
+	switch {
+	case ok && records.Error == ErrorTempFail:
+		return Records{}, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	case !ok || !has(records):
+		return Records{}, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+// normalize returns the key of name: lowercase without the trailing dot,
+// and addresses in their canonical form.
+func normalize(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if ip := net.ParseIP(name); ip != nil {
+		return ip.String()
+	}
+	return name
+}
+
+// IsNotFound reports whether err tells that the name has no records, as
+// opposed to a lookup failure worth retrying.
+func IsNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+)
+
+const fixtures = `
+example.com:
+  mx: [mx1.example.com, mx2.example.com.]
+  txt: ["v=spf1 -all"]
+mx1.example.com:
+  a: [192.0.2.10, "2001:db8::10"]
+nomail.test:
+  mx: ["."]
+flaky.test:
+  error: tempfail
+192.0.2.10:
+  ptr: [mx1.example.com]
+"2001:db8::10":
+  ptr: [mx1.example.com]
+`
+
+func TestFixture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns.yaml")
+	if err := os.WriteFile(path, []byte(fixtures), 0o644); err != nil {
+		t.Fatalf("writing fixtures failed: %v", err)
+	}
+	resolver, err := New(config.DNSConfig{Fixtures: path})
+	if err != nil {
+		t.Fatalf("creating resolver failed: %v", err)
+	}
+	ctx := context.Background()
+
+	mxs, err := resolver.LookupMX(ctx, "Example.COM.")
+	if err != nil || len(mxs) != 2 || mxs[0].Host != "mx1.example.com." || mxs[1].Host != "mx2.example.com." || mxs[0].Pref >= mxs[1].Pref {
+		t.Errorf("LookupMX = %+v, %v; want mx1 then mx2", mxs, err)
+	}
+	if mxs, err := resolver.LookupMX(ctx, "nomail.test"); err != nil || len(mxs) != 1 || mxs[0].Host != "." {
+		t.Errorf("LookupMX null MX = %+v, %v", mxs, err)
+	}
+	if addrs, err := resolver.LookupHost(ctx, "mx1.example.com"); err != nil || !slices.Equal(addrs, []string{"192.0.2.10", "2001:db8::10"}) {
+		t.Errorf("LookupHost = %v, %v", addrs, err)
+	}
+	if names, err := resolver.LookupAddr(ctx, "2001:DB8:0::10"); err != nil || !slices.Equal(names, []string{"mx1.example.com."}) {
+		t.Errorf("LookupAddr = %v, %v", names, err)
+	}
+	if txts, err := resolver.LookupTXT(ctx, "example.com"); err != nil || !slices.Equal(txts, []string{"v=spf1 -all"}) {
+		t.Errorf("LookupTXT = %v, %v", txts, err)
+	}
+
+	// Missing names and record types do not exist; tempfail is retryable
+	if _, err := resolver.LookupHost(ctx, "missing.test"); !IsNotFound(err) {
+		t.Errorf("LookupHost of a missing name = %v, want not found", err)
+	}
+	if _, err := resolver.LookupHost(ctx, "example.com"); !IsNotFound(err) {
+		t.Errorf("LookupHost of a name without A records = %v, want not found", err)
+	}
+	if _, err := resolver.LookupMX(ctx, "flaky.test"); err == nil || IsNotFound(err) {
+		t.Errorf("LookupMX of tempfail = %v, want a temporary error", err)
+	}
+}
+
+func TestLoadFixtureErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixtures string
+	}{
+		{"invalid_yaml", "example.com: [mx"},
+		{"invalid_error", "example.com:\n  error: refused\n"},
+		{"invalid_address", "example.com:\n  a: [not-an-ip]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dns.yaml")
+			if err := os.WriteFile(path, []byte(tt.fixtures), 0o644); err != nil {
+				t.Fatalf("writing fixtures failed: %v", err)
+			}
+			if _, err := LoadFixture(path); err == nil {
+				t.Error("LoadFixture succeeded, want an error")
+			}
+		})
+	}
+
+	if _, err := LoadFixture(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadFixture of a missing file succeeded, want an error")
+	}
+}
@@ -0,0 +1,100 @@
+// Package export writes stored emails in formats meant for other tools.
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"net/mail"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/scrub"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// Record is the JSON Lines representation of one email: its storage
+// description and metadata, parsed headers, text body and attachment list.
+type Record struct {
+	storage.StoredEmail
+	Header      mail.Header  `json:"header"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments"`
+	ParseError  string       `json:"parse_error,omitempty"`
+}
+
+// Attachment describes an attachment without its content.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// NewRecord builds the record of a stored email from its parsed message.
+func NewRecord(email storage.StoredEmail, msg *message.Message) Record {
+	record := Record{
+		StoredEmail: email,
+		Header:      msg.Header,
+		Text:        msg.Text(),
+		Attachments: []Attachment{},
+		ParseError:  msg.ParseError,
+	}
+	for _, part := range msg.Attachments() {
+		record.Attachments = append(record.Attachments, Attachment{
+			Filename:    part.Filename,
+			ContentType: part.ContentType,
+			Size:        part.Size,
+		})
+	}
+	return record
+}
+
+// Anonymize returns a copy of the record with personal data removed:
+// addresses, mailbox and identifiers hashed, subject, text and file names
+// redacted preserving their layout, and shadow and hold details dropped.
+func (record Record) Anonymize(scrubber *scrub.Scrubber) Record {
+	anonymized := record
+	anonymized.Domain = scrubber.Hash(record.Domain) + ".invalid"
+	anonymized.User = scrubber.Hash(record.User)
+	anonymized.Subject = scrub.Text(record.Subject)
+	anonymized.Header = scrubber.Header(record.Header)
+	anonymized.Text = scrub.Text(record.Text)
+
+	anonymized.Metadata = storage.Metadata{Tags: record.Metadata.Tags}
+	for _, verdict := range record.Metadata.Verdicts {
+		verdict.Detail = scrub.Text(verdict.Detail)
+		anonymized.Metadata.Verdicts = append(anonymized.Metadata.Verdicts, verdict)
+	}
+
+	anonymized.Attachments = make([]Attachment, len(record.Attachments))
+	for i, attachment := range record.Attachments {
+		attachment.Filename = scrub.Filename(attachment.Filename)
+		anonymized.Attachments[i] = attachment
+	}
+	return anonymized
+}
+
+// JSONLWriter writes one record per line.
+type JSONLWriter struct {
+	encoder  *json.Encoder
+	scrubber *scrub.Scrubber
+}
+
+// NewJSONLWriter creates a writer emitting JSON Lines to w.
+func NewJSONLWriter(w io.Writer) *JSONLWriter {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return &JSONLWriter{encoder: encoder}
+}
+
+// Anonymize makes the writer scrub every record with scrubber.
+func (writer *JSONLWriter) Anonymize(scrubber *scrub.Scrubber) {
+	writer.scrubber = scrubber
+}
+
+// Write appends the record of a stored email.
+func (writer *JSONLWriter) Write(email storage.StoredEmail, msg *message.Message) error {
+	record := NewRecord(email, msg)
+	if writer.scrubber != nil {
+		record = record.Anonymize(writer.scrubber)
+	}
+	return writer.encoder.Encode(record)
+}
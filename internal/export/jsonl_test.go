@@ -0,0 +1,111 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/scrub"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+const attachmentEmail = "From: sender@example.com\r\n" +
+	"Subject: Report <Q3>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"See attached\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/csv\r\n" +
+	"Content-Disposition: attachment; filename=q3.csv\r\n" +
+	"\r\n" +
+	"a,b\r\n" +
+	"--b--\r\n"
+
+func TestJSONLWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewJSONLWriter(&out)
+
+	emails := []storage.StoredEmail{
+		{ID: "1", Domain: "example.com", User: "john", Metadata: storage.Metadata{Tags: []string{"spam"}}},
+		{ID: "2", Domain: "example.com", User: "jane"},
+	}
+	for _, email := range emails {
+		msg := message.Parse(message.Envelope{}, message.Bytes(attachmentEmail))
+		if err := writer.Write(email, msg); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+
+	var records []Record
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("decoding line %q failed: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("wrote %d lines, want 2", len(records))
+	}
+	record := records[0]
+	if record.ID != "1" || len(record.Metadata.Tags) != 1 {
+		t.Errorf("record = %+v, want email 1 with its tags", record.StoredEmail)
+	}
+	if record.Header.Get("Subject") != "Report <Q3>" {
+		t.Errorf("Subject header = %q", record.Header.Get("Subject"))
+	}
+	if record.Text != "See attached" {
+		t.Errorf("Text = %q, want the text part", record.Text)
+	}
+	if len(record.Attachments) != 1 || record.Attachments[0].Filename != "q3.csv" {
+		t.Errorf("Attachments = %+v, want q3.csv", record.Attachments)
+	}
+}
+
+func TestJSONLWriterAnonymize(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewJSONLWriter(&out)
+	writer.Anonymize(scrub.New([]byte("salt")))
+
+	email := storage.StoredEmail{
+		ID:       "1",
+		Domain:   "example.com",
+		User:     "john",
+		Subject:  "Report",
+		Metadata: storage.Metadata{Tags: []string{"spam"}, Hold: &storage.Hold{By: "legal"}},
+	}
+	msg := message.Parse(message.Envelope{}, message.Bytes(attachmentEmail))
+	if err := writer.Write(email, msg); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+
+	line := out.String()
+	for _, leaked := range []string{"example.com", "john", "sender@", "See attached", "q3.csv", "legal"} {
+		if strings.Contains(line, leaked) {
+			t.Errorf("anonymized record contains %q: %s", leaked, line)
+		}
+	}
+
+	var record Record
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("decoding record failed: %v", err)
+	}
+	if record.Text != "xxx xxxxxxxx" {
+		t.Errorf("Text = %q, want redacted with its layout", record.Text)
+	}
+	if len(record.Attachments) != 1 || record.Attachments[0].Filename != "x0.csv" || record.Attachments[0].Size != 3 {
+		t.Errorf("Attachments = %+v, want redacted name with size kept", record.Attachments)
+	}
+	if len(record.Metadata.Tags) != 1 || record.Header.Get("Content-Type") == "" {
+		t.Errorf("record = %+v, want tags and structural headers kept", record)
+	}
+}
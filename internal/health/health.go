@@ -0,0 +1,151 @@
+// Package health tracks the storage health of each domain so a failing
+// directory only affects mail for its own domain.
+package health
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+)
+
+// DefaultProbeInterval is how often an unhealthy domain is probed for recovery.
+const DefaultProbeInterval = 10 * time.Second
+
+// Status is the storage health of one domain.
+type Status struct {
+	Domain    string    `json:"domain"`
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`      // Last storage error while unhealthy
+	Since     time.Time `json:"since,omitempty"`      // When the domain became unhealthy
+	Failures  int       `json:"failures"`             // Storage failures since start
+	LastProbe time.Time `json:"last_probe,omitempty"` // Last recovery attempt
+}
+
+// Tracker records storage outcomes per domain. Domains start healthy.
+// It is safe for concurrent use.
+type Tracker struct {
+	mu            sync.Mutex
+	domains       map[string]*Status
+	probeInterval time.Duration
+	now           func() time.Time
+}
+
+// NewTracker creates a tracker probing unhealthy domains at most once per interval.
+func NewTracker(probeInterval time.Duration) *Tracker {
+	return &Tracker{
+		domains:       make(map[string]*Status),
+		probeInterval: probeInterval,
+		now:           time.Now,
+	}
+}
+
+// Fail records a storage error for domain, marking it unhealthy.
+func (tracker *Tracker) Fail(domain string, err error) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	status := tracker.status(domain)
+	status.Failures++
+	status.Error = err.Error()
+	if status.Healthy {
+		status.Healthy = false
+		status.Since = tracker.now()
+		status.LastProbe = status.Since
+	}
+}
+
+// Succeed records a successful write for domain, marking it healthy.
+func (tracker *Tracker) Succeed(domain string) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if status, ok := tracker.domains[strings.ToLower(domain)]; ok && !status.Healthy {
+		status.Healthy = true
+		status.Error = ""
+		status.Since = time.Time{}
+	}
+}
+
+// Check reports whether mail for domain can be accepted. An unhealthy
+// domain is probed once the probe interval has elapsed and recovers when
+// probe succeeds.
+func (tracker *Tracker) Check(domain string, probe func() error) bool {
+	tracker.mu.Lock()
+	status, ok := tracker.domains[strings.ToLower(domain)]
+	if !ok || status.Healthy {
+		tracker.mu.Unlock()
+		return true
+	}
+	if tracker.now().Sub(status.LastProbe) < tracker.probeInterval {
+		tracker.mu.Unlock()
+		return false
+	}
+	status.LastProbe = tracker.now()
+	tracker.mu.Unlock()
+
+	if err := probe(); err != nil {
+		tracker.Fail(domain, err)
+		return false
+	}
+	tracker.Succeed(domain)
+	return true
+}
+
+// Statuses returns the status of every domain that has had a storage
+// failure, sorted by domain.
+func (tracker *Tracker) Statuses() []Status {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	statuses := make([]Status, 0, len(tracker.domains))
+	for _, status := range tracker.domains {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Domain < statuses[j].Domain })
+	return statuses
+}
+
+// Ready reports whether every domain is healthy.
+func (tracker *Tracker) Ready() bool {
+	for _, status := range tracker.Statuses() {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Collect returns the domain health metrics.
+func (tracker *Tracker) Collect() []metrics.Family {
+	healthy := metrics.Family{
+		Name: "gargantua_domain_storage_healthy",
+		Help: "Whether the storage of a domain accepts writes (1) or mail is rejected with 452 (0).",
+		Type: metrics.Gauge,
+	}
+	failures := metrics.Family{
+		Name: "gargantua_domain_storage_failures_total",
+		Help: "Storage write failures per domain.",
+		Type: metrics.Counter,
+	}
+
+	for _, status := range tracker.Statuses() {
+		labels := map[string]string{"domain": status.Domain}
+		healthy.Samples = append(healthy.Samples, metrics.Sample{Labels: labels, Value: metrics.Bool(status.Healthy)})
+		failures.Samples = append(failures.Samples, metrics.Sample{Labels: labels, Value: float64(status.Failures)})
+	}
+	return []metrics.Family{healthy, failures}
+}
+
+// status returns the entry for domain, creating a healthy one. Callers hold tracker.mu.
+func (tracker *Tracker) status(domain string) *Status {
+	domain = strings.ToLower(domain)
+	status, ok := tracker.domains[domain]
+	if !ok {
+		status = &Status{Domain: domain, Healthy: true}
+		tracker.domains[domain] = status
+	}
+	return status
+}
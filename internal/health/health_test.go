@@ -0,0 +1,50 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker(10 * time.Second)
+	tracker.now = func() time.Time { return now }
+
+	probeErr := errors.New("disk full")
+	probe := func() error { return probeErr }
+
+	if !tracker.Check("example.com", probe) {
+		t.Fatal("unknown domain is not accepted")
+	}
+
+	tracker.Fail("Example.com", errors.New("disk full"))
+	if tracker.Check("example.com", probe) {
+		t.Error("failed domain is accepted before the probe interval")
+	}
+	if !tracker.Check("other.com", probe) {
+		t.Error("failure of one domain affected another")
+	}
+	if tracker.Ready() {
+		t.Error("Ready() = true with an unhealthy domain")
+	}
+
+	now = now.Add(11 * time.Second)
+	if tracker.Check("example.com", probe) {
+		t.Error("domain recovered although the probe failed")
+	}
+
+	now = now.Add(11 * time.Second)
+	probeErr = nil
+	if !tracker.Check("example.com", probe) {
+		t.Error("domain did not recover after a successful probe")
+	}
+	if !tracker.Ready() {
+		t.Error("Ready() = false after recovery")
+	}
+
+	statuses := tracker.Statuses()
+	if len(statuses) != 1 || statuses[0].Failures != 2 {
+		t.Errorf("Statuses() = %+v, want example.com with 2 failures", statuses)
+	}
+}
@@ -0,0 +1,273 @@
+// Package honeypot fingerprints the senders reaching a sink exposed as a
+// spam trap and builds per-sender intelligence reports: host names given
+// in HELO, reverse DNS, TLS parameters, credentials tried, envelope
+// addresses and timing.
+package honeypot
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/smtp"
+)
+
+// maxSenders bounds the senders tracked; the least recently seen are
+// forgotten beyond it.
+const maxSenders = 10000
+
+// maxValues bounds the distinct values kept per fingerprint field.
+const maxValues = 20
+
+// lookupTimeout bounds each reverse DNS lookup.
+const lookupTimeout = 5 * time.Second
+
+// maxLookups bounds the reverse DNS lookups running at once, so a flood of
+// new addresses does not flood the resolver too.
+const maxLookups = 16
+
+// Resolver performs the DNS lookups of the reverse DNS check.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Sender is the intelligence gathered about one client address.
+type Sender struct {
+	IP string `json:"ip"`
+
+	// PTR lists the reverse DNS names of the address; PTRConfirmed tells
+	// whether one of them resolves back to it (forward-confirmed rDNS)
+	PTR          []string `json:"ptr,omitempty"`
+	PTRConfirmed bool     `json:"ptr_confirmed"`
+
+	Hellos      []string `json:"hellos,omitempty"`       // Names given in EHLO or HELO
+	TLS         []string `json:"tls,omitempty"`          // Negotiated version and cipher suite
+	Usernames   []string `json:"usernames,omitempty"`    // Credentials tried with AUTH
+	MailFrom    []string `json:"mail_from,omitempty"`    // Envelope senders
+	RcptDomains []string `json:"rcpt_domains,omitempty"` // Domains of the envelope recipients
+
+	Sessions   int       `json:"sessions"`
+	Messages   int       `json:"messages"`
+	Recipients int       `json:"recipients"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+
+	// MeanSessionSeconds is the average time from EHLO to disconnection;
+	// bots typically finish far faster than mail servers
+	MeanSessionSeconds float64 `json:"mean_session_seconds"`
+
+	totalSession time.Duration
+}
+
+// Tracker aggregates the sessions and emails of every sender. It is safe
+// for concurrent use.
+type Tracker struct {
+	resolver Resolver
+	now      func() time.Time
+
+	mu      sync.Mutex
+	senders map[string]*Sender
+	wg      sync.WaitGroup
+	lookups chan struct{} // Slots of the running lookups
+}
+
+// NewTracker creates a tracker resolving reverse DNS with resolver, or the
+// system resolver when nil.
+func NewTracker(resolver Resolver) *Tracker {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &Tracker{
+		resolver: resolver,
+		now:      time.Now,
+		senders:  make(map[string]*Sender),
+		lookups:  make(chan struct{}, maxLookups),
+	}
+}
+
+// ObserveSession records a finished SMTP session. The reverse DNS of new
+// senders is looked up in the background.
+func (tracker *Tracker) ObserveSession(info smtp.SessionInfo) {
+	ip := hostOf(info.RemoteAddr)
+	now := tracker.now()
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	sender := tracker.sender(ip, now)
+	sender.Sessions++
+	sender.totalSession += now.Sub(info.StartedAt)
+	sender.MeanSessionSeconds = sender.totalSession.Seconds() / float64(sender.Sessions)
+	sender.Hellos = addValue(sender.Hellos, info.Hostname)
+	sender.Usernames = addValue(sender.Usernames, info.Username)
+	if info.TLS != nil {
+		sender.TLS = addValue(sender.TLS, info.TLS.Version+" "+info.TLS.CipherSuite)
+	}
+}
+
+// Middleware returns an ingest middleware recording the envelope of every
+// email. It must be registered at the notify stage.
+func (tracker *Tracker) Middleware() pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			err := next(ctx, delivery)
+			tracker.ObserveMessage(delivery.Message)
+			return err
+		}
+	}
+}
+
+// ObserveMessage records the envelope of an email.
+func (tracker *Tracker) ObserveMessage(msg *message.Message) {
+	ip := hostOf(msg.Envelope.RemoteAddr)
+	now := tracker.now()
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	sender := tracker.sender(ip, now)
+	sender.Messages++
+	sender.Recipients += len(msg.Envelope.To)
+	sender.MailFrom = addValue(sender.MailFrom, msg.Envelope.From)
+	for _, to := range msg.Envelope.To {
+		if _, domain, ok := strings.Cut(to, "@"); ok {
+			sender.RcptDomains = addValue(sender.RcptDomains, strings.ToLower(domain))
+		}
+	}
+}
+
+// sender returns the record of ip, creating it on first sight. Callers
+// hold tracker.mu.
+func (tracker *Tracker) sender(ip string, now time.Time) *Sender {
+	sender, ok := tracker.senders[ip]
+	if !ok {
+		if len(tracker.senders) >= maxSenders {
+			tracker.forgetOldest()
+		}
+		sender = &Sender{IP: ip, FirstSeen: now}
+		tracker.senders[ip] = sender
+		tracker.wg.Add(1)
+		go tracker.lookup(ip)
+	}
+	sender.LastSeen = now
+	return sender
+}
+
+// forgetOldest drops the least recently seen sender. Callers hold tracker.mu.
+func (tracker *Tracker) forgetOldest() {
+	var oldest *Sender
+	for _, sender := range tracker.senders {
+		if oldest == nil || sender.LastSeen.Before(oldest.LastSeen) {
+			oldest = sender
+		}
+	}
+	delete(tracker.senders, oldest.IP)
+}
+
+// lookup resolves the reverse DNS of ip and checks that one of the names
+// resolves back to it.
+func (tracker *Tracker) lookup(ip string) {
+	defer tracker.wg.Done()
+	tracker.lookups <- struct{}{}
+	defer func() { <-tracker.lookups }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	names, err := tracker.resolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return
+	}
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+	confirmed := false
+	for _, name := range names {
+		addrs, err := tracker.resolver.LookupHost(ctx, name)
+		if err == nil && slices.Contains(addrs, ip) {
+			confirmed = true
+			break
+		}
+	}
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if sender, ok := tracker.senders[ip]; ok {
+		for _, name := range names {
+			sender.PTR = addValue(sender.PTR, name)
+		}
+		sender.PTRConfirmed = confirmed
+	}
+}
+
+// Wait blocks until the pending reverse DNS lookups are done.
+func (tracker *Tracker) Wait() {
+	tracker.wg.Wait()
+}
+
+// Senders returns the report of every sender, most recently seen first.
+func (tracker *Tracker) Senders() []Sender {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	senders := make([]Sender, 0, len(tracker.senders))
+	for _, sender := range tracker.senders {
+		senders = append(senders, sender.copy())
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		if !senders[i].LastSeen.Equal(senders[j].LastSeen) {
+			return senders[i].LastSeen.After(senders[j].LastSeen)
+		}
+		return senders[i].IP < senders[j].IP
+	})
+	return senders
+}
+
+// Sender returns the report of one client address.
+func (tracker *Tracker) Sender(ip string) (Sender, bool) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	sender, ok := tracker.senders[ip]
+	if !ok {
+		return Sender{}, false
+	}
+	return sender.copy(), true
+}
+
+// copy returns a snapshot of the sender sharing no slices with it.
+func (sender *Sender) copy() Sender {
+	snapshot := *sender
+	snapshot.PTR = slices.Clone(sender.PTR)
+	snapshot.Hellos = slices.Clone(sender.Hellos)
+	snapshot.TLS = slices.Clone(sender.TLS)
+	snapshot.Usernames = slices.Clone(sender.Usernames)
+	snapshot.MailFrom = slices.Clone(sender.MailFrom)
+	snapshot.RcptDomains = slices.Clone(sender.RcptDomains)
+	return snapshot
+}
+
+// addValue appends value to values unless empty, already present or the
+// list is full.
+func addValue(values []string, value string) []string {
+	if value == "" || len(values) >= maxValues || slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// hostOf returns the host part of a host:port address.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
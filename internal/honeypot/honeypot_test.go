@@ -0,0 +1,76 @@
+package honeypot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/smtp"
+)
+
+// fakeResolver answers reverse and forward lookups from maps.
+type fakeResolver struct {
+	ptr   map[string][]string
+	hosts map[string][]string
+}
+
+func (resolver fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if names, ok := resolver.ptr[addr]; ok {
+		return names, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (resolver fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := resolver.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestTracker(t *testing.T) {
+	tracker := NewTracker(fakeResolver{
+		ptr:   map[string][]string{"192.0.2.10": {"mail.example.net."}, "192.0.2.20": {"spoofed.example.org."}},
+		hosts: map[string][]string{"mail.example.net": {"192.0.2.10"}, "spoofed.example.org": {"198.51.100.1"}},
+	})
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return start.Add(2 * time.Second) }
+
+	tracker.ObserveSession(smtp.SessionInfo{
+		RemoteAddr: "192.0.2.10:40000",
+		Hostname:   "mail.example.net",
+		StartedAt:  start,
+		TLS:        &message.TLS{Version: "TLS 1.2", CipherSuite: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	})
+	tracker.ObserveMessage(&message.Message{Envelope: message.Envelope{
+		RemoteAddr: "192.0.2.10:40000",
+		From:       "offers@example.net",
+		To:         []string{"a@Trap.example", "b@trap.example"},
+	}})
+	tracker.ObserveSession(smtp.SessionInfo{RemoteAddr: "192.0.2.20:50000", Hostname: "localhost", Username: "admin", StartedAt: start})
+	tracker.Wait()
+
+	sender, ok := tracker.Sender("192.0.2.10")
+	if !ok {
+		t.Fatalf("Sender(192.0.2.10) not found")
+	}
+	if !sender.PTRConfirmed || len(sender.PTR) != 1 || sender.PTR[0] != "mail.example.net" {
+		t.Errorf("PTR = %v confirmed %v, want confirmed mail.example.net", sender.PTR, sender.PTRConfirmed)
+	}
+	if sender.Sessions != 1 || sender.Messages != 1 || sender.Recipients != 2 || sender.MeanSessionSeconds != 2 {
+		t.Errorf("counts = %+v, want 1 session of 2s, 1 message, 2 recipients", sender)
+	}
+	if len(sender.RcptDomains) != 1 || sender.RcptDomains[0] != "trap.example" || len(sender.TLS) != 1 {
+		t.Errorf("fingerprint = %+v, want one recipient domain and TLS", sender)
+	}
+
+	spoofed, _ := tracker.Sender("192.0.2.20")
+	if spoofed.PTRConfirmed || len(spoofed.Usernames) != 1 || spoofed.Usernames[0] != "admin" {
+		t.Errorf("spoofed sender = %+v, want unconfirmed PTR and the tried username", spoofed)
+	}
+	if senders := tracker.Senders(); len(senders) != 2 {
+		t.Errorf("Senders() = %d, want 2", len(senders))
+	}
+}
@@ -0,0 +1,168 @@
+// Package junk simulates the spam filtering of a mailbox provider: emails
+// scoring above a threshold on built-in heuristics and configured rules are
+// filed in the Junk folder of their mailbox instead of the inbox, so product
+// tests can assert whether an email would land in spam.
+//
+// The heuristics are a small subset of what real filters check and only
+// look at the email itself; they are meant to catch regressions such as a
+// template losing its text part, not to predict a provider's verdict.
+package junk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+)
+
+// Folder is the folder junk emails are filed in.
+const Folder = "Junk"
+
+// Check is the name of the verdict recorded on every email.
+const Check = "junk"
+
+// gtube is the Generic Test for Unsolicited Bulk Email: any email
+// containing it is junk.
+const gtube = "XJS*C4JDBQADN1.NSBN3*2IDNEN*GTUBE-STANDARD-ANTI-UBE-TEST-EMAIL*C.34X"
+
+// phrases are wordings typical of unsolicited bulk email.
+var phrases = []string{
+	"act now", "100% free", "click here", "limited time offer", "you are a winner",
+	"risk-free", "no credit check", "earn extra cash", "double your", "viagra",
+}
+
+// heuristic scores one trait of an email.
+type heuristic struct {
+	name  string
+	score float64
+	test  func(msg *message.Message) bool
+}
+
+// heuristics are checked on every email.
+var heuristics = []heuristic{
+	{"GTUBE", 1000, func(msg *message.Message) bool {
+		return strings.Contains(msg.Subject, gtube) || strings.Contains(msg.Text(), gtube)
+	}},
+	{"SPAM_FLAG", 5, func(msg *message.Message) bool {
+		return strings.EqualFold(strings.TrimSpace(msg.Header.Get("X-Spam-Flag")), "YES")
+	}},
+	{"SPAM_PHRASES", 2.5, func(msg *message.Message) bool {
+		text := strings.ToLower(msg.Subject + "\n" + msg.Text())
+		for _, phrase := range phrases {
+			if strings.Contains(text, phrase) {
+				return true
+			}
+		}
+		return false
+	}},
+	{"SUBJECT_ALL_CAPS", 1.5, func(msg *message.Message) bool {
+		letters, upper := 0, 0
+		for _, r := range msg.Subject {
+			if unicode.IsLetter(r) {
+				letters++
+				if unicode.IsUpper(r) {
+					upper++
+				}
+			}
+		}
+		return letters >= 10 && upper == letters
+	}},
+	{"SUBJECT_EXCLAMATIONS", 1, func(msg *message.Message) bool {
+		return strings.Count(msg.Subject, "!") >= 3
+	}},
+	{"MISSING_SUBJECT", 1, func(msg *message.Message) bool {
+		return strings.TrimSpace(msg.Subject) == ""
+	}},
+	{"MISSING_DATE", 1, func(msg *message.Message) bool {
+		return msg.Header.Get("Date") == ""
+	}},
+	{"MISSING_MESSAGE_ID", 1, func(msg *message.Message) bool {
+		return msg.Header.Get("Message-Id") == ""
+	}},
+	{"HTML_ONLY", 1.5, func(msg *message.Message) bool {
+		html := false
+		for _, part := range msg.Parts {
+			switch {
+			case part.Filename != "":
+			case part.ContentType == "text/plain":
+				return false
+			case part.ContentType == "text/html":
+				html = true
+			}
+		}
+		return html
+	}},
+}
+
+// Result is the score of an email and the traits that contributed to it.
+type Result struct {
+	Score   float64
+	Reasons []string // Heuristic and rule names, sorted
+	Junk    bool
+}
+
+// Classifier scores emails against the heuristics and rules of a
+// configuration.
+type Classifier struct {
+	threshold  float64
+	heuristics bool
+	rules      []config.JunkRuleConfig
+}
+
+// NewClassifier creates a classifier from cfg.
+func NewClassifier(cfg config.JunkConfig) *Classifier {
+	return &Classifier{threshold: cfg.Threshold, heuristics: cfg.Heuristics, rules: cfg.Rules}
+}
+
+// Classify scores msg. Rules with a negative score can keep an email out of
+// the Junk folder, like a safe sender list.
+func (classifier *Classifier) Classify(msg *message.Message) Result {
+	var result Result
+	if classifier.heuristics {
+		for _, heuristic := range heuristics {
+			if heuristic.test(msg) {
+				result.Score += heuristic.score
+				result.Reasons = append(result.Reasons, heuristic.name)
+			}
+		}
+	}
+	for _, rule := range classifier.rules {
+		if rules.Any(rule.Rules, msg) {
+			result.Score += rule.Score
+			result.Reasons = append(result.Reasons, rule.Name)
+		}
+	}
+	sort.Strings(result.Reasons)
+	result.Junk = result.Score >= classifier.threshold
+	return result
+}
+
+// Middleware returns an ingest middleware filing junk emails in the Junk
+// folder and recording the score of every email as a verdict. It must be
+// registered at the enrich stage.
+func (classifier *Classifier) Middleware() pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			msg := delivery.Message
+
+			result := classifier.Classify(msg)
+			verdict := "inbox"
+			if result.Junk {
+				verdict = "junk"
+				msg.Folder = Folder
+			}
+			detail := fmt.Sprintf("score %.1f, threshold %.1f", result.Score, classifier.threshold)
+			if len(result.Reasons) > 0 {
+				detail += ": " + strings.Join(result.Reasons, ", ")
+			}
+			msg.AddVerdict(Check, verdict, detail)
+			return next(ctx, delivery)
+		}
+	}
+}
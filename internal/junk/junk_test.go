@@ -0,0 +1,99 @@
+package junk
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+)
+
+// parse builds a message from raw content.
+func parse(from, content string) *message.Message {
+	return message.Parse(message.Envelope{From: from, To: []string{"john@example.com"}}, message.Bytes(content))
+}
+
+// headers are those of a well-formed email.
+const headers = "Date: Thu, 15 Oct 2026 10:00:00 +0000\r\nMessage-Id: <1@example.org>\r\n"
+
+func TestClassify(t *testing.T) {
+	classifier := NewClassifier(config.JunkConfig{
+		Threshold:  5,
+		Heuristics: true,
+		Rules: []config.JunkRuleConfig{
+			{Name: "BLOCKED_SENDER", Score: 10, Rules: []rules.Match{{From: "*@promo.example.com"}}},
+			{Name: "SAFE_SENDER", Score: -100, Rules: []rules.Match{{From: "*@bank.example.com"}}},
+		},
+	})
+
+	tests := []struct {
+		name        string
+		msg         *message.Message
+		wantJunk    bool
+		wantReasons string
+	}{
+		{
+			name:     "transactional",
+			msg:      parse("billing@example.org", headers+"Subject: Your invoice\r\n\r\nThanks for your order.\r\n"),
+			wantJunk: false,
+		},
+		{
+			name:        "spammy",
+			msg:         parse("deals@example.org", "Subject: YOU ARE A WINNER!!!\r\n\r\nClick here to claim.\r\n"),
+			wantJunk:    true,
+			wantReasons: "MISSING_DATE, MISSING_MESSAGE_ID, SPAM_PHRASES, SUBJECT_ALL_CAPS, SUBJECT_EXCLAMATIONS",
+		},
+		{
+			name:        "html_only",
+			msg:         parse("news@example.org", headers+"Subject: News\r\nContent-Type: text/html\r\n\r\n<p>News</p>\r\n"),
+			wantJunk:    false,
+			wantReasons: "HTML_ONLY",
+		},
+		{
+			name:        "gtube",
+			msg:         parse("qa@example.org", headers+"Subject: Test\r\n\r\n"+gtube+"\r\n"),
+			wantJunk:    true,
+			wantReasons: "GTUBE",
+		},
+		{
+			name:        "blocked_sender",
+			msg:         parse("offers@promo.example.com", headers+"Subject: Hello\r\n\r\nHello\r\n"),
+			wantJunk:    true,
+			wantReasons: "BLOCKED_SENDER",
+		},
+		{
+			name:        "safe_sender",
+			msg:         parse("alerts@bank.example.com", "Subject: ACT NOW!!!\r\nX-Spam-Flag: YES\r\n\r\nAct now\r\n"),
+			wantJunk:    false,
+			wantReasons: "MISSING_DATE, MISSING_MESSAGE_ID, SAFE_SENDER, SPAM_FLAG, SPAM_PHRASES, SUBJECT_EXCLAMATIONS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := classifier.Classify(tt.msg)
+			if result.Junk != tt.wantJunk || strings.Join(result.Reasons, ", ") != tt.wantReasons {
+				t.Errorf("Classify() = %+v, want junk %v for %q", result, tt.wantJunk, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	classifier := NewClassifier(config.JunkConfig{Threshold: 5, Heuristics: true})
+	handler := classifier.Middleware()(func(ctx context.Context, delivery *pipeline.Delivery) error { return nil })
+
+	msg := parse("deals@example.org", headers+"Subject: Test\r\nX-Spam-Flag: YES\r\n\r\nHello\r\n")
+	if err := handler(context.Background(), &pipeline.Delivery{Message: msg}); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+	if msg.Folder != Folder {
+		t.Errorf("Folder = %q, want %q", msg.Folder, Folder)
+	}
+	if len(msg.Verdicts) != 1 || msg.Verdicts[0].Result != "junk" || msg.Verdicts[0].Detail != "score 5.0, threshold 5.0: SPAM_FLAG" {
+		t.Errorf("Verdicts = %+v, want junk with score 5.0", msg.Verdicts)
+	}
+}
@@ -0,0 +1,184 @@
+// Package lang detects the language of email bodies, so localization QA can
+// filter the captured emails by language.
+//
+// Detection is deliberately simple: the writing system identifies languages
+// with their own script, and frequent function words tell apart the
+// languages written in the Latin script. It is reliable on the paragraphs of
+// transactional emails, not on a few words.
+package lang
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+)
+
+// minWords is the number of function words a Latin-script text must
+// contain for its language to be reported.
+const minWords = 3
+
+// maxText bounds the number of bytes of body examined.
+const maxText = 16 * 1024
+
+// scripts maps the writing systems used by a single common language to it.
+// Han is handled apart, as Japanese mixes it with kana.
+var scripts = []struct {
+	table    *unicode.RangeTable
+	language string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+}
+
+// functionWords lists frequent words specific enough to a Latin-script
+// language to vote for it.
+var functionWords = map[string][]string{
+	"en": {"the", "and", "you", "your", "is", "are", "of", "to", "for", "with", "this", "have", "please", "we", "our", "will", "be", "not", "it", "that"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "sie", "ihre", "ihr", "wir", "mit", "für", "zu", "den", "dem", "ein", "eine", "bitte", "auf", "werden"},
+	"fr": {"le", "la", "les", "et", "est", "vous", "votre", "nous", "pour", "avec", "une", "des", "du", "de", "en", "que", "pas", "dans", "sur", "merci", "ce", "sont", "être"},
+	"es": {"el", "la", "los", "las", "y", "es", "usted", "su", "para", "con", "una", "por", "que", "de", "en", "del", "gracias", "está", "nuestro", "como", "más", "al", "sus"},
+	"it": {"il", "la", "gli", "di", "e", "è", "sono", "per", "con", "una", "non", "che", "della", "grazie", "tuo", "tua", "suo", "nel", "questo", "alla", "del"},
+	"pt": {"o", "os", "as", "e", "é", "você", "seu", "sua", "para", "com", "uma", "não", "que", "de", "obrigado", "do", "da", "dos", "em", "está", "nosso"},
+	"nl": {"de", "het", "een", "en", "is", "niet", "u", "uw", "je", "jouw", "wij", "met", "voor", "van", "op", "dat", "bedankt", "zijn", "wordt", "naar"},
+	"sv": {"och", "är", "inte", "du", "din", "ditt", "vi", "med", "för", "att", "en", "ett", "det", "som", "på", "tack", "har", "av", "till", "vår"},
+	"pl": {"i", "jest", "nie", "się", "na", "w", "z", "do", "że", "to", "dla", "twoje", "twój", "dziękujemy", "prosimy", "jak", "od", "przez", "oraz", "są"},
+}
+
+// votes maps each function word to the languages it belongs to.
+var votes = buildVotes()
+
+// buildVotes indexes functionWords by word.
+func buildVotes() map[string][]string {
+	votes := make(map[string][]string)
+	for language, words := range functionWords {
+		for _, word := range words {
+			votes[word] = append(votes[word], language)
+		}
+	}
+	return votes
+}
+
+// htmlTag matches the markup stripped from HTML bodies.
+var htmlTag = regexp.MustCompile(`(?s)<style.*?</style>|<script.*?</script>|<[^>]*>`)
+
+// Detect returns the ISO 639-1 code of the language text is written in, or
+// an empty string when it cannot tell.
+func Detect(text string) string {
+	if len(text) > maxText {
+		text = text[:maxText]
+	}
+
+	letters, han := 0, 0
+	counts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Han, r) {
+			han++
+			continue
+		}
+		for _, script := range scripts {
+			if unicode.Is(script.table, r) {
+				counts[script.language]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// Kana alongside Han is Japanese, Han alone Chinese
+	if counts["ja"] > 0 && (counts["ja"]+han)*2 > letters {
+		return "ja"
+	}
+	if han*2 > letters {
+		return "zh"
+	}
+	for language, count := range counts {
+		if count*2 > letters {
+			return language
+		}
+	}
+	return detectLatin(text)
+}
+
+// detectLatin returns the Latin-script language with the most function
+// words in text, when it has enough of them and a clear lead.
+func detectLatin(text string) string {
+	scores := make(map[string]int)
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		for _, language := range votes[word] {
+			scores[language]++
+		}
+	}
+
+	best, bestScore, runnerUp := "", 0, 0
+	for language, score := range scores {
+		switch {
+		case score > bestScore:
+			runnerUp = max(runnerUp, bestScore)
+			best, bestScore = language, score
+		case score > runnerUp:
+			runnerUp = score
+		}
+	}
+	if bestScore < minWords || bestScore == runnerUp {
+		return ""
+	}
+	return best
+}
+
+// Middleware returns an ingest middleware recording the language of each
+// email: detected from its text body, or its HTML body without markup, and
+// otherwise taken from the Content-Language header. It must be registered
+// at the enrich stage.
+func Middleware() pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			msg := delivery.Message
+
+			text := msg.Text()
+			if text == "" {
+				for _, part := range msg.Parts {
+					if part.ContentType == "text/html" && part.Filename == "" {
+						text = htmlTag.ReplaceAllString(string(part.Content), " ")
+						break
+					}
+				}
+			}
+
+			msg.Language = Detect(text)
+			if msg.Language == "" {
+				msg.Language = headerLanguage(msg.Header.Get("Content-Language"))
+			}
+			return next(ctx, delivery)
+		}
+	}
+}
+
+// headerLanguage returns the primary language of the first tag of a
+// Content-Language header, e.g. "de" for "de-DE, en".
+func headerLanguage(header string) string {
+	tag, _, _ := strings.Cut(header, ",")
+	primary, _, _ := strings.Cut(strings.TrimSpace(tag), "-")
+	primary = strings.ToLower(primary)
+	if len(primary) != 2 && len(primary) != 3 {
+		return ""
+	}
+	return primary
+}
@@ -0,0 +1,75 @@
+package lang
+
+import (
+	"context"
+	"net/mail"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"english", "Thank you for your order. We will send you an email when it ships.", "en"},
+		{"german", "Vielen Dank für Ihre Bestellung. Wir werden Sie informieren, sobald die Ware unterwegs ist.", "de"},
+		{"french", "Merci pour votre commande. Nous vous enverrons un email dès que le colis est parti.", "fr"},
+		{"spanish", "Gracias por su pedido. Le enviaremos un correo cuando el paquete esté en camino con los detalles.", "es"},
+		{"portuguese", "Obrigado pelo seu pedido. Você receberá um email quando o pacote for enviado com os detalhes.", "pt"},
+		{"dutch", "Bedankt voor je bestelling. We sturen je een e-mail zodra het pakket onderweg is naar het adres.", "nl"},
+		{"japanese", "ご注文ありがとうございます。発送後にメールでお知らせします。", "ja"},
+		{"chinese", "感谢您的订单。我们会在发货后通过电子邮件通知您。", "zh"},
+		{"russian", "Спасибо за ваш заказ. Мы сообщим вам, когда он будет отправлен.", "ru"},
+		{"too_short", "Order 42", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.text); got != tt.want {
+				t.Errorf("Detect(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *message.Message
+		want string
+	}{
+		{
+			name: "html_body",
+			msg: &message.Message{Parts: []message.Part{{
+				ContentType: "text/html",
+				Content:     []byte("<p>Ihre Rechnung ist <b>da</b>. Bitte prüfen Sie die Angaben und zahlen Sie mit der Karte.</p>"),
+			}}},
+			want: "de",
+		},
+		{
+			name: "header_fallback",
+			msg:  &message.Message{Header: mail.Header{"Content-Language": {"pt-BR, en"}}},
+			want: "pt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.msg.Header == nil {
+				tt.msg.Header = mail.Header{}
+			}
+			handler := Middleware()(func(ctx context.Context, delivery *pipeline.Delivery) error { return nil })
+			if err := handler(context.Background(), &pipeline.Delivery{Message: tt.msg}); err != nil {
+				t.Fatalf("handler failed: %v", err)
+			}
+			if tt.msg.Language != tt.want {
+				t.Errorf("Language = %q, want %q", tt.msg.Language, tt.want)
+			}
+		})
+	}
+}
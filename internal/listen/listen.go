@@ -0,0 +1,130 @@
+// Package listen opens the network listeners of the server and hands them
+// over to a new process so a restart does not refuse any connection.
+//
+// Listeners are inherited as extra file descriptors starting at 3, in the
+// order named by the GARGANTUA_LISTEN_FDS variable, e.g. "api,smtp".
+package listen
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// EnvVar names the inherited listeners, in file descriptor order.
+const EnvVar = "GARGANTUA_LISTEN_FDS"
+
+// firstInheritedFD is the descriptor of the first file in exec.Cmd.ExtraFiles.
+const firstInheritedFD = 3
+
+var (
+	inheritOnce sync.Once
+	inherited   map[string]*os.File
+	handedOver  bool // Started with inherited listeners
+)
+
+// Listen returns the listener inherited under name or, when there is none,
+// a new TCP listener on addr. With reusePort, SO_REUSEPORT lets another
+// process bind the same address, e.g. a new binary started alongside.
+func Listen(name, addr string, reusePort bool) (net.Listener, error) {
+	if file := takeInherited(name); file != nil {
+		defer file.Close()
+
+		listener, err := net.FileListener(file)
+		if err != nil {
+			return nil, fmt.Errorf("using inherited %s listener: %w", name, err)
+		}
+		return listener, nil
+	}
+
+	var config net.ListenConfig
+	if reusePort {
+		config.Control = reusePortControl
+	}
+	return config.Listen(context.Background(), "tcp", addr)
+}
+
+// Inherited reports whether the process was started by Handoff, so the
+// previous instance may still be draining its sessions.
+func Inherited() bool {
+	loadInherited()
+	return handedOver
+}
+
+// takeInherited returns the inherited file for name once, or nil.
+func takeInherited(name string) *os.File {
+	loadInherited()
+	file := inherited[name]
+	delete(inherited, name)
+	return file
+}
+
+// loadInherited reads the inherited listeners from the environment once.
+func loadInherited() {
+	inheritOnce.Do(func() {
+		inherited = make(map[string]*os.File)
+
+		names := os.Getenv(EnvVar)
+		if names == "" {
+			return
+		}
+		os.Unsetenv(EnvVar)
+		handedOver = true
+
+		for i, inheritedName := range strings.Split(names, ",") {
+			fd := uintptr(firstInheritedFD + i)
+			inherited[inheritedName] = os.NewFile(fd, inheritedName+"-listener")
+		}
+	})
+}
+
+// Handoff starts a new instance of the running executable with the same
+// arguments, passing it the listeners by name. The caller keeps serving
+// open connections and should shut down gracefully afterwards.
+func Handoff(listeners map[string]net.Listener) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locating executable: %w", err)
+	}
+
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make([]*os.File, 0, len(names))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, name := range names {
+		filer, ok := listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("%s listener cannot be handed over", name)
+		}
+		file, err := filer.File()
+		if err != nil {
+			return nil, fmt.Errorf("duplicating %s listener: %w", name, err)
+		}
+		files = append(files, file)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), EnvVar+"="+strings.Join(names, ","))
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting new process: %w", err)
+	}
+	return cmd.Process, nil
+}
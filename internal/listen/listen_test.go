@@ -0,0 +1,35 @@
+package listen
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SO_REUSEPORT is not supported on Windows")
+	}
+
+	first, err := Listen("first", "127.0.0.1:0", true)
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	defer first.Close()
+
+	second, err := Listen("second", first.Addr().String(), true)
+	if err != nil {
+		t.Fatalf("second Listen() on the same port failed: %v", err)
+	}
+	second.Close()
+
+	plain, err := Listen("plain", "127.0.0.1:0", false)
+	if err != nil {
+		t.Fatalf("Listen() failed: %v", err)
+	}
+	defer plain.Close()
+
+	if conflict, err := Listen("conflict", plain.Addr().String(), false); err == nil {
+		conflict.Close()
+		t.Error("second Listen() without SO_REUSEPORT succeeded")
+	}
+}
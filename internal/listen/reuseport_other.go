@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package listen
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePortControl fails on platforms without SO_REUSEPORT; use the
+// listener handoff instead.
+func reusePortControl(network, address string, conn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is not supported on this platform")
+}
@@ -0,0 +1,61 @@
+// Package logging configures the process-wide structured logger.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+)
+
+// Setup installs the default slog logger described by cfg and returns the
+// level variable controlling its verbosity. Calls to the standard log
+// package are routed through the same handler at INFO level.
+func Setup(cfg config.LogConfig) (*slog.LevelVar, error) {
+	level := new(slog.LevelVar)
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	out, err := output(cfg.Output)
+	if err != nil {
+		return nil, err
+	}
+
+	handler, err := newHandler(cfg.Format, out, level)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return level, nil
+}
+
+// newHandler creates the slog handler for the given format.
+func newHandler(format string, out io.Writer, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.NewTextHandler(out, opts), nil
+	case "json":
+		return slog.NewJSONHandler(out, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+}
+
+// output resolves the named log destination.
+func output(name string) (io.Writer, error) {
+	switch strings.ToLower(name) {
+	case "", "stderr":
+		return os.Stderr, nil
+	case "stdout":
+		return os.Stdout, nil
+	default:
+		return nil, fmt.Errorf("invalid log output %q (want stderr or stdout)", name)
+	}
+}
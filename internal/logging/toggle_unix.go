@@ -0,0 +1,35 @@
+//go:build !windows
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ToggleOnSignal switches level between debug and its current value each
+// time the process receives SIGUSR1, until ctx is cancelled.
+func ToggleOnSignal(ctx context.Context, level *slog.LevelVar) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	previous := level.Level()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if level.Level() == slog.LevelDebug {
+				level.Set(previous)
+			} else {
+				previous = level.Level()
+				level.Set(slog.LevelDebug)
+			}
+			slog.Info("Log level changed by SIGUSR1", "level", level.Level().String())
+		}
+	}
+}
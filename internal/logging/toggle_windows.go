@@ -0,0 +1,12 @@
+//go:build windows
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ToggleOnSignal is a no-op on Windows, which has no SIGUSR1.
+// Use the log level API endpoint instead.
+func ToggleOnSignal(ctx context.Context, level *slog.LevelVar) {}
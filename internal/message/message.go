@@ -0,0 +1,299 @@
+// Package message defines the email model shared by the subsystems that
+// handle captured emails. A Message is parsed once at ingest and passed
+// along instead of raw bytes.
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// maxPartDepth bounds the nesting of multipart bodies that are walked.
+const maxPartDepth = 10
+
+// DefaultPartBudget is the decoded part content kept in memory by Parse.
+const DefaultPartBudget = 10 * 1024 * 1024 // 10MB
+
+// Body provides the raw content of a message, which may live on disk.
+type Body interface {
+	Open() (io.ReadCloser, error)
+	Size() int64
+}
+
+// Bytes is a Body held in memory.
+type Bytes []byte
+
+// Open returns a reader over the content.
+func (b Bytes) Open() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// Size returns the content length.
+func (b Bytes) Size() int64 {
+	return int64(len(b))
+}
+
+// Retain keeps a body available past the current transaction when it
+// supports reference counting, returning the function that releases it.
+func Retain(body Body) (release func()) {
+	counted, ok := body.(interface {
+		Acquire()
+		Release() error
+	})
+	if !ok {
+		return func() {}
+	}
+
+	counted.Acquire()
+	return func() { counted.Release() }
+}
+
+// Envelope holds the SMTP transaction details of a message.
+type Envelope struct {
+	RemoteAddr string   `json:"remote_addr,omitempty"` // Client address
+	Username   string   `json:"username,omitempty"`    // Authenticated user, empty for anonymous sessions
+	From       string   `json:"from"`                  // MAIL FROM
+	To         []string `json:"to"`                    // RCPT TO
+	TLS        *TLS     `json:"tls,omitempty"`         // Nil for plain-text sessions
+}
+
+// TLS describes the TLS connection a message was received over.
+type TLS struct {
+	Version       string `json:"version"`                  // e.g. TLS 1.3
+	CipherSuite   string `json:"cipher_suite"`             // e.g. TLS_AES_128_GCM_SHA256
+	ClientSubject string `json:"client_subject,omitempty"` // Subject of the verified client certificate, with mutual TLS
+}
+
+// Part is a leaf body part of a message with its transfer encoding removed.
+// Content is nil when the part did not fit in the memory budget of Parse.
+type Part struct {
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename,omitempty"`
+	Inline      bool   `json:"inline"`
+	Size        int64  `json:"size"`
+	Content     []byte `json:"-"`
+}
+
+// Verdict is the outcome of a check run on the message, such as an SPF or
+// spam check.
+type Verdict struct {
+	Check  string `json:"check"`
+	Result string `json:"result"` // e.g. pass, fail, neutral
+	Detail string `json:"detail,omitempty"`
+}
+
+// Timeline stages recorded while an email is processed.
+const (
+	StageData     = "data"         // DATA command accepted, content transfer starts
+	StageReceived = "received"     // Content fully read
+	StageParsed   = "parsed"       // Headers and parts decoded
+	StageStored   = "stored"       // Copy written to storage
+	StageWebhook  = "webhook_sent" // Webhook notified, the detail names it
+	StageShadow   = "shadow_sent"  // Mirrored to the shadow target, the detail names it
+	StageRelayed  = "relayed"      // Forwarded upstream, the detail lists the recipients
+)
+
+// TimelineEvent records when a message went through a processing stage.
+type TimelineEvent struct {
+	Stage  string    `json:"stage"`
+	At     time.Time `json:"at"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// Message is an email with its envelope, parsed headers and body parts.
+type Message struct {
+	Envelope   Envelope    `json:"envelope"`
+	Header     mail.Header `json:"header"`
+	Subject    string      `json:"subject"` // Decoded Subject header
+	Parts      []Part      `json:"parts"`
+	ReceivedAt time.Time   `json:"received_at"`
+	Tags       []string    `json:"tags,omitempty"`
+	Verdicts   []Verdict   `json:"verdicts,omitempty"`
+	Language   string      `json:"language,omitempty"` // ISO 639-1 code of the body language, empty when unknown
+	Folder     string      `json:"folder,omitempty"`   // Mailbox folder the email is filed in, empty for the inbox
+
+	// Timeline lists the processing stages reached before storing
+	Timeline []TimelineEvent `json:"timeline,omitempty"`
+
+	// ParseError describes why headers or parts could not be read; the raw
+	// content is kept regardless, since a sink must capture malformed mail too.
+	ParseError string `json:"parse_error,omitempty"`
+
+	// Body is the raw content as received
+	Body Body `json:"-"`
+	// Size is the raw content length in bytes
+	Size int64 `json:"size"`
+}
+
+// Parse builds a message from its envelope and raw content, keeping up to
+// DefaultPartBudget bytes of decoded parts in memory.
+func Parse(envelope Envelope, body Body) *Message {
+	return ParseWithBudget(envelope, body, DefaultPartBudget)
+}
+
+// ParseWithBudget builds a message like Parse, keeping at most budget bytes
+// of decoded part content in memory. The body is streamed, so large messages
+// are never loaded whole. It never fails: problems are recorded in
+// ParseError and whatever could be read is kept.
+func ParseWithBudget(envelope Envelope, body Body, budget int64) *Message {
+	msg := &Message{
+		Envelope:   envelope,
+		Header:     mail.Header{},
+		ReceivedAt: time.Now(),
+		Body:       body,
+		Size:       body.Size(),
+	}
+
+	r, err := body.Open()
+	if err != nil {
+		msg.ParseError = fmt.Sprintf("opening body: %v", err)
+		return msg
+	}
+	defer r.Close()
+
+	parsed, err := mail.ReadMessage(bufio.NewReader(r))
+	if err != nil {
+		msg.ParseError = fmt.Sprintf("reading headers: %v", err)
+		return msg
+	}
+
+	msg.Header = parsed.Header
+	msg.Subject = decodeHeader(parsed.Header.Get("Subject"))
+
+	if err := msg.addParts(parsed.Header, parsed.Body, &budget, 0); err != nil {
+		msg.ParseError = fmt.Sprintf("reading parts: %v", err)
+	}
+	return msg
+}
+
+// ReadRaw returns the whole raw content in memory.
+func (msg *Message) ReadRaw() ([]byte, error) {
+	r, err := msg.Body.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// AddVerdict records the outcome of a check.
+func (msg *Message) AddVerdict(check, result, detail string) {
+	msg.Verdicts = append(msg.Verdicts, Verdict{Check: check, Result: result, Detail: detail})
+}
+
+// Mark records that the message reached stage now.
+func (msg *Message) Mark(stage string) {
+	msg.Timeline = append(msg.Timeline, TimelineEvent{Stage: stage, At: time.Now()})
+}
+
+// Text returns the first text/plain part, or an empty string.
+func (msg *Message) Text() string {
+	for _, part := range msg.Parts {
+		if part.ContentType == "text/plain" && part.Filename == "" {
+			return string(part.Content)
+		}
+	}
+	return ""
+}
+
+// Attachments returns the parts carrying a file name.
+func (msg *Message) Attachments() []Part {
+	var attachments []Part
+	for _, part := range msg.Parts {
+		if part.Filename != "" {
+			attachments = append(attachments, part)
+		}
+	}
+	return attachments
+}
+
+// headerGetter is implemented by mail.Header and textproto.MIMEHeader.
+type headerGetter interface {
+	Get(key string) string
+}
+
+// addParts appends the leaf parts of a body, descending into multiparts.
+// Decoded content is kept while budget allows.
+func (msg *Message) addParts(header headerGetter, body io.Reader, budget *int64, depth int) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") && depth < maxPartDepth {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := msg.addParts(part.Header, part, budget, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	part := Part{ContentType: mediaType}
+	if disposition, dispParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		part.Inline = disposition == "inline"
+		part.Filename = decodeHeader(dispParams["filename"])
+	}
+	if part.Filename == "" {
+		part.Filename = decodeHeader(params["name"])
+	}
+
+	decoded := decodeTransfer(header.Get("Content-Transfer-Encoding"), body)
+	kept, err := io.ReadAll(io.LimitReader(decoded, *budget+1))
+	if err != nil {
+		return err
+	}
+	part.Size = int64(len(kept))
+
+	if part.Size > *budget {
+		rest, err := io.Copy(io.Discard, decoded)
+		if err != nil {
+			return err
+		}
+		part.Size += rest
+		*budget = 0
+	} else {
+		part.Content = kept
+		*budget -= part.Size
+	}
+
+	msg.Parts = append(msg.Parts, part)
+	return nil
+}
+
+// decodeTransfer removes a base64 or quoted-printable transfer encoding.
+func decodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	default:
+		return body
+	}
+}
+
+// decodeHeader decodes RFC 2047 encoded words, returning the input on failure.
+func decodeHeader(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
@@ -0,0 +1,90 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+const multipartEmail = "From: sender@example.com\r\n" +
+	"To: john@test.org\r\n" +
+	"Subject: =?UTF-8?Q?Caf=C3=A9_report?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Caf=C3=A9 body\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>body</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=report.csv\r\n" +
+	"Content-Disposition: attachment; filename=report.csv\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"YSxiCjEsMgo=\r\n" +
+	"--outer--\r\n"
+
+func TestParse(t *testing.T) {
+	envelope := Envelope{From: "sender@example.com", To: []string{"john@test.org"}}
+	msg := Parse(envelope, Bytes(multipartEmail))
+
+	if msg.ParseError != "" {
+		t.Fatalf("ParseError = %s", msg.ParseError)
+	}
+	if msg.Subject != "Café report" {
+		t.Errorf("Subject = %q, want decoded subject", msg.Subject)
+	}
+	if len(msg.Parts) != 3 {
+		t.Fatalf("parsed %d parts, want 3", len(msg.Parts))
+	}
+	if text := msg.Text(); strings.TrimSpace(text) != "Café body" {
+		t.Errorf("Text() = %q, want decoded quoted-printable body", text)
+	}
+
+	attachments := msg.Attachments()
+	if len(attachments) != 1 || attachments[0].Filename != "report.csv" {
+		t.Fatalf("Attachments() = %+v, want report.csv", attachments)
+	}
+	if got := string(attachments[0].Content); got != "a,b\n1,2\n" {
+		t.Errorf("attachment content = %q, want decoded base64", got)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	raw := []byte("not an email at all")
+	msg := Parse(Envelope{From: "a@example.com"}, Bytes(raw))
+
+	if msg.ParseError == "" {
+		t.Error("ParseError is empty for malformed content")
+	}
+	if kept, err := msg.ReadRaw(); err != nil || string(kept) != string(raw) {
+		t.Errorf("ReadRaw() = %q, %v, want the raw content", kept, err)
+	}
+}
+
+func TestParseWithBudget(t *testing.T) {
+	msg := ParseWithBudget(Envelope{}, Bytes(multipartEmail), 12)
+
+	if len(msg.Parts) != 3 {
+		t.Fatalf("parsed %d parts, want 3", len(msg.Parts))
+	}
+	if msg.Parts[0].Content == nil {
+		t.Error("first part within the budget was not kept")
+	}
+	for _, part := range msg.Parts[1:] {
+		if part.Content != nil {
+			t.Errorf("part %s beyond the budget was kept", part.ContentType)
+		}
+		if part.Size == 0 {
+			t.Errorf("part %s has no size", part.ContentType)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package metrics exposes server metrics in the Prometheus text format.
+//
+// Metrics are pulled: each subsystem registers a collector returning the
+// current values, which is called on every scrape.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metric types.
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// Family is a named metric with its samples.
+type Family struct {
+	Name    string
+	Help    string
+	Type    string // Counter or Gauge
+	Samples []Sample
+}
+
+// Sample is one value of a metric family.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// Collector returns the current metric families of a subsystem.
+type Collector func() []Family
+
+// Registry holds the collectors scraped by the metrics endpoint.
+// It is safe for concurrent use.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a collector.
+func (registry *Registry) Register(collector Collector) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.collectors = append(registry.collectors, collector)
+}
+
+// Gather calls every collector and returns the families sorted by name.
+func (registry *Registry) Gather() []Family {
+	registry.mu.Lock()
+	collectors := append([]Collector(nil), registry.collectors...)
+	registry.mu.Unlock()
+
+	var families []Family
+	for _, collect := range collectors {
+		families = append(families, collect()...)
+	}
+	sort.SliceStable(families, func(i, j int) bool { return families[i].Name < families[j].Name })
+	return families
+}
+
+// WriteText writes every metric in the Prometheus text exposition format.
+func (registry *Registry) WriteText(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	for _, family := range registry.Gather() {
+		fmt.Fprintf(out, "# HELP %s %s\n", family.Name, escapeHelp(family.Help))
+		fmt.Fprintf(out, "# TYPE %s %s\n", family.Name, family.Type)
+		for _, sample := range family.Samples {
+			fmt.Fprintf(out, "%s%s %s\n", family.Name, formatLabels(sample.Labels),
+				strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		}
+	}
+	return out.Flush()
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registry.WriteText(w)
+	})
+}
+
+// formatLabels renders labels as {name="value",...} in name order.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, labels[name])
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escapeHelp escapes backslashes and newlines in help text.
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+// Bool converts a condition to a gauge value.
+func Bool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(func() []Family {
+		return []Family{{
+			Name: "test_healthy",
+			Help: "Whether the thing is healthy.",
+			Type: Gauge,
+			Samples: []Sample{
+				{Labels: map[string]string{"domain": "b.com", "kind": "x"}, Value: 0},
+				{Labels: map[string]string{"domain": "a.com"}, Value: 1},
+			},
+		}}
+	})
+	registry.Register(func() []Family {
+		return []Family{{Name: "test_failures_total", Help: "Failures.", Type: Counter, Samples: []Sample{{Value: 3}}}}
+	})
+
+	var buf bytes.Buffer
+	if err := registry.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() failed: %v", err)
+	}
+
+	want := `# HELP test_failures_total Failures.
+# TYPE test_failures_total counter
+test_failures_total 3
+# HELP test_healthy Whether the thing is healthy.
+# TYPE test_healthy gauge
+test_healthy{domain="b.com",kind="x"} 0
+test_healthy{domain="a.com"} 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteText() =\n%s\nwant\n%s", got, want)
+	}
+}
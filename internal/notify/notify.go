@@ -0,0 +1,373 @@
+// Package notify posts captured emails to webhooks such as Slack incoming
+// webhooks, either one notification per email or as periodic digests.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/internal/worker"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+)
+
+// requestTimeout bounds each webhook request.
+const requestTimeout = 10 * time.Second
+
+// topRecipients is the number of recipients listed in a digest.
+const topRecipients = 5
+
+// Notifications are posted by sendWorkers goroutines per webhook; up to
+// queueSize more wait for one, and later ones are dropped.
+const (
+	sendWorkers = 4
+	queueSize   = 1000
+)
+
+// Email is the JSON payload sent for one email.
+type Email struct {
+	Event      string    `json:"event"` // Always "email"
+	Webhook    string    `json:"webhook,omitempty"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject"`
+	Tags       []string  `json:"tags,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+	URL        string    `json:"url,omitempty"` // Link to the first recipient copy, when api.public_url is set
+}
+
+// Digest is the JSON payload summarizing the emails of one period.
+type Digest struct {
+	Event         string           `json:"event"` // Always "digest"
+	Webhook       string           `json:"webhook,omitempty"`
+	Count         int              `json:"count"`
+	Since         time.Time        `json:"since"`
+	Until         time.Time        `json:"until"`
+	TopRecipients []RecipientCount `json:"top_recipients"`
+}
+
+// RecipientCount is the number of emails received by one recipient.
+type RecipientCount struct {
+	Recipient string `json:"recipient"`
+	Count     int    `json:"count"`
+}
+
+// Webhook posts JSON payloads, or chat text in the Slack format, to a URL.
+type Webhook struct {
+	url    string
+	format string
+	client *http.Client
+}
+
+// NewWebhook creates a webhook posting to url in format, json or slack,
+// defaulting to json.
+func NewWebhook(url, format string) *Webhook {
+	if format == "" {
+		format = "json"
+	}
+	return &Webhook{url: url, format: format, client: &http.Client{Timeout: requestTimeout}}
+}
+
+// Send posts payload, or text in the Slack format, to the webhook.
+func (webhook *Webhook) Send(payload any, text string) error {
+	if webhook.format == "slack" {
+		payload = map[string]string{"text": text}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding notification: %w", err)
+	}
+
+	resp, err := webhook.client.Post(webhook.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL may embed a credential, so only the error cause is kept
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("posting notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting notification: webhook replied %s", resp.Status)
+	}
+	return nil
+}
+
+// Notifier sends the emails matching its rules to one webhook.
+type Notifier struct {
+	name      string
+	webhook   *Webhook
+	rules     []rules.Match
+	digest    time.Duration
+	publicURL string
+	now       func() time.Time
+	pool      *worker.Pool
+
+	wg         sync.WaitGroup // Digest mode, until Run sends the final digest
+	mu         sync.Mutex
+	count      int
+	recipients map[string]int
+	since      time.Time // Start of the current digest period
+}
+
+// New creates a notifier for the webhook configuration. Notifications link
+// to the emails on the API at publicURL, unless it is empty. In digest mode,
+// Run must be started before Wait is called.
+func New(cfg config.WebhookConfig, publicURL string) *Notifier {
+	notifier := &Notifier{
+		name:       cfg.Name,
+		webhook:    NewWebhook(string(cfg.URL), cfg.Format),
+		rules:      cfg.Rules,
+		digest:     cfg.Digest,
+		publicURL:  publicURL,
+		now:        time.Now,
+		pool:       worker.NewPool(sendWorkers, queueSize),
+		recipients: make(map[string]int),
+		since:      time.Now(),
+	}
+	if notifier.digest > 0 {
+		// Counted here rather than in Run, so Wait cannot return before a
+		// Run goroutine that has not started yet sends the final digest
+		notifier.wg.Add(1)
+	}
+	return notifier
+}
+
+// Middleware returns an ingest middleware notifying about stored emails.
+// It must be registered at the notify stage.
+func (notifier *Notifier) Middleware() pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			if err := next(ctx, delivery); err != nil {
+				return err
+			}
+			notifier.Notify(delivery)
+			return nil
+		}
+	}
+}
+
+// Notify queues a notification for a stored email matching the rules, or
+// adds it to the current digest in digest mode. Notifications are dropped
+// while the queue is full.
+func (notifier *Notifier) Notify(delivery *pipeline.Delivery) {
+	msg := delivery.Message
+	if !rules.Any(notifier.rules, msg) {
+		return
+	}
+
+	if notifier.digest > 0 {
+		notifier.mu.Lock()
+		notifier.count++
+		for _, recipient := range msg.Envelope.To {
+			notifier.recipients[strings.ToLower(recipient)]++
+		}
+		notifier.mu.Unlock()
+		return
+	}
+
+	email := Email{
+		Event:      "email",
+		Webhook:    notifier.name,
+		From:       msg.Envelope.From,
+		To:         msg.Envelope.To,
+		Subject:    msg.Subject,
+		Tags:       msg.Tags,
+		ReceivedAt: msg.ReceivedAt,
+		URL:        notifier.link(delivery.Stored),
+	}
+	stored := append([]pipeline.StoredCopy(nil), delivery.Stored...)
+	timeline := len(msg.Timeline) > 0
+	queued := notifier.pool.Submit(func() {
+		if err := notifier.webhook.Send(email, emailText(email)); err != nil {
+			slog.Warn("Webhook notification failed", "webhook", notifier.name, "error", err)
+			return
+		}
+		if timeline {
+			sentAt := time.Now()
+			pipeline.UpdateStored(stored, func(metadata *storage.Metadata) {
+				metadata.Mark(message.StageWebhook, notifier.name, sentAt)
+			})
+		}
+	})
+	if !queued {
+		slog.Debug("Webhook notification dropped, queue full", "webhook", notifier.name)
+	}
+}
+
+// Collect returns the metrics of notifiers.
+func Collect(notifiers []*Notifier) []metrics.Family {
+	dropped := metrics.Family{
+		Name: "gargantua_webhook_notifications_dropped_total",
+		Help: "Webhook notifications dropped because the send queue of the webhook was full.",
+		Type: metrics.Counter,
+	}
+	for _, notifier := range notifiers {
+		dropped.Samples = append(dropped.Samples, metrics.Sample{
+			Labels: map[string]string{"webhook": notifier.name},
+			Value:  float64(notifier.pool.Dropped()),
+		})
+	}
+	return []metrics.Family{dropped}
+}
+
+// link returns the URL of the first recipient copy, or of the sender copy
+// when no recipient copy was stored, or nothing without a public URL.
+func (notifier *Notifier) link(stored []pipeline.StoredCopy) string {
+	if notifier.publicURL == "" || len(stored) == 0 {
+		return ""
+	}
+	for _, item := range stored {
+		if item.Direction == storage.Incoming {
+			return client.MessageURL(notifier.publicURL, item.ID)
+		}
+	}
+	return client.MessageURL(notifier.publicURL, stored[0].ID)
+}
+
+// Run sends the pending digest every period until ctx is canceled, then
+// sends the last one. It returns immediately when digests are disabled.
+func (notifier *Notifier) Run(ctx context.Context) {
+	if notifier.digest <= 0 {
+		return
+	}
+	defer notifier.wg.Done()
+
+	ticker := time.NewTicker(notifier.digest)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err := notifier.Flush(); err != nil {
+				slog.Warn("Webhook digest failed", "webhook", notifier.name, "error", err)
+			}
+			return
+		}
+		if err := notifier.Flush(); err != nil {
+			slog.Warn("Webhook digest failed", "webhook", notifier.name, "error", err)
+		}
+	}
+}
+
+// Flush sends the pending digest, if any email was collected since the last one.
+func (notifier *Notifier) Flush() error {
+	notifier.mu.Lock()
+	now := notifier.now()
+	if notifier.count == 0 {
+		notifier.since = now
+		notifier.mu.Unlock()
+		return nil
+	}
+	digest := Digest{
+		Event:         "digest",
+		Webhook:       notifier.name,
+		Count:         notifier.count,
+		Since:         notifier.since,
+		Until:         now,
+		TopRecipients: top(notifier.recipients, topRecipients),
+	}
+	notifier.count = 0
+	notifier.recipients = make(map[string]int)
+	notifier.since = now
+	notifier.mu.Unlock()
+
+	return notifier.webhook.Send(digest, digestText(digest))
+}
+
+// Wait blocks until background notifications, and the final digest once
+// the context of Run is canceled, are sent or ctx expires.
+func (notifier *Notifier) Wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		notifier.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return notifier.pool.Wait(ctx)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// top returns the n recipients with the most emails, ties in address order.
+func top(recipients map[string]int, n int) []RecipientCount {
+	counts := make([]RecipientCount, 0, len(recipients))
+	for recipient, count := range recipients {
+		counts = append(counts, RecipientCount{Recipient: recipient, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Recipient < counts[j].Recipient
+	})
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// emailText formats an email notification for chat.
+func emailText(email Email) string {
+	subject := email.Subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	text := fmt.Sprintf("New email from %s to %s: %s", email.From, strings.Join(email.To, ", "), subject)
+	if email.URL != "" {
+		text += " " + email.URL
+	}
+	return text
+}
+
+// digestText formats a digest for chat, e.g. "42 messages in the last 10m,
+// top recipients: a@example.com (30), b@example.com (12)".
+func digestText(digest Digest) string {
+	noun := "messages"
+	if digest.Count == 1 {
+		noun = "message"
+	}
+	text := fmt.Sprintf("%d %s in the last %s", digest.Count, noun, formatPeriod(digest.Until.Sub(digest.Since)))
+
+	if len(digest.TopRecipients) > 0 {
+		recipients := make([]string, len(digest.TopRecipients))
+		for i, recipient := range digest.TopRecipients {
+			recipients[i] = fmt.Sprintf("%s (%d)", recipient.Recipient, recipient.Count)
+		}
+		text += ", top recipients: " + strings.Join(recipients, ", ")
+	}
+	return text
+}
+
+// formatPeriod formats a digest period rounded to the second, dropping
+// zero trailing units: "10m" rather than "10m0s".
+func formatPeriod(period time.Duration) string {
+	text := period.Round(time.Second).String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/notify/notifytest"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/internal/worker"
+)
+
+// testDelivery builds a delivery of a message from sender to recipients,
+// stored as a sender and a recipient copy with a timeline.
+func testDelivery(t *testing.T, subject string, to ...string) *pipeline.Delivery {
+	t.Helper()
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+	delivery := &pipeline.Delivery{
+		Message: &message.Message{
+			Envelope:   message.Envelope{From: "app@example.com", To: to},
+			Subject:    subject,
+			ReceivedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			Body:       message.Bytes([]byte("Subject: " + subject + "\r\n\r\nHello\r\n")),
+		},
+	}
+	delivery.Message.Mark(message.StageReceived)
+	for _, direction := range []storage.Direction{storage.Outgoing, storage.Incoming} {
+		id, err := emailStorage.StoreMessage(direction, "example.com", "app", subject, delivery.Message)
+		if err != nil {
+			t.Fatalf("storing email failed: %v", err)
+		}
+		delivery.Stored = append(delivery.Stored, pipeline.StoredCopy{Storage: emailStorage, ID: id, Direction: direction})
+	}
+	return delivery
+}
+
+func TestNotifyEmail(t *testing.T) {
+	url, received := notifytest.StartWebhook(t)
+	notifier := New(config.WebhookConfig{
+		Name:  "alerts",
+		URL:   config.Secret(url),
+		Rules: []rules.Match{{To: "*@example.com"}},
+	}, "https://sink.example.com/")
+
+	delivery := testDelivery(t, "Welcome", "user@example.com")
+	notifier.Notify(testDelivery(t, "Skipped", "user@other.test"))
+	notifier.Notify(delivery)
+	if err := notifier.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+
+	if len(received) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(received))
+	}
+	var email Email
+	if err := json.Unmarshal(<-received, &email); err != nil {
+		t.Fatalf("decoding notification failed: %v", err)
+	}
+	if email.Event != "email" || email.Webhook != "alerts" || email.Subject != "Welcome" || len(email.To) != 1 || email.To[0] != "user@example.com" {
+		t.Errorf("got notification %+v", email)
+	}
+	if want := "https://sink.example.com/m/" + delivery.Stored[1].ID; email.URL != want {
+		t.Errorf("got notification URL %q, want %q", email.URL, want)
+	}
+
+	for _, item := range delivery.Stored {
+		stored, err := item.Storage.Get(item.ID)
+		if err != nil {
+			t.Fatalf("reading email failed: %v", err)
+		}
+		timeline := stored.Metadata.Timeline
+		if len(timeline) != 3 || timeline[1].Stage != message.StageStored || timeline[2].Stage != message.StageWebhook || timeline[2].Detail != "alerts" {
+			t.Errorf("timeline of %s = %+v, want received, stored and the webhook", item.Direction, timeline)
+		}
+	}
+}
+
+func TestNotifyDigest(t *testing.T) {
+	url, received := notifytest.StartWebhook(t)
+	notifier := New(config.WebhookConfig{URL: config.Secret(url), Format: "slack", Digest: 10 * time.Minute}, "")
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	notifier.since = start
+	notifier.now = func() time.Time { return start.Add(10 * time.Minute) }
+
+	for i := 0; i < 3; i++ {
+		notifier.Notify(testDelivery(t, "Reset", "a@example.com"))
+	}
+	notifier.Notify(testDelivery(t, "Invoice", "B@example.com", "a@example.com"))
+
+	if len(received) != 0 {
+		t.Fatalf("got %d notifications before the digest, want 0", len(received))
+	}
+	if err := notifier.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	var payload struct{ Text string }
+	if err := json.Unmarshal(<-received, &payload); err != nil {
+		t.Fatalf("decoding digest failed: %v", err)
+	}
+	want := "4 messages in the last 10m, top recipients: a@example.com (4), b@example.com (1)"
+	if payload.Text != want {
+		t.Errorf("got digest %q, want %q", payload.Text, want)
+	}
+
+	// An empty period sends nothing
+	if err := notifier.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if len(received) != 0 {
+		t.Errorf("got %d notifications for an empty digest, want 0", len(received))
+	}
+}
+
+func TestNotifyWaitSendsFinalDigest(t *testing.T) {
+	url, received := notifytest.StartWebhook(t)
+	notifier := New(config.WebhookConfig{URL: config.Secret(url), Digest: time.Hour}, "")
+	notifier.Notify(testDelivery(t, "Reset", "a@example.com"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go notifier.Run(ctx)
+	if err := notifier.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if len(received) != 1 {
+		t.Errorf("got %d notifications after Wait, want the final digest", len(received))
+	}
+}
+
+func TestNotifyDropsWhenQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(server.Close)
+
+	notifier := New(config.WebhookConfig{Name: "slow", URL: config.Secret(server.URL)}, "")
+	notifier.pool = worker.NewPool(1, 1)
+	delivery := testDelivery(t, "Load", "user@example.com")
+	for i := 0; i < 5; i++ {
+		notifier.Notify(delivery)
+		time.Sleep(5 * time.Millisecond) // Let the worker take the first one
+	}
+
+	samples := Collect([]*Notifier{notifier})[0].Samples
+	if len(samples) != 1 || samples[0].Labels["webhook"] != "slow" || samples[0].Value != 3 {
+		t.Errorf("dropped samples = %+v, want 3 for webhook slow", samples)
+	}
+	close(release)
+	if err := notifier.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+}
@@ -0,0 +1,25 @@
+// Package notifytest provides a webhook receiver for testing code posting
+// through notify.Webhook.
+package notifytest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// StartWebhook runs a webhook receiver passing each request body to the
+// returned channel, which buffers up to 10 bodies. The receiver is closed
+// when the test ends.
+func StartWebhook(t testing.TB) (string, <-chan []byte) {
+	t.Helper()
+
+	received := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, received
+}
@@ -0,0 +1,117 @@
+// Package pipeline composes the steps an accepted email goes through between
+// the end of DATA and the reply to the client.
+//
+// Middlewares are registered per stage and run in stage order: auth, filter,
+// enrich, store and notify. Each middleware receives the next handler and
+// decides whether, and when, to call it; a filter rejects an email by
+// returning an error instead, and a notifier acts after next has stored it.
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// Stage orders the middlewares of the chain.
+type Stage int
+
+// Stages in execution order.
+const (
+	StageAuth   Stage = iota // Checks on the client and its credentials
+	StageFilter              // Accept or reject the email, e.g. SPF checks
+	StageEnrich              // Add tags or rewrite content before storing, e.g. PII scrubbing
+	StageStore               // Write the email to storage
+	StageNotify              // React to stored emails, e.g. mirroring or webhooks
+	numStages
+)
+
+var stageNames = [...]string{"auth", "filter", "enrich", "store", "notify"}
+
+// String returns the stage name.
+func (stage Stage) String() string {
+	if stage < 0 || stage >= numStages {
+		return fmt.Sprintf("stage(%d)", int(stage))
+	}
+	return stageNames[stage]
+}
+
+// Delivery is an email travelling through the chain. Middlewares may modify
+// the message for the stages that follow; its tags and verdicts are saved
+// with every stored copy. The message body is only valid until the chain
+// returns unless kept with message.Retain.
+type Delivery struct {
+	Message *message.Message
+
+	// Stored lists the copies written by the store stage
+	Stored []StoredCopy
+}
+
+// StoredCopy identifies one stored copy of an email.
+type StoredCopy struct {
+	Storage   *storage.EmailStorage
+	ID        string
+	Direction storage.Direction // OUT for the sender copy, IN for recipient copies
+}
+
+// UpdateStored applies update to the metadata of every stored copy, logging
+// failures. It may be called after the chain returned, e.g. by a notifier
+// recording the outcome of background work.
+func UpdateStored(stored []StoredCopy, update func(*storage.Metadata)) {
+	for _, item := range stored {
+		if _, err := item.Storage.UpdateMetadata(item.ID, update); err != nil {
+			log.Printf("Error updating metadata of email %s: %v", item.ID, err)
+		}
+	}
+}
+
+// Handler processes a delivery. A returned error rejects the email; an
+// *smtp.SMTPError from go-smtp sets the reply code sent to the client.
+type Handler func(ctx context.Context, delivery *Delivery) error
+
+// Middleware wraps the rest of the chain.
+type Middleware func(next Handler) Handler
+
+// Chain holds the registered middlewares of every stage.
+// It is safe for concurrent use.
+type Chain struct {
+	mu     sync.RWMutex
+	stages [numStages][]Middleware
+}
+
+// NewChain creates an empty chain.
+func NewChain() *Chain {
+	return &Chain{}
+}
+
+// Use appends a middleware to a stage. Middlewares of the same stage run in
+// registration order.
+func (chain *Chain) Use(stage Stage, middleware Middleware) {
+	if stage < 0 || stage >= numStages {
+		panic(fmt.Sprintf("pipeline: invalid %s", stage))
+	}
+
+	chain.mu.Lock()
+	defer chain.mu.Unlock()
+	chain.stages[stage] = append(chain.stages[stage], middleware)
+}
+
+// Handler builds a handler running every middleware in stage order.
+// Middlewares registered afterwards are not included.
+func (chain *Chain) Handler() Handler {
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+
+	handler := Handler(func(context.Context, *Delivery) error { return nil })
+	for stage := numStages - 1; stage >= 0; stage-- {
+		middlewares := chain.stages[stage]
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			handler = middlewares[i](handler)
+		}
+	}
+	return handler
+}
@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recorder returns a middleware appending name to trace before calling next.
+func recorder(trace *[]string, name string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, delivery *Delivery) error {
+			*trace = append(*trace, name)
+			return next(ctx, delivery)
+		}
+	}
+}
+
+func TestChainStageOrder(t *testing.T) {
+	var trace []string
+
+	chain := NewChain()
+	chain.Use(StageNotify, recorder(&trace, "notify"))
+	chain.Use(StageStore, recorder(&trace, "store"))
+	chain.Use(StageFilter, recorder(&trace, "filter-1"))
+	chain.Use(StageAuth, recorder(&trace, "auth"))
+	chain.Use(StageFilter, recorder(&trace, "filter-2"))
+	chain.Use(StageEnrich, recorder(&trace, "enrich"))
+
+	if err := chain.Handler()(context.Background(), &Delivery{}); err != nil {
+		t.Fatalf("handler failed: %v", err)
+	}
+
+	want := "auth,filter-1,filter-2,enrich,store,notify"
+	if got := strings.Join(trace, ","); got != want {
+		t.Errorf("order = %s, want %s", got, want)
+	}
+}
+
+func TestChainRejectStopsLaterStages(t *testing.T) {
+	var trace []string
+	errRejected := errors.New("rejected")
+
+	chain := NewChain()
+	chain.Use(StageFilter, func(next Handler) Handler {
+		return func(ctx context.Context, delivery *Delivery) error {
+			return errRejected
+		}
+	})
+	chain.Use(StageStore, recorder(&trace, "store"))
+
+	if err := chain.Handler()(context.Background(), &Delivery{}); !errors.Is(err, errRejected) {
+		t.Errorf("handler error = %v, want %v", err, errRejected)
+	}
+	if len(trace) != 0 {
+		t.Errorf("later stages ran after rejection: %v", trace)
+	}
+}
@@ -0,0 +1,145 @@
+// Package retention decides which stored emails are old enough to be
+// deleted under a retention policy. Planning only looks at a summary of
+// each email, so the same report is produced from the storage directories
+// and from the API of a running server.
+package retention
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// Policy sets how long emails are kept, per domain.
+type Policy struct {
+	MaxAge  time.Duration            // Zero keeps emails forever
+	Domains map[string]time.Duration // Overrides by lowercase domain
+}
+
+// NewPolicy creates the policy of cfg.
+func NewPolicy(cfg config.RetentionConfig) Policy {
+	policy := Policy{MaxAge: cfg.MaxAge, Domains: make(map[string]time.Duration, len(cfg.Domains))}
+	for domain, maxAge := range cfg.Domains {
+		policy.Domains[strings.ToLower(domain)] = maxAge
+	}
+	return policy
+}
+
+// MaxAgeFor returns how long the emails of domain are kept, zero meaning
+// forever.
+func (policy Policy) MaxAgeFor(domain string) time.Duration {
+	if maxAge, ok := policy.Domains[strings.ToLower(domain)]; ok {
+		return maxAge
+	}
+	return policy.MaxAge
+}
+
+// Item summarizes a stored email.
+type Item struct {
+	ID         string
+	Domain     string
+	Size       int64
+	ReceivedAt time.Time
+	Held       bool // On legal hold or awaiting approval, never deleted
+}
+
+// Report describes what applying a policy would delete.
+type Report struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Messages    int            `json:"messages"` // Emails that would be deleted
+	Bytes       int64          `json:"bytes"`
+	Domains     []DomainReport `json:"domains"`
+}
+
+// DomainReport describes what applying a policy would delete from a domain.
+type DomainReport struct {
+	Domain   string     `json:"domain"`
+	MaxAge   string     `json:"max_age,omitempty"` // Empty when kept forever
+	Messages int        `json:"messages"`          // Emails that would be deleted
+	Bytes    int64      `json:"bytes"`
+	Oldest   *time.Time `json:"oldest,omitempty"` // Oldest email that would be deleted
+	Newest   *time.Time `json:"newest,omitempty"` // Newest email that would be deleted
+	Held     int        `json:"held"`             // Expired emails kept because of a hold or pending approval
+	Kept     int        `json:"kept"`             // Emails younger than the max age
+}
+
+// Plan applies policy to items at now. It returns the report, with one
+// entry per domain sorted by name, and the IDs of the emails to delete.
+func Plan(policy Policy, items []Item, now time.Time) (Report, []string) {
+	report := Report{GeneratedAt: now, Domains: []DomainReport{}}
+	domains := make(map[string]*DomainReport)
+	var expired []string
+
+	for _, item := range items {
+		name := strings.ToLower(item.Domain)
+		domain, ok := domains[name]
+		if !ok {
+			domain = &DomainReport{Domain: name}
+			if maxAge := policy.MaxAgeFor(name); maxAge > 0 {
+				domain.MaxAge = maxAge.String()
+			}
+			domains[name] = domain
+		}
+
+		maxAge := policy.MaxAgeFor(name)
+		switch {
+		case maxAge <= 0 || now.Sub(item.ReceivedAt) < maxAge:
+			domain.Kept++
+		case item.Held:
+			domain.Held++
+		default:
+			domain.Messages++
+			domain.Bytes += item.Size
+			receivedAt := item.ReceivedAt
+			if domain.Oldest == nil || receivedAt.Before(*domain.Oldest) {
+				domain.Oldest = &receivedAt
+			}
+			if domain.Newest == nil || receivedAt.After(*domain.Newest) {
+				domain.Newest = &receivedAt
+			}
+			report.Messages++
+			report.Bytes += item.Size
+			expired = append(expired, item.ID)
+		}
+	}
+
+	for _, domain := range domains {
+		report.Domains = append(report.Domains, *domain)
+	}
+	sort.Slice(report.Domains, func(i, j int) bool {
+		return report.Domains[i].Domain < report.Domains[j].Domain
+	})
+	return report, expired
+}
+
+// Collect summarizes every email of storages, noting those on hold and
+// those still awaiting an approval decision.
+func Collect(storages []*storage.EmailStorage) ([]Item, error) {
+	var items []Item
+	for _, emailStorage := range storages {
+		emails, err := emailStorage.List(storage.ListFilter{})
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range emails {
+			held, err := emailStorage.OnHold(email)
+			if err != nil {
+				return nil, err
+			}
+			if approval := email.Metadata.Approval; approval != nil && approval.State == storage.ApprovalPending {
+				held = true
+			}
+			items = append(items, Item{
+				ID:         email.ID,
+				Domain:     email.Domain,
+				Size:       email.Size,
+				ReceivedAt: email.ReceivedAt,
+				Held:       held,
+			})
+		}
+	}
+	return items, nil
+}
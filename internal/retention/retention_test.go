@@ -0,0 +1,103 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestPlan(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	policy := NewPolicy(config.RetentionConfig{
+		MaxAge:  30 * day,
+		Domains: map[string]time.Duration{"Keep.example": 0, "short.example": day},
+	})
+
+	items := []Item{
+		{ID: "a", Domain: "example.com", Size: 100, ReceivedAt: now.Add(-40 * day)},
+		{ID: "b", Domain: "example.com", Size: 200, ReceivedAt: now.Add(-31 * day)},
+		{ID: "c", Domain: "example.com", Size: 400, ReceivedAt: now.Add(-2 * day)},
+		{ID: "d", Domain: "example.com", Size: 800, ReceivedAt: now.Add(-50 * day), Held: true},
+		{ID: "e", Domain: "keep.example", Size: 100, ReceivedAt: now.Add(-400 * day)},
+		{ID: "f", Domain: "short.example", Size: 50, ReceivedAt: now.Add(-2 * day)},
+	}
+	report, expired := Plan(policy, items, now)
+
+	if len(expired) != 3 || expired[0] != "a" || expired[1] != "b" || expired[2] != "f" {
+		t.Errorf("expired = %v, want [a b f]", expired)
+	}
+	if report.Messages != 3 || report.Bytes != 350 {
+		t.Errorf("report totals = %d emails, %d bytes; want 3 emails, 350 bytes", report.Messages, report.Bytes)
+	}
+	if len(report.Domains) != 3 {
+		t.Fatalf("report domains = %+v, want 3", report.Domains)
+	}
+
+	tests := []DomainReport{
+		{Domain: "example.com", MaxAge: "720h0m0s", Messages: 2, Bytes: 300, Held: 1, Kept: 1},
+		{Domain: "keep.example", Kept: 1},
+		{Domain: "short.example", MaxAge: "24h0m0s", Messages: 1, Bytes: 50},
+	}
+	for i, want := range tests {
+		got := report.Domains[i]
+		if got.Domain != want.Domain || got.MaxAge != want.MaxAge || got.Messages != want.Messages ||
+			got.Bytes != want.Bytes || got.Held != want.Held || got.Kept != want.Kept {
+			t.Errorf("domain %d = %+v, want %+v", i, got, want)
+		}
+	}
+
+	example := report.Domains[0]
+	if example.Oldest == nil || !example.Oldest.Equal(now.Add(-40*day)) || example.Newest == nil || !example.Newest.Equal(now.Add(-31*day)) {
+		t.Errorf("example.com range = %v - %v, want 40 to 31 days ago", example.Oldest, example.Newest)
+	}
+	if report.Domains[1].Oldest != nil {
+		t.Errorf("keep.example oldest = %v, want none", report.Domains[1].Oldest)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+	content := []byte("Subject: Hi\r\n\r\nHello\r\n")
+	if _, err := emailStorage.Store(storage.Incoming, "example.com", "john", "hi", content); err != nil {
+		t.Fatalf("storing email failed: %v", err)
+	}
+	if _, err := emailStorage.Store(storage.Incoming, "example.com", "jane", "hi", content); err != nil {
+		t.Fatalf("storing email failed: %v", err)
+	}
+	if err := emailStorage.HoldMailbox("example.com", "jane", storage.Hold{Reason: "litigation"}); err != nil {
+		t.Fatalf("holding mailbox failed: %v", err)
+	}
+	pending, err := emailStorage.Store(storage.Incoming, "example.com", "joe", "hi", content)
+	if err != nil {
+		t.Fatalf("storing email failed: %v", err)
+	}
+	if _, err := emailStorage.UpdateMetadata(pending, func(m *storage.Metadata) {
+		m.Approval = &storage.Approval{State: storage.ApprovalPending}
+	}); err != nil {
+		t.Fatalf("requesting approval failed: %v", err)
+	}
+
+	items, err := Collect([]*storage.EmailStorage{emailStorage})
+	if err != nil {
+		t.Fatalf("Collect() failed: %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("items = %+v, want 3", items)
+	}
+	for _, item := range items {
+		if item.Domain != "example.com" || item.Size != int64(len(content)) {
+			t.Errorf("item = %+v, want an example.com email of %d bytes", item, len(content))
+		}
+	}
+
+	report, expired := Plan(Policy{MaxAge: time.Nanosecond}, items, time.Now().Add(time.Second))
+	if len(expired) != 1 || report.Domains[0].Held != 2 {
+		t.Errorf("expired = %v, held = %d; want the held mailbox and the pending approval kept", expired, report.Domains[0].Held)
+	}
+}
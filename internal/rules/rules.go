@@ -0,0 +1,77 @@
+// Package rules selects emails by envelope and header criteria shared by the
+// features that act on a subset of the traffic.
+package rules
+
+import (
+	"path"
+	"strings"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+)
+
+// Match selects emails. Every non-empty criterion must hold; an empty Match
+// matches every email.
+type Match struct {
+	From    string `yaml:"from,omitempty" json:"from,omitempty"`       // Glob on the envelope sender, e.g. *@example.com
+	To      string `yaml:"to,omitempty" json:"to,omitempty"`           // Glob that at least one recipient must match
+	Subject string `yaml:"subject,omitempty" json:"subject,omitempty"` // Case-insensitive substring of the Subject header
+}
+
+// Matches reports whether the message satisfies every criterion.
+func (match Match) Matches(msg *message.Message) bool {
+	if match.From != "" && !globMatch(match.From, msg.Envelope.From) {
+		return false
+	}
+
+	if match.To != "" {
+		found := false
+		for _, recipient := range msg.Envelope.To {
+			if globMatch(match.To, recipient) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if match.Subject != "" && !strings.Contains(strings.ToLower(msg.Subject), strings.ToLower(match.Subject)) {
+		return false
+	}
+
+	return true
+}
+
+// Any reports whether at least one match selects the message.
+// An empty list matches every email.
+func Any(matches []Match, msg *message.Message) bool {
+	if len(matches) == 0 {
+		return true
+	}
+	for _, match := range matches {
+		if match.Matches(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate reports malformed glob patterns.
+func (match Match) Validate() error {
+	for _, pattern := range []string{match.From, match.To} {
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globMatch matches an address against a shell pattern, case-insensitively.
+func globMatch(pattern, address string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(address))
+	return ok
+}
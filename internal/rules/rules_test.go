@@ -0,0 +1,54 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+)
+
+func TestMatches(t *testing.T) {
+	msg := &message.Message{
+		Envelope: message.Envelope{
+			From: "alerts@Example.com",
+			To:   []string{"john@test.org", "ops@company.net"},
+		},
+		Subject: "Nightly Build FAILED",
+	}
+
+	tests := []struct {
+		name  string
+		match Match
+		want  bool
+	}{
+		{name: "empty", match: Match{}, want: true},
+		{name: "from_glob", match: Match{From: "*@example.com"}, want: true},
+		{name: "from_mismatch", match: Match{From: "*@other.com"}, want: false},
+		{name: "any_recipient", match: Match{To: "ops@*"}, want: true},
+		{name: "no_recipient", match: Match{To: "sales@*"}, want: false},
+		{name: "subject_substring", match: Match{Subject: "failed"}, want: true},
+		{name: "all_criteria", match: Match{From: "alerts@*", To: "*@test.org", Subject: "build"}, want: true},
+		{name: "one_criterion_fails", match: Match{From: "alerts@*", Subject: "passed"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.match.Matches(msg); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAny(t *testing.T) {
+	msg := &message.Message{Envelope: message.Envelope{From: "a@example.com"}}
+
+	if !Any(nil, msg) {
+		t.Error("Any(nil) = false, want true")
+	}
+	if Any([]Match{{From: "*@other.com"}}, msg) {
+		t.Error("Any() matched a non-matching rule")
+	}
+	if !Any([]Match{{From: "*@other.com"}, {From: "*@example.com"}}, msg) {
+		t.Error("Any() did not match the second rule")
+	}
+}
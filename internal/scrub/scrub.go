@@ -0,0 +1,180 @@
+// Package scrub removes personal data from emails while preserving their
+// structure, so captured corpora can be shared outside the team.
+package scrub
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// hashLength is the number of hex digits kept from each hash.
+const hashLength = 12
+
+// addressHeaders hold addresses, which are hashed.
+var addressHeaders = map[string]bool{
+	"From":          true,
+	"To":            true,
+	"Cc":            true,
+	"Bcc":           true,
+	"Reply-To":      true,
+	"Sender":        true,
+	"Return-Path":   true,
+	"Delivered-To":  true,
+	"X-Original-To": true,
+}
+
+// hashedHeaders identify messages or people without holding addresses.
+var hashedHeaders = map[string]bool{
+	"Message-Id":  true,
+	"In-Reply-To": true,
+	"References":  true,
+	"Content-Id":  true,
+}
+
+// structuralHeaders describe the format of a message rather than people and
+// are kept; every header not listed here or above is dropped.
+var structuralHeaders = map[string]bool{
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Content-Disposition":       true,
+	"Content-Language":          true,
+	"Date":                      true,
+	"X-Mailer":                  true,
+	"User-Agent":                true,
+	"Importance":                true,
+	"Priority":                  true,
+	"X-Priority":                true,
+	"Precedence":                true,
+	"Auto-Submitted":            true,
+}
+
+// Scrubber anonymizes emails. Hashes are keyed by a salt: the same value
+// always maps to the same hash for one scrubber, so threads and senders stay
+// linkable inside a dataset without being reversible.
+type Scrubber struct {
+	salt []byte
+}
+
+// New creates a scrubber keyed by salt.
+func New(salt []byte) *Scrubber {
+	return &Scrubber{salt: salt}
+}
+
+// NewRandom creates a scrubber with a random salt, so its hashes cannot be
+// linked to another dataset.
+func NewRandom() (*Scrubber, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return New(salt), nil
+}
+
+// Hash returns the keyed hash of value, ignoring case.
+func (scrubber *Scrubber) Hash(value string) string {
+	mac := hmac.New(sha256.New, scrubber.salt)
+	mac.Write([]byte(strings.ToLower(value)))
+	return hex.EncodeToString(mac.Sum(nil))[:hashLength]
+}
+
+// Address hashes the local part and domain of an address separately, so
+// emails of one domain can still be grouped. The result uses the reserved
+// .invalid top-level domain.
+func (scrubber *Scrubber) Address(address string) string {
+	local, domain, found := strings.Cut(address, "@")
+	if !found {
+		return scrubber.Hash(address)
+	}
+	return scrubber.Hash(local) + "@" + scrubber.Hash(domain) + ".invalid"
+}
+
+// Text redacts letters and digits, keeping whitespace, punctuation and
+// length so the layout of the text survives.
+func Text(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r):
+			return 'x'
+		case unicode.IsDigit(r):
+			return '0'
+		default:
+			return r
+		}
+	}, text)
+}
+
+// Filename redacts a file name, keeping its extension.
+func Filename(name string) string {
+	ext := path.Ext(name)
+	return Text(strings.TrimSuffix(name, ext)) + ext
+}
+
+// Header returns a copy of header with addresses and identifiers hashed
+// and the subject redacted. Of the other headers only structural ones, such
+// as Content-Type or X-Mailer, are kept, with file names redacted; the rest,
+// which may repeat addresses or the subject, are dropped.
+func (scrubber *Scrubber) Header(header mail.Header) mail.Header {
+	scrubbed := make(mail.Header, len(header))
+	for key, values := range header {
+		switch canonical := textproto.CanonicalMIMEHeaderKey(key); {
+		case addressHeaders[canonical]:
+			scrubbed[key] = mapValues(values, scrubber.addressList)
+		case hashedHeaders[canonical]:
+			scrubbed[key] = mapValues(values, scrubber.Hash)
+		case canonical == "Subject":
+			scrubbed[key] = mapValues(values, Text)
+		case canonical == "Content-Type" || canonical == "Content-Disposition":
+			scrubbed[key] = mapValues(values, mediaType)
+		case structuralHeaders[canonical]:
+			scrubbed[key] = append([]string(nil), values...)
+		}
+	}
+	return scrubbed
+}
+
+// mediaType redacts the file name parameters of a Content-Type or
+// Content-Disposition value, dropping values that cannot be parsed.
+func mediaType(value string) string {
+	mediatype, params, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	for _, param := range []string{"name", "filename"} {
+		if name, ok := params[param]; ok {
+			params[param] = Filename(name)
+		}
+	}
+	return mime.FormatMediaType(mediatype, params)
+}
+
+// addressList hashes every address of a header value, dropping display names.
+func (scrubber *Scrubber) addressList(value string) string {
+	addresses, err := mail.ParseAddressList(value)
+	if err != nil {
+		return scrubber.Hash(value)
+	}
+
+	hashed := make([]string, len(addresses))
+	for i, address := range addresses {
+		hashed[i] = scrubber.Address(address.Address)
+	}
+	return strings.Join(hashed, ", ")
+}
+
+// mapValues applies fn to every value.
+func mapValues(values []string, fn func(string) string) []string {
+	mapped := make([]string, len(values))
+	for i, value := range values {
+		mapped[i] = fn(value)
+	}
+	return mapped
+}
@@ -0,0 +1,122 @@
+package scrub
+
+import (
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestAddress(t *testing.T) {
+	scrubber := New([]byte("salt"))
+
+	first := scrubber.Address("John@Example.com")
+	if first != scrubber.Address("john@example.com") {
+		t.Error("hashing is case sensitive")
+	}
+	if strings.Contains(first, "john") || !strings.HasSuffix(first, ".invalid") {
+		t.Errorf("Address() = %q, want an anonymous .invalid address", first)
+	}
+
+	other := scrubber.Address("jane@example.com")
+	if domainOf(first) != domainOf(other) {
+		t.Error("addresses of one domain do not share the hashed domain")
+	}
+	if New([]byte("other")).Address("john@example.com") == first {
+		t.Error("different salts produced the same hash")
+	}
+}
+
+func domainOf(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	return domain
+}
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Call me at 555-1234.", "xxxx xx xx 000-0000."},
+		{"Olá\r\n\tJoão", "xxx\r\n\txxxx"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Text(tt.in); got != tt.want {
+			t.Errorf("Text(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	if got := Filename("invoice-2024.pdf"); got != "xxxxxxx-0000.pdf" {
+		t.Errorf("Filename() = %q, want the extension kept", got)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	scrubber := New([]byte("salt"))
+	header := mail.Header{
+		"From":         {"John Doe <john@example.com>"},
+		"To":           {"a@example.com, b@test.org"},
+		"Subject":      {"Secret plan"},
+		"Received":     {"from mail.example.com"},
+		"Content-Type": {"text/plain"},
+		"Message-Id":   {"<123@example.com>"},
+	}
+
+	scrubbed := scrubber.Header(header)
+
+	if got := scrubbed.Get("From"); got != scrubber.Address("john@example.com") {
+		t.Errorf("From = %q, want the hashed address without display name", got)
+	}
+	if got := scrubbed.Get("To"); strings.Count(got, "@") != 2 || strings.Contains(got, "example") {
+		t.Errorf("To = %q, want two hashed addresses", got)
+	}
+	if got := scrubbed.Get("Subject"); got != "xxxxxx xxxx" {
+		t.Errorf("Subject = %q, want redacted", got)
+	}
+	if _, ok := scrubbed["Received"]; ok {
+		t.Error("Received header was kept")
+	}
+	if got := scrubbed.Get("Content-Type"); got != "text/plain" {
+		t.Errorf("Content-Type = %q, want kept", got)
+	}
+	if got := scrubbed.Get("Message-Id"); strings.Contains(got, "example") {
+		t.Errorf("Message-Id = %q, want hashed", got)
+	}
+	if header.Get("Subject") != "Secret plan" {
+		t.Error("Header() modified its input")
+	}
+}
+
+func TestHeaderDropsUnlisted(t *testing.T) {
+	scrubber := New([]byte("salt"))
+	header := mail.Header{
+		"Resent-From":                 {"john@example.com"},
+		"Resent-To":                   {"jane@example.com"},
+		"Resent-Cc":                   {"bob@example.com"},
+		"X-Sender":                    {"john@example.com"},
+		"X-Forwarded-To":              {"jane@example.com"},
+		"Disposition-Notification-To": {"john@example.com"},
+		"List-Unsubscribe":            {"<mailto:leave@example.com>"},
+		"List-Post":                   {"<mailto:list@example.com>"},
+		"Thread-Topic":                {"Secret plan"},
+		"Mime-Version":                {"1.0"},
+		"X-Mailer":                    {"Mailer 1.0"},
+		"Content-Disposition":         {`attachment; filename="secret-plan.pdf"`},
+	}
+
+	scrubbed := scrubber.Header(header)
+
+	for key := range scrubbed {
+		switch key {
+		case "Mime-Version", "X-Mailer", "Content-Disposition":
+		default:
+			t.Errorf("header %s = %q was kept, want dropped", key, scrubbed[key])
+		}
+	}
+	if got := scrubbed.Get("Mime-Version"); got != "1.0" {
+		t.Errorf("Mime-Version = %q, want kept", got)
+	}
+	if got := scrubbed.Get("Content-Disposition"); got != "attachment; filename=xxxxxx-xxxx.pdf" {
+		t.Errorf("Content-Disposition = %q, want the file name redacted", got)
+	}
+}
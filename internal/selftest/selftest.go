@@ -0,0 +1,508 @@
+// Package selftest runs protocol-level conformance checks against an SMTP
+// server and reports which supported behaviours work as expected.
+package selftest
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	Pass Status = "pass"
+	Fail Status = "fail"
+	Skip Status = "skip" // The behaviour is not offered by the server
+)
+
+// dialTimeout bounds connecting to the server and each exchange that is not
+// expected to block.
+const dialTimeout = 5 * time.Second
+
+// sender is the envelope sender used by every check.
+const sender = "selftest@gargantua.invalid"
+
+// Target describes the server under test and the settings it runs with,
+// which the checks compare against what the server announces.
+type Target struct {
+	Addr            string
+	Recipient       string        // Address the server accepts mail for
+	MaxMessageBytes int64         // Expected SIZE limit, 0 when unknown
+	ReadTimeout     time.Duration // Expected idle timeout, 0 skips the timeout check
+
+	// Confirm optionally verifies that the email sent by the delivery check
+	// reached storage.
+	Confirm func() error
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name     string        `json:"name"`
+	Status   Status        `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Report lists the results of every check.
+type Report struct {
+	Addr    string   `json:"addr"`
+	Results []Result `json:"results"`
+}
+
+// Passed reports whether no check failed.
+func (report Report) Passed() bool {
+	for _, result := range report.Results {
+		if result.Status == Fail {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteText prints the report as an aligned table followed by a summary.
+func (report Report) WriteText(w io.Writer) error {
+	counts := make(map[Status]int)
+	if _, err := fmt.Fprintf(w, "SMTP conformance report for %s\n\n", report.Addr); err != nil {
+		return err
+	}
+	for _, result := range report.Results {
+		counts[result.Status]++
+		line := fmt.Sprintf("  %-4s  %-12s %8s", strings.ToUpper(string(result.Status)), result.Name, result.Duration.Round(time.Millisecond))
+		if result.Detail != "" {
+			line += "  " + result.Detail
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", counts[Pass], counts[Fail], counts[Skip])
+	return err
+}
+
+// check is one conformance check. It returns the detail of a pass, or an
+// error describing the failure; a skipError marks behaviour that is not offered.
+type check struct {
+	name string
+	run  func(target Target) (string, error)
+}
+
+// skipError reports a check that does not apply to the server.
+type skipError struct {
+	reason string
+}
+
+func (err skipError) Error() string {
+	return err.reason
+}
+
+// checks are run in order; later ones rely on the basics checked first.
+var checks = []check{
+	{"greeting", checkGreeting},
+	{"ehlo", checkEHLO},
+	{"delivery", checkDelivery},
+	{"pipelining", checkPipelining},
+	{"starttls", checkSTARTTLS},
+	{"size", checkSize},
+	{"malformed", checkMalformed},
+	{"line-length", checkLineLength},
+	{"timeout", checkTimeout},
+}
+
+// Run executes every check against target.
+func Run(target Target) Report {
+	report := Report{Addr: target.Addr}
+	for _, check := range checks {
+		start := time.Now()
+		detail, err := check.run(target)
+		result := Result{Name: check.name, Status: Pass, Detail: detail, Duration: time.Since(start)}
+
+		var skip skipError
+		switch {
+		case errors.As(err, &skip):
+			result.Status = Skip
+			result.Detail = skip.reason
+		case err != nil:
+			result.Status = Fail
+			result.Detail = err.Error()
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// client is a raw SMTP connection, so checks can send what a well-behaved
+// client library would refuse to.
+type client struct {
+	conn       net.Conn
+	text       *textproto.Conn
+	extensions map[string]string
+}
+
+// dial connects and reads the greeting.
+func dial(addr string) (*client, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &client{conn: conn, text: textproto.NewConn(conn)}
+	if _, _, err := c.expect(220); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("greeting: %w", err)
+	}
+	return c, nil
+}
+
+// dialEHLO connects and introduces itself, recording the extensions.
+func dialEHLO(addr string) (*client, error) {
+	c, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ehlo(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// ehlo sends EHLO and records the announced extensions.
+func (c *client) ehlo() error {
+	_, message, err := c.cmd(250, "EHLO selftest.invalid")
+	if err != nil {
+		return fmt.Errorf("EHLO: %w", err)
+	}
+
+	c.extensions = make(map[string]string)
+	for _, line := range strings.Split(message, "\n")[1:] {
+		name, param, _ := strings.Cut(line, " ")
+		c.extensions[strings.ToUpper(name)] = param
+	}
+	return nil
+}
+
+// send writes raw lines without waiting for replies.
+func (c *client) send(lines ...string) error {
+	c.conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err := io.WriteString(c.conn, strings.Join(lines, "\r\n")+"\r\n")
+	return err
+}
+
+// reply reads one reply, whatever its code.
+func (c *client) reply() (int, string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(dialTimeout))
+	code, message, err := c.text.ReadResponse(0)
+	var replyErr *textproto.Error
+	if err != nil && !errors.As(err, &replyErr) {
+		return 0, "", err
+	}
+	return code, message, nil
+}
+
+// expect reads one reply and fails unless its code is want.
+func (c *client) expect(want int) (int, string, error) {
+	code, message, err := c.reply()
+	if err != nil {
+		return code, message, err
+	}
+	if code != want {
+		return code, message, fmt.Errorf("got %d %s, want %d", code, message, want)
+	}
+	return code, message, nil
+}
+
+// cmd sends a command and expects the reply code want.
+func (c *client) cmd(want int, format string, args ...any) (int, string, error) {
+	if err := c.send(fmt.Sprintf(format, args...)); err != nil {
+		return 0, "", err
+	}
+	return c.expect(want)
+}
+
+// rejects sends a command and fails unless the server refuses it.
+func (c *client) rejects(command string) (int, error) {
+	if err := c.send(command); err != nil {
+		return 0, err
+	}
+	code, message, err := c.reply()
+	if err != nil {
+		return 0, err
+	}
+	if code < 400 {
+		return code, fmt.Errorf("%q accepted with %d %s", command, code, message)
+	}
+	return code, nil
+}
+
+// Close ends the connection.
+func (c *client) Close() error {
+	return c.text.Close()
+}
+
+// transaction sends MAIL and RCPT for the target and starts DATA.
+func (c *client) transaction(target Target) error {
+	if _, _, err := c.cmd(250, "MAIL FROM:<%s>", sender); err != nil {
+		return fmt.Errorf("MAIL: %w", err)
+	}
+	if _, _, err := c.cmd(250, "RCPT TO:<%s>", target.Recipient); err != nil {
+		return fmt.Errorf("RCPT: %w", err)
+	}
+	if _, _, err := c.cmd(354, "DATA"); err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	return nil
+}
+
+// testMessage returns a small email addressed to the target.
+func testMessage(target Target, subject string) string {
+	return "From: <" + sender + ">\r\n" +
+		"To: <" + target.Recipient + ">\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"\r\n" +
+		"Conformance self-test message.\r\n"
+}
+
+func checkGreeting(target Target) (string, error) {
+	c, err := dial(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	return "220 received", nil
+}
+
+func checkEHLO(target Target) (string, error) {
+	c, err := dialEHLO(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	names := make([]string, 0, len(c.extensions))
+	for name := range c.extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "extensions: " + strings.Join(names, " "), nil
+}
+
+func checkDelivery(target Target) (string, error) {
+	c, err := dialEHLO(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	if err := c.transaction(target); err != nil {
+		return "", err
+	}
+	if _, _, err := c.cmd(250, "%s.", testMessage(target, "selftest delivery")); err != nil {
+		return "", fmt.Errorf("end of data: %w", err)
+	}
+	if _, _, err := c.cmd(221, "QUIT"); err != nil {
+		return "", fmt.Errorf("QUIT: %w", err)
+	}
+
+	if target.Confirm != nil {
+		if err := target.Confirm(); err != nil {
+			return "", fmt.Errorf("accepted but not stored: %w", err)
+		}
+		return "accepted and stored", nil
+	}
+	return "accepted", nil
+}
+
+func checkPipelining(target Target) (string, error) {
+	c, err := dialEHLO(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	if _, ok := c.extensions["PIPELINING"]; !ok {
+		return "", skipError{"PIPELINING not announced"}
+	}
+
+	// RFC 2920: the whole envelope may be sent in one write
+	err = c.send(
+		"MAIL FROM:<"+sender+">",
+		"RCPT TO:<"+target.Recipient+">",
+		"RCPT TO:<"+target.Recipient+">",
+		"DATA",
+	)
+	if err != nil {
+		return "", err
+	}
+	for i, want := range []int{250, 250, 250, 354} {
+		if _, _, err := c.expect(want); err != nil {
+			return "", fmt.Errorf("pipelined reply %d: %w", i+1, err)
+		}
+	}
+	if _, _, err := c.cmd(250, "%s.", testMessage(target, "selftest pipelining")); err != nil {
+		return "", fmt.Errorf("end of data: %w", err)
+	}
+	return "envelope of 4 commands in one write", nil
+}
+
+func checkSTARTTLS(target Target) (string, error) {
+	c, err := dialEHLO(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	if _, ok := c.extensions["STARTTLS"]; !ok {
+		code, err := c.rejects("STARTTLS")
+		if err != nil {
+			return "", fmt.Errorf("not announced but %w", err)
+		}
+		return "", skipError{fmt.Sprintf("not announced, command refused with %d", code)}
+	}
+
+	if _, _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return "", err
+	}
+	// The certificate is not verified: only the upgrade itself is checked
+	tlsConn := tls.Client(c.conn, &tls.Config{InsecureSkipVerify: true, ServerName: "selftest.invalid"})
+	tlsConn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return "", fmt.Errorf("handshake: %w", err)
+	}
+
+	c.conn = tlsConn
+	c.text = textproto.NewConn(tlsConn)
+	if err := c.ehlo(); err != nil {
+		return "", fmt.Errorf("after upgrade: %w", err)
+	}
+	if _, ok := c.extensions["STARTTLS"]; ok {
+		return "", errors.New("STARTTLS still announced after the upgrade")
+	}
+	return "upgraded to " + tls.VersionName(tlsConn.ConnectionState().Version), nil
+}
+
+// maxSizeProbe bounds the content sent to check the DATA size limit.
+const maxSizeProbe = 4 * 1024 * 1024
+
+func checkSize(target Target) (string, error) {
+	c, err := dialEHLO(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	param, ok := c.extensions["SIZE"]
+	if !ok {
+		return "", skipError{"SIZE not announced"}
+	}
+	limit, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || limit <= 0 {
+		return "", skipError{"SIZE announced without a limit"}
+	}
+	if target.MaxMessageBytes > 0 && limit != target.MaxMessageBytes {
+		return "", fmt.Errorf("SIZE announces %d, configured %d", limit, target.MaxMessageBytes)
+	}
+
+	if _, _, err := c.cmd(552, "MAIL FROM:<%s> SIZE=%d", sender, limit+1); err != nil {
+		return "", fmt.Errorf("declared size above the limit: %w", err)
+	}
+	if limit > maxSizeProbe {
+		return fmt.Sprintf("limit %d enforced on MAIL, DATA not probed", limit), nil
+	}
+
+	if err := c.transaction(target); err != nil {
+		return "", err
+	}
+	header := testMessage(target, "selftest size")
+	line := strings.Repeat("x", 76) + "\r\n"
+	body := strings.Repeat(line, int(limit)/len(line)+1)
+	if _, _, err := c.cmd(552, "%s%s.", header, body); err != nil {
+		return "", fmt.Errorf("oversized DATA: %w", err)
+	}
+	return fmt.Sprintf("limit %d enforced on MAIL and DATA", limit), nil
+}
+
+func checkMalformed(target Target) (string, error) {
+	c, err := dialEHLO(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	commands := []string{
+		"FOOBAR",                   // unknown command
+		"MAIL",                     // missing argument
+		"RCPT TO:<" + sender + ">", // out of sequence
+		"DATA",                     // out of sequence
+		"MAIL FROM:<" + sender + "> BOGUS=1",
+	}
+
+	var codes []string
+	for _, command := range commands {
+		code, err := c.rejects(command)
+		if err != nil {
+			return "", err
+		}
+		codes = append(codes, strconv.Itoa(code))
+		// Leave no transaction open for the next command
+		if _, _, err := c.cmd(250, "RSET"); err != nil {
+			return "", fmt.Errorf("RSET after %q: %w", command, err)
+		}
+	}
+
+	if _, _, err := c.cmd(250, "NOOP"); err != nil {
+		return "", fmt.Errorf("session unusable after malformed commands: %w", err)
+	}
+	return fmt.Sprintf("%d commands refused (%s), session still usable", len(commands), strings.Join(codes, " ")), nil
+}
+
+// lineLengthProbe is well above the 512 octet command limit of RFC 5321.
+const lineLengthProbe = 10 * 1024
+
+func checkLineLength(target Target) (string, error) {
+	c, err := dialEHLO(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	code, err := c.rejects("NOOP " + strings.Repeat("x", lineLengthProbe))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d octet line refused with %d", lineLengthProbe, code), nil
+}
+
+func checkTimeout(target Target) (string, error) {
+	if target.ReadTimeout <= 0 {
+		return "", skipError{"no idle timeout configured"}
+	}
+
+	c, err := dialEHLO(target.Addr)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	start := time.Now()
+	c.conn.SetReadDeadline(start.Add(target.ReadTimeout + dialTimeout))
+	code, _, err := c.text.ReadResponse(0)
+	waited := time.Since(start).Round(100 * time.Millisecond)
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "", fmt.Errorf("connection still open after %s, timeout is %s", waited, target.ReadTimeout)
+	case code != 0 && code != 421:
+		return "", fmt.Errorf("idle connection got %d, want 421 or close", code)
+	}
+	return fmt.Sprintf("idle connection closed after %s", waited), nil
+}
@@ -0,0 +1,85 @@
+package selftest
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestRunAgainstServer(t *testing.T) {
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.MaxMessageBytes = 16 * 1024
+	cfg.ReadTimeout = time.Second
+	server := smtp.NewServerFromConfig(cfg, emailStorage)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening failed: %v", err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	report := Run(Target{
+		Addr:            listener.Addr().String(),
+		Recipient:       "selftest@example.com",
+		MaxMessageBytes: cfg.MaxMessageBytes,
+		ReadTimeout:     cfg.ReadTimeout,
+		Confirm: func() error {
+			emails, err := emailStorage.List(storage.ListFilter{User: "selftest"})
+			if err == nil && len(emails) == 0 {
+				err = errors.New("no email stored")
+			}
+			return err
+		},
+	})
+
+	var out strings.Builder
+	report.WriteText(&out)
+	if !report.Passed() {
+		t.Fatalf("self-test failed:\n%s", out.String())
+	}
+
+	statuses := make(map[string]Status)
+	for _, result := range report.Results {
+		statuses[result.Name] = result.Status
+	}
+	if statuses["pipelining"] != Pass || statuses["size"] != Pass || statuses["timeout"] != Pass {
+		t.Errorf("unexpected statuses:\n%s", out.String())
+	}
+	if statuses["starttls"] != Skip {
+		t.Errorf("starttls = %s without TLS configured, want skip", statuses["starttls"])
+	}
+}
+
+func TestRunDetectsWrongLimit(t *testing.T) {
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+
+	server := smtp.NewServerFromConfig(config.Default().SMTP, emailStorage)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening failed: %v", err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	report := Run(Target{Addr: listener.Addr().String(), Recipient: "selftest@example.com", MaxMessageBytes: 1})
+	for _, result := range report.Results {
+		if result.Name == "size" && result.Status != Fail {
+			t.Errorf("size check = %s with a mismatched limit, want fail", result.Status)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Package shadow mirrors captured emails to a secondary SMTP server and
+// records whether it accepted them, to compare a provider against the sink.
+package shadow
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/smtp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/worker"
+)
+
+// Result describes the delivery of one email to the shadow server.
+type Result struct {
+	Target   string        `json:"target"`
+	Accepted bool          `json:"accepted"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	At       time.Time     `json:"at"`
+}
+
+// Stats summarizes the shadow deliveries since the server started.
+type Stats struct {
+	Target     string        `json:"target"`
+	Sent       int           `json:"sent"`
+	Skipped    int           `json:"skipped"` // Not selected by the rules or sample rate
+	Accepted   int           `json:"accepted"`
+	Rejected   int           `json:"rejected"`
+	AvgLatency time.Duration `json:"-"`
+	P95Latency time.Duration `json:"-"`
+	MaxLatency time.Duration `json:"-"`
+	LastError  string        `json:"last_error,omitempty"`
+
+	// Latencies in milliseconds for API consumers
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+	P95LatencyMS float64 `json:"p95_latency_ms"`
+	MaxLatencyMS float64 `json:"max_latency_ms"`
+}
+
+// maxSamples bounds the number of latencies kept for percentile computation.
+const maxSamples = 10000
+
+// Copies are delivered by sendWorkers goroutines; up to queueSize more wait
+// for one, and later ones are dropped. Each queued copy keeps its body, so
+// the queue is smaller than the webhook and calendar ones.
+const (
+	sendWorkers = 4
+	queueSize   = 100
+)
+
+// Mirror sends copies of emails to the shadow server.
+type Mirror struct {
+	rules      []rules.Match
+	sampleRate float64
+	random     func() float64
+
+	addr     string
+	host     string
+	auth     smtp.Auth
+	timeout  time.Duration
+	pool     *worker.Pool
+	mu       sync.Mutex
+	stats    Stats
+	samples  []time.Duration
+	totalDur time.Duration
+}
+
+// NewMirror creates a mirror for the shadow configuration.
+func NewMirror(cfg config.ShadowConfig) *Mirror {
+	host := cfg.Host
+	if host == "" {
+		host, _, _ = net.SplitHostPort(cfg.Addr)
+	}
+
+	mirror := &Mirror{
+		rules:      cfg.Rules,
+		sampleRate: cfg.SampleRate,
+		random:     rand.Float64,
+
+		addr:    cfg.Addr,
+		host:    host,
+		timeout: cfg.Timeout,
+		pool:    worker.NewPool(sendWorkers, queueSize),
+		stats:   Stats{Target: cfg.Addr},
+	}
+	if cfg.Username != "" {
+		mirror.auth = smtp.PlainAuth("", cfg.Username, string(cfg.Password), host)
+	}
+	return mirror
+}
+
+// Select reports whether an email should be mirrored: it must match one of
+// the rules, if any, and fall within the sample rate. Skipped emails are counted.
+func (mirror *Mirror) Select(msg *message.Message) bool {
+	selected := rules.Any(mirror.rules, msg) && mirror.random() < mirror.sampleRate
+	if !selected {
+		mirror.mu.Lock()
+		mirror.stats.Skipped++
+		mirror.mu.Unlock()
+	}
+	return selected
+}
+
+// Go queues the delivery of a copy of the email and passes the result to
+// done. It reports false, without calling done, when the queue is full.
+func (mirror *Mirror) Go(msg *message.Message, done func(Result)) bool {
+	queued := mirror.pool.Submit(func() {
+		done(mirror.Send(msg))
+	})
+	if !queued {
+		slog.Debug("Shadow delivery dropped, queue full", "target", mirror.addr)
+	}
+	return queued
+}
+
+// Wait blocks until the queued deliveries finish or ctx expires.
+func (mirror *Mirror) Wait(ctx context.Context) error {
+	return mirror.pool.Wait(ctx)
+}
+
+// Collect returns the shadow delivery metrics.
+func (mirror *Mirror) Collect() []metrics.Family {
+	return []metrics.Family{{
+		Name:    "gargantua_shadow_dropped_total",
+		Help:    "Shadow deliveries dropped because the queue was full.",
+		Type:    metrics.Counter,
+		Samples: []metrics.Sample{{Value: float64(mirror.pool.Dropped())}},
+	}}
+}
+
+// Send delivers a copy of the email to the shadow server and records the result.
+func (mirror *Mirror) Send(msg *message.Message) Result {
+	start := time.Now()
+	err := mirror.deliver(msg.Envelope.From, msg.Envelope.To, msg.Body)
+
+	result := Result{
+		Target:   mirror.addr,
+		Accepted: err == nil,
+		Duration: time.Since(start),
+		At:       start,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	mirror.record(result)
+	return result
+}
+
+// Stats returns a summary of the deliveries so far.
+func (mirror *Mirror) Stats() Stats {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+
+	stats := mirror.stats
+	if stats.Sent > 0 {
+		stats.AvgLatency = mirror.totalDur / time.Duration(stats.Sent)
+	}
+	if len(mirror.samples) > 0 {
+		sorted := append([]time.Duration(nil), mirror.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		stats.P95Latency = sorted[(len(sorted)*95+99)/100-1]
+	}
+
+	stats.AvgLatencyMS = milliseconds(stats.AvgLatency)
+	stats.P95LatencyMS = milliseconds(stats.P95Latency)
+	stats.MaxLatencyMS = milliseconds(stats.MaxLatency)
+	return stats
+}
+
+// milliseconds converts a duration to fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// record adds a delivery result to the statistics.
+func (mirror *Mirror) record(result Result) {
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+
+	mirror.stats.Sent++
+	if result.Accepted {
+		mirror.stats.Accepted++
+	} else {
+		mirror.stats.Rejected++
+		mirror.stats.LastError = result.Error
+	}
+
+	mirror.totalDur += result.Duration
+	if result.Duration > mirror.stats.MaxLatency {
+		mirror.stats.MaxLatency = result.Duration
+	}
+
+	if len(mirror.samples) >= maxSamples {
+		mirror.samples = mirror.samples[1:]
+	}
+	mirror.samples = append(mirror.samples, result.Duration)
+}
+
+// deliver performs the SMTP transaction with the shadow server, bounded by the timeout.
+func (mirror *Mirror) deliver(from string, to []string, body message.Body) error {
+	conn, err := net.DialTimeout("tcp", mirror.addr, mirror.timeout)
+	if err != nil {
+		return fmt.Errorf("connecting to shadow server: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(mirror.timeout)); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, mirror.host)
+	if err != nil {
+		return fmt.Errorf("greeting shadow server: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: mirror.host}); err != nil {
+			return fmt.Errorf("starting TLS with shadow server: %w", err)
+		}
+	}
+	if mirror.auth != nil {
+		if err := client.Auth(mirror.auth); err != nil {
+			return fmt.Errorf("authenticating with shadow server: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("MAIL FROM rejected: %w", err)
+	}
+	for _, recipient := range to {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("RCPT TO %s rejected: %w", recipient, err)
+		}
+	}
+
+	content, err := body.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	wc, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA rejected: %w", err)
+	}
+	if _, err := io.Copy(wc, content); err != nil {
+		return fmt.Errorf("writing message: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("message rejected: %w", err)
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,181 @@
+package shadow
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	gosmtp "github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/worker"
+)
+
+// targetBackend is a minimal SMTP server standing in for the shadow provider.
+type targetBackend struct {
+	rejectRcpt bool
+	received   chan []byte
+}
+
+func (bkd *targetBackend) NewSession(_ *gosmtp.Conn) (gosmtp.Session, error) {
+	return &targetSession{backend: bkd}, nil
+}
+
+type targetSession struct {
+	backend *targetBackend
+}
+
+func (s *targetSession) AuthPlain(username, password string) error        { return nil }
+func (s *targetSession) Mail(from string, opts *gosmtp.MailOptions) error { return nil }
+func (s *targetSession) Reset()                                           {}
+func (s *targetSession) Logout() error                                    { return nil }
+
+func (s *targetSession) Rcpt(to string, opts *gosmtp.RcptOptions) error {
+	if s.backend.rejectRcpt {
+		return errors.New("mailbox unavailable")
+	}
+	return nil
+}
+
+func (s *targetSession) Data(r io.Reader) error {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.backend.received <- content
+	return nil
+}
+
+// startTarget runs a shadow target server and returns its address.
+func startTarget(t *testing.T, backend *targetBackend) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	server := gosmtp.NewServer(backend)
+	server.Domain = "shadow.test"
+	server.AllowInsecureAuth = true
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return listener.Addr().String()
+}
+
+// testMessage builds a message from sender@example.com to john@example.com.
+func testMessage(raw string) *message.Message {
+	envelope := message.Envelope{From: "sender@example.com", To: []string{"john@example.com"}}
+	return message.Parse(envelope, message.Bytes(raw))
+}
+
+func TestMirrorSend(t *testing.T) {
+	tests := []struct {
+		name         string
+		rejectRcpt   bool
+		wantAccepted bool
+	}{
+		{name: "accepted", rejectRcpt: false, wantAccepted: true},
+		{name: "rejected", rejectRcpt: true, wantAccepted: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &targetBackend{rejectRcpt: tt.rejectRcpt, received: make(chan []byte, 1)}
+			addr := startTarget(t, backend)
+
+			mirror := NewMirror(config.ShadowConfig{Addr: addr, Timeout: 5 * time.Second})
+			result := mirror.Send(testMessage("Subject: hi\r\n\r\nbody\r\n"))
+
+			if result.Accepted != tt.wantAccepted {
+				t.Errorf("Accepted = %v, want %v (error: %s)", result.Accepted, tt.wantAccepted, result.Error)
+			}
+			if !tt.wantAccepted && result.Error == "" {
+				t.Error("rejected delivery has no error")
+			}
+
+			stats := mirror.Stats()
+			if stats.Sent != 1 || (stats.Accepted == 1) != tt.wantAccepted {
+				t.Errorf("Stats = %+v, want one %s delivery", stats, tt.name)
+			}
+		})
+	}
+}
+
+func TestMirrorUnreachable(t *testing.T) {
+	mirror := NewMirror(config.ShadowConfig{Addr: "127.0.0.1:1", Timeout: time.Second})
+
+	result := mirror.Send(testMessage("body"))
+	if result.Accepted || result.Error == "" {
+		t.Errorf("Send() to unreachable target = %+v, want failure with error", result)
+	}
+}
+
+func TestMirrorDropsWhenQueueFull(t *testing.T) {
+	mirror := NewMirror(config.ShadowConfig{Addr: "127.0.0.1:1", Timeout: time.Second})
+	mirror.pool = worker.NewPool(1, 1)
+
+	release := make(chan struct{})
+	queued := 0
+	for i := 0; i < 5; i++ {
+		if mirror.Go(testMessage("body"), func(Result) { <-release }) {
+			queued++
+		}
+		time.Sleep(20 * time.Millisecond) // Let the worker take the first one
+	}
+
+	if queued != 2 {
+		t.Errorf("queued %d deliveries, want 2", queued)
+	}
+	if dropped := mirror.Collect()[0].Samples[0].Value; dropped != 3 {
+		t.Errorf("dropped = %v, want 3", dropped)
+	}
+	close(release)
+	if err := mirror.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+}
+
+func TestMirrorSelect(t *testing.T) {
+	msg := &message.Message{
+		Envelope: message.Envelope{From: "app@example.com", To: []string{"user@test.org"}},
+		Subject:  "Welcome",
+	}
+
+	tests := []struct {
+		name   string
+		cfg    config.ShadowConfig
+		random float64
+		want   bool
+	}{
+		{name: "all", cfg: config.ShadowConfig{SampleRate: 1}, random: 0.99, want: true},
+		{name: "within_rate", cfg: config.ShadowConfig{SampleRate: 0.05}, random: 0.01, want: true},
+		{name: "outside_rate", cfg: config.ShadowConfig{SampleRate: 0.05}, random: 0.5, want: false},
+		{name: "rule_match", cfg: config.ShadowConfig{SampleRate: 1, Rules: []rules.Match{{Subject: "welcome"}}}, random: 0, want: true},
+		{name: "rule_mismatch", cfg: config.ShadowConfig{SampleRate: 1, Rules: []rules.Match{{To: "*@example.com"}}}, random: 0, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirror := NewMirror(tt.cfg)
+			mirror.random = func() float64 { return tt.random }
+
+			if got := mirror.Select(msg); got != tt.want {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+
+			wantSkipped := 0
+			if !tt.want {
+				wantSkipped = 1
+			}
+			if skipped := mirror.Stats().Skipped; skipped != wantSkipped {
+				t.Errorf("Stats().Skipped = %d, want %d", skipped, wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+)
+
+// maxSegment bounds the payload of each synthesized TCP segment.
+const maxSegment = 16384
+
+// TCP flags of the synthesized segments.
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+)
+
+// redacted replaces the AUTH credentials in captures.
+const redacted = "***"
+
+// captureListener records every accepted connection in a pcap file.
+type captureListener struct {
+	net.Listener
+	dir      string
+	maxBytes int64
+	count    atomic.Uint64
+}
+
+// newCaptureListener records the connections accepted by listener in dir,
+// which is created if missing.
+func newCaptureListener(listener net.Listener, cfg config.SMTPCaptureConfig) (*captureListener, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating capture directory: %w", err)
+	}
+	return &captureListener{Listener: listener, dir: cfg.Dir, maxBytes: cfg.MaxBytes}, nil
+}
+
+// Accept waits for the next connection and starts its capture. A capture
+// that cannot be opened is skipped rather than refusing the connection.
+func (listener *captureListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%s-%d-%s.pcap", time.Now().Format("20060102-150405"), listener.count.Add(1),
+		strings.NewReplacer(":", "_", "[", "", "]", "").Replace(conn.RemoteAddr().String()))
+	capture, err := newCaptureConn(conn, filepath.Join(listener.dir, name), listener.maxBytes)
+	if err != nil {
+		slog.Warn("Opening connection capture failed", "remote", conn.RemoteAddr().String(), "error", err)
+		return conn, nil
+	}
+	return capture, nil
+}
+
+// captureEndpoint is one side of a captured connection.
+type captureEndpoint struct {
+	ip   net.IP
+	port uint16
+	seq  uint32 // Next TCP sequence number
+}
+
+// captureConn writes the traffic of a connection to a pcap file as
+// synthesized TCP/IP packets, so Wireshark and tcpdump can dissect it.
+// AUTH credentials sent in clear are replaced; after STARTTLS the traffic
+// is recorded encrypted, as it was sent.
+type captureConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	file      *os.File
+	written   int64
+	maxBytes  int64
+	truncated bool
+	client    captureEndpoint
+	server    captureEndpoint
+
+	// The client bytes are followed like the tap does, to find credentials
+	line      []byte // Partial client line
+	reply     []byte // Partial server line
+	mode      tapMode
+	chunkLeft int64
+	pending   []string // Commands awaiting a reply, oldest first
+	authReply bool
+}
+
+// newCaptureConn creates the capture file of conn at path and records the
+// TCP handshake.
+func newCaptureConn(conn net.Conn, path string, maxBytes int64) (*captureConn, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("creating capture file: %w", err)
+	}
+
+	capture := &captureConn{
+		Conn:     conn,
+		file:     file,
+		maxBytes: maxBytes,
+		pending:  []string{greeting},
+		client:   endpointOf(conn.RemoteAddr(), 1000),
+		server:   endpointOf(conn.LocalAddr(), 5000),
+	}
+	if capture.client.ip.To4() == nil || capture.server.ip.To4() == nil {
+		capture.client.ip, capture.server.ip = capture.client.ip.To16(), capture.server.ip.To16()
+	} else {
+		capture.client.ip, capture.server.ip = capture.client.ip.To4(), capture.server.ip.To4()
+	}
+
+	// Microsecond pcap with raw IP packets (LINKTYPE_RAW)
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 262144)
+	binary.LittleEndian.PutUint32(header[20:], 101)
+	capture.write(header)
+
+	capture.segment(true, tcpSYN, nil)
+	capture.segment(false, tcpSYN|tcpACK, nil)
+	capture.segment(true, tcpACK, nil)
+	return capture, nil
+}
+
+// endpointOf returns the endpoint of a TCP address, starting its sequence
+// numbers at isn.
+func endpointOf(addr net.Addr, isn uint32) captureEndpoint {
+	endpoint := captureEndpoint{ip: net.IPv4zero, seq: isn}
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		endpoint.ip, endpoint.port = tcp.IP, uint16(tcp.Port)
+	}
+	return endpoint
+}
+
+// Read receives client bytes and records them.
+func (conn *captureConn) Read(p []byte) (int, error) {
+	n, err := conn.Conn.Read(p)
+	if n > 0 {
+		conn.mu.Lock()
+		conn.fromClient(p[:n])
+		conn.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write records server bytes and sends them.
+func (conn *captureConn) Write(p []byte) (int, error) {
+	conn.mu.Lock()
+	conn.fromServer(p)
+	conn.mu.Unlock()
+	return conn.Conn.Write(p)
+}
+
+// Close records the end of the connection and closes it.
+func (conn *captureConn) Close() error {
+	conn.mu.Lock()
+	if conn.file != nil {
+		if len(conn.line) > 0 {
+			conn.segment(true, tcpPSH|tcpACK, conn.line)
+		}
+		conn.segment(false, tcpFIN|tcpACK, nil)
+		conn.segment(true, tcpFIN|tcpACK, nil)
+		conn.file.Close()
+		conn.file = nil
+	}
+	conn.mu.Unlock()
+	return conn.Conn.Close()
+}
+
+// fromClient records client bytes. Command lines are recorded once
+// complete, so credentials can be replaced.
+func (conn *captureConn) fromClient(p []byte) {
+	var out []byte
+	for len(p) > 0 {
+		switch conn.mode {
+		case tapPassthrough:
+			out, p = append(out, p...), nil
+			continue
+		case tapChunk:
+			n := min(int64(len(p)), conn.chunkLeft)
+			out, p = append(out, p[:n]...), p[n:]
+			conn.chunkLeft -= n
+			if conn.chunkLeft == 0 {
+				conn.mode = tapCommand
+			}
+			continue
+		}
+
+		end := bytes.IndexByte(p, '\n')
+		if end < 0 {
+			conn.line = append(conn.line, p...)
+			if len(conn.line) > maxTapLine {
+				out, conn.line = append(out, conn.line...), nil
+			}
+			break
+		}
+		line := append(conn.line, p[:end+1]...)
+		conn.line, p = nil, p[end+1:]
+
+		if conn.mode == tapData {
+			if string(line) == ".\r\n" || string(line) == ".\n" {
+				conn.mode = tapCommand
+				conn.pending = append(conn.pending, ".")
+			}
+			out = append(out, line...)
+			continue
+		}
+		out = append(out, conn.command(line)...)
+	}
+
+	if len(out) > 0 {
+		conn.segment(true, tcpPSH|tcpACK, out)
+	}
+}
+
+// command follows a client command line and returns it with credentials
+// replaced.
+func (conn *captureConn) command(line []byte) []byte {
+	ending := line[len(bytes.TrimRight(line, "\r\n")):]
+	if conn.authReply {
+		conn.authReply = false
+		return append([]byte(redacted), ending...)
+	}
+
+	verb, arg, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+	conn.pending = append(conn.pending, strings.ToUpper(verb))
+	switch strings.ToUpper(verb) {
+	case "AUTH":
+		if mechanism, initial, ok := strings.Cut(strings.TrimSpace(arg), " "); ok && initial != "" {
+			return append([]byte(verb+" "+mechanism+" "+redacted), ending...)
+		}
+	case "BDAT":
+		if fields := strings.Fields(arg); len(fields) > 0 {
+			if size, err := strconv.ParseInt(fields[0], 10, 64); err == nil && size > 0 {
+				conn.mode, conn.chunkLeft = tapChunk, size
+			}
+		}
+	}
+	return line
+}
+
+// fromServer records server bytes, matching each reply to the oldest
+// command awaiting one, as pipelined commands are answered in order, to
+// follow the replies that change how client bytes are read.
+func (conn *captureConn) fromServer(p []byte) {
+	conn.segment(false, tcpPSH|tcpACK, p)
+
+	for len(p) > 0 && conn.mode != tapPassthrough {
+		end := bytes.IndexByte(p, '\n')
+		if end < 0 {
+			if len(conn.reply) < maxTapLine {
+				conn.reply = append(conn.reply, p...)
+			}
+			return
+		}
+		line := string(append(conn.reply, p[:end]...))
+		conn.reply, p = conn.reply[:0], p[end+1:]
+
+		if len(line) < 3 || (len(line) > 3 && line[3] == '-') {
+			continue
+		}
+		conn.answer(line[:3])
+	}
+}
+
+// answer follows the final line of a reply with code.
+func (conn *captureConn) answer(code string) {
+	if len(conn.pending) == 0 {
+		return // Unsolicited, e.g. 421 on shutdown
+	}
+	switch command := conn.pending[0]; {
+	case command == "DATA" && code == "354":
+		conn.mode = tapData
+	case command == "STARTTLS" && code == "220":
+		conn.mode = tapPassthrough
+	case command == "AUTH" && code == "334":
+		// The challenge is answered by a credentials line, not a command
+		conn.authReply = true
+		return
+	}
+	conn.pending = conn.pending[1:]
+}
+
+// segment records payload sent by one side in TCP segments with flags.
+func (conn *captureConn) segment(fromClient bool, flags byte, payload []byte) {
+	for {
+		n := min(len(payload), maxSegment)
+		conn.packet(fromClient, flags, payload[:n])
+		payload = payload[n:]
+		if len(payload) == 0 {
+			return
+		}
+	}
+}
+
+// packet records one TCP/IP packet and advances the sequence number of the
+// sender.
+func (conn *captureConn) packet(fromClient bool, flags byte, payload []byte) {
+	src, dst := &conn.client, &conn.server
+	if !fromClient {
+		src, dst = dst, src
+	}
+
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], src.seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], dst.seq)
+	}
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, payload...)
+
+	// The TCP checksum covers a pseudo header with the addresses
+	pseudo := append(append([]byte{}, src.ip...), dst.ip...)
+	var ip []byte
+	if len(src.ip) == net.IPv4len {
+		pseudo = append(pseudo, 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+len(tcp)))
+		ip[6] = 0x40 // Don't fragment
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:], src.ip)
+		copy(ip[16:], dst.ip)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+	} else {
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(tcp)))
+		pseudo = append(pseudo, 0, 0, 0, 6)
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[7] = 64
+		copy(ip[8:], src.ip)
+		copy(ip[24:], dst.ip)
+	}
+	binary.BigEndian.PutUint16(tcp[16:], checksum(append(pseudo, tcp...)))
+
+	now := time.Now()
+	record := make([]byte, 16, 16+len(ip)+len(tcp))
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(ip)+len(tcp)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(ip)+len(tcp)))
+	record = append(append(record, ip...), tcp...)
+	conn.write(record)
+
+	src.seq += uint32(len(payload))
+	if flags&(tcpSYN|tcpFIN) != 0 {
+		src.seq++
+	}
+}
+
+// write appends data to the capture file unless it would exceed the size
+// limit, after which nothing more is recorded.
+func (conn *captureConn) write(data []byte) {
+	if conn.file == nil || conn.truncated {
+		return
+	}
+	if conn.written+int64(len(data)) > conn.maxBytes {
+		conn.truncated = true
+		slog.Warn("Connection capture reached its size limit", "file", conn.file.Name(), "max_bytes", conn.maxBytes)
+		return
+	}
+	n, err := conn.file.Write(data)
+	conn.written += int64(n)
+	if err != nil {
+		conn.truncated = true
+		slog.Warn("Writing connection capture failed", "file", conn.file.Name(), "error", err)
+	}
+}
+
+// checksum returns the Internet checksum (RFC 1071) of data.
+func checksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
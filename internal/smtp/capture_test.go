@@ -0,0 +1,255 @@
+package smtp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// readCapture returns the TCP payloads of a pcap file written by the
+// capture, concatenated per direction.
+func readCapture(t *testing.T, path string) (client, server []byte) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading capture failed: %v", err)
+	}
+	if len(data) < 24 || binary.LittleEndian.Uint32(data) != 0xa1b2c3d4 {
+		t.Fatalf("capture is not a pcap file")
+	}
+	var clientPort uint16
+	for data = data[24:]; len(data) >= 16; {
+		length := int(binary.LittleEndian.Uint32(data[8:]))
+		packet := data[16 : 16+length]
+		data = data[16+length:]
+
+		ipLength := 40
+		if packet[0]>>4 == 4 {
+			ipLength = int(packet[0]&0x0f) * 4
+			if checksum(packet[:ipLength]) != 0 {
+				t.Errorf("invalid IPv4 header checksum")
+			}
+		}
+		tcp := packet[ipLength:]
+		if clientPort == 0 {
+			clientPort = binary.BigEndian.Uint16(tcp[0:]) // The first SYN comes from the client
+		}
+		payload := tcp[int(tcp[12]>>4)*4:]
+		if binary.BigEndian.Uint16(tcp[0:]) == clientPort {
+			client = append(client, payload...)
+		} else {
+			server = append(server, payload...)
+		}
+	}
+	return client, server
+}
+
+func TestCapture(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(filepath.Join(dir, "mail"))
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.Capture = config.SMTPCaptureConfig{Dir: filepath.Join(dir, "captures"), MaxBytes: 1 << 20}
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	plain := base64.StdEncoding.EncodeToString([]byte("\x00john\x00hunter2"))
+	steps := []struct {
+		command string
+		want    int
+	}{
+		{"", 220},
+		{"EHLO client", 250},
+		{"AUTH PLAIN", 334},
+		{"*", 501},
+		{"AUTH PLAIN " + plain, 235},
+		{"MAIL FROM:<a@example.com>", 250},
+		{"RCPT TO:<b@example.com>", 250},
+		{"DATA", 354},
+		{"Subject: captured\r\n\r\nAUTH PLAIN in the body\r\n.", 250},
+		{"QUIT", 221},
+	}
+	for _, step := range steps {
+		if step.command != "" {
+			if err := text.PrintfLine("%s", step.command); err != nil {
+				t.Fatalf("sending %q failed: %v", step.command, err)
+			}
+		}
+		if code, msg, err := text.ReadResponse(0); code != step.want {
+			t.Fatalf("reply to %q = %d %s (%v), want %d", step.command, code, msg, err, step.want)
+		}
+	}
+	conn.Close()
+
+	var files []string
+	for deadline := time.Now().Add(time.Second); len(files) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		files, _ = filepath.Glob(filepath.Join(dir, "captures", "*.pcap"))
+	}
+	if len(files) != 1 {
+		t.Fatalf("captures = %v, want one", files)
+	}
+	time.Sleep(50 * time.Millisecond) // Let the server close the connection
+
+	client, serverBytes := readCapture(t, files[0])
+	for _, want := range []string{"EHLO client\r\n", "AUTH PLAIN\r\n***\r\nAUTH PLAIN ***\r\n", "AUTH PLAIN in the body\r\n", "QUIT\r\n"} {
+		if !bytes.Contains(client, []byte(want)) {
+			t.Errorf("client stream lacks %q:\n%s", want, client)
+		}
+	}
+	if bytes.Contains(client, []byte(plain)) {
+		t.Errorf("client stream contains the credentials %q", plain)
+	}
+	if !strings.Contains(string(serverBytes), "235 2.0.0") || !strings.Contains(string(serverBytes), "221 2.0.0") {
+		t.Errorf("server stream lacks the replies:\n%s", serverBytes)
+	}
+}
+
+func TestCaptureSizeLimit(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening failed: %v", err)
+	}
+	defer listener.Close()
+
+	dir := t.TempDir()
+	capture, err := newCaptureListener(listener, config.SMTPCaptureConfig{Dir: dir, MaxBytes: 1000})
+	if err != nil {
+		t.Fatalf("creating capture listener failed: %v", err)
+	}
+
+	go func() {
+		client, err := net.Dial("tcp", listener.Addr().String())
+		if err == nil {
+			client.Write(bytes.Repeat([]byte("x"), 5000))
+			client.Close()
+		}
+	}()
+	conn, err := capture.Accept()
+	if err != nil {
+		t.Fatalf("accepting failed: %v", err)
+	}
+	buf := make([]byte, 1024)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			break
+		}
+	}
+	conn.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.pcap"))
+	if len(files) != 1 {
+		t.Fatalf("captures = %v, want one", files)
+	}
+	info, err := os.Stat(files[0])
+	if err != nil || info.Size() == 0 || info.Size() > 1000 {
+		t.Errorf("capture size = %d (%v), want at most 1000 bytes", info.Size(), err)
+	}
+}
+
+func TestCapturePipelined(t *testing.T) {
+	tests := []struct {
+		name   string
+		steps  []string // Alternating server and client bytes, starting with the greeting
+		want   string   // Expected in the client stream
+		hidden []string // Must not appear in the client stream
+	}{
+		{
+			name: "envelope_in_one_read",
+			steps: []string{
+				"220 sink\r\n", "EHLO x\r\n",
+				"250-sink\r\n250 CHUNKING\r\n", "MAIL FROM:<a@example.com>\r\nRCPT TO:<b@example.com>\r\nDATA\r\n",
+				"250 ok\r\n", "",
+				"250 ok\r\n", "",
+				"354 go ahead\r\n", "Subject: hi\r\n\r\nAUTH PLAIN c2VjcmV0\r\nBDAT 5\r\n.\r\n",
+				"250 queued\r\n", "QUIT\r\n",
+			},
+			want: "\r\nAUTH PLAIN c2VjcmV0\r\nBDAT 5\r\n.\r\nQUIT\r\n",
+		},
+		{
+			name: "auth_after_ehlo_in_one_read",
+			steps: []string{
+				"220 sink\r\n", "EHLO x\r\nAUTH LOGIN\r\n",
+				"250-sink\r\n250 AUTH LOGIN\r\n", "",
+				"334 VXNlcm5hbWU6\r\n", "am9obg==\r\n",
+				"334 UGFzc3dvcmQ6\r\n", "aHVudGVyMg==\r\n",
+				"235 ok\r\n", "QUIT\r\n",
+			},
+			want:   "AUTH LOGIN\r\n***\r\n***\r\nQUIT\r\n",
+			hidden: []string{"am9obg==", "aHVudGVyMg=="},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("listening failed: %v", err)
+			}
+			defer listener.Close()
+			remote, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatalf("dial failed: %v", err)
+			}
+			defer remote.Close()
+			local, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("accepting failed: %v", err)
+			}
+
+			path := filepath.Join(t.TempDir(), "capture.pcap")
+			capture, err := newCaptureConn(local, path, 1<<20)
+			if err != nil {
+				t.Fatalf("creating capture failed: %v", err)
+			}
+
+			for i, step := range tt.steps {
+				if i%2 == 0 {
+					capture.fromServer([]byte(step))
+				} else if step != "" {
+					capture.fromClient([]byte(step))
+				}
+			}
+			capture.Close()
+
+			client, _ := readCapture(t, path)
+			if !bytes.Contains(client, []byte(tt.want)) {
+				t.Errorf("client stream lacks %q:\n%s", tt.want, client)
+			}
+			for _, hidden := range tt.hidden {
+				if bytes.Contains(client, []byte(hidden)) {
+					t.Errorf("client stream contains %q:\n%s", hidden, client)
+				}
+			}
+		})
+	}
+}
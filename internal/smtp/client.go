@@ -3,6 +3,7 @@ package smtp
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net/smtp"
 	"strings"
@@ -10,6 +11,9 @@ import (
 	"github.com/nathabonfim59/gargantua-sink/internal/storage"
 )
 
+// ErrForwardingDisabled is returned when relaying without a forwarding server.
+var ErrForwardingDisabled = errors.New("forwarding server not configured")
+
 // Client represents an SMTP client that can send emails.
 type Client struct {
 	storage    *storage.EmailStorage
@@ -77,6 +81,19 @@ func (c *Client) SendMail(from string, to []string, subject string, body []byte)
 	return nil
 }
 
+// Relay sends an already formatted email through the forwarding server
+// without storing another copy, e.g. to release a captured email.
+func (c *Client) Relay(from string, to []string, content []byte) error {
+	if c.forwardTo == "" {
+		return ErrForwardingDisabled
+	}
+
+	if err := smtp.SendMail(c.forwardTo, c.forwardAuth, from, to, content); err != nil {
+		return fmt.Errorf("failed to relay email: %w", err)
+	}
+	return nil
+}
+
 // SendMailWithAttachments sends an email with attachments.
 func (c *Client) SendMailWithAttachments(from string, to []string, subject, body string, attachments map[string][]byte) error {
 	// Create email content with attachments
@@ -0,0 +1,151 @@
+package smtp
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// domainRegistry tracks the domains accepted by the server and their storage.
+// It is safe for concurrent use so domains can change while sessions run.
+type domainRegistry struct {
+	mu         sync.RWMutex
+	domains    map[string]*storage.EmailStorage
+	restricted bool // Once restricted, unknown domains are rejected even if none remain
+}
+
+// newDomainRegistry creates an empty registry accepting every domain.
+func newDomainRegistry() *domainRegistry {
+	return &domainRegistry{
+		domains: make(map[string]*storage.EmailStorage),
+	}
+}
+
+// set registers or replaces a domain and restricts the registry.
+func (registry *domainRegistry) set(name string, domainStorage *storage.EmailStorage) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.domains[strings.ToLower(name)] = domainStorage
+	registry.restricted = true
+}
+
+// remove unregisters a domain and reports whether it was present.
+func (registry *domainRegistry) remove(name string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	name = strings.ToLower(name)
+	_, ok := registry.domains[name]
+	delete(registry.domains, name)
+	return ok
+}
+
+// restrict rejects mail for unknown domains even while the registry is empty.
+func (registry *domainRegistry) restrict() {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.restricted = true
+}
+
+// lookup returns the storage registered for a domain and whether the domain is accepted.
+// Unrestricted registries accept every domain and return a nil storage.
+func (registry *domainRegistry) lookup(name string) (*storage.EmailStorage, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	if !registry.restricted {
+		return nil, true
+	}
+
+	domainStorage, ok := registry.domains[strings.ToLower(name)]
+	return domainStorage, ok
+}
+
+// names returns the registered domains in alphabetical order.
+func (registry *domainRegistry) names() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	names := make([]string, 0, len(registry.domains))
+	for name := range registry.domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// storages returns the distinct storages registered for domains.
+func (registry *domainRegistry) storages() []*storage.EmailStorage {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	var storages []*storage.EmailStorage
+	seen := make(map[*storage.EmailStorage]bool)
+	for _, domainStorage := range registry.domains {
+		if !seen[domainStorage] {
+			seen[domainStorage] = true
+			storages = append(storages, domainStorage)
+		}
+	}
+	return storages
+}
+
+// storageHandles caches one storage per directory, so that domains and size
+// routes sharing a directory also share its index and lock. It is safe for
+// concurrent use.
+type storageHandles struct {
+	mu      sync.Mutex
+	handles map[string]*storage.EmailStorage // By absolute directory
+	faults  *storage.Faults                  // Injected into every handle
+}
+
+// newStorageHandles creates a cache already holding base.
+func newStorageHandles(base *storage.EmailStorage) *storageHandles {
+	return &storageHandles{
+		handles: map[string]*storage.EmailStorage{storageKey(base.Root()): base},
+	}
+}
+
+// open returns the storage of path, creating it on first use.
+func (handles *storageHandles) open(path string) (*storage.EmailStorage, error) {
+	key := storageKey(path)
+
+	handles.mu.Lock()
+	defer handles.mu.Unlock()
+
+	if emailStorage, ok := handles.handles[key]; ok {
+		return emailStorage, nil
+	}
+	emailStorage, err := storage.NewEmailStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	emailStorage.InjectFaults(handles.faults)
+	handles.handles[key] = emailStorage
+	return emailStorage, nil
+}
+
+// injectFaults degrades the writes of every handle, including the ones
+// opened later, with faults.
+func (handles *storageHandles) injectFaults(faults *storage.Faults) {
+	handles.mu.Lock()
+	defer handles.mu.Unlock()
+
+	handles.faults = faults
+	for _, emailStorage := range handles.handles {
+		emailStorage.InjectFaults(faults)
+	}
+}
+
+// storageKey identifies the directory of path, however it is spelled.
+func storageKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,77 @@
+package smtp
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestDomainsShareStorageHandles(t *testing.T) {
+	root := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(root)
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	server := NewServer(0, emailStorage)
+
+	shared := filepath.Join(root, "shared")
+	if err := server.AddDomain("a.example.com", shared); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	if err := server.AddDomain("b.example.com", shared+"/"); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	if err := server.AddDomain("c.example.com", root); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	if err := server.AddSizeRoute(1024, shared); err != nil {
+		t.Fatalf("adding size route failed: %v", err)
+	}
+
+	a, _ := server.domains.lookup("a.example.com")
+	b, _ := server.domains.lookup("b.example.com")
+	c, _ := server.domains.lookup("c.example.com")
+	if a != b || a != server.backend.routes[0].storage {
+		t.Error("domains and size route in one directory use distinct storages")
+	}
+	if c != emailStorage {
+		t.Error("domain in the server directory does not use the server storage")
+	}
+	if storages := server.Storages(); len(storages) != 2 {
+		t.Errorf("storages = %d, want the server and shared ones", len(storages))
+	}
+}
+
+func TestConcurrentDomainChanges(t *testing.T) {
+	root := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(root)
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	server := NewServer(0, emailStorage)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := fmt.Sprintf("d%d.example.com", i%5)
+			path := filepath.Join(root, fmt.Sprintf("d%d", i%5))
+			if err := server.AddDomain(name, path); err != nil {
+				t.Errorf("adding domain failed: %v", err)
+			}
+			server.Storages()
+			if i%2 == 0 {
+				server.RemoveDomain(name)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(server.handles.handles); n != 6 {
+		t.Errorf("storage handles = %d, want the server one and one per directory", n)
+	}
+}
@@ -0,0 +1,153 @@
+package smtp
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+)
+
+// extensionProfile restricts the EHLO reply to the configured extensions,
+// in their configured order. go-smtp always offers PIPELINING, 8BITMIME,
+// ENHANCEDSTATUSCODES, CHUNKING, SIZE and AUTH PLAIN, so the tap rewrites
+// its reply and refuses the commands of the extensions left out; the other
+// extensions are switched in go-smtp directly.
+type extensionProfile struct {
+	keywords   []string // Upper-case keywords in advertising order, nil for the defaults
+	mechanisms []string // Upper-case SASL mechanisms offered with AUTH
+}
+
+// newExtensionProfile returns the profile of cfg, or nil when the server
+// keeps the go-smtp defaults.
+func newExtensionProfile(cfg config.SMTPExtensionsConfig) *extensionProfile {
+	if len(cfg.Advertise) == 0 && len(cfg.AuthMechanisms) == 0 {
+		return nil
+	}
+
+	profile := &extensionProfile{mechanisms: []string{sasl.Plain}}
+	for _, keyword := range cfg.Advertise {
+		profile.keywords = append(profile.keywords, strings.ToUpper(keyword))
+	}
+	if len(cfg.AuthMechanisms) > 0 {
+		profile.mechanisms = nil
+		for _, mechanism := range cfg.AuthMechanisms {
+			profile.mechanisms = append(profile.mechanisms, strings.ToUpper(mechanism))
+		}
+	}
+	return profile
+}
+
+// offers reports whether the EHLO reply lists keyword.
+func (profile *extensionProfile) offers(keyword string) bool {
+	return profile == nil || profile.keywords == nil || slices.Contains(profile.keywords, keyword)
+}
+
+// configure switches the extensions go-smtp can turn off itself and adds
+// the LOGIN mechanism.
+func (profile *extensionProfile) configure(server *smtp.Server) {
+	if profile == nil {
+		return
+	}
+	if profile.keywords != nil {
+		server.EnableSMTPUTF8 = profile.offers("SMTPUTF8")
+		server.EnableDSN = profile.offers("DSN")
+		server.EnableBINARYMIME = profile.offers("BINARYMIME")
+		server.EnableREQUIRETLS = profile.offers("REQUIRETLS")
+		server.AuthDisabled = !profile.offers("AUTH")
+		if !profile.offers("STARTTLS") {
+			server.TLSConfig = nil
+		}
+	}
+	if slices.Contains(profile.mechanisms, sasl.Login) {
+		server.EnableAuth(sasl.Login, func(conn *smtp.Conn) sasl.Server {
+			return sasl.NewLoginServer(func(username, password string) error {
+				return conn.Session().AuthPlain(username, password)
+			})
+		})
+	}
+}
+
+// rewriteEHLO returns the lines of the EHLO reply of go-smtp, without
+// CRLF, keeping only the offered extensions. Once the tap completed
+// STARTTLS, which go-smtp does not know about, STARTTLS is left out and
+// REQUIRETLS added when configured. The profile may be nil.
+func (profile *extensionProfile) rewriteEHLO(lines []string, secure bool) []string {
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "250") {
+		return lines
+	}
+
+	offered := make(map[string]string)
+	var order []string
+	for _, line := range lines[1:] {
+		if len(line) < 4 {
+			continue
+		}
+		text := line[4:]
+		keyword, _, _ := strings.Cut(text, " ")
+		keyword = strings.ToUpper(keyword)
+		if keyword == "AUTH" && profile != nil {
+			text = "AUTH " + strings.Join(profile.mechanisms, " ")
+		}
+		offered[keyword] = text
+		order = append(order, keyword)
+	}
+	if secure {
+		delete(offered, "STARTTLS")
+		if profile != nil && slices.Contains(profile.keywords, "REQUIRETLS") {
+			offered["REQUIRETLS"] = "REQUIRETLS"
+		}
+	}
+	if profile != nil && profile.keywords != nil {
+		order = profile.keywords
+	}
+
+	texts := []string{lines[0][4:]}
+	for _, keyword := range order {
+		if text, ok := offered[keyword]; ok {
+			texts = append(texts, text)
+		}
+	}
+	rewritten := make([]string, len(texts))
+	for i, text := range texts {
+		separator := "-"
+		if i == len(texts)-1 {
+			separator = " "
+		}
+		rewritten[i] = "250" + separator + text
+	}
+	return rewritten
+}
+
+// reject answers a command using an extension that is not offered, or
+// returns "" to let go-smtp handle it.
+func (profile *extensionProfile) reject(verb, arg string) string {
+	switch verb {
+	case "BDAT":
+		if !profile.offers("CHUNKING") {
+			return "502 5.5.1 BDAT command not implemented"
+		}
+	case "AUTH":
+		mechanism, _, _ := strings.Cut(strings.TrimSpace(arg), " ")
+		if profile.offers("AUTH") && mechanism != "" && !slices.Contains(profile.mechanisms, strings.ToUpper(mechanism)) {
+			return "504 5.5.4 Unrecognized authentication mechanism"
+		}
+	}
+	return ""
+}
+
+// checkMailOptions refuses the MAIL parameters of extensions go-smtp
+// always accepts but that are not offered.
+func (profile *extensionProfile) checkMailOptions(opts *smtp.MailOptions) error {
+	if opts == nil {
+		return nil
+	}
+	if opts.Body == smtp.Body8BitMIME && !profile.offers("8BITMIME") {
+		return &smtp.SMTPError{Code: 555, EnhancedCode: smtp.EnhancedCode{5, 5, 4}, Message: "BODY=8BITMIME not supported"}
+	}
+	if opts.Size > 0 && !profile.offers("SIZE") {
+		return &smtp.SMTPError{Code: 555, EnhancedCode: smtp.EnhancedCode{5, 5, 4}, Message: "SIZE parameter not supported"}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestExtensionProfile(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.Extensions = config.SMTPExtensionsConfig{
+		Advertise:      []string{"auth", "SIZE", "8BITMIME"},
+		AuthMechanisms: []string{"LOGIN", "PLAIN"},
+	}
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		t.Fatalf("reading greeting failed: %v", err)
+	}
+
+	// Only the configured extensions are offered, in the configured order
+	sendGroup(t, conn, text, "EHLO client.example.com\r\n")
+	_, msg, err := text.ReadResponse(250)
+	want := "Hello client.example.com\nAUTH LOGIN PLAIN\nSIZE 1048576\n8BITMIME"
+	if err != nil || msg != want {
+		t.Fatalf("EHLO reply = %q (%v), want %q", msg, err, want)
+	}
+
+	// Commands of extensions left out are refused, and the chunk of a
+	// refused BDAT is not taken for commands
+	sendGroup(t, conn, text, "BDAT 6 LAST\r\nNOOP\r\n", 502)
+	sendGroup(t, conn, text, "NOOP\r\n", 250)
+	sendGroup(t, conn, text, "AUTH CRAM-MD5\r\n", 504)
+
+	sendGroup(t, conn, text, "AUTH LOGIN\r\n", 334)
+	sendGroup(t, conn, text, base64.StdEncoding.EncodeToString([]byte("john"))+"\r\n", 334)
+	sendGroup(t, conn, text, base64.StdEncoding.EncodeToString([]byte("secret"))+"\r\n", 235)
+
+	sendGroup(t, conn, text, "MAIL FROM:<a@example.com> BODY=8BITMIME SIZE=100\r\n", 250)
+	sendGroup(t, conn, text, "RSET\r\n", 250)
+}
+
+func TestExtensionProfileWithoutSize(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.Extensions.Advertise = []string{"PIPELINING"}
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		t.Fatalf("reading greeting failed: %v", err)
+	}
+
+	sendGroup(t, conn, text, "EHLO client.example.com\r\n")
+	if _, msg, err := text.ReadResponse(250); err != nil || msg != "Hello client.example.com\nPIPELINING" {
+		t.Fatalf("EHLO reply = %q (%v), want only PIPELINING", msg, err)
+	}
+
+	sendGroup(t, conn, text, "AUTH PLAIN\r\n", 500)
+	sendGroup(t, conn, text, "MAIL FROM:<a@example.com> SIZE=100\r\n", 555)
+	sendGroup(t, conn, text, "MAIL FROM:<a@example.com> BODY=8BITMIME\r\n", 555)
+	sendGroup(t, conn, text, "MAIL FROM:<a@example.com>\r\n", 250)
+}
+
+// startTLS sends STARTTLS on conn and returns the TLS connection.
+func startTLS(t *testing.T, conn net.Conn, text *textproto.Conn) (net.Conn, *textproto.Conn) {
+	t.Helper()
+
+	sendGroup(t, conn, text, "STARTTLS\r\n", 220)
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	return tlsConn, textproto.NewConn(tlsConn)
+}
+
+func TestExtensionProfileAfterSTARTTLS(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(filepath.Join(dir, "mail"))
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	certFile, keyFile := writeTestCert(t, dir, "sink", x509.ExtKeyUsageServerAuth)
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.TLS = config.SMTPTLSConfig{CertFile: certFile, KeyFile: keyFile}
+	cfg.Extensions = config.SMTPExtensionsConfig{
+		Advertise:      []string{"STARTTLS", "AUTH", "SIZE", "REQUIRETLS"},
+		AuthMechanisms: []string{"LOGIN"},
+	}
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		t.Fatalf("reading greeting failed: %v", err)
+	}
+
+	sendGroup(t, conn, text, "EHLO client.example.com\r\n")
+	if _, msg, err := text.ReadResponse(250); err != nil || msg != "Hello client.example.com\nSTARTTLS\nAUTH LOGIN\nSIZE 1048576" {
+		t.Fatalf("EHLO reply = %q (%v), want STARTTLS without REQUIRETLS", msg, err)
+	}
+
+	conn, text = startTLS(t, conn, text)
+	sendGroup(t, conn, text, "MAIL FROM:<a@example.com>\r\n", 502)
+
+	// The profile still applies to the EHLO sent over TLS
+	sendGroup(t, conn, text, "EHLO client.example.com\r\n")
+	if _, msg, err := text.ReadResponse(250); err != nil || msg != "Hello client.example.com\nAUTH LOGIN\nSIZE 1048576\nREQUIRETLS" {
+		t.Fatalf("EHLO reply over TLS = %q (%v), want the configured extensions without STARTTLS", msg, err)
+	}
+	sendGroup(t, conn, text, "STARTTLS\r\n", 502)
+	sendGroup(t, conn, text, "BDAT 6 LAST\r\nNOOP\r\n", 502)
+	sendGroup(t, conn, text, "NOOP\r\n", 250)
+	sendGroup(t, conn, text, "AUTH PLAIN\r\n", 504)
+
+	sendGroup(t, conn, text, "MAIL FROM:<a@example.com> REQUIRETLS\r\nRCPT TO:<b@example.org>\r\nDATA\r\n", 250, 250, 354)
+	sendGroup(t, conn, text, "Subject: secure\r\n\r\nhi\r\n.\r\n", 250)
+
+	if sessions := server.Sessions(); len(sessions) != 1 || sessions[0].TLS == nil {
+		t.Errorf("Sessions() = %+v, want the TLS session only", sessions)
+	}
+	emails, err := emailStorage.List(storage.ListFilter{User: "b"})
+	if err != nil || len(emails) != 1 || emails[0].Metadata.TLS == nil {
+		t.Fatalf("List() = %+v, %v; want one email with TLS details", emails, err)
+	}
+}
@@ -0,0 +1,126 @@
+package smtp
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/metrics"
+)
+
+// Reasons of header section rejections, the labels of their metric.
+const (
+	headerLimitFields = "fields"
+	headerLimitBytes  = "bytes"
+)
+
+// errTooManyHeaderFields is returned when the header section has more fields than allowed.
+var errTooManyHeaderFields = &smtp.SMTPError{
+	Code:         552,
+	EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+	Message:      "Too many header fields",
+}
+
+// errHeaderTooLarge is returned when the header section exceeds the size limit.
+var errHeaderTooLarge = &smtp.SMTPError{
+	Code:         552,
+	EnhancedCode: smtp.EnhancedCode{5, 3, 4},
+	Message:      "Message header size exceeds limit",
+}
+
+// headerLimiter passes the email content through, failing as soon as its
+// header section, up to the first empty line, has more fields or bytes
+// than allowed, before the content reaches the spool and the parser. Folded
+// continuation lines count toward the size but not as fields.
+type headerLimiter struct {
+	reader    io.Reader
+	maxFields int   // 0 for no limit
+	maxBytes  int64 // 0 for no limit
+
+	fields    int
+	bytes     int64
+	lineBytes int  // Bytes of the current line, without CR and LF
+	done      bool // The header section ended
+	exceeded  string
+}
+
+// newHeaderLimiter limits the header section of the content read from reader.
+func newHeaderLimiter(reader io.Reader, maxFields int, maxBytes int64) *headerLimiter {
+	return &headerLimiter{
+		reader:    reader,
+		maxFields: maxFields,
+		maxBytes:  maxBytes,
+		done:      maxFields <= 0 && maxBytes <= 0,
+	}
+}
+
+// Read implements io.Reader.
+func (limiter *headerLimiter) Read(p []byte) (int, error) {
+	n, err := limiter.reader.Read(p)
+	if limiter.done {
+		return n, err
+	}
+
+	for _, b := range p[:n] {
+		limiter.bytes++
+		switch {
+		case b == '\n':
+			if limiter.lineBytes == 0 {
+				limiter.done = true
+				return n, err
+			}
+			limiter.lineBytes = 0
+		case b == '\r':
+		default:
+			if limiter.lineBytes == 0 && b != ' ' && b != '\t' {
+				limiter.fields++
+			}
+			limiter.lineBytes++
+		}
+
+		if limiter.maxFields > 0 && limiter.fields > limiter.maxFields {
+			limiter.exceeded = headerLimitFields
+			return 0, errTooManyHeaderFields
+		}
+		if limiter.maxBytes > 0 && limiter.bytes > limiter.maxBytes {
+			limiter.exceeded = headerLimitBytes
+			return 0, errHeaderTooLarge
+		}
+	}
+	return n, err
+}
+
+// headerRejections counts the emails refused for their header section.
+type headerRejections struct {
+	fields atomic.Int64
+	bytes  atomic.Int64
+}
+
+// add counts a rejection for reason.
+func (rejections *headerRejections) add(reason string) {
+	switch reason {
+	case headerLimitFields:
+		rejections.fields.Add(1)
+	case headerLimitBytes:
+		rejections.bytes.Add(1)
+	}
+}
+
+// Collect returns the header rejection and OUT copy failure metrics.
+func (server *Server) Collect() []metrics.Family {
+	rejections := &server.backend.headerRejections
+	return []metrics.Family{{
+		Name: "gargantua_smtp_header_rejections_total",
+		Help: "Emails rejected with 552 because their header section exceeded smtp.max_header_fields or smtp.max_header_bytes.",
+		Type: metrics.Counter,
+		Samples: []metrics.Sample{
+			{Labels: map[string]string{"reason": headerLimitFields}, Value: float64(rejections.fields.Load())},
+			{Labels: map[string]string{"reason": headerLimitBytes}, Value: float64(rejections.bytes.Load())},
+		},
+	}, {
+		Name:    "gargantua_outgoing_storage_failures_total",
+		Help:    "Sender OUT copies that could not be stored; the email is still accepted for its recipients.",
+		Type:    metrics.Counter,
+		Samples: []metrics.Sample{{Value: float64(server.backend.outgoingFailures.Load())}},
+	}}
+}
@@ -0,0 +1,88 @@
+package smtp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestHeaderLimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		maxFields int
+		maxBytes  int64
+		want      error
+	}{
+		{name: "within", content: "From: a\r\nTo: b\r\n\r\nbody\r\n", maxFields: 2, maxBytes: 100},
+		{name: "too_many_fields", content: "From: a\r\nTo: b\r\nSubject: c\r\n\r\nbody\r\n", maxFields: 2, want: errTooManyHeaderFields},
+		{name: "folded_lines_are_one_field", content: "Subject: a\r\n b\r\n\tc\r\nTo: d\r\n\r\nbody\r\n", maxFields: 2},
+		{name: "too_large", content: "Subject: " + strings.Repeat("x", 100) + "\r\n\r\nbody\r\n", maxBytes: 64, want: errHeaderTooLarge},
+		{name: "body_not_counted", content: "To: b\r\n\r\n" + strings.Repeat("X: y\r\n", 100), maxFields: 2, maxBytes: 64},
+		{name: "bare_lf", content: "From: a\nTo: b\nSubject: c\n\nbody\n", maxFields: 2, want: errTooManyHeaderFields},
+		{name: "no_limits", content: strings.Repeat("X: y\r\n", 10000) + "\r\n", maxFields: 0, maxBytes: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One byte at a time, so the limits hold across reads
+			limiter := newHeaderLimiter(iotest.OneByteReader(strings.NewReader(tt.content)), tt.maxFields, tt.maxBytes)
+			content, err := io.ReadAll(limiter)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("reading error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && string(content) != tt.content {
+				t.Errorf("content = %q, want it unchanged", content)
+			}
+		})
+	}
+}
+
+func TestHeaderLimits(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.MaxHeaderFields = 50
+	cfg.MaxHeaderBytes = 4096
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	bombs := []string{
+		strings.Repeat("X-Bomb: 1\r\n", 51) + "\r\nbody\r\n",
+		strings.Repeat("X-Bomb: "+strings.Repeat("x", 300)+"\r\n", 20) + "\r\nbody\r\n",
+	}
+	for _, bomb := range bombs {
+		if err := sendTestEmail(addr, "fuzz@example.com", "qa@example.org", []byte(bomb)); err == nil || !strings.Contains(err.Error(), "552") {
+			t.Errorf("sending header bomb error = %v, want 552", err)
+		}
+	}
+	if err := sendTestEmail(addr, "app@example.com", "qa@example.org", []byte("Subject: fine\r\n\r\nbody\r\n")); err != nil {
+		t.Fatalf("sending email failed: %v", err)
+	}
+
+	emails, err := emailStorage.List(storage.ListFilter{Domain: "example.org"})
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("listing emails = %d, %v; want only the valid one", len(emails), err)
+	}
+	samples := server.Collect()[0].Samples
+	if len(samples) != 2 || samples[0].Value != 1 || samples[1].Value != 1 {
+		t.Errorf("header rejection samples = %+v, want one per reason", samples)
+	}
+}
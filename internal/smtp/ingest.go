@@ -0,0 +1,125 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/shadow"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// storeMiddleware writes the sender's OUT copy and one IN copy per recipient,
+// recording them in the delivery for the notify stage. Messages reaching a
+// size route go to its storage. A failed IN copy marks the recipient domain
+// unhealthy and rejects the email with 452, removing the copies already
+// written so the retry does not duplicate them; a failed OUT copy, whose
+// sender domain is not ours, is only counted. In honeypot mode failures are
+// logged and the email is still accepted.
+func storeMiddleware(bkd *Backend) pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			msg := delivery.Message
+
+			// Extract domain and user from sender
+			senderDomain, senderUser := parseEmailAddress(msg.Envelope.From)
+
+			// Store email in sender's OUT directory
+			subject := fmt.Sprintf("to-%s", msg.Envelope.To[0]) // Use first recipient for subject
+			senderStorage := bkd.routes.storageFor(msg.Size, bkd.storage)
+			if id, err := senderStorage.StoreMessage(storage.Outgoing, senderDomain, senderUser, subject, msg); err != nil {
+				log.Printf("Error storing outgoing email for sender %s: %v", msg.Envelope.From, err)
+				bkd.outgoingFailures.Add(1)
+			} else {
+				delivery.Stored = append(delivery.Stored, pipeline.StoredCopy{Storage: senderStorage, ID: id, Direction: storage.Outgoing})
+			}
+
+			// Store email for each recipient in their IN directory
+			for _, recipient := range msg.Envelope.To {
+				domain, user := parseEmailAddress(recipient)
+				subject := fmt.Sprintf("from-%s", msg.Envelope.From)
+
+				recipientStorage, ok := bkd.storageFor(domain)
+				if !ok {
+					log.Printf("Dropping email for recipient %s: domain %s was removed during the session", recipient, domain)
+					continue
+				}
+				recipientStorage = bkd.routes.storageFor(msg.Size, recipientStorage)
+				id, err := recipientStorage.StoreMessage(storage.Incoming, domain, user, subject, msg)
+				if err != nil {
+					log.Printf("Error storing email for recipient %s: %v", recipient, err)
+					bkd.health.Fail(domain, err)
+					if bkd.honeypot {
+						continue
+					}
+					removeCopies(delivery.Stored)
+					delivery.Stored = nil
+					return errStorageUnavailable
+				}
+				bkd.health.Succeed(domain)
+				delivery.Stored = append(delivery.Stored, pipeline.StoredCopy{Storage: recipientStorage, ID: id, Direction: storage.Incoming})
+			}
+
+			// Let the client retry when no copy could be written at all
+			if len(delivery.Stored) == 0 && !bkd.honeypot {
+				return errStorageUnavailable
+			}
+
+			return next(ctx, delivery)
+		}
+	}
+}
+
+// removeCopies deletes the copies of an email rejected after they were stored.
+func removeCopies(stored []pipeline.StoredCopy) {
+	for _, storedCopy := range stored {
+		if err := storedCopy.Storage.Delete(storedCopy.ID); err != nil {
+			log.Printf("Error removing copy %s of a rejected email: %v", storedCopy.ID, err)
+		}
+	}
+}
+
+// shadowMiddleware mirrors stored emails selected by the mirror in the background.
+func shadowMiddleware(mirror *shadow.Mirror) pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			if err := next(ctx, delivery); err != nil {
+				return err
+			}
+
+			if mirror.Select(delivery.Message) {
+				stored := append([]pipeline.StoredCopy(nil), delivery.Stored...)
+				release := message.Retain(delivery.Message.Body)
+				queued := mirror.Go(delivery.Message, func(result shadow.Result) {
+					defer release()
+					recordShadowResult(stored, result)
+				})
+				if !queued {
+					release()
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// recordShadowResult saves the shadow delivery outcome in the metadata of every stored copy.
+func recordShadowResult(stored []pipeline.StoredCopy, result shadow.Result) {
+	slog.Debug("Shadow delivery finished", "target", result.Target, "accepted", result.Accepted,
+		"duration", result.Duration, "error", result.Error)
+
+	delivery := &storage.ShadowDelivery{
+		Target:     result.Target,
+		Accepted:   result.Accepted,
+		DurationMS: result.Duration.Milliseconds(),
+		Error:      result.Error,
+		At:         result.At,
+	}
+	pipeline.UpdateStored(stored, func(metadata *storage.Metadata) {
+		metadata.Shadow = delivery
+		metadata.Mark(message.StageShadow, result.Target, result.At.Add(result.Duration))
+	})
+}
@@ -0,0 +1,315 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxViolations bounds the distinct violations recorded per connection.
+const maxViolations = 20
+
+// greeting stands for the server banner in the commands awaiting a reply.
+const greeting = "greeting"
+
+// groupable lists the commands that may be followed by others before their
+// reply arrives (RFC 2920 section 3.1). "." ends message content.
+var groupable = map[string]bool{
+	"RSET": true, "MAIL": true, "RCPT": true, "BDAT": true, ".": true,
+	"SEND": true, "SOML": true, "SAML": true,
+}
+
+// PipeliningReport describes how a client used PIPELINING (RFC 2920) on
+// one connection. A command counts as pipelined when it was received
+// before the reply to an earlier one was sent.
+type PipeliningReport struct {
+	Advertised bool     `json:"advertised"` // PIPELINING was offered in the last EHLO reply
+	Commands   int      `json:"commands"`
+	Pipelined  int      `json:"pipelined"`
+	MaxDepth   int      `json:"max_depth"` // Most commands awaiting a reply at once
+	Violations []string `json:"violations,omitempty"`
+}
+
+// pipeliningListener wraps the accepted connections in a pipeliningConn.
+type pipeliningListener struct {
+	net.Listener
+}
+
+// Accept waits for the next connection and wraps it.
+func (listener pipeliningListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &pipeliningConn{Conn: conn, pending: []string{greeting}}, nil
+}
+
+// pipeliningConn follows the commands of the client and the replies of the
+// server on a connection to check the client waits for replies where RFC
+// 2920 requires it. It sits below the tap, so it sees the bytes as they
+// arrive from the network: commands received along with an earlier one are
+// detected, while a client that does not wait but whose commands arrive
+// separately may go unnoticed. After STARTTLS, the tap performs the
+// handshake and the decrypted stream is followed by a pipeliningTLSConn.
+type pipeliningConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	report    PipeliningReport
+	clientBuf []byte   // Partial client line
+	serverBuf []byte   // Partial server line
+	pending   []string // Commands awaiting a reply, oldest first
+	offered   bool     // The EHLO reply being written lists PIPELINING
+	data      bool     // Receiving message content
+	earlyData bool     // Content was sent before the reply to DATA
+	auth      bool     // The next client line answers an AUTH challenge
+	chunkLeft int64    // Bytes of the current BDAT chunk still to come
+	encrypted bool     // After STARTTLS, the network bytes cannot be followed
+}
+
+// Read receives client bytes, following the commands.
+func (conn *pipeliningConn) Read(p []byte) (int, error) {
+	n, err := conn.Conn.Read(p)
+	conn.mu.Lock()
+	if !conn.encrypted {
+		conn.observeClient(p[:n])
+	}
+	conn.mu.Unlock()
+	return n, err
+}
+
+// Write sends server replies, recording them before the client can see
+// them.
+func (conn *pipeliningConn) Write(p []byte) (int, error) {
+	conn.mu.Lock()
+	if !conn.encrypted {
+		conn.observeServer(p)
+	}
+	conn.mu.Unlock()
+	return conn.Conn.Write(p)
+}
+
+// decrypted returns tlsConn, established over conn after STARTTLS, wrapped
+// to follow the decrypted commands and replies in the same report.
+func (conn *pipeliningConn) decrypted(tlsConn net.Conn) net.Conn {
+	return &pipeliningTLSConn{Conn: tlsConn, recorder: conn}
+}
+
+// pipeliningTLSConn follows the decrypted stream of a connection after
+// STARTTLS for the pipeliningConn below it.
+type pipeliningTLSConn struct {
+	net.Conn
+	recorder *pipeliningConn
+}
+
+// Read receives decrypted client bytes, following the commands.
+func (conn *pipeliningTLSConn) Read(p []byte) (int, error) {
+	n, err := conn.Conn.Read(p)
+	conn.recorder.mu.Lock()
+	conn.recorder.observeClient(p[:n])
+	conn.recorder.mu.Unlock()
+	return n, err
+}
+
+// Write records server replies and encrypts them.
+func (conn *pipeliningTLSConn) Write(p []byte) (int, error) {
+	conn.recorder.mu.Lock()
+	conn.recorder.observeServer(p)
+	conn.recorder.mu.Unlock()
+	return conn.Conn.Write(p)
+}
+
+// Report returns a snapshot of the report of the connection.
+func (conn *pipeliningConn) Report() *PipeliningReport {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	report := conn.report
+	report.Violations = slices.Clone(conn.report.Violations)
+	return &report
+}
+
+// observeClient splits client bytes into lines, skipping BDAT chunks.
+func (conn *pipeliningConn) observeClient(p []byte) {
+	for len(p) > 0 {
+		if conn.chunkLeft > 0 {
+			n := min(int64(len(p)), conn.chunkLeft)
+			p = p[n:]
+			conn.chunkLeft -= n
+			continue
+		}
+
+		end := bytes.IndexByte(p, '\n')
+		if end < 0 {
+			if len(conn.clientBuf) < maxTapLine {
+				conn.clientBuf = append(conn.clientBuf, p...)
+			}
+			return
+		}
+		line := string(append(conn.clientBuf, p[:end]...))
+		conn.clientBuf = conn.clientBuf[:0]
+		p = p[end+1:]
+		conn.clientLine(strings.TrimSuffix(line, "\r"))
+	}
+}
+
+// clientLine follows one line sent by the client.
+func (conn *pipeliningConn) clientLine(line string) {
+	if !conn.data && len(conn.pending) > 0 && conn.pending[len(conn.pending)-1] == "DATA" {
+		conn.violation("message content sent before the 354 reply to DATA")
+		conn.data, conn.earlyData = true, true
+	}
+	if conn.data {
+		if line == "." {
+			conn.data = false
+			conn.expect(".")
+		}
+		return
+	}
+	if conn.auth {
+		conn.auth = false
+		conn.expect("AUTH")
+		return
+	}
+
+	verb, arg, _ := strings.Cut(line, " ")
+	verb = strings.ToUpper(verb)
+	conn.report.Commands++
+	if len(conn.pending) > 0 {
+		conn.report.Pipelined++
+		conn.checkGroup(verb)
+	}
+	conn.expect(verb)
+
+	if verb == "BDAT" {
+		if fields := strings.Fields(arg); len(fields) > 0 {
+			if size, err := strconv.ParseInt(fields[0], 10, 64); err == nil && size > 0 {
+				conn.chunkLeft = size
+			}
+		}
+	}
+}
+
+// checkGroup records a violation when verb was sent while a command that
+// must end a group awaits its reply, or without PIPELINING offered.
+func (conn *pipeliningConn) checkGroup(verb string) {
+	for _, waiting := range conn.pending {
+		switch {
+		case waiting == greeting:
+			conn.violation(verb + " sent before the greeting")
+			return
+		case !groupable[waiting]:
+			conn.violation(fmt.Sprintf("%s sent before the reply to %s", verb, waiting))
+			return
+		}
+	}
+	if !conn.report.Advertised {
+		conn.violation(verb + " pipelined without PIPELINING advertised")
+	}
+}
+
+// expect records that command awaits a reply.
+func (conn *pipeliningConn) expect(command string) {
+	conn.pending = append(conn.pending, command)
+	conn.report.MaxDepth = max(conn.report.MaxDepth, len(conn.pending))
+}
+
+// violation records a distinct protocol violation.
+func (conn *pipeliningConn) violation(description string) {
+	if len(conn.report.Violations) >= maxViolations || slices.Contains(conn.report.Violations, description) {
+		return
+	}
+	conn.report.Violations = append(conn.report.Violations, description)
+	slog.Warn("PIPELINING violation", "remote", conn.RemoteAddr().String(), "violation", description)
+}
+
+// observeServer splits server bytes into reply lines.
+func (conn *pipeliningConn) observeServer(p []byte) {
+	for len(p) > 0 {
+		end := bytes.IndexByte(p, '\n')
+		if end < 0 {
+			conn.serverBuf = append(conn.serverBuf, p...)
+			return
+		}
+		line := string(append(conn.serverBuf, p[:end]...))
+		conn.serverBuf = conn.serverBuf[:0]
+		p = p[end+1:]
+
+		line = strings.TrimSuffix(line, "\r")
+		if len(line) < 3 {
+			continue
+		}
+		if len(line) > 4 && strings.EqualFold(strings.TrimSpace(line[4:]), "PIPELINING") {
+			conn.offered = true
+		}
+		if len(line) > 3 && line[3] == '-' {
+			continue
+		}
+		conn.reply(line[:3])
+	}
+}
+
+// reply follows the final line of a reply with code, which answers the
+// oldest command awaiting one.
+func (conn *pipeliningConn) reply(code string) {
+	offered := conn.offered
+	conn.offered = false
+	if len(conn.pending) == 0 {
+		return // Unsolicited, e.g. 421 on shutdown
+	}
+	command := conn.pending[0]
+	conn.pending = conn.pending[1:]
+
+	switch command {
+	case "EHLO":
+		if code == "250" {
+			conn.report.Advertised = offered
+		}
+	case "HELO":
+		if code == "250" {
+			conn.report.Advertised = false
+		}
+	case "DATA":
+		if code != "354" {
+			conn.data = false
+		} else if !conn.earlyData {
+			conn.data = true
+		}
+		conn.earlyData = false
+	case "STARTTLS":
+		if code == "220" {
+			// The client starts over with EHLO on the encrypted stream
+			conn.encrypted, conn.pending, conn.report.Advertised = true, nil, false
+			conn.clientBuf, conn.serverBuf = conn.clientBuf[:0], conn.serverBuf[:0]
+		}
+	case "AUTH":
+		conn.auth = code == "334"
+	}
+}
+
+// pipeliningRecorder returns the pipeliningConn under conn, or nil when
+// the diagnostics are disabled.
+func pipeliningRecorder(conn net.Conn) *pipeliningConn {
+	for {
+		switch wrapped := conn.(type) {
+		case *pipeliningConn:
+			return wrapped
+		case *pipeliningTLSConn:
+			return wrapped.recorder
+		case *tapConn:
+			conn = wrapped.Conn
+		case *captureConn:
+			conn = wrapped.Conn
+		case *tls.Conn:
+			conn = wrapped.NetConn()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package smtp
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// sendGroup writes commands in a single packet and checks the codes of
+// their replies.
+func sendGroup(t *testing.T, conn net.Conn, text *textproto.Conn, commands string, want ...int) {
+	t.Helper()
+
+	if _, err := conn.Write([]byte(commands)); err != nil {
+		t.Fatalf("writing commands failed: %v", err)
+	}
+	for _, code := range want {
+		got, msg, err := text.ReadResponse(0)
+		if got != code {
+			t.Fatalf("reply to %q = %d %s (%v), want %d", commands, got, msg, err, code)
+		}
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.DiagnosePipelining = true
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	tests := []struct {
+		name           string
+		groups         []string
+		replies        [][]int
+		wantPipelined  int
+		wantDepth      int
+		wantViolations []string
+	}{
+		{
+			name: "compliant",
+			groups: []string{
+				"EHLO client\r\n",
+				"MAIL FROM:<a@example.com>\r\nRCPT TO:<b@example.com>\r\nRCPT TO:<c@example.com>\r\nDATA\r\n",
+				"Subject: one\r\n\r\nbody\r\n.\r\nRSET\r\nMAIL FROM:<a@example.com>\r\nRCPT TO:<b@example.com>\r\nDATA\r\n",
+				"Subject: two\r\n\r\nbody\r\n.\r\nQUIT\r\n",
+			},
+			replies:       [][]int{{250}, {250, 250, 250, 354}, {250, 250, 250, 250, 354}, {250, 221}},
+			wantPipelined: 8,
+			wantDepth:     5,
+		},
+		{
+			name: "violations",
+			groups: []string{
+				"EHLO client\r\nMAIL FROM:<a@example.com>\r\n",
+				"RCPT TO:<b@example.com>\r\nDATA\r\nSubject: early\r\n\r\nbody\r\n.\r\n",
+				"NOOP\r\nQUIT\r\n",
+			},
+			replies:       [][]int{{250, 250}, {250, 354, 250}, {250, 221}},
+			wantPipelined: 3,
+			wantDepth:     3,
+			wantViolations: []string{
+				"MAIL sent before the reply to EHLO",
+				"message content sent before the 354 reply to DATA",
+				"QUIT sent before the reply to NOOP",
+			},
+		},
+		{
+			name: "not_advertised",
+			groups: []string{
+				"HELO client\r\n",
+				"MAIL FROM:<a@example.com>\r\nRCPT TO:<b@example.com>\r\n",
+				"QUIT\r\n",
+			},
+			replies:        [][]int{{250}, {250, 250}, {221}},
+			wantPipelined:  1,
+			wantDepth:      2,
+			wantViolations: []string{"RCPT pipelined without PIPELINING advertised"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finished := len(server.ClosedSessions())
+			conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+			if err != nil {
+				t.Fatalf("dial failed: %v", err)
+			}
+			defer conn.Close()
+			text := textproto.NewConn(conn)
+			if _, _, err := text.ReadResponse(220); err != nil {
+				t.Fatalf("reading greeting failed: %v", err)
+			}
+
+			for i, group := range tt.groups {
+				sendGroup(t, conn, text, group, tt.replies[i]...)
+			}
+			conn.Close()
+
+			var report *PipeliningReport
+			for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if closed := server.ClosedSessions(); len(closed) > finished && closed[0].Pipelining != nil {
+					report = closed[0].Pipelining
+					break
+				}
+			}
+			if report == nil {
+				t.Fatal("no finished session with a pipelining report")
+			}
+			if report.Pipelined != tt.wantPipelined || report.MaxDepth != tt.wantDepth {
+				t.Errorf("report = %+v, want %d pipelined at depth %d", report, tt.wantPipelined, tt.wantDepth)
+			}
+			if !slices.Equal(report.Violations, tt.wantViolations) {
+				t.Errorf("violations = %q, want %q", report.Violations, tt.wantViolations)
+			}
+		})
+	}
+}
+
+func TestPipeliningAfterSTARTTLS(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(filepath.Join(dir, "mail"))
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	certFile, keyFile := writeTestCert(t, dir, "sink", x509.ExtKeyUsageServerAuth)
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.DiagnosePipelining = true
+	cfg.TLS = config.SMTPTLSConfig{CertFile: certFile, KeyFile: keyFile}
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		t.Fatalf("reading greeting failed: %v", err)
+	}
+	sendGroup(t, conn, text, "EHLO client\r\n", 250)
+	tlsConn, tlsText := startTLS(t, conn, text)
+
+	// The commands sent over TLS are followed like clear ones
+	sendGroup(t, tlsConn, tlsText, "EHLO client\r\nMAIL FROM:<a@example.com>\r\n", 250, 250)
+	sendGroup(t, tlsConn, tlsText, "RCPT TO:<b@example.com>\r\nDATA\r\n", 250, 354)
+	sendGroup(t, tlsConn, tlsText, "Subject: secure\r\n\r\nbody\r\n.\r\nQUIT\r\n", 250, 221)
+	tlsConn.Close()
+
+	var report *PipeliningReport
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if closed := server.ClosedSessions(); len(closed) > 0 && closed[0].Pipelining != nil {
+			report = closed[0].Pipelining
+			break
+		}
+	}
+	if report == nil {
+		t.Fatal("no finished session with a pipelining report")
+	}
+	if report.Pipelined != 3 || !report.Advertised {
+		t.Errorf("report = %+v, want 3 pipelined commands with PIPELINING advertised", report)
+	}
+	want := []string{"MAIL sent before the reply to EHLO"}
+	if !slices.Equal(report.Violations, want) {
+		t.Errorf("violations = %q, want %q", report.Violations, want)
+	}
+}
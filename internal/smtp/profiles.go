@@ -0,0 +1,169 @@
+package smtp
+
+import (
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+)
+
+// providerProfile emulates what clients observe of a mail provider. Texts
+// may hold {host}, the local host name, {ip}, the client address, {date},
+// {time}, a Unix timestamp, {id}, a random queue ID, and {num}, a random
+// number.
+type providerProfile struct {
+	greeting string // Banner, after the 220 code
+	hello    string // First line of the EHLO reply, after the 250 code
+
+	extensions []string // EHLO keywords in order; STARTTLS needs a certificate
+	mechanisms []string // SASL mechanisms of AUTH
+
+	maxMessageBytes int64
+	maxRecipients   int
+
+	// messagesPerConnection is the number of transactions after which MAIL
+	// gets the throttled reply and the connection is closed, 0 for no limit
+	messagesPerConnection int
+	throttled             string
+
+	// replies replace the text of single-line replies, by the command they
+	// answer and their code; "." is the end of DATA content
+	replies map[string]string
+}
+
+// providerProfiles are the profiles selectable with smtp.profile, named in
+// config.SMTPProfiles.
+var providerProfiles = map[string]providerProfile{
+	"gmail-like": {
+		greeting:              "{host} ESMTP {id} - gsmtp",
+		hello:                 "{host} at your service, [{ip}]",
+		extensions:            []string{"SIZE", "8BITMIME", "STARTTLS", "AUTH", "ENHANCEDSTATUSCODES", "PIPELINING", "CHUNKING", "SMTPUTF8"},
+		mechanisms:            []string{"LOGIN", "PLAIN"},
+		maxMessageBytes:       35882577,
+		maxRecipients:         100,
+		messagesPerConnection: 100,
+		throttled:             "421 4.7.0 Try again later, closing connection. {id} - gsmtp",
+		replies: map[string]string{
+			"MAIL 250": "2.1.0 OK {id} - gsmtp",
+			"RCPT 250": "2.1.5 OK {id} - gsmtp",
+			"RCPT 452": "4.5.3 Your message has too many recipients. {id} - gsmtp",
+			"RCPT 550": "5.1.1 The email account that you tried to reach does not exist. {id} - gsmtp",
+			"DATA 354": "Go ahead {id} - gsmtp",
+			". 250":    "2.0.0 OK  {time} {id} - gsmtp",
+			"BDAT 250": "2.0.0 OK  {time} {id} - gsmtp",
+			"RSET 250": "2.1.5 Flushed {id} - gsmtp",
+			"NOOP 250": "2.0.0 OK {id} - gsmtp",
+			"QUIT 221": "2.0.0 closing connection {id} - gsmtp",
+		},
+	},
+	"office365-like": {
+		greeting:              "{host} Microsoft ESMTP MAIL Service ready at {date}",
+		hello:                 "{host} Hello [{ip}]",
+		extensions:            []string{"SIZE", "PIPELINING", "DSN", "ENHANCEDSTATUSCODES", "STARTTLS", "AUTH", "8BITMIME", "BINARYMIME", "CHUNKING", "SMTPUTF8"},
+		mechanisms:            []string{"LOGIN"},
+		maxMessageBytes:       36700160,
+		maxRecipients:         500,
+		messagesPerConnection: 30,
+		throttled:             "421 4.4.2 Message submission rate for this client has exceeded the configured limit",
+		replies: map[string]string{
+			"MAIL 250": "2.1.0 Sender OK",
+			"RCPT 250": "2.1.5 Recipient OK",
+			"RCPT 452": "4.5.3 Too many recipients",
+			"RCPT 550": "5.4.1 Recipient address rejected: Access denied",
+			"DATA 354": "Start mail input; end with <CRLF>.<CRLF>",
+			". 250":    "2.6.0 <{id}@{host}> [InternalId={num}] Queued mail for delivery",
+			"BDAT 250": "2.6.0 <{id}@{host}> [InternalId={num}] Queued mail for delivery",
+			"RSET 250": "2.0.0 Resetting",
+			"NOOP 250": "2.0.0 OK",
+			"QUIT 221": "2.0.0 Service closing transmission channel",
+		},
+	},
+	"strict-rfc": {
+		greeting:        "{host} ESMTP",
+		hello:           "{host}",
+		extensions:      []string{"SIZE", "8BITMIME", "PIPELINING", "ENHANCEDSTATUSCODES", "STARTTLS"},
+		maxMessageBytes: 10 * 1024 * 1024,
+		maxRecipients:   100, // The minimum RFC 5321 section 4.5.3.1.8 requires
+		replies: map[string]string{
+			"MAIL 250": "2.1.0 OK",
+			"RCPT 250": "2.1.5 OK",
+			"RCPT 452": "4.5.3 Too many recipients",
+			"DATA 354": "Start mail input; end with <CRLF>.<CRLF>",
+			". 250":    "2.0.0 OK",
+			"BDAT 250": "2.0.0 OK",
+			"RSET 250": "2.0.0 OK",
+			"NOOP 250": "2.0.0 OK",
+			"QUIT 221": "2.0.0 {host} Service closing transmission channel",
+		},
+	},
+}
+
+// applyProfile returns cfg with the limits and extensions of its provider
+// profile, and the profile, nil when none is selected.
+func applyProfile(cfg config.SMTPConfig) (config.SMTPConfig, *providerProfile) {
+	profile, ok := providerProfiles[cfg.Profile]
+	if !ok {
+		return cfg, nil
+	}
+
+	cfg.MaxMessageBytes = profile.maxMessageBytes
+	cfg.MaxRecipients = profile.maxRecipients
+	cfg.Extensions = config.SMTPExtensionsConfig{AuthMechanisms: profile.mechanisms}
+	for _, keyword := range profile.extensions {
+		if keyword == "STARTTLS" && cfg.TLS.CertFile == "" {
+			continue
+		}
+		cfg.Extensions.Advertise = append(cfg.Extensions.Advertise, keyword)
+	}
+	return cfg, &profile
+}
+
+// expand fills the placeholders of text for a client at ip.
+func (profile *providerProfile) expand(text, ip string) string {
+	if !strings.Contains(text, "{") {
+		return text
+	}
+	now := time.Now()
+	return strings.NewReplacer(
+		"{host}", localHost(),
+		"{ip}", ip,
+		"{date}", now.Format(time.RFC1123Z),
+		"{time}", strconv.FormatInt(now.Unix(), 10),
+		"{id}", queueID(),
+		"{num}", strconv.Itoa(rand.IntN(1000000)),
+	).Replace(text)
+}
+
+// reword returns the reply line, without CRLF, replacing the text of a
+// reply with code to command.
+func (profile *providerProfile) reword(command, line, ip string) string {
+	if len(line) < 4 || line[3] != ' ' {
+		return line
+	}
+	text, ok := profile.replies[command+" "+line[:3]]
+	if !ok {
+		return line
+	}
+	return line[:4] + profile.expand(text, ip)
+}
+
+// localHost returns the host name announced by the profiles.
+func localHost() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "localhost"
+}
+
+// queueID returns a random identifier in the style of provider queue IDs.
+func queueID() string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	id := make([]byte, 12)
+	for i := range id {
+		id[i] = alphabet[rand.IntN(len(alphabet))]
+	}
+	return string(id)
+}
@@ -0,0 +1,157 @@
+package smtp
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestProviderProfilesExist(t *testing.T) {
+	for _, name := range config.SMTPProfiles {
+		if _, ok := providerProfiles[name]; !ok {
+			t.Errorf("profile %s has no provider profile", name)
+		}
+	}
+	if len(providerProfiles) != len(config.SMTPProfiles) {
+		t.Errorf("provider profiles = %d, config lists %d", len(providerProfiles), len(config.SMTPProfiles))
+	}
+}
+
+func TestProviderProfile(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.Profile = "office365-like"
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+
+	_, banner, err := text.ReadResponse(220)
+	if err != nil || !strings.Contains(banner, " Microsoft ESMTP MAIL Service ready at ") {
+		t.Fatalf("banner = %q (%v), want the office365-like one", banner, err)
+	}
+
+	sendGroup(t, conn, text, "EHLO client.example.com\r\n")
+	_, ehlo, err := text.ReadResponse(250)
+	lines := strings.Split(ehlo, "\n")
+	if err != nil || !strings.HasSuffix(lines[0], " Hello [127.0.0.1]") || lines[1] != "SIZE 36700160" || strings.Contains(ehlo, "STARTTLS") {
+		t.Fatalf("EHLO reply = %q (%v), want the office365-like extensions without STARTTLS", ehlo, err)
+	}
+
+	replies := []struct {
+		command string
+		code    int
+		pattern string
+	}{
+		{"MAIL FROM:<app@example.com>", 250, `^2\.1\.0 Sender OK$`},
+		{"RCPT TO:<john@example.org>", 250, `^2\.1\.5 Recipient OK$`},
+		{"DATA", 354, `^Start mail input`},
+		{"Subject: hi\r\n\r\nhi\r\n.", 250, `^2\.6\.0 <[a-z0-9]{12}@\S+> \[InternalId=\d+\] Queued mail for delivery$`},
+		{"NOOP", 250, `^2\.0\.0 OK$`},
+	}
+	for _, reply := range replies {
+		if _, err := conn.Write([]byte(reply.command + "\r\n")); err != nil {
+			t.Fatalf("writing %q failed: %v", reply.command, err)
+		}
+		code, msg, err := text.ReadResponse(reply.code)
+		if err != nil || !regexp.MustCompile(reply.pattern).MatchString(msg) {
+			t.Errorf("reply to %q = %d %q (%v), want %d %s", reply.command, code, msg, err, reply.code, reply.pattern)
+		}
+	}
+
+	// The connection is throttled after 30 transactions
+	sendGroup(t, conn, text, strings.Repeat("MAIL FROM:<app@example.com>\r\nRSET\r\n", 29), slices.Repeat([]int{250}, 58)...)
+	sendGroup(t, conn, text, "MAIL FROM:<app@example.com>\r\n", 421)
+	if _, err := text.ReadLine(); err == nil {
+		t.Error("connection still open after the throttled reply")
+	}
+}
+
+func TestProviderProfileAfterSTARTTLS(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(filepath.Join(dir, "mail"))
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	certFile, keyFile := writeTestCert(t, dir, "sink", x509.ExtKeyUsageServerAuth)
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.TLS = config.SMTPTLSConfig{CertFile: certFile, KeyFile: keyFile}
+	cfg.Profile = "office365-like"
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		t.Fatalf("reading banner failed: %v", err)
+	}
+
+	sendGroup(t, conn, text, "EHLO client.example.com\r\n")
+	if _, ehlo, err := text.ReadResponse(250); err != nil || !strings.Contains(ehlo, "\nSTARTTLS\n") {
+		t.Fatalf("EHLO reply = %q (%v), want STARTTLS", ehlo, err)
+	}
+	conn, text = startTLS(t, conn, text)
+
+	sendGroup(t, conn, text, "EHLO client.example.com\r\n")
+	_, ehlo, err := text.ReadResponse(250)
+	lines := strings.Split(ehlo, "\n")
+	if err != nil || !strings.HasSuffix(lines[0], " Hello [127.0.0.1]") || strings.Contains(ehlo, "STARTTLS") || !slices.Contains(lines, "AUTH LOGIN") || !slices.Contains(lines, "CHUNKING") {
+		t.Fatalf("EHLO reply over TLS = %q (%v), want the office365-like extensions without STARTTLS", ehlo, err)
+	}
+	sendGroup(t, conn, text, "AUTH PLAIN\r\n", 504)
+
+	if _, err := conn.Write([]byte("MAIL FROM:<app@example.com>\r\n")); err != nil {
+		t.Fatalf("writing MAIL failed: %v", err)
+	}
+	if _, msg, err := text.ReadResponse(250); err != nil || msg != "2.1.0 Sender OK" {
+		t.Errorf("reply to MAIL over TLS = %q (%v), want the office365-like wording", msg, err)
+	}
+	sendGroup(t, conn, text, "RSET\r\n", 250)
+
+	// The throttling counts the transactions made over TLS too
+	sendGroup(t, conn, text, strings.Repeat("MAIL FROM:<app@example.com>\r\nRSET\r\n", 29), slices.Repeat([]int{250}, 58)...)
+	sendGroup(t, conn, text, "MAIL FROM:<app@example.com>\r\n", 421)
+	if _, err := text.ReadLine(); err == nil {
+		t.Error("connection still open after the throttled reply")
+	}
+}
@@ -0,0 +1,34 @@
+package smtp
+
+import (
+	"sort"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// sizeRoute sends messages of at least minBytes to a dedicated storage.
+type sizeRoute struct {
+	minBytes int64
+	storage  *storage.EmailStorage
+}
+
+// sizeRoutes holds the size routes, largest threshold first.
+type sizeRoutes []sizeRoute
+
+// add registers a route, keeping the largest threshold first.
+func (routes sizeRoutes) add(route sizeRoute) sizeRoutes {
+	routes = append(routes, route)
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].minBytes > routes[j].minBytes })
+	return routes
+}
+
+// storageFor returns the storage of the largest threshold reached by size,
+// or fallback when the message is below every threshold.
+func (routes sizeRoutes) storageFor(size int64, fallback *storage.EmailStorage) *storage.EmailStorage {
+	for _, route := range routes {
+		if size >= route.minBytes {
+			return route.storage
+		}
+	}
+	return fallback
+}
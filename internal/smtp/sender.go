@@ -0,0 +1,93 @@
+package smtp
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/dns"
+)
+
+// Sender check modes; off disables the check.
+const (
+	senderCheckRecord  = "record"
+	senderCheckEnforce = "enforce"
+)
+
+// senderCheckName is the check of the verdict recorded on every email.
+const senderCheckName = "sender_domain"
+
+// senderLookupTimeout bounds the lookups of one sender domain.
+const senderLookupTimeout = 5 * time.Second
+
+// senderResult is the outcome of checking a sender domain.
+type senderResult struct {
+	result string          // pass, fail or temperror
+	detail string          // Record found or reason of the failure
+	reject *smtp.SMTPError // Reply to MAIL FROM when enforcing, nil on pass
+}
+
+// checkSenderDomain verifies that domain can receive mail: it needs an MX
+// record, or an address record acting as implicit MX (RFC 5321 section 5.1),
+// and no null MX (RFC 7505).
+func checkSenderDomain(ctx context.Context, resolver dns.Resolver, domain string) senderResult {
+	ctx, cancel := context.WithTimeout(ctx, senderLookupTimeout)
+	defer cancel()
+
+	mxs, err := resolver.LookupMX(ctx, domain)
+	switch {
+	case err == nil && len(mxs) == 1 && (mxs[0].Host == "." || mxs[0].Host == ""):
+		return senderResult{result: "fail", detail: "null MX", reject: &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 27},
+			Message:      "Sender address domain does not accept mail",
+		}}
+	case err == nil && len(mxs) > 0:
+		return senderResult{result: "pass", detail: "MX " + strings.TrimSuffix(mxs[0].Host, ".")}
+	case err != nil && !dns.IsNotFound(err):
+		return senderTempError(err)
+	}
+
+	addrs, err := resolver.LookupHost(ctx, domain)
+	switch {
+	case err == nil && len(addrs) > 0:
+		return senderResult{result: "pass", detail: "A " + addrs[0]}
+	case err != nil && !dns.IsNotFound(err):
+		return senderTempError(err)
+	}
+	return senderResult{result: "fail", detail: "no MX or A record", reject: &smtp.SMTPError{
+		Code:         550,
+		EnhancedCode: smtp.EnhancedCode{5, 1, 8},
+		Message:      "Sender address rejected: domain not found",
+	}}
+}
+
+// senderTempError is the result of a lookup that failed for a reason other
+// than the domain having no records; the sender may retry later.
+func senderTempError(err error) senderResult {
+	return senderResult{result: "temperror", detail: err.Error(), reject: &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 4, 3},
+		Message:      "Sender address domain lookup failed, try again later",
+	}}
+}
+
+// stubFixture answers the sender check from the sender_check stub, which
+// maps domains to mx, a, nullmx or tempfail.
+func stubFixture(stub map[string]string) *dns.Fixture {
+	records := make(map[string]dns.Records, len(stub))
+	for domain, answer := range stub {
+		switch answer {
+		case "mx":
+			records[domain] = dns.Records{MX: []string{"mx." + domain}, A: []string{"192.0.2.1"}}
+		case "a":
+			records[domain] = dns.Records{A: []string{"192.0.2.1"}}
+		case "nullmx":
+			records[domain] = dns.Records{MX: []string{"."}}
+		case "tempfail":
+			records[domain] = dns.Records{Error: dns.ErrorTempFail}
+		}
+	}
+	return dns.NewFixture(records)
+}
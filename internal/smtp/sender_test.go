@@ -0,0 +1,112 @@
+package smtp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestCheckSenderDomain(t *testing.T) {
+	resolver := stubFixture(map[string]string{
+		"example.com": "mx",
+		"A-Only.test": "a",
+		"nomail.test": "nullmx",
+		"flaky.test":  "tempfail",
+	})
+
+	tests := []struct {
+		domain     string
+		wantResult string
+		wantCode   int
+	}{
+		{"example.com", "pass", 0},
+		{"a-only.test", "pass", 0},
+		{"nomail.test", "fail", 550},
+		{"missing.test", "fail", 550},
+		{"flaky.test", "temperror", 451},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			result := checkSenderDomain(context.Background(), resolver, tt.domain)
+			if result.result != tt.wantResult {
+				t.Errorf("result = %s (%s), want %s", result.result, result.detail, tt.wantResult)
+			}
+			code := 0
+			if result.reject != nil {
+				code = result.reject.Code
+			}
+			if code != tt.wantCode {
+				t.Errorf("reject code = %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestSenderCheck(t *testing.T) {
+	tests := []struct {
+		mode        string
+		from        string
+		wantErr     string
+		wantVerdict string
+	}{
+		{mode: "enforce", from: "app@example.com", wantVerdict: "pass"},
+		{mode: "enforce", from: "app@missing.test", wantErr: "550: Sender address rejected"},
+		{mode: "enforce", from: "app@flaky.test", wantErr: "451: Sender address domain lookup failed"},
+		{mode: "record", from: "app@missing.test", wantVerdict: "fail"},
+		{mode: "off", from: "app@missing.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"_"+tt.from, func(t *testing.T) {
+			port, err := getFreePort()
+			if err != nil {
+				t.Fatalf("getting free port failed: %v", err)
+			}
+			emailStorage, err := storage.NewEmailStorage(t.TempDir())
+			if err != nil {
+				t.Fatalf("creating email storage failed: %v", err)
+			}
+
+			cfg := config.Default().SMTP
+			cfg.Port = port
+			cfg.SenderCheck = config.SMTPSenderCheckConfig{
+				Mode: tt.mode,
+				Stub: map[string]string{"example.com": "mx", "flaky.test": "tempfail"},
+			}
+			server := NewServerFromConfig(cfg, emailStorage)
+			go server.Start()
+			defer server.Stop()
+			time.Sleep(100 * time.Millisecond)
+
+			err = sendTestEmail(fmt.Sprintf("localhost:%d", port), tt.from, "qa@example.org", []byte("Subject: hi\r\n\r\nhi\r\n"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("sending error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sending email failed: %v", err)
+			}
+
+			emails, err := emailStorage.List(storage.ListFilter{Domain: "example.org"})
+			if err != nil || len(emails) != 1 {
+				t.Fatalf("listing emails = %d, %v; want one", len(emails), err)
+			}
+			verdicts := emails[0].Metadata.Verdicts
+			if tt.wantVerdict == "" {
+				if len(verdicts) != 0 {
+					t.Errorf("verdicts = %+v, want none", verdicts)
+				}
+				return
+			}
+			if len(verdicts) != 1 || verdicts[0].Check != senderCheckName || verdicts[0].Result != tt.wantVerdict {
+				t.Errorf("verdicts = %+v, want %s %s", verdicts, senderCheckName, tt.wantVerdict)
+			}
+		})
+	}
+}
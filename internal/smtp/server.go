@@ -2,77 +2,209 @@
 package smtp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/dns"
+	"github.com/nathabonfim59/gargantua-sink/internal/health"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/shadow"
+	"github.com/nathabonfim59/gargantua-sink/internal/spool"
 	"github.com/nathabonfim59/gargantua-sink/internal/storage"
 )
 
+// errDomainNotConfigured is returned for recipients outside the configured domains.
+var errDomainNotConfigured = &smtp.SMTPError{
+	Code:         550,
+	EnhancedCode: smtp.EnhancedCode{5, 1, 2},
+	Message:      "Recipient domain not configured",
+}
+
+// errStorageUnavailable is returned while the storage of a domain is failing.
+var errStorageUnavailable = &smtp.SMTPError{
+	Code:         452,
+	EnhancedCode: smtp.EnhancedCode{4, 3, 1},
+	Message:      "Mail system storage unavailable, try again later",
+}
+
 // Backend implements SMTP server handler.
 type Backend struct {
-	storage *storage.EmailStorage
+	config   config.SMTPConfig
+	storage  *storage.EmailStorage
+	domains  *domainRegistry
+	health   *health.Tracker
+	routes   sizeRoutes       // Storages for large messages, set before Start
+	handler  pipeline.Handler // Ingest chain run for every email
+	sessions *sessionRegistry
+
+	honeypot bool              // Accept every recipient and never bounce
+	observe  func(SessionInfo) // Called with every finished session, may be nil
+	resolver dns.Resolver      // Lookups of the sender domain check
+
+	extensions *extensionProfile // Extensions offered in EHLO, nil for the defaults
+	provider   *providerProfile  // Emulated provider, nil for none
+
+	headerRejections headerRejections
+	outgoingFailures atomic.Int64 // Sender OUT copies that could not be stored
 }
 
-// NewSession creates a new SMTP session.
-func (bkd *Backend) NewSession(_ *smtp.Conn) (smtp.Session, error) {
-	return &Session{
-		storage: bkd.storage,
-	}, nil
+// NewSession creates a new SMTP session, recording the TLS parameters of
+// the connection once STARTTLS completed. A session replaced by a new
+// EHLO on the same connection is logged out.
+func (bkd *Backend) NewSession(conn *smtp.Conn) (smtp.Session, error) {
+	if previous := conn.Session(); previous != nil {
+		previous.Logout()
+	}
+	session := &Session{
+		backend:    bkd,
+		conn:       conn,
+		remoteAddr: conn.Conn().RemoteAddr().String(),
+	}
+	if state, ok := connectionState(conn); ok {
+		session.tls = tlsDetails(state)
+	}
+	session.id = bkd.sessions.register(conn, SessionInfo{
+		RemoteAddr: session.remoteAddr,
+		Hostname:   conn.Hostname(),
+		TLS:        session.tls,
+		StartedAt:  time.Now(),
+		recorder:   pipeliningRecorder(conn.Conn()),
+	})
+	return session, nil
+}
+
+// storageFor returns the storage for a domain and whether the domain is accepted.
+func (bkd *Backend) storageFor(domain string) (*storage.EmailStorage, bool) {
+	domainStorage, ok := bkd.domains.lookup(domain)
+	if !ok {
+		if bkd.honeypot {
+			return bkd.storage, true
+		}
+		return nil, false
+	}
+	if domainStorage == nil {
+		return bkd.storage, true
+	}
+	return domainStorage, true
 }
 
 // Session represents an SMTP session.
 type Session struct {
-	storage    *storage.EmailStorage
+	backend    *Backend
+	conn       *smtp.Conn
+	id         uint64 // Key in the session registry
+	remoteAddr string
+	tls        *message.TLS
+	username   string
 	from       string
 	recipients []string
+	sender     *senderResult // Sender domain check of the transaction, nil when off
 }
 
 // AuthPlain implements authentication - always returns nil as we accept all auth.
+// The user name is passed on to the ingest pipeline.
 func (s *Session) AuthPlain(username, password string) error {
+	s.username = username
+	s.backend.sessions.update(s.conn, s.id, func(info *SessionInfo) { info.Username = username })
 	return nil
 }
 
 // Mail sets the sender address.
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
+	slog.Debug("MAIL FROM", "from", from)
+	s.sender = nil
+	if err := s.backend.extensions.checkMailOptions(opts); err != nil {
+		return err
+	}
+
+	mode := s.backend.config.SenderCheck.Mode
+	if (mode == senderCheckRecord || mode == senderCheckEnforce) && from != "" {
+		domain, _ := parseEmailAddress(from)
+		result := checkSenderDomain(context.Background(), s.backend.resolver, domain)
+		slog.Debug("Sender domain checked", "domain", domain, "result", result.result, "detail", result.detail)
+		if mode == senderCheckEnforce && result.reject != nil {
+			return result.reject
+		}
+		s.sender = &result
+	}
+
 	s.from = from
 	return nil
 }
 
 // Rcpt adds a recipient address.
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
+	domain, _ := parseEmailAddress(to)
+	domainStorage, ok := s.backend.storageFor(domain)
+	if !ok {
+		slog.Debug("RCPT TO rejected, domain not configured", "to", to)
+		return errDomainNotConfigured
+	}
+	if !s.backend.honeypot && !s.backend.health.Check(domain, func() error { return domainStorage.Probe(domain) }) {
+		slog.Debug("RCPT TO rejected, domain storage unavailable", "to", to)
+		return errStorageUnavailable
+	}
+
+	slog.Debug("RCPT TO", "to", to)
 	s.recipients = append(s.recipients, to)
 	return nil
 }
 
-// Data handles the email content.
+// Data handles the email content by running it through the ingest pipeline.
+// Content above the spill threshold is buffered on disk instead of in memory.
 func (s *Session) Data(r io.Reader) error {
-	content, err := io.ReadAll(r)
-	if err != nil {
+	dataAt := time.Now()
+	content := spool.New(s.backend.config.SpoolDir, s.backend.config.SpillThreshold)
+	defer content.Release()
+
+	reader := newHeaderLimiter(r, s.backend.config.MaxHeaderFields, s.backend.config.MaxHeaderBytes)
+	if _, err := io.Copy(content, reader); err != nil {
+		if reader.exceeded != "" {
+			slog.Warn("DATA rejected, header section too large", "from", s.from, "limit", reader.exceeded, "fields", reader.fields, "bytes", reader.bytes)
+			s.backend.headerRejections.add(reader.exceeded)
+		}
+		// Protocol errors such as the size limit are sent to the client as is
+		var smtpErr *smtp.SMTPError
+		if errors.As(err, &smtpErr) {
+			return smtpErr
+		}
 		return fmt.Errorf("reading email content: %w", err)
 	}
+	slog.Debug("DATA received", "from", s.from, "recipients", len(s.recipients), "bytes", content.Size(), "spilled", content.Spilled())
 
-	// Extract domain and user from sender
-	senderDomain, senderUser := parseEmailAddress(s.from)
-
-	// Store email in sender's OUT directory
-	subject := fmt.Sprintf("to-%s", s.recipients[0]) // Use first recipient for subject
-	if err := s.storage.StoreEmail(storage.Outgoing, senderDomain, senderUser, subject, content); err != nil {
-		log.Printf("Error storing outgoing email for sender %s: %v", s.from, err)
+	envelope := message.Envelope{
+		RemoteAddr: s.remoteAddr,
+		Username:   s.username,
+		From:       s.from,
+		To:         append([]string(nil), s.recipients...),
+		TLS:        s.tls,
 	}
-
-	// Store email for each recipient in their IN directory
-	for _, recipient := range s.recipients {
-		domain, user := parseEmailAddress(recipient)
-		subject := fmt.Sprintf("from-%s", s.from)
-
-		if err := s.storage.StoreEmail(storage.Incoming, domain, user, subject, content); err != nil {
-			log.Printf("Error storing email for recipient %s: %v", recipient, err)
+	msg := message.ParseWithBudget(envelope, content, s.backend.config.SpillThreshold)
+	if s.sender != nil {
+		msg.AddVerdict(senderCheckName, s.sender.result, s.sender.detail)
+	}
+	if s.backend.config.Timeline {
+		msg.Timeline = []message.TimelineEvent{
+			{Stage: message.StageData, At: dataAt},
+			{Stage: message.StageReceived, At: msg.ReceivedAt},
 		}
+		msg.Mark(message.StageParsed)
 	}
-
+	delivery := &pipeline.Delivery{Message: msg}
+	if err := s.backend.handler(context.Background(), delivery); err != nil {
+		return err
+	}
+	s.backend.sessions.update(s.conn, s.id, func(info *SessionInfo) { info.Messages++ })
 	return nil
 }
 
@@ -80,43 +212,264 @@ func (s *Session) Data(r io.Reader) error {
 func (s *Session) Reset() {
 	s.from = ""
 	s.recipients = nil
+	s.sender = nil
 }
 
 // Logout closes the session.
 func (s *Session) Logout() error {
+	info, ok := s.backend.sessions.remove(s.conn, s.id)
+	if ok && s.backend.observe != nil {
+		s.backend.observe(info)
+	}
 	return nil
 }
 
 // Server represents an SMTP server instance.
 type Server struct {
 	port    int
+	config  config.SMTPConfig
 	storage *storage.EmailStorage
+	domains *domainRegistry
+	handles *storageHandles
+	shadow  *shadow.Mirror
+	chain   *pipeline.Chain
+	backend *Backend
 	server  *smtp.Server
 }
 
-// NewServer creates a new SMTP server instance.
+// NewServer creates a new SMTP server instance with the default limits.
 func NewServer(port int, emailStorage *storage.EmailStorage) *Server {
-	return &Server{
-		port:    port,
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	return NewServerFromConfig(cfg, emailStorage)
+}
+
+// NewServerFromConfig creates a new SMTP server instance from the SMTP configuration.
+func NewServerFromConfig(cfg config.SMTPConfig, emailStorage *storage.EmailStorage) *Server {
+	cfg, provider := applyProfile(cfg)
+	server := &Server{
+		port:    cfg.Port,
+		config:  cfg,
 		storage: emailStorage,
+		domains: newDomainRegistry(),
+		handles: newStorageHandles(emailStorage),
+		chain:   pipeline.NewChain(),
 	}
+	server.backend = &Backend{
+		config:   cfg,
+		storage:  emailStorage,
+		domains:  server.domains,
+		health:   health.NewTracker(health.DefaultProbeInterval),
+		sessions: newSessionRegistry(closedHistory(cfg)),
+		resolver: net.DefaultResolver,
+
+		extensions: newExtensionProfile(cfg.Extensions),
+		provider:   provider,
+	}
+	if len(cfg.SenderCheck.Stub) > 0 {
+		server.backend.resolver = stubFixture(cfg.SenderCheck.Stub)
+	}
+
+	server.chain.Use(pipeline.StageStore, storeMiddleware(server.backend))
+	return server
+}
+
+// Use registers an ingest middleware at the given stage, after the ones
+// already registered there. The built-in storage runs first in the store
+// stage. It must be called before Start.
+func (server *Server) Use(stage pipeline.Stage, middleware pipeline.Middleware) {
+	server.chain.Use(stage, middleware)
+}
+
+// AddDomain restricts the server to accept mail for the given domain, or
+// updates its storage when already registered. Emails for the domain are
+// stored under storagePath, or the server storage when empty; domains and
+// size routes in the same directory share one storage.
+// It is safe to call while the server is running.
+func (server *Server) AddDomain(name, storagePath string) error {
+	domainStorage := server.storage
+	if storagePath != "" {
+		var err error
+		domainStorage, err = server.handles.open(storagePath)
+		if err != nil {
+			return fmt.Errorf("creating storage for domain %s: %w", name, err)
+		}
+	}
+
+	server.domains.set(name, domainStorage)
+	return nil
+}
+
+// AddSizeRoute stores messages of at least minBytes under storagePath instead
+// of the storage of their domain, e.g. to keep large attachments off fast
+// local disk. When several thresholds are reached the largest one wins.
+// It must be called before Start.
+func (server *Server) AddSizeRoute(minBytes int64, storagePath string) error {
+	routeStorage, err := server.handles.open(storagePath)
+	if err != nil {
+		return fmt.Errorf("creating storage for messages above %d bytes: %w", minBytes, err)
+	}
+
+	server.backend.routes = server.backend.routes.add(sizeRoute{minBytes: minBytes, storage: routeStorage})
+	return nil
+}
+
+// InjectStorageFaults degrades the email writes of every storage, including
+// the ones added later, with faults. It is meant for failure testing and
+// must be called before Start.
+func (server *Server) InjectStorageFaults(faults *storage.Faults) {
+	server.handles.injectFaults(faults)
+}
+
+// EnableHoneypot tunes the server for catching unsolicited traffic on an
+// exposed address: every recipient is accepted whatever its domain, the
+// recipient limit is lifted and storage failures are hidden from the
+// client, so senders never see a bounce. observe, when not nil, is called
+// with every finished session. It must be called before Start.
+func (server *Server) EnableHoneypot(observe func(SessionInfo)) {
+	server.backend.honeypot = true
+	server.backend.observe = observe
+}
+
+// SetResolver routes the DNS lookups of the server through resolver. The
+// sender_check stub, when configured, still answers the sender check; the
+// configuration rejects combining it with DNS fixtures. It must be called
+// before Start.
+func (server *Server) SetResolver(resolver dns.Resolver) {
+	if len(server.config.SenderCheck.Stub) == 0 {
+		server.backend.resolver = resolver
+	}
+}
+
+// SetShadow mirrors the emails selected by mirror to a dark-launch target
+// from the notify stage. It must be called before Start.
+func (server *Server) SetShadow(mirror *shadow.Mirror) {
+	server.shadow = mirror
+	server.chain.Use(pipeline.StageNotify, shadowMiddleware(mirror))
+}
+
+// RemoveDomain stops accepting mail for a domain and reports whether it was registered.
+// Removing the last domain does not reopen the server to every domain.
+func (server *Server) RemoveDomain(name string) bool {
+	return server.domains.remove(name)
+}
+
+// RestrictDomains rejects mail for every domain not added with AddDomain,
+// even while no domain is registered yet.
+func (server *Server) RestrictDomains() {
+	server.domains.restrict()
+}
+
+// Storages returns every distinct storage emails may be written to: the
+// server storage followed by the per-domain and size route storages.
+func (server *Server) Storages() []*storage.EmailStorage {
+	storages := []*storage.EmailStorage{server.storage}
+	seen := map[*storage.EmailStorage]bool{server.storage: true}
+	for _, domainStorage := range server.domains.storages() {
+		if !seen[domainStorage] {
+			seen[domainStorage] = true
+			storages = append(storages, domainStorage)
+		}
+	}
+	for _, route := range server.backend.routes {
+		if !seen[route.storage] {
+			seen[route.storage] = true
+			storages = append(storages, route.storage)
+		}
+	}
+	return storages
+}
+
+// Health returns the per-domain storage health tracker.
+func (server *Server) Health() *health.Tracker {
+	return server.backend.health
+}
+
+// Sessions returns the open SMTP sessions, oldest first.
+func (server *Server) Sessions() []SessionInfo {
+	return server.backend.sessions.list()
+}
+
+// ClosedSessions returns the last finished SMTP sessions, most recent
+// first. They are only kept with smtp.diagnose_pipelining.
+func (server *Server) ClosedSessions() []SessionInfo {
+	return server.backend.sessions.listClosed()
+}
+
+// closedHistory returns the number of finished sessions kept for cfg.
+func closedHistory(cfg config.SMTPConfig) int {
+	if cfg.DiagnosePipelining {
+		return maxClosedSessions
+	}
+	return 0
+}
+
+// Domains returns the currently accepted domains; empty when every domain is accepted.
+func (server *Server) Domains() []string {
+	return server.domains.names()
 }
 
 // Start initializes the SMTP server and begins listening for connections.
 func (server *Server) Start() error {
-	backend := &Backend{storage: server.storage}
-
-	server.server = smtp.NewServer(backend)
-	server.server.Addr = fmt.Sprintf(":%d", server.port)
-	server.server.ReadTimeout = 10 * time.Second
-	server.server.WriteTimeout = 10 * time.Second
-	server.server.MaxMessageBytes = 1024 * 1024 // 1MB
-	server.server.MaxRecipients = 50
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", server.port))
+	if err != nil {
+		return err
+	}
+	return server.Serve(listener)
+}
+
+// Serve accepts SMTP connections on listener, which may be inherited from
+// a previous process, until the server is stopped.
+func (server *Server) Serve(listener net.Listener) error {
+	server.backend.handler = server.chain.Handler()
+	tlsConfig, err := loadTLSConfig(server.config.TLS)
+	if err != nil {
+		return err
+	}
+
+	// The capture and the recorder go first to see the bytes as they arrive
+	if server.config.Capture.Dir != "" {
+		capture, err := newCaptureListener(listener, server.config.Capture)
+		if err != nil {
+			return err
+		}
+		listener = capture
+	}
+	if server.config.DiagnosePipelining {
+		listener = pipeliningListener{listener}
+	}
+	var verbs []string
+	switch server.config.VRFY {
+	case verifyDisabled, verifyAccept, verifyStrict:
+		verbs = []string{"VRFY", "EXPN"}
+	}
+	// The tap also performs STARTTLS so the recorder sees the decrypted stream
+	diagnoseTLS := server.config.DiagnosePipelining && tlsConfig != nil
+	if verbs != nil || server.backend.extensions != nil || diagnoseTLS {
+		tapTLS := tlsConfig
+		if !server.backend.extensions.offers("STARTTLS") {
+			tapTLS = nil
+		}
+		listener = newTapListener(listener, verbs, server.backend.verify, server.backend.extensions, server.backend.provider, tapTLS)
+	}
+
+	server.server = smtp.NewServer(server.backend)
+	server.server.Addr = listener.Addr().String()
+	server.server.ReadTimeout = server.config.ReadTimeout
+	server.server.WriteTimeout = server.config.WriteTimeout
+	server.server.MaxMessageBytes = server.config.MaxMessageBytes
+	server.server.MaxRecipients = server.config.MaxRecipients
+	if server.backend.honeypot {
+		server.server.MaxRecipients = 0
+	}
 	server.server.AllowInsecureAuth = true
+	server.server.TLSConfig = tlsConfig
+	server.server.ErrorLog = log.Default()
+	server.backend.extensions.configure(server.server)
 	// server.server.Direction = smtp.DirectionInbound
 
-	log.Printf("Starting SMTP server on :%d", server.port)
-	return server.server.ListenAndServe()
+	log.Printf("Starting SMTP server on %s", listener.Addr())
+	return server.server.Serve(listener)
 }
 
 // Stop gracefully shuts down the SMTP server.
@@ -127,6 +480,23 @@ func (server *Server) Stop() error {
 	return nil
 }
 
+// Shutdown stops accepting connections and waits for open sessions to
+// finish until ctx expires, after which remaining connections are closed.
+func (server *Server) Shutdown(ctx context.Context) error {
+	if server.server == nil {
+		return nil
+	}
+
+	if err := server.server.Shutdown(ctx); err != nil {
+		server.server.Close()
+		return err
+	}
+	if server.shadow != nil {
+		return server.shadow.Wait(ctx)
+	}
+	return nil
+}
+
 // parseEmailAddress extracts domain and user from email address.
 func parseEmailAddress(email string) (domain, user string) {
 	for i := 0; i < len(email); i++ {
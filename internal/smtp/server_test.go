@@ -3,6 +3,8 @@ package smtp
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"mime/multipart"
 	"net"
@@ -14,6 +16,8 @@ import (
 	"time"
 
 	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
 	"github.com/nathabonfim59/gargantua-sink/internal/storage"
 )
 
@@ -438,3 +442,483 @@ func TestSimultaneousSMTPSessions(t *testing.T) {
 
 	t.Logf("Successfully processed %d simultaneous sessions with %d emails each", numSessions, emailsPerSession)
 }
+
+func TestRejectsUnconfiguredDomain(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	tempDir := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(tempDir)
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	server := NewServer(port, emailStorage)
+	if err := server.AddDomain("example.com", ""); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := smtp.Dial(fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.Mail("sender@external.org", nil); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+	if err := client.Rcpt("someone@unknown.org", nil); err == nil {
+		t.Error("RCPT TO for unconfigured domain succeeded, want rejection")
+	}
+	if err := client.Rcpt("john@EXAMPLE.com", nil); err != nil {
+		t.Errorf("RCPT TO for configured domain failed: %v", err)
+	}
+}
+
+// sendTestEmail delivers one email over a plain SMTP connection.
+func sendTestEmail(addr, from, to string, content []byte) error {
+	client, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(from, nil); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to, nil); err != nil {
+		return err
+	}
+
+	wc, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(content); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func TestIngestPipeline(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	server := NewServer(port, emailStorage)
+	server.Use(pipeline.StageFilter, func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			if delivery.Message.Subject == "spam" {
+				return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 7, 1}, Message: "Rejected by filter"}
+			}
+			return next(ctx, delivery)
+		}
+	})
+	server.Use(pipeline.StageEnrich, func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			delivery.Message.Tags = append(delivery.Message.Tags, "enriched")
+			delivery.Message.AddVerdict("test", "pass", "")
+			return next(ctx, delivery)
+		}
+	})
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	if err := sendTestEmail(addr, "a@example.com", "b@example.com", []byte("Subject: spam\r\n\r\nbuy now\r\n")); err == nil {
+		t.Error("filtered email was accepted")
+	}
+	if err := sendTestEmail(addr, "a@example.com", "b@example.com", []byte("Subject: hello\r\n\r\nhi\r\n")); err != nil {
+		t.Fatalf("sending email failed: %v", err)
+	}
+
+	emails, err := emailStorage.List(storage.ListFilter{})
+	if err != nil {
+		t.Fatalf("listing emails failed: %v", err)
+	}
+	if len(emails) != 2 {
+		t.Fatalf("stored %d emails, want OUT and IN copies of the accepted email", len(emails))
+	}
+	for _, email := range emails {
+		if !email.Metadata.HasTag("enriched") {
+			t.Errorf("email %s is missing the enrichment tag", email.ID)
+		}
+		if len(email.Metadata.Verdicts) != 1 || email.Metadata.Verdicts[0].Check != "test" {
+			t.Errorf("email %s verdicts = %+v, want the test verdict", email.ID, email.Metadata.Verdicts)
+		}
+	}
+}
+
+func TestLargeMessageSpillsToDisk(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	spoolDir := t.TempDir()
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.SpillThreshold = 1024
+	cfg.SpoolDir = spoolDir
+
+	var spilled bool
+	server := NewServerFromConfig(cfg, emailStorage)
+	server.Use(pipeline.StageFilter, func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			entries, _ := os.ReadDir(spoolDir)
+			spilled = len(entries) == 1
+			return next(ctx, delivery)
+		}
+	})
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	attachment := bytes.Repeat([]byte("0123456789abcdef\r\n"), 1024)
+	email, err := createTestEmail("a@example.com", "b@example.com", "Large", "body", map[string][]byte{"big.bin": attachment})
+	if err != nil {
+		t.Fatalf("creating email failed: %v", err)
+	}
+	if err := sendTestEmail(fmt.Sprintf("localhost:%d", port), "a@example.com", "b@example.com", email); err != nil {
+		t.Fatalf("sending email failed: %v", err)
+	}
+
+	if !spilled {
+		t.Error("message above the threshold was not spooled to disk")
+	}
+	if entries, _ := os.ReadDir(spoolDir); len(entries) != 0 {
+		t.Errorf("spool directory holds %d files after delivery, want 0", len(entries))
+	}
+
+	emails, err := emailStorage.List(storage.ListFilter{Direction: ptr(storage.Incoming)})
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("List() = %d emails, %v, want 1", len(emails), err)
+	}
+	stored, err := emailStorage.ReadContent(emails[0].ID)
+	if err != nil {
+		t.Fatalf("reading stored email failed: %v", err)
+	}
+	if !bytes.Contains(stored, []byte("big.bin")) || len(stored) < len(attachment) {
+		t.Error("stored email is incomplete")
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestFailingDomainStorageIsIsolated(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	// A file where the domain directory should be makes every write fail
+	brokenPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(brokenPath, "broken.com"), nil, 0644); err != nil {
+		t.Fatalf("creating blocking file failed: %v", err)
+	}
+
+	server := NewServer(port, emailStorage)
+	if err := server.AddDomain("broken.com", brokenPath); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	if err := server.AddDomain("ok.com", ""); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	content := []byte("Subject: hi\r\n\r\nbody\r\n")
+	// The failed write itself is answered 452, then the domain is
+	// rejected at RCPT TO
+	var smtpErr *smtp.SMTPError
+	for i := 0; i < 2; i++ {
+		err := sendTestEmail(addr, "a@ok.com", "john@broken.com", content)
+		if !errors.As(err, &smtpErr) || smtpErr.Code != 452 {
+			t.Errorf("email %d to failing domain error = %v, want 452", i, err)
+		}
+	}
+
+	// No copy is left behind for the retry to duplicate
+	emails, err := emailStorage.List(storage.ListFilter{Domain: "ok.com"})
+	if err != nil || len(emails) != 0 {
+		t.Errorf("List() = %d emails, %v; want the rejected OUT copies removed", len(emails), err)
+	}
+	if err := sendTestEmail(addr, "a@ok.com", "jane@ok.com", content); err != nil {
+		t.Errorf("email to healthy domain failed: %v", err)
+	}
+
+	if server.Health().Ready() {
+		t.Error("Ready() = true with a failing domain")
+	}
+}
+
+func TestFailingOutgoingStorageIsCounted(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	// A file where the sender domain directory should be makes the OUT copy fail
+	basePath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(basePath, "sender.com"), nil, 0644); err != nil {
+		t.Fatalf("creating blocking file failed: %v", err)
+	}
+	emailStorage, err := storage.NewEmailStorage(basePath)
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	server := NewServer(port, emailStorage)
+	if err := server.AddDomain("ok.com", t.TempDir()); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	if err := sendTestEmail(addr, "a@sender.com", "john@ok.com", []byte("Subject: hi\r\n\r\nbody\r\n")); err != nil {
+		t.Fatalf("email with a stored IN copy failed: %v", err)
+	}
+
+	if statuses := server.Health().Statuses(); len(statuses) != 0 {
+		t.Errorf("Statuses() = %+v, want no domain affected by the OUT copy", statuses)
+	}
+	families := server.Collect()
+	if failures := families[len(families)-1]; failures.Samples[0].Value != 1 {
+		t.Errorf("%s = %v, want 1", failures.Name, failures.Samples[0].Value)
+	}
+}
+
+func TestSizeRouting(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	server := NewServer(port, emailStorage)
+	if err := server.AddSizeRoute(4096, t.TempDir()); err != nil {
+		t.Fatalf("adding size route failed: %v", err)
+	}
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	if err := sendTestEmail(addr, "a@example.com", "b@example.com", []byte("Subject: small\r\n\r\nhi\r\n")); err != nil {
+		t.Fatalf("sending small email failed: %v", err)
+	}
+	large := append([]byte("Subject: large\r\n\r\n"), bytes.Repeat([]byte("0123456789abcdef\r\n"), 512)...)
+	if err := sendTestEmail(addr, "a@example.com", "b@example.com", large); err != nil {
+		t.Fatalf("sending large email failed: %v", err)
+	}
+
+	storages := server.Storages()
+	if len(storages) != 2 {
+		t.Fatalf("Storages() returned %d storages, want the server and route storages", len(storages))
+	}
+	for i, wantLarge := range []bool{false, true} {
+		emails, err := storages[i].List(storage.ListFilter{})
+		if err != nil {
+			t.Fatalf("listing emails failed: %v", err)
+		}
+		if len(emails) != 2 {
+			t.Fatalf("storage %d holds %d emails, want the OUT and IN copies of one email", i, len(emails))
+		}
+		for _, email := range emails {
+			if large := email.Size >= 4096; large != wantLarge {
+				t.Errorf("storage %d holds an email of %d bytes", i, email.Size)
+			}
+		}
+	}
+}
+
+func TestStorageFaultsReject(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	faults, err := storage.NewFaults(storage.FaultSettings{ErrorRate: 1})
+	if err != nil {
+		t.Fatalf("creating faults failed: %v", err)
+	}
+
+	server := NewServer(port, emailStorage)
+	server.InjectStorageFaults(faults)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	content := []byte("Subject: hi\r\n\r\nbody\r\n")
+	err = sendTestEmail(addr, "a@example.com", "b@example.com", content)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 452 {
+		t.Errorf("email with failing storage error = %v, want 452", err)
+	}
+
+	// The failing domain is now rejected at RCPT TO until a probe succeeds
+	if err := faults.Set(storage.FaultSettings{}); err != nil {
+		t.Fatalf("clearing faults failed: %v", err)
+	}
+	err = sendTestEmail(addr, "a@example.com", "b@example.com", content)
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 452 {
+		t.Errorf("email to unhealthy domain error = %v, want 452", err)
+	}
+	if server.Health().Ready() {
+		t.Error("Ready() = true with failing storage")
+	}
+}
+
+func TestVerifyStrict(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.VRFY = "strict"
+	server := NewServerFromConfig(cfg, emailStorage)
+	if err := server.AddDomain("example.com", ""); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// Verb lines inside the content are not answered by the sink
+	addr := fmt.Sprintf("localhost:%d", port)
+	content := []byte("Subject: hi\r\n\r\nVRFY nobody@example.com\r\n")
+	if err := sendTestEmail(addr, "a@example.com", "john@example.com", content); err != nil {
+		t.Fatalf("sending email failed: %v", err)
+	}
+	emails, err := emailStorage.List(storage.ListFilter{User: "john"})
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("List() = %d emails, %v, want 1", len(emails), err)
+	}
+	stored, err := emailStorage.ReadContent(emails[0].ID)
+	if err != nil || !bytes.Contains(stored, []byte("VRFY nobody@example.com")) {
+		t.Errorf("stored content = %q, %v, want the VRFY line", stored, err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	if _, _, err := text.ReadResponse(220); err != nil {
+		t.Fatalf("reading greeting failed: %v", err)
+	}
+
+	// Pipelined commands are answered in order
+	if _, err := conn.Write([]byte("NOOP\r\nVRFY <john@example.com>\r\nEXPN nobody@example.com\r\nVRFY john@other.org\r\nVRFY john\r\nNOOP\r\n")); err != nil {
+		t.Fatalf("writing commands failed: %v", err)
+	}
+	for _, want := range []int{250, 250, 550, 550, 553, 250} {
+		code, msg, err := text.ReadResponse(0)
+		if code != want {
+			t.Errorf("reply = %d %s (%v), want %d", code, msg, err, want)
+		}
+	}
+}
+
+func TestHoneypot(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	faults, err := storage.NewFaults(storage.FaultSettings{})
+	if err != nil {
+		t.Fatalf("creating faults failed: %v", err)
+	}
+
+	observed := make(chan SessionInfo, 4)
+	server := NewServer(port, emailStorage)
+	server.InjectStorageFaults(faults)
+	if err := server.AddDomain("example.com", ""); err != nil {
+		t.Fatalf("adding domain failed: %v", err)
+	}
+	server.EnableHoneypot(func(info SessionInfo) { observed <- info })
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// Unknown domains are accepted and stored in the default storage
+	addr := fmt.Sprintf("localhost:%d", port)
+	content := []byte("Subject: offer\r\n\r\nbuy now\r\n")
+	if err := sendTestEmail(addr, "spam@bulk.test", "victim@other.org", content); err != nil {
+		t.Fatalf("email to unknown domain failed: %v", err)
+	}
+	emails, err := emailStorage.List(storage.ListFilter{User: "victim"})
+	if err != nil || len(emails) != 1 {
+		t.Errorf("List() = %d emails, %v, want 1", len(emails), err)
+	}
+
+	select {
+	case info := <-observed:
+		if info.Hostname != "localhost" || info.Messages != 1 {
+			t.Errorf("observed session = %+v, want localhost with 1 message", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("session was not observed")
+	}
+
+	// Storage failures are not reported to the sender
+	if err := faults.Set(storage.FaultSettings{ErrorRate: 1}); err != nil {
+		t.Fatalf("setting faults failed: %v", err)
+	}
+	if err := sendTestEmail(addr, "spam@bulk.test", "victim@example.com", content); err != nil {
+		t.Errorf("email with failing storage failed: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package smtp
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+)
+
+// maxClosedSessions is the number of finished sessions kept for diagnostics.
+const maxClosedSessions = 100
+
+// SessionInfo describes an open SMTP session.
+type SessionInfo struct {
+	ID         uint64       `json:"id"`
+	RemoteAddr string       `json:"remote_addr"`
+	Hostname   string       `json:"hostname"`           // Name given in EHLO or HELO
+	Username   string       `json:"username,omitempty"` // Authenticated user, empty for anonymous sessions
+	TLS        *message.TLS `json:"tls,omitempty"`      // Nil until STARTTLS completes
+	StartedAt  time.Time    `json:"started_at"`
+	Messages   int          `json:"messages"` // Emails accepted so far
+
+	// Pipelining reports how the client pipelined commands on the
+	// connection, only with smtp.diagnose_pipelining
+	Pipelining *PipeliningReport `json:"pipelining,omitempty"`
+
+	recorder *pipeliningConn
+}
+
+// snapshot returns a copy of info with the current pipelining report.
+func (info *SessionInfo) snapshot() SessionInfo {
+	copied := *info
+	if info.recorder != nil {
+		copied.Pipelining = info.recorder.Report()
+	}
+	return copied
+}
+
+// sessionRegistry tracks the open sessions, one per connection. go-smtp
+// replaces the session of a connection on every EHLO, so registering a
+// session drops the previous one of its connection.
+type sessionRegistry struct {
+	mu       sync.Mutex
+	nextID   uint64
+	sessions map[*smtp.Conn]*SessionInfo
+
+	history int           // Number of finished sessions kept
+	closed  []SessionInfo // Finished sessions, oldest first
+}
+
+// newSessionRegistry creates an empty registry keeping the last history
+// finished sessions.
+func newSessionRegistry(history int) *sessionRegistry {
+	return &sessionRegistry{sessions: make(map[*smtp.Conn]*SessionInfo), history: history}
+}
+
+// register records a new session of conn and returns its ID.
+func (registry *sessionRegistry) register(conn *smtp.Conn, info SessionInfo) uint64 {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.nextID++
+	info.ID = registry.nextID
+	registry.sessions[conn] = &info
+	return info.ID
+}
+
+// update applies change to the session of conn, if it is still the one with id.
+func (registry *sessionRegistry) update(conn *smtp.Conn, id uint64, change func(*SessionInfo)) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if info, ok := registry.sessions[conn]; ok && info.ID == id {
+		change(info)
+	}
+}
+
+// remove forgets the session of conn, if it is still the one with id, and
+// returns its last state.
+func (registry *sessionRegistry) remove(conn *smtp.Conn, id uint64) (SessionInfo, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	info, ok := registry.sessions[conn]
+	if !ok || info.ID != id {
+		return SessionInfo{}, false
+	}
+	delete(registry.sessions, conn)
+
+	finished := info.snapshot()
+	if registry.history > 0 {
+		if len(registry.closed) >= registry.history {
+			registry.closed = registry.closed[1:]
+		}
+		registry.closed = append(registry.closed, finished)
+	}
+	return finished, true
+}
+
+// list returns the open sessions, oldest first.
+func (registry *sessionRegistry) list() []SessionInfo {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	sessions := make([]SessionInfo, 0, len(registry.sessions))
+	for _, info := range registry.sessions {
+		sessions = append(sessions, info.snapshot())
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return sessions
+}
+
+// listClosed returns the kept finished sessions, most recent first.
+func (registry *sessionRegistry) listClosed() []SessionInfo {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	sessions := make([]SessionInfo, 0, len(registry.closed))
+	for i := len(registry.closed) - 1; i >= 0; i-- {
+		sessions = append(sessions, registry.closed[i])
+	}
+	return sessions
+}
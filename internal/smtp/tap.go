@@ -0,0 +1,386 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// maxTapLine bounds the command line buffered by the tap; longer lines are
+// passed on for go-smtp to reject.
+const maxTapLine = 4096
+
+// tapMode tells how the tap treats the bytes sent by the client.
+type tapMode int
+
+const (
+	tapCommand     tapMode = iota // One command per line, some answered by the tap
+	tapData                       // DATA content up to the terminating dot line
+	tapChunk                      // BDAT chunk of a known size
+	tapPassthrough                // After STARTTLS, everything is encrypted; only used by the capture
+)
+
+// verbHandler answers a command handled by the tap with a full reply line,
+// without CRLF.
+type verbHandler func(verb, arg string) string
+
+// tapListener wraps the accepted connections in a tapConn.
+type tapListener struct {
+	net.Listener
+	verbs      map[string]bool
+	handler    verbHandler
+	extensions *extensionProfile // Rewrites the EHLO reply when set
+	provider   *providerProfile  // Rewords replies and throttles when set
+	tlsConfig  *tls.Config       // Answers STARTTLS when set
+}
+
+// newTapListener answers the verbs, e.g. VRFY and EXPN, with handler on
+// every connection accepted by listener, restricts the extensions offered
+// to extensions and emulates provider when not nil, which go-smtp does not
+// allow to customize. The tap performs STARTTLS with tlsConfig itself, so
+// it keeps working on the decrypted commands.
+func newTapListener(listener net.Listener, verbs []string, handler verbHandler, extensions *extensionProfile, provider *providerProfile, tlsConfig *tls.Config) *tapListener {
+	tap := &tapListener{Listener: listener, verbs: make(map[string]bool), handler: handler, extensions: extensions, provider: provider, tlsConfig: tlsConfig}
+	for _, verb := range verbs {
+		tap.verbs[verb] = true
+	}
+	return tap
+}
+
+// Accept waits for the next connection and wraps it.
+func (listener *tapListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tapConn{Conn: conn, listener: listener, lineStart: true}, nil
+}
+
+// tapConn sits between the client and go-smtp. It hands go-smtp one
+// command line per Read, so go-smtp has replied to the previous command
+// before the tap answers one of its own and replies stay in order, even
+// when the client pipelines. Replies written by go-smtp tell when message
+// content begins; the tap passes them on, reworded only when emulating a
+// provider. STARTTLS is answered by the tap, which then reads and writes
+// through the TLS connection, so go-smtp never sees the handshake.
+//
+// Reads and writes happen on the go-smtp connection goroutine only.
+type tapConn struct {
+	net.Conn
+	listener *tapListener
+
+	in        []byte // Received from the client, not processed yet
+	out       []byte // Processed, waiting for go-smtp to read
+	mode      tapMode
+	chunkLeft int64
+	lineStart bool   // In data mode, whether in[0] starts a line
+	awaiting  string // Command whose reply may change the mode
+	authReply bool   // The next line answers an AUTH challenge
+	discard   bool   // The current BDAT chunk belongs to a refused command
+	ehlo      []byte // EHLO reply lines written so far, to be rewritten
+
+	greeted      bool   // The banner was written
+	last         string // Command answered by the next reply of go-smtp
+	transactions int    // MAIL commands accepted, for the provider throttling
+
+	tlsState *tls.ConnectionState // Set once STARTTLS completed
+	rehello  bool                 // STARTTLS completed and the client has not sent EHLO again
+}
+
+// Read returns the client bytes for go-smtp, answering the tapped verbs.
+func (conn *tapConn) Read(p []byte) (int, error) {
+	for {
+		if len(conn.out) > 0 {
+			n := copy(p, conn.out)
+			conn.out = conn.out[n:]
+			if len(conn.out) == 0 {
+				conn.out = nil // Do not append into the buffer of in
+			}
+			return n, nil
+		}
+		if conn.process() {
+			continue
+		}
+
+		buf := make([]byte, 4096)
+		n, err := conn.Conn.Read(buf)
+		conn.in = append(conn.in, buf[:n]...)
+		if err != nil {
+			if len(conn.in) > 0 {
+				// Hand over what is left before reporting the error
+				conn.out, conn.in = conn.in, nil
+				continue
+			}
+			return 0, err
+		}
+	}
+}
+
+// process moves complete units from in to out, or answers them, and
+// reports whether it made progress.
+func (conn *tapConn) process() bool {
+	switch conn.mode {
+	case tapChunk:
+		n := int64(len(conn.in))
+		if n == 0 {
+			return false
+		}
+		n = min(n, conn.chunkLeft)
+		chunk := conn.in[:n]
+		conn.in = conn.in[n:]
+		conn.chunkLeft -= n
+		if conn.chunkLeft == 0 {
+			conn.mode = tapCommand
+		}
+		if conn.discard {
+			conn.discard = conn.chunkLeft > 0
+			return true
+		}
+		conn.out = chunk
+		return true
+
+	case tapData:
+		return conn.processData()
+	}
+
+	end := bytes.IndexByte(conn.in, '\n')
+	if end < 0 {
+		if len(conn.in) > maxTapLine {
+			conn.out, conn.in = conn.in, nil
+			return true
+		}
+		return false
+	}
+	line := conn.in[:end+1]
+	conn.in = conn.in[end+1:]
+
+	if conn.authReply {
+		conn.authReply = false
+		conn.out = line
+		return true
+	}
+
+	verb, arg, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+	verb = strings.ToUpper(verb)
+	if verb == "STARTTLS" && conn.listener.tlsConfig != nil {
+		conn.startTLS()
+		return true
+	}
+	if conn.rehello {
+		switch verb {
+		case "EHLO", "HELO":
+			conn.rehello = false
+		case "MAIL", "RCPT", "DATA", "BDAT", "AUTH":
+			// Like go-smtp, which forgets the EHLO name on STARTTLS
+			conn.Conn.Write([]byte("502 5.5.1 Please introduce yourself first.\r\n"))
+			if verb == "BDAT" {
+				conn.skipChunk(arg)
+				conn.discard = conn.mode == tapChunk
+			}
+			return true
+		}
+	}
+	if conn.listener.verbs[verb] {
+		reply := conn.listener.handler(verb, strings.TrimSpace(arg))
+		conn.Conn.Write([]byte(reply + "\r\n"))
+		return true
+	}
+	if extensions := conn.listener.extensions; extensions != nil {
+		if reply := extensions.reject(verb, arg); reply != "" {
+			conn.Conn.Write([]byte(reply + "\r\n"))
+			if verb == "BDAT" {
+				conn.skipChunk(arg)
+				conn.discard = conn.mode == tapChunk
+			}
+			return true
+		}
+	}
+	if verb == "EHLO" && (conn.listener.extensions != nil || conn.tlsState != nil) {
+		conn.awaiting = verb
+	}
+	if provider := conn.listener.provider; provider != nil && verb == "MAIL" &&
+		provider.messagesPerConnection > 0 && conn.transactions >= provider.messagesPerConnection {
+		conn.Conn.Write([]byte(provider.expand(provider.throttled, conn.remoteIP()) + "\r\n"))
+		conn.Conn.Close()
+		conn.in = nil
+		return true
+	}
+	conn.last = verb
+
+	switch verb {
+	case "DATA", "AUTH":
+		conn.awaiting = verb
+	case "BDAT":
+		conn.skipChunk(arg)
+	}
+	conn.out = line
+	return true
+}
+
+// startTLS answers STARTTLS and performs the handshake, after which the
+// tap reads and writes through the TLS connection. Commands pipelined
+// after STARTTLS are discarded, as RFC 3207 requires.
+func (conn *tapConn) startTLS() {
+	if conn.tlsState != nil {
+		conn.Conn.Write([]byte("502 5.5.1 Already running in TLS\r\n"))
+		return
+	}
+
+	conn.in = nil
+	conn.Conn.Write([]byte("220 2.0.0 Ready to start TLS\r\n"))
+	tlsConn := tls.Server(conn.Conn, conn.listener.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Conn.Write([]byte("550 5.0.0 Handshake error\r\n"))
+		return
+	}
+	state := tlsConn.ConnectionState()
+	conn.Conn, conn.tlsState, conn.rehello = tlsConn, &state, true
+	if recorder := pipeliningRecorder(tlsConn); recorder != nil {
+		conn.Conn = recorder.decrypted(tlsConn)
+	}
+}
+
+// skipChunk passes the chunk following a BDAT command on without looking
+// for commands in it; the chunk follows without waiting for a reply.
+func (conn *tapConn) skipChunk(arg string) {
+	if fields := strings.Fields(arg); len(fields) > 0 {
+		if size, err := strconv.ParseInt(fields[0], 10, 64); err == nil && size > 0 {
+			conn.mode, conn.chunkLeft = tapChunk, size
+		}
+	}
+}
+
+// processData passes message content on up to and including the line
+// holding a single dot, then switches back to commands.
+func (conn *tapConn) processData() bool {
+	progress := false
+	for len(conn.in) > 0 {
+		end := bytes.IndexByte(conn.in, '\n')
+		if end < 0 {
+			// A partial line is kept only while it may still be the terminator
+			if conn.lineStart && len(conn.in) <= 2 && conn.in[0] == '.' {
+				break
+			}
+			conn.out = append(conn.out, conn.in...)
+			conn.in = nil
+			conn.lineStart = false
+			return true
+		}
+
+		line := conn.in[:end+1]
+		conn.in = conn.in[end+1:]
+		conn.out = append(conn.out, line...)
+		progress = true
+
+		terminator := conn.lineStart && (string(line) == ".\r\n" || string(line) == ".\n")
+		conn.lineStart = true
+		if terminator {
+			conn.mode, conn.last = tapCommand, "."
+			break
+		}
+	}
+	return progress
+}
+
+// Write sends go-smtp replies to the client, rewritten by the extension
+// and provider profiles.
+func (conn *tapConn) Write(p []byte) (int, error) {
+	if conn.awaiting == "EHLO" {
+		return conn.writeEHLO(p)
+	}
+	if provider := conn.listener.provider; provider != nil && conn.mode == tapCommand {
+		return conn.writeReworded(provider, p)
+	}
+	return conn.write(p)
+}
+
+// write sends a reply to the client as it is, watching the reply to the
+// commands that change how client bytes must be read.
+func (conn *tapConn) write(p []byte) (int, error) {
+	if conn.awaiting != "" && conn.mode == tapCommand {
+		code := lastReplyCode(p)
+		switch {
+		case conn.awaiting == "DATA" && code == "354":
+			conn.mode, conn.lineStart = tapData, true
+		case conn.awaiting == "AUTH" && code == "334":
+			conn.authReply = true
+		}
+		if code != "334" {
+			conn.awaiting = ""
+		}
+	}
+	return conn.Conn.Write(p)
+}
+
+// lastReplyCode returns the code of the last reply line in p.
+func lastReplyCode(p []byte) string {
+	lines := bytes.Split(bytes.TrimRight(p, "\r\n"), []byte("\n"))
+	last := lines[len(lines)-1]
+	if len(last) < 3 {
+		return ""
+	}
+	return string(last[:3])
+}
+
+// writeEHLO collects the lines of the EHLO reply and sends it rewritten by
+// the extension profile once the last line is written.
+func (conn *tapConn) writeEHLO(p []byte) (int, error) {
+	conn.ehlo = append(conn.ehlo, p...)
+	reply := string(conn.ehlo)
+	if !strings.HasSuffix(reply, "\n") {
+		return len(p), nil
+	}
+	lines := strings.Split(strings.TrimRight(reply, "\r\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+	}
+	if last := lines[len(lines)-1]; len(last) > 3 && last[3] == '-' {
+		return len(p), nil
+	}
+
+	conn.awaiting, conn.ehlo = "", nil
+	lines = conn.listener.extensions.rewriteEHLO(lines, conn.tlsState != nil)
+	if provider := conn.listener.provider; provider != nil && strings.HasPrefix(lines[0], "250") {
+		lines[0] = lines[0][:4] + provider.expand(provider.hello, conn.remoteIP())
+	}
+	if _, err := conn.Conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeReworded sends a reply in the wording of the provider: the banner,
+// then the replies to the commands handed to go-smtp one at a time.
+func (conn *tapConn) writeReworded(provider *providerProfile, p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\r\n")
+	switch {
+	case !conn.greeted:
+		conn.greeted = true
+		if strings.HasPrefix(line, "220 ") {
+			line = "220 " + provider.expand(provider.greeting, conn.remoteIP())
+		}
+	case strings.Contains(line, "\n"):
+		return conn.write(p) // Multi-line replies keep their wording
+	default:
+		if conn.last == "MAIL" && strings.HasPrefix(line, "250") {
+			conn.transactions++
+		}
+		line = provider.reword(conn.last, line, conn.remoteIP())
+	}
+
+	if _, err := conn.write([]byte(line + "\r\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// remoteIP returns the address of the client without the port.
+func (conn *tapConn) remoteIP() string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
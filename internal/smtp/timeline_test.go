@@ -0,0 +1,56 @@
+package smtp
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+func TestTimeline(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.Timeline = true
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	addr := fmt.Sprintf("localhost:%d", port)
+	if err := sendTestEmail(addr, "a@example.com", "b@example.com", []byte("Subject: hello\r\n\r\nhi\r\n")); err != nil {
+		t.Fatalf("sending email failed: %v", err)
+	}
+
+	emails, err := emailStorage.List(storage.ListFilter{})
+	if err != nil || len(emails) != 2 {
+		t.Fatalf("listing emails = %d, %v; want OUT and IN copies", len(emails), err)
+	}
+	want := []string{message.StageData, message.StageReceived, message.StageParsed, message.StageStored}
+	for _, email := range emails {
+		timeline := email.Metadata.Timeline
+		if len(timeline) != len(want) {
+			t.Fatalf("email %s timeline = %+v, want stages %v", email.ID, timeline, want)
+		}
+		for i, event := range timeline {
+			if event.Stage != want[i] {
+				t.Errorf("email %s stage %d = %s, want %s", email.ID, i, event.Stage, want[i])
+			}
+			if i > 0 && event.At.Before(timeline[i-1].At) {
+				t.Errorf("email %s stage %s at %s precedes the previous stage", email.ID, event.Stage, event.At)
+			}
+		}
+	}
+}
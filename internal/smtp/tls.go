@@ -0,0 +1,97 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+)
+
+// loadTLSConfig builds the STARTTLS configuration, or returns nil when no
+// certificate is configured.
+func loadTLSConfig(cfg config.SMTPTLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("TLS client CA file holds no PEM certificate")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if cfg.KeyLogFile != "" {
+		// Check the file can be written now rather than on the first handshake
+		file, err := os.OpenFile(cfg.KeyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("opening TLS key log file: %w", err)
+		}
+		file.Close()
+		tlsConfig.KeyLogWriter = &keyLog{path: cfg.KeyLogFile}
+		slog.Warn("TLS session secrets are written to the key log file, do not use in production", "file", cfg.KeyLogFile)
+	}
+	return tlsConfig, nil
+}
+
+// keyLog appends the TLS secrets written by crypto/tls to a file, opening
+// it for each line so it can be rotated or removed while running.
+type keyLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// Write appends one key log line.
+func (keys *keyLog) Write(p []byte) (int, error) {
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+
+	file, err := os.OpenFile(keys.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		slog.Warn("Writing TLS key log failed", "file", keys.path, "error", err)
+		return 0, err
+	}
+	defer file.Close()
+	return file.Write(p)
+}
+
+// connectionState returns the TLS state of conn, whether STARTTLS was
+// performed by go-smtp or by the tap.
+func connectionState(conn *smtp.Conn) (tls.ConnectionState, bool) {
+	if tapped, ok := conn.Conn().(*tapConn); ok && tapped.tlsState != nil {
+		return *tapped.tlsState, true
+	}
+	return conn.TLSConnectionState()
+}
+
+// tlsDetails returns the negotiated parameters of a TLS connection.
+func tlsDetails(state tls.ConnectionState) *message.TLS {
+	details := &message.TLS{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+	// Peer certificates are verified against the client CAs when present
+	if len(state.PeerCertificates) > 0 {
+		details.ClientSubject = state.PeerCertificates[0].Subject.String()
+	}
+	return details
+}
@@ -0,0 +1,182 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// writeTestCert writes a self-signed certificate for commonName and its key
+// in PEM format under dir, returning their paths.
+func writeTestCert(t *testing.T, dir, commonName string, usage x509.ExtKeyUsage) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate failed: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("encoding key failed: %v", err)
+	}
+
+	certFile = filepath.Join(dir, commonName+".pem")
+	keyFile = filepath.Join(dir, commonName+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("writing certificate failed: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("writing key failed: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSDetails(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(filepath.Join(dir, "mail"))
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	serverCert, serverKey := writeTestCert(t, dir, "sink", x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := writeTestCert(t, dir, "sender", x509.ExtKeyUsageClientAuth)
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.TLS = config.SMTPTLSConfig{CertFile: serverCert, KeyFile: serverKey, ClientCAFile: clientCert}
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatalf("loading client certificate failed: %v", err)
+	}
+	client, err := smtp.Dial(fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	tlsConfig := &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS13, Certificates: []tls.Certificate{cert}}
+	if err := client.StartTLS(tlsConfig); err != nil {
+		t.Fatalf("STARTTLS failed: %v", err)
+	}
+	if err := client.Mail("a@example.com", nil); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+
+	sessions := server.Sessions()
+	if len(sessions) != 1 || sessions[0].TLS == nil {
+		t.Fatalf("Sessions() = %+v, want one TLS session", sessions)
+	}
+	if sessions[0].TLS.Version != "TLS 1.3" || sessions[0].TLS.ClientSubject != "CN=sender" {
+		t.Errorf("session TLS = %+v, want TLS 1.3 from CN=sender", sessions[0].TLS)
+	}
+
+	if err := client.Rcpt("b@example.com", nil); err != nil {
+		t.Fatalf("RCPT failed: %v", err)
+	}
+	wc, err := client.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	wc.Write([]byte("Subject: secure\r\n\r\nhello\r\n"))
+	if err := wc.Close(); err != nil {
+		t.Fatalf("sending content failed: %v", err)
+	}
+	client.Quit()
+
+	emails, err := emailStorage.List(storage.ListFilter{User: "b"})
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("List() = %d emails, %v, want 1", len(emails), err)
+	}
+	details := emails[0].Metadata.TLS
+	if details == nil || details.Version != "TLS 1.3" || details.CipherSuite == "" || details.ClientSubject != "CN=sender" {
+		t.Errorf("metadata TLS = %+v, want TLS 1.3 with a cipher suite from CN=sender", details)
+	}
+}
+
+func TestTLSKeyLog(t *testing.T) {
+	port, err := getFreePort()
+	if err != nil {
+		t.Fatalf("getting free port failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	emailStorage, err := storage.NewEmailStorage(filepath.Join(dir, "mail"))
+	if err != nil {
+		t.Fatalf("creating email storage failed: %v", err)
+	}
+	serverCert, serverKey := writeTestCert(t, dir, "sink", x509.ExtKeyUsageServerAuth)
+	keyLogFile := filepath.Join(dir, "keys.log")
+
+	cfg := config.Default().SMTP
+	cfg.Port = port
+	cfg.TLS = config.SMTPTLSConfig{CertFile: serverCert, KeyFile: serverKey, KeyLogFile: keyLogFile}
+	server := NewServerFromConfig(cfg, emailStorage)
+	go server.Start()
+	defer server.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := smtp.Dial(fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer client.Close()
+	var clientKeys bytes.Buffer
+	if err := client.StartTLS(&tls.Config{InsecureSkipVerify: true, KeyLogWriter: &clientKeys}); err != nil {
+		t.Fatalf("STARTTLS failed: %v", err)
+	}
+	if err := client.Noop(); err != nil {
+		t.Fatalf("NOOP failed: %v", err)
+	}
+
+	serverKeys, err := os.ReadFile(keyLogFile)
+	if err != nil {
+		t.Fatalf("reading key log failed: %v", err)
+	}
+	if !bytes.Contains(serverKeys, []byte("CLIENT_TRAFFIC_SECRET_0 ")) {
+		t.Errorf("key log lacks the traffic secrets:\n%s", serverKeys)
+	}
+	// Both sides log the same secrets, so a capture decrypts with either
+	for _, line := range bytes.SplitAfter(clientKeys.Bytes(), []byte("\n")) {
+		if !bytes.Contains(serverKeys, line) {
+			t.Errorf("key log lacks the client line %q", line)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package smtp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Answers of the VRFY modes; "ambiguous" keeps the go-smtp replies.
+const (
+	verifyDisabled = "disabled"
+	verifyAccept   = "accept"
+	verifyStrict   = "strict"
+)
+
+// verify answers VRFY and EXPN according to the configured mode. EXPN
+// treats its argument as a list holding a single mailbox.
+func (bkd *Backend) verify(verb, arg string) string {
+	if bkd.config.VRFY == verifyDisabled {
+		return fmt.Sprintf("502 5.5.1 %s command disabled", verb)
+	}
+
+	address := arg
+	if start := strings.IndexByte(address, '<'); start >= 0 {
+		address = address[start+1:]
+		if end := strings.IndexByte(address, '>'); end >= 0 {
+			address = address[:end]
+		}
+	}
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "501 5.5.4 Missing parameter"
+	}
+	if !strings.Contains(address, "@") {
+		return "553 5.1.3 Fully qualified address required"
+	}
+
+	domain, user := parseEmailAddress(address)
+	domainStorage, ok := bkd.storageFor(domain)
+	if !ok {
+		return "550 5.1.2 Recipient domain not configured"
+	}
+
+	if bkd.config.VRFY == verifyStrict {
+		known := domainStorage.HasMailbox(domain, user)
+		for _, route := range bkd.routes {
+			known = known || route.storage.HasMailbox(domain, user)
+		}
+		if !known {
+			return "550 5.1.1 Mailbox unknown"
+		}
+	}
+	return fmt.Sprintf("250 2.1.5 <%s>", address)
+}
@@ -0,0 +1,157 @@
+// Package spool buffers message content in memory up to a threshold and
+// spills anything larger to a temporary file, bounding the memory used by
+// each SMTP transaction.
+package spool
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// filePattern names the temporary files of spilled buffers.
+const filePattern = "gargantua-spool-*.eml"
+
+// Buffer holds the content of one message. It starts with a single
+// reference; the temporary file, if any, is removed once every reference
+// has been released.
+type Buffer struct {
+	dir       string
+	threshold int64
+
+	mem  bytes.Buffer
+	file *os.File
+	size int64
+
+	mu   sync.Mutex
+	refs int
+}
+
+// New creates a buffer keeping up to threshold bytes in memory before
+// spilling to a temporary file in dir, or the system temporary directory
+// when dir is empty.
+func New(dir string, threshold int64) *Buffer {
+	return &Buffer{
+		dir:       dir,
+		threshold: threshold,
+		refs:      1,
+	}
+}
+
+// Write appends p, moving the content to disk once it exceeds the threshold.
+func (buf *Buffer) Write(p []byte) (int, error) {
+	if buf.file == nil && int64(buf.mem.Len()+len(p)) > buf.threshold {
+		if err := buf.spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	var err error
+	if buf.file != nil {
+		n, err = buf.file.Write(p)
+	} else {
+		n, err = buf.mem.Write(p)
+	}
+	buf.size += int64(n)
+	return n, err
+}
+
+// spill moves the in-memory content to a new temporary file.
+func (buf *Buffer) spill() error {
+	file, err := os.CreateTemp(buf.dir, filePattern)
+	if err != nil {
+		return fmt.Errorf("creating spool file: %w", err)
+	}
+	if _, err := file.Write(buf.mem.Bytes()); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("writing spool file: %w", err)
+	}
+
+	buf.file = file
+	buf.mem = bytes.Buffer{}
+	return nil
+}
+
+// Size returns the number of bytes written.
+func (buf *Buffer) Size() int64 {
+	return buf.size
+}
+
+// Spilled reports whether the content was moved to disk.
+func (buf *Buffer) Spilled() bool {
+	return buf.file != nil
+}
+
+// Open returns a reader over the whole content. Writing must be finished.
+func (buf *Buffer) Open() (io.ReadCloser, error) {
+	if buf.file == nil {
+		return io.NopCloser(bytes.NewReader(buf.mem.Bytes())), nil
+	}
+
+	file, err := os.Open(buf.file.Name())
+	if err != nil {
+		return nil, fmt.Errorf("opening spool file: %w", err)
+	}
+	return file, nil
+}
+
+// Acquire adds a reference, keeping the content available until the
+// matching Release, e.g. for a background delivery.
+func (buf *Buffer) Acquire() {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	buf.refs++
+}
+
+// Release drops a reference and removes the temporary file after the last one.
+func (buf *Buffer) Release() error {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	buf.refs--
+	if buf.refs > 0 || buf.file == nil {
+		return nil
+	}
+
+	name := buf.file.Name()
+	buf.file.Close()
+	if err := os.Remove(name); err != nil {
+		return fmt.Errorf("removing spool file: %w", err)
+	}
+	return nil
+}
+
+// Sweep removes the spool files left in dir, or the system temporary
+// directory when empty, by a process that crashed mid-transaction, and
+// returns how many were removed. Files modified within olderThan are kept
+// as another process may still use them. Their transactions were never
+// acknowledged, so the senders retried them.
+func Sweep(dir string, olderThan time.Duration) (int, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, filePattern))
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	removed := 0
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("removing spool file: %w", err)
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,115 @@
+package spool
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, buf *Buffer) []byte {
+	t.Helper()
+
+	r, err := buf.Open()
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	defer r.Close()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading buffer failed: %v", err)
+	}
+	return content
+}
+
+func TestBufferInMemory(t *testing.T) {
+	dir := t.TempDir()
+	buf := New(dir, 16)
+
+	if _, err := buf.Write([]byte("small")); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	if buf.Spilled() {
+		t.Error("buffer spilled below the threshold")
+	}
+	if got := readAll(t, buf); string(got) != "small" {
+		t.Errorf("content = %q, want small", got)
+	}
+	if err := buf.Release(); err != nil {
+		t.Errorf("Release() failed: %v", err)
+	}
+}
+
+func TestBufferSpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	buf := New(dir, 16)
+
+	content := bytes.Repeat([]byte("0123456789"), 10)
+	for i := 0; i < len(content); i += 7 {
+		end := min(i+7, len(content))
+		if _, err := buf.Write(content[i:end]); err != nil {
+			t.Fatalf("Write() failed: %v", err)
+		}
+	}
+
+	if !buf.Spilled() {
+		t.Fatal("buffer did not spill above the threshold")
+	}
+	if buf.Size() != int64(len(content)) {
+		t.Errorf("Size() = %d, want %d", buf.Size(), len(content))
+	}
+	if got := readAll(t, buf); !bytes.Equal(got, content) {
+		t.Error("spilled content differs from the written content")
+	}
+
+	buf.Acquire()
+	if err := buf.Release(); err != nil {
+		t.Fatalf("Release() failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("spool file removed while still referenced")
+	}
+
+	if err := buf.Release(); err != nil {
+		t.Fatalf("Release() failed: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spool file left behind after the last release")
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+
+	stale := filepath.Join(dir, "gargantua-spool-1.eml")
+	recent := filepath.Join(dir, "gargantua-spool-2.eml")
+	other := filepath.Join(dir, "unrelated.eml")
+	old := time.Now().Add(-time.Hour)
+	for _, path := range []string{stale, recent, other} {
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		if path != recent {
+			os.Chtimes(path, old, old)
+		}
+	}
+
+	removed, err := Sweep(dir, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Sweep() failed: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Sweep() removed %d files, want 1", removed)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("stale spool file was kept")
+	}
+	for _, path := range []string{recent, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is returned by writes failed on purpose by Faults.
+var ErrInjectedFault = errors.New("injected storage fault")
+
+// FaultSettings describe the degradation injected into email writes.
+type FaultSettings struct {
+	Latency   time.Duration // Added to every write
+	Jitter    time.Duration // Random extra latency, up to this value
+	ErrorRate float64       // Fraction of writes failing with ErrInjectedFault, 0 to 1
+}
+
+// ParseFaults parses fault settings written as comma-separated key=value
+// pairs, e.g. "latency=200ms,jitter=50ms,error_rate=0.1". Omitted keys are zero.
+func ParseFaults(spec string) (FaultSettings, error) {
+	var settings FaultSettings
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return settings, fmt.Errorf("invalid fault %q, want key=value", pair)
+		}
+
+		var err error
+		switch strings.TrimSpace(key) {
+		case "latency":
+			settings.Latency, err = time.ParseDuration(strings.TrimSpace(value))
+		case "jitter":
+			settings.Jitter, err = time.ParseDuration(strings.TrimSpace(value))
+		case "error_rate":
+			settings.ErrorRate, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		default:
+			return settings, fmt.Errorf("unknown fault %q, want latency, jitter or error_rate", key)
+		}
+		if err != nil {
+			return settings, fmt.Errorf("parsing fault %s: %w", key, err)
+		}
+	}
+	return settings, settings.validate()
+}
+
+// String formats the settings in the ParseFaults syntax.
+func (settings FaultSettings) String() string {
+	return fmt.Sprintf("latency=%s,jitter=%s,error_rate=%g", settings.Latency, settings.Jitter, settings.ErrorRate)
+}
+
+// validate checks that durations are not negative and the error rate is a fraction.
+func (settings FaultSettings) validate() error {
+	if settings.Latency < 0 || settings.Jitter < 0 {
+		return errors.New("fault latency and jitter must not be negative")
+	}
+	if settings.ErrorRate < 0 || settings.ErrorRate > 1 {
+		return fmt.Errorf("invalid fault error_rate %g, want 0 to 1", settings.ErrorRate)
+	}
+	return nil
+}
+
+// Faults injects latency and errors into the email writes of the storages
+// it is attached to, to check how the server behaves when storage degrades.
+// It is meant for testing only. It is safe for concurrent use, so the
+// settings can change while the server runs.
+type Faults struct {
+	mu       sync.Mutex
+	settings FaultSettings
+	random   func() float64
+}
+
+// NewFaults creates a fault injector with the given settings.
+func NewFaults(settings FaultSettings) (*Faults, error) {
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	return &Faults{settings: settings, random: rand.Float64}, nil
+}
+
+// Settings returns the current settings.
+func (faults *Faults) Settings() FaultSettings {
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+	return faults.settings
+}
+
+// Set replaces the settings; writes already delayed keep their latency.
+func (faults *Faults) Set(settings FaultSettings) error {
+	if err := settings.validate(); err != nil {
+		return err
+	}
+
+	faults.mu.Lock()
+	defer faults.mu.Unlock()
+	faults.settings = settings
+	return nil
+}
+
+// inject delays the caller by the configured latency and jitter, then
+// fails with ErrInjectedFault at the configured rate.
+func (faults *Faults) inject() error {
+	faults.mu.Lock()
+	settings := faults.settings
+	delay := settings.Latency + time.Duration(faults.random()*float64(settings.Jitter))
+	fail := faults.random() < settings.ErrorRate
+	faults.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	if fail {
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+// InjectFaults makes every following email write of the storage go through
+// faults; nil removes the injection. It must not be called concurrently
+// with writes.
+func (storage *EmailStorage) InjectFaults(faults *Faults) {
+	storage.faults = faults
+}
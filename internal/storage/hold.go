@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ErrOnHold is returned when deleting an email under legal hold.
+var ErrOnHold = errors.New("email is on hold")
+
+// mailboxHoldFile is kept in a mailbox directory while the mailbox is on hold.
+const mailboxHoldFile = ".hold.json"
+
+// Hold records why an email or mailbox must not be deleted.
+type Hold struct {
+	Reason string    `json:"reason"`
+	By     string    `json:"by,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// MailboxHold is a hold placed on every email of a mailbox.
+type MailboxHold struct {
+	Domain string `json:"domain"`
+	User   string `json:"user"`
+	Hold
+}
+
+// HoldMailbox places every email of domain/user, present and future, on hold.
+func (storage *EmailStorage) HoldMailbox(domain, user string, hold Hold) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	dir := filepath.Join(storage.rootPath, domain, user)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating mailbox directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(hold, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding hold: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, mailboxHoldFile), data, 0644); err != nil {
+		return fmt.Errorf("writing hold file: %w", err)
+	}
+	return nil
+}
+
+// ReleaseMailbox lifts the hold of domain/user, returning ErrNotFound when
+// the mailbox is not on hold.
+func (storage *EmailStorage) ReleaseMailbox(domain, user string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	err := os.Remove(filepath.Join(storage.rootPath, domain, user, mailboxHoldFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("removing hold file: %w", err)
+	}
+	return nil
+}
+
+// MailboxHolds returns every mailbox on hold, sorted by domain and user.
+func (storage *EmailStorage) MailboxHolds() ([]MailboxHold, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	paths, err := filepath.Glob(filepath.Join(storage.rootPath, "*", "*", mailboxHoldFile))
+	if err != nil {
+		return nil, err
+	}
+
+	holds := make([]MailboxHold, 0, len(paths))
+	for _, path := range paths {
+		hold, err := readHold(path)
+		if err != nil {
+			return nil, err
+		}
+		mailbox := filepath.Dir(path)
+		holds = append(holds, MailboxHold{
+			Domain: filepath.Base(filepath.Dir(mailbox)),
+			User:   filepath.Base(mailbox),
+			Hold:   *hold,
+		})
+	}
+
+	sort.Slice(holds, func(i, j int) bool {
+		if holds[i].Domain != holds[j].Domain {
+			return holds[i].Domain < holds[j].Domain
+		}
+		return holds[i].User < holds[j].User
+	})
+	return holds, nil
+}
+
+// OnHold reports whether the email or its mailbox is on hold.
+func (storage *EmailStorage) OnHold(email StoredEmail) (bool, error) {
+	if email.Metadata.Hold != nil {
+		return true, nil
+	}
+
+	hold, err := readHold(filepath.Join(storage.rootPath, email.Domain, email.User, mailboxHoldFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return hold != nil, err
+}
+
+// readHold loads a mailbox hold file.
+func readHold(path string) (*Hold, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hold Hold
+	if err := json.Unmarshal(data, &hold); err != nil {
+		return nil, fmt.Errorf("parsing hold file: %w", err)
+	}
+	return &hold, nil
+}
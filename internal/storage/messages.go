@@ -0,0 +1,549 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+)
+
+// ErrNotFound is returned when no stored email has the requested ID.
+var ErrNotFound = errors.New("email not found")
+
+// FolderInbox names the inbox in list filters; the emails in it have no
+// folder in their metadata.
+const FolderInbox = "Inbox"
+
+const (
+	emailExt    = ".eml"
+	metadataExt = ".json"
+	tempExt     = ".tmp" // Files being written, renamed into place once complete
+)
+
+// StoredEmail describes an email file in the storage tree.
+type StoredEmail struct {
+	ID         string    `json:"id"`
+	Domain     string    `json:"domain"`
+	User       string    `json:"user"`
+	Direction  Direction `json:"direction"`
+	Subject    string    `json:"subject"`
+	Size       int64     `json:"size"`
+	ReceivedAt time.Time `json:"received_at"`
+	Metadata   Metadata  `json:"metadata"`
+
+	path string
+}
+
+// Metadata holds mutable information kept next to an email in a JSON sidecar file.
+type Metadata struct {
+	Tags     []string          `json:"tags,omitempty"`
+	Verdicts []message.Verdict `json:"verdicts,omitempty"`
+	Shadow   *ShadowDelivery   `json:"shadow,omitempty"`
+	Hold     *Hold             `json:"hold,omitempty"`     // Legal hold preventing deletion
+	TLS      *message.TLS      `json:"tls,omitempty"`      // TLS connection the email was received over
+	Language string            `json:"language,omitempty"` // Detected body language, e.g. de
+	Folder   string            `json:"folder,omitempty"`   // Mailbox folder, e.g. Junk; empty for the inbox
+	Approval *Approval         `json:"approval,omitempty"` // Pending or past decision to forward the email
+
+	// Timeline lists when the email went through each processing stage
+	Timeline []message.TimelineEvent `json:"timeline,omitempty"`
+}
+
+// Mark records that the email reached stage at the given time. Emails
+// stored without a timeline are left alone.
+func (metadata *Metadata) Mark(stage, detail string, at time.Time) {
+	if len(metadata.Timeline) == 0 {
+		return
+	}
+	metadata.Timeline = append(metadata.Timeline, message.TimelineEvent{Stage: stage, At: at, Detail: detail})
+}
+
+// ShadowDelivery records how the shadow server handled a copy of the email.
+type ShadowDelivery struct {
+	Target     string    `json:"target"`
+	Accepted   bool      `json:"accepted"`
+	DurationMS int64     `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// Approval states.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// Approval tracks an email held until a person decides whether it is
+// forwarded to its real recipients.
+type Approval struct {
+	State       string     `json:"state"`
+	From        string     `json:"from"` // Envelope the email is forwarded with
+	To          []string   `json:"to"`
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	Reason      string     `json:"reason,omitempty"` // Given when rejecting
+	Error       string     `json:"error,omitempty"`  // Last forwarding failure, the email stays pending
+}
+
+// ListFilter restricts the emails returned by List. Zero values match everything.
+type ListFilter struct {
+	Domain    string
+	User      string
+	Direction *Direction
+	Tag       string
+	Language  string // ISO 639-1 code, e.g. de
+	Folder    string // Mailbox folder, e.g. Junk; FolderInbox selects the emails in no folder
+
+	// Query is matched case-insensitively against the subject, addresses and
+	// text body. It requires parsing every candidate, so it is applied last.
+	Query string
+}
+
+// MarshalText encodes the direction as IN or OUT.
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes IN or OUT, case-insensitively.
+func (d *Direction) UnmarshalText(text []byte) error {
+	direction, err := ParseDirection(string(text))
+	if err != nil {
+		return err
+	}
+	*d = direction
+	return nil
+}
+
+// ParseDirection parses IN or OUT, case-insensitively.
+func ParseDirection(s string) (Direction, error) {
+	switch strings.ToUpper(s) {
+	case "IN":
+		return Incoming, nil
+	case "OUT":
+		return Outgoing, nil
+	default:
+		return 0, fmt.Errorf("invalid direction %q (want IN or OUT)", s)
+	}
+}
+
+// Probe checks that emails for domain can be written by creating and
+// removing a temporary file in the domain directory.
+func (storage *EmailStorage) Probe(domain string) error {
+	dir := filepath.Join(storage.rootPath, domain)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating domain directory: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return fmt.Errorf("creating probe file: %w", err)
+	}
+	_, err = file.Write([]byte("probe"))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(file.Name())
+	if err != nil {
+		return fmt.Errorf("writing probe file: %w", err)
+	}
+	return nil
+}
+
+// HasMailbox reports whether the mailbox of user at domain holds any email
+// or was created by a previous delivery.
+func (storage *EmailStorage) HasMailbox(domain, user string) bool {
+	for _, name := range []string{domain, user} {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return false
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(storage.rootPath, domain, user))
+	return err == nil && info.IsDir()
+}
+
+// Root returns the root directory of the storage.
+func (storage *EmailStorage) Root() string {
+	return storage.rootPath
+}
+
+// List returns the stored emails matching filter, newest first.
+func (storage *EmailStorage) List(filter ListFilter) ([]StoredEmail, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	emails, err := storage.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := emails[:0]
+	for _, email := range emails {
+		if filter.matches(email) && (filter.Query == "" || matchesQuery(email, filter.Query)) {
+			matched = append(matched, email)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].ReceivedAt.Equal(matched[j].ReceivedAt) {
+			return matched[i].ReceivedAt.After(matched[j].ReceivedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+	return matched, nil
+}
+
+// Get returns the stored email with the given ID.
+func (storage *EmailStorage) Get(id string) (StoredEmail, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	return storage.lookup(id)
+}
+
+// ReadContent returns the raw content of the stored email with the given ID.
+func (storage *EmailStorage) ReadContent(id string) ([]byte, error) {
+	email, err := storage.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(email.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading email file: %w", err)
+	}
+	return content, nil
+}
+
+// ReadMessage parses the stored email with the given ID. The envelope is not
+// stored, so only the mailbox the copy was filed under is known.
+func (storage *EmailStorage) ReadMessage(id string) (*message.Message, error) {
+	email, err := storage.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := message.Parse(message.Envelope{}, fileBody{path: email.path, size: email.Size})
+	msg.ReceivedAt = email.ReceivedAt
+	msg.Tags = email.Metadata.Tags
+	msg.Verdicts = email.Metadata.Verdicts
+	msg.Language = email.Metadata.Language
+	msg.Folder = email.Metadata.Folder
+	return msg, nil
+}
+
+// fileBody streams the content of a stored email from disk.
+type fileBody struct {
+	path string
+	size int64
+}
+
+// Open opens the email file.
+func (body fileBody) Open() (io.ReadCloser, error) {
+	file, err := os.Open(body.path)
+	if err != nil {
+		return nil, fmt.Errorf("reading email file: %w", err)
+	}
+	return file, nil
+}
+
+// Size returns the email file size.
+func (body fileBody) Size() int64 {
+	return body.size
+}
+
+// Delete removes the stored email with the given ID and its metadata.
+// It returns ErrOnHold when the email or its mailbox is on hold.
+func (storage *EmailStorage) Delete(id string) error {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	email, err := storage.lookup(id)
+	if err != nil {
+		return err
+	}
+	if held, err := storage.OnHold(email); err != nil {
+		return err
+	} else if held {
+		return ErrOnHold
+	}
+
+	if err := os.Remove(email.path); err != nil {
+		return fmt.Errorf("removing email file: %w", err)
+	}
+	if err := os.Remove(email.path + metadataExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing metadata file: %w", err)
+	}
+
+	delete(storage.index, id)
+	return nil
+}
+
+// UpdateMetadata applies update to the metadata of the stored email with the given ID.
+func (storage *EmailStorage) UpdateMetadata(id string, update func(*Metadata)) (Metadata, error) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	email, err := storage.lookup(id)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	update(&email.Metadata)
+	if err := writeMetadata(email.path, email.Metadata); err != nil {
+		return Metadata{}, err
+	}
+	return email.Metadata, nil
+}
+
+// lookup resolves an ID through the index, rescanning the tree on a miss
+// so files written by other processes are found. Callers hold storage.mu.
+func (storage *EmailStorage) lookup(id string) (StoredEmail, error) {
+	if path, ok := storage.index[id]; ok {
+		if email, err := readStoredEmail(storage.rootPath, path); err == nil {
+			return email, nil
+		}
+	}
+
+	if _, err := storage.scan(); err != nil {
+		return StoredEmail{}, err
+	}
+
+	path, ok := storage.index[id]
+	if !ok {
+		return StoredEmail{}, ErrNotFound
+	}
+	return readStoredEmail(storage.rootPath, path)
+}
+
+// scan walks the storage tree, rebuilding the ID index. Callers hold storage.mu.
+func (storage *EmailStorage) scan() ([]StoredEmail, error) {
+	var emails []StoredEmail
+	index := make(map[string]string)
+
+	err := filepath.WalkDir(storage.rootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || filepath.Ext(path) != emailExt {
+			return nil
+		}
+
+		email, err := readStoredEmail(storage.rootPath, path)
+		if err != nil {
+			return nil // Not part of the domain/user/direction layout
+		}
+
+		index[email.ID] = path
+		emails = append(emails, email)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scanning storage: %w", err)
+	}
+
+	storage.index = index
+	return emails, nil
+}
+
+// readStoredEmail describes the email file at path, which must follow the
+// rootPath/domain/user/IN|OUT/YYYYMMDDHHMMSS-[unique-id]-subject.eml layout.
+func readStoredEmail(rootPath, path string) (StoredEmail, error) {
+	rel, err := filepath.Rel(rootPath, path)
+	if err != nil {
+		return StoredEmail{}, err
+	}
+
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 4 {
+		return StoredEmail{}, fmt.Errorf("unexpected email path %s", rel)
+	}
+
+	direction, err := ParseDirection(parts[2])
+	if err != nil {
+		return StoredEmail{}, err
+	}
+
+	id, subject, receivedAt, err := parseEmailFilename(parts[3])
+	if err != nil {
+		return StoredEmail{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return StoredEmail{}, err
+	}
+
+	metadata, err := readMetadata(path)
+	if err != nil {
+		return StoredEmail{}, err
+	}
+
+	return StoredEmail{
+		ID:         id,
+		Domain:     parts[0],
+		User:       parts[1],
+		Direction:  direction,
+		Subject:    subject,
+		Size:       info.Size(),
+		ReceivedAt: receivedAt,
+		Metadata:   metadata,
+		path:       path,
+	}, nil
+}
+
+// parseEmailFilename splits YYYYMMDDHHMMSS-[unique-id]-subject.eml into its
+// ID, subject and timestamp. The ID is the unique ID when it is a ULID, or
+// the timestamp and unique ID for emails stored by earlier versions.
+func parseEmailFilename(name string) (id, subject string, receivedAt time.Time, err error) {
+	fields := strings.SplitN(strings.TrimSuffix(name, emailExt), "-", 3)
+	if len(fields) != 3 {
+		return "", "", time.Time{}, fmt.Errorf("unexpected email filename %s", name)
+	}
+
+	receivedAt, err = time.ParseInLocation("20060102150405", fields[0], time.Local)
+	if err != nil {
+		return "", "", time.Time{}, fmt.Errorf("unexpected email filename %s: %w", name, err)
+	}
+
+	if isULID(fields[1]) {
+		return fields[1], fields[2], receivedAt, nil
+	}
+	return fields[0] + "-" + fields[1], fields[2], receivedAt, nil
+}
+
+// readMetadata loads the sidecar metadata of an email, if any.
+func readMetadata(emailPath string) (Metadata, error) {
+	var metadata Metadata
+
+	data, err := os.ReadFile(emailPath + metadataExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return metadata, nil
+	}
+	if err != nil {
+		return metadata, fmt.Errorf("reading metadata file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return metadata, fmt.Errorf("parsing metadata file: %w", err)
+	}
+	return metadata, nil
+}
+
+// writeMetadata saves the sidecar metadata of an email.
+func writeMetadata(emailPath string, metadata Metadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding metadata: %w", err)
+	}
+
+	path := emailPath + metadataExt
+	if err := os.WriteFile(path+tempExt, data, 0644); err != nil {
+		os.Remove(path + tempExt)
+		return fmt.Errorf("writing metadata file: %w", err)
+	}
+	if err := os.Rename(path+tempExt, path); err != nil {
+		return fmt.Errorf("writing metadata file: %w", err)
+	}
+	return nil
+}
+
+// matches reports whether an email satisfies the filter.
+func (filter ListFilter) matches(email StoredEmail) bool {
+	if filter.Domain != "" && !strings.EqualFold(filter.Domain, email.Domain) {
+		return false
+	}
+	if filter.User != "" && !strings.EqualFold(filter.User, email.User) {
+		return false
+	}
+	if filter.Direction != nil && *filter.Direction != email.Direction {
+		return false
+	}
+	if filter.Tag != "" && !email.Metadata.HasTag(filter.Tag) {
+		return false
+	}
+	if filter.Language != "" && !strings.EqualFold(filter.Language, email.Metadata.Language) {
+		return false
+	}
+	if filter.Folder != "" && !strings.EqualFold(filter.Folder, email.Metadata.folder()) {
+		return false
+	}
+	return true
+}
+
+// matchesQuery reports whether the subject, From, To or Cc headers or text
+// body of an email contain query, ignoring case.
+func matchesQuery(email StoredEmail, query string) bool {
+	query = strings.ToLower(query)
+	if strings.Contains(strings.ToLower(email.Subject), query) {
+		return true
+	}
+
+	msg := message.Parse(message.Envelope{}, fileBody{path: email.path, size: email.Size})
+	fields := []string{msg.Subject, msg.Text()}
+	for _, header := range []string{"From", "To", "Cc"} {
+		fields = append(fields, msg.Header.Get(header))
+	}
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
+}
+
+// folder returns the folder of the email, FolderInbox when it is in none.
+func (metadata Metadata) folder() string {
+	if metadata.Folder == "" {
+		return FolderInbox
+	}
+	return metadata.Folder
+}
+
+// HasTag reports whether the metadata carries the tag.
+func (metadata Metadata) HasTag(tag string) bool {
+	for _, t := range metadata.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTags adds tags not already present, keeping them sorted.
+func (metadata *Metadata) AddTags(tags ...string) {
+	for _, tag := range tags {
+		if tag != "" && !metadata.HasTag(tag) {
+			metadata.Tags = append(metadata.Tags, tag)
+		}
+	}
+	sort.Strings(metadata.Tags)
+}
+
+// RemoveTags removes the given tags.
+func (metadata *Metadata) RemoveTags(tags ...string) {
+	kept := metadata.Tags[:0]
+	for _, t := range metadata.Tags {
+		remove := false
+		for _, tag := range tags {
+			if t == tag {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			kept = append(kept, t)
+		}
+	}
+	metadata.Tags = kept
+}
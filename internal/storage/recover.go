@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// quarantineDir holds the files set aside by Recover, under the storage root.
+const quarantineDir = ".quarantine"
+
+// RecoveryReport summarizes the leftovers of an interrupted run found by Recover.
+type RecoveryReport struct {
+	// Quarantined lists the incomplete emails and orphaned metadata moved to
+	// the quarantine directory, relative to the storage root. Incomplete
+	// emails were never acknowledged, so their senders retried them.
+	Quarantined []string
+	Completed   int // Metadata updates finished from a complete temporary file
+	Removed     int // Leftover probe files deleted
+	Skipped     int // Recent leftovers kept, as another process may still be writing them
+}
+
+// Clean reports whether nothing was left behind.
+func (report RecoveryReport) Clean() bool {
+	return len(report.Quarantined) == 0 && report.Completed == 0 && report.Removed == 0 && report.Skipped == 0
+}
+
+// QuarantinePath returns the directory holding quarantined files.
+func (storage *EmailStorage) QuarantinePath() string {
+	return filepath.Join(storage.rootPath, quarantineDir)
+}
+
+// Recover finds the files left behind by a crash and repairs the storage:
+// incomplete email files are moved to the quarantine directory, interrupted
+// metadata updates are completed when the new metadata is whole, metadata
+// without its email is quarantined and probe files are removed. Only files
+// last modified more than olderThan ago are touched, so it is safe while a
+// previous instance is still draining during a handoff.
+func (storage *EmailStorage) Recover(olderThan time.Duration) (RecoveryReport, error) {
+	var report RecoveryReport
+	cutoff := time.Now().Add(-olderThan)
+
+	err := filepath.WalkDir(storage.rootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != storage.rootPath && entry.Name() == quarantineDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		name := entry.Name()
+		isProbe := strings.HasPrefix(name, ".probe-")
+		isTemp := strings.HasSuffix(name, emailExt+tempExt) || strings.HasSuffix(name, emailExt+metadataExt+tempExt)
+		isOrphan := strings.HasSuffix(name, emailExt+metadataExt) && !exists(strings.TrimSuffix(path, metadataExt))
+		if !isProbe && !isTemp && !isOrphan {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			report.Skipped++
+			return nil
+		}
+
+		switch {
+		case isProbe:
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("removing probe file: %w", err)
+			}
+			report.Removed++
+		case strings.HasSuffix(name, metadataExt+tempExt) && completeMetadata(path):
+			if err := os.Rename(path, strings.TrimSuffix(path, tempExt)); err != nil {
+				return fmt.Errorf("completing metadata file: %w", err)
+			}
+			report.Completed++
+		default:
+			rel, err := storage.quarantine(path)
+			if err != nil {
+				return err
+			}
+			report.Quarantined = append(report.Quarantined, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("recovering storage %s: %w", storage.rootPath, err)
+	}
+	return report, nil
+}
+
+// quarantine moves a file to the quarantine directory, keeping its path
+// relative to the root, and returns that relative path.
+func (storage *EmailStorage) quarantine(path string) (string, error) {
+	rel, err := filepath.Rel(storage.rootPath, path)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(storage.QuarantinePath(), rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", fmt.Errorf("creating quarantine directory: %w", err)
+	}
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("quarantining %s: %w", rel, err)
+	}
+	return rel, nil
+}
+
+// completeMetadata reports whether the temporary metadata file at path is
+// valid and belongs to an existing email.
+func completeMetadata(path string) bool {
+	emailPath := strings.TrimSuffix(path, metadataExt+tempExt)
+	if !exists(emailPath) {
+		return false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	var metadata Metadata
+	return json.Unmarshal(data, &metadata) == nil
+}
+
+// exists reports whether a file exists at path.
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
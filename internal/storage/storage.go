@@ -2,14 +2,15 @@
 package storage
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sync"
 	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
 )
 
 // Direction represents the flow of an email (incoming or outgoing)
@@ -37,6 +38,8 @@ func (d Direction) String() string {
 type EmailStorage struct {
 	rootPath string
 	mu       sync.Mutex
+	index    map[string]string // Email ID to file path, rebuilt by scan
+	faults   *Faults           // Injected write degradation, for testing only
 }
 
 var (
@@ -44,13 +47,6 @@ var (
 	safeFilename = regexp.MustCompile(`[^a-zA-Z0-9-.]`)
 )
 
-// generateUniqueID generates a random 8-character hex string
-func generateUniqueID() string {
-	b := make([]byte, 4)
-	rand.Read(b)
-	return hex.EncodeToString(b)
-}
-
 // NewEmailStorage creates a new storage instance with the specified root directory.
 // It ensures the storage directory exists and is accessible.
 func NewEmailStorage(rootPath string) (*EmailStorage, error) {
@@ -67,26 +63,105 @@ func NewEmailStorage(rootPath string) (*EmailStorage, error) {
 // The email is stored in the following structure:
 // rootPath/domain/user/IN|OUT/YYYYMMDDHHMMSS-[unique-id]-subject.eml
 func (storage *EmailStorage) StoreEmail(direction Direction, domain, user, subject string, content []byte) error {
+	_, err := storage.Store(direction, domain, user, subject, content)
+	return err
+}
+
+// Store saves an email message like StoreEmail and returns its ID.
+func (storage *EmailStorage) Store(direction Direction, domain, user, subject string, content []byte) (string, error) {
+	return storage.store(direction, domain, user, subject, message.Bytes(content), nil)
+}
+
+// StoreMessage saves a parsed message like Store, keeping its tags,
+// verdicts, language, timeline and the TLS details of its envelope in the
+// metadata sidecar. The folder of the message only applies to incoming
+// copies, and the timeline gains the time the copy was written.
+func (storage *EmailStorage) StoreMessage(direction Direction, domain, user, subject string, msg *message.Message) (string, error) {
+	folder := msg.Folder
+	if direction != Incoming {
+		folder = ""
+	}
+
+	var metadata *Metadata
+	if len(msg.Tags) > 0 || len(msg.Verdicts) > 0 || msg.Envelope.TLS != nil || msg.Language != "" || folder != "" || len(msg.Timeline) > 0 {
+		metadata = &Metadata{Verdicts: msg.Verdicts, TLS: msg.Envelope.TLS, Language: msg.Language, Folder: folder}
+		metadata.AddTags(msg.Tags...)
+		if len(msg.Timeline) > 0 {
+			metadata.Timeline = append([]message.TimelineEvent(nil), msg.Timeline...)
+		}
+	}
+	return storage.store(direction, domain, user, subject, msg.Body, metadata)
+}
+
+// store writes an email file, streaming its content from body, and, when
+// given, its metadata.
+func (storage *EmailStorage) store(direction Direction, domain, user, subject string, body message.Body, metadata *Metadata) (string, error) {
+	// Faults are injected outside the lock so latency does not serialize writers
+	if storage.faults != nil {
+		if err := storage.faults.inject(); err != nil {
+			return "", err
+		}
+	}
+
 	storage.mu.Lock()
 	defer storage.mu.Unlock()
 
 	// Create safe filename from subject
 	safeSubject := safeFilename.ReplaceAllString(subject, "_")
-	timestamp := time.Now().Format("20060102150405")
-	uniqueID := generateUniqueID()
-	filename := fmt.Sprintf("%s-%s-%s.eml", timestamp, uniqueID, safeSubject)
+	// The timestamp keeps directory listings readable; the ULID is the ID
+	now := time.Now()
+	id := newULID(now)
+	filename := fmt.Sprintf("%s-%s-%s.eml", now.Format("20060102150405"), id, safeSubject)
 
 	// Create direction-specific directory
 	dirPath := filepath.Join(storage.rootPath, domain, user, direction.String())
 	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return fmt.Errorf("creating direction directory: %w", err)
+		return "", fmt.Errorf("creating direction directory: %w", err)
 	}
 
 	// Write email file
 	emailPath := filepath.Join(dirPath, filename)
-	if err := os.WriteFile(emailPath, content, 0644); err != nil {
-		return fmt.Errorf("writing email file: %w", err)
+	if err := writeEmailFile(emailPath, body); err != nil {
+		return "", fmt.Errorf("writing email file: %w", err)
+	}
+	if metadata != nil {
+		if len(metadata.Timeline) > 0 {
+			metadata.Mark(message.StageStored, "", time.Now())
+		}
+		if err := writeMetadata(emailPath, *metadata); err != nil {
+			return "", err
+		}
+	}
+
+	if storage.index != nil {
+		storage.index[id] = emailPath
+	}
+
+	return id, nil
+}
+
+// writeEmailFile copies body to a new file at path. The content is written
+// to a temporary file first, so a crash never leaves a truncated email.
+func writeEmailFile(path string, body message.Body) error {
+	r, err := body.Open()
+	if err != nil {
+		return err
 	}
+	defer r.Close()
 
-	return nil
+	tempPath := path + tempExt
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, path)
 }
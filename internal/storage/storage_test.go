@@ -2,10 +2,16 @@ package storage
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
 )
 
 func TestNewEmailStorage(t *testing.T) {
@@ -187,3 +193,370 @@ func TestConcurrentStorage(t *testing.T) {
 		t.Errorf("Expected %d files in OUT directory, got %d", expectedPerDirection, len(outFiles))
 	}
 }
+
+func TestListGetDelete(t *testing.T) {
+	storage, err := NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if err := storage.StoreEmail(Incoming, "example.com", "john", "from-a", []byte("first")); err != nil {
+		t.Fatalf("Failed to store email: %v", err)
+	}
+	if err := storage.StoreEmail(Outgoing, "example.com", "jane", "to-b", []byte("second")); err != nil {
+		t.Fatalf("Failed to store email: %v", err)
+	}
+
+	all, err := storage.List(ListFilter{})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("List() returned %d emails, want 2", len(all))
+	}
+
+	incoming := Incoming
+	filtered, err := storage.List(ListFilter{Direction: &incoming})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].User != "john" || filtered[0].Subject != "from-a" {
+		t.Fatalf("List(IN) = %+v, want john's email", filtered)
+	}
+
+	id := filtered[0].ID
+	content, err := storage.ReadContent(id)
+	if err != nil || !bytes.Equal(content, []byte("first")) {
+		t.Errorf("ReadContent() = %q, %v; want first", content, err)
+	}
+
+	metadata, err := storage.UpdateMetadata(id, func(m *Metadata) { m.AddTags("b", "a", "a") })
+	if err != nil {
+		t.Fatalf("UpdateMetadata() error = %v", err)
+	}
+	if len(metadata.Tags) != 2 || metadata.Tags[0] != "a" {
+		t.Errorf("Tags = %v, want [a b]", metadata.Tags)
+	}
+
+	tagged, err := storage.List(ListFilter{Tag: "a"})
+	if err != nil || len(tagged) != 1 || tagged[0].ID != id {
+		t.Errorf("List(tag=a) = %+v, %v; want the tagged email", tagged, err)
+	}
+
+	if err := storage.Delete(id); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := storage.Get(id); err != ErrNotFound {
+		t.Errorf("Get() after delete error = %v, want ErrNotFound", err)
+	}
+
+	files, _ := os.ReadDir(filepath.Join(storage.Root(), "example.com", "john", "IN"))
+	if len(files) != 0 {
+		t.Errorf("expected email and metadata files to be removed, found %d files", len(files))
+	}
+}
+
+func TestHoldPreventsDelete(t *testing.T) {
+	storage, err := NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	if err := storage.StoreEmail(Incoming, "example.com", "john", "held", []byte("content")); err != nil {
+		t.Fatalf("Failed to store email: %v", err)
+	}
+	emails, err := storage.List(ListFilter{})
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("List() = %d emails, %v; want 1", len(emails), err)
+	}
+	id := emails[0].ID
+
+	if _, err := storage.UpdateMetadata(id, func(m *Metadata) { m.Hold = &Hold{Reason: "case 42"} }); err != nil {
+		t.Fatalf("UpdateMetadata() error = %v", err)
+	}
+	if err := storage.Delete(id); !errors.Is(err, ErrOnHold) {
+		t.Fatalf("Delete() of held email error = %v, want ErrOnHold", err)
+	}
+
+	if _, err := storage.UpdateMetadata(id, func(m *Metadata) { m.Hold = nil }); err != nil {
+		t.Fatalf("UpdateMetadata() error = %v", err)
+	}
+	if err := storage.HoldMailbox("example.com", "john", Hold{Reason: "case 43"}); err != nil {
+		t.Fatalf("HoldMailbox() error = %v", err)
+	}
+	if err := storage.Delete(id); !errors.Is(err, ErrOnHold) {
+		t.Fatalf("Delete() in held mailbox error = %v, want ErrOnHold", err)
+	}
+
+	holds, err := storage.MailboxHolds()
+	if err != nil || len(holds) != 1 || holds[0].Domain != "example.com" || holds[0].User != "john" {
+		t.Fatalf("MailboxHolds() = %+v, %v; want example.com/john", holds, err)
+	}
+
+	if err := storage.ReleaseMailbox("example.com", "john"); err != nil {
+		t.Fatalf("ReleaseMailbox() error = %v", err)
+	}
+	if err := storage.ReleaseMailbox("example.com", "john"); err != ErrNotFound {
+		t.Errorf("second ReleaseMailbox() error = %v, want ErrNotFound", err)
+	}
+	if err := storage.Delete(id); err != nil {
+		t.Errorf("Delete() after release error = %v", err)
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	storage, err := NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	emails := map[string]string{
+		"invoice": "From: billing@shop.example\r\nSubject: Your invoice\r\n\r\nTotal due\r\n",
+		"reset":   "From: auth@app.example\r\nSubject: Password reset\r\n\r\nUse code 4711\r\n",
+	}
+	for subject, content := range emails {
+		if err := storage.StoreEmail(Incoming, "example.com", "john", subject, []byte(content)); err != nil {
+			t.Fatalf("Failed to store email: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"INVOICE", "invoice"},
+		{"code 4711", "reset"},
+		{"auth@app", "reset"},
+		{"missing", ""},
+	}
+
+	for _, tt := range tests {
+		found, err := storage.List(ListFilter{Query: tt.query})
+		if err != nil {
+			t.Fatalf("List(%q) error = %v", tt.query, err)
+		}
+		if tt.want == "" {
+			if len(found) != 0 {
+				t.Errorf("List(%q) = %d emails, want none", tt.query, len(found))
+			}
+			continue
+		}
+		if len(found) != 1 || found[0].Subject != tt.want {
+			t.Errorf("List(%q) = %+v, want %s", tt.query, found, tt.want)
+		}
+	}
+}
+
+func TestParseFaults(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    FaultSettings
+		wantErr bool
+	}{
+		{spec: "", want: FaultSettings{}},
+		{spec: "latency=200ms, jitter=50ms,error_rate=0.1", want: FaultSettings{Latency: 200 * time.Millisecond, Jitter: 50 * time.Millisecond, ErrorRate: 0.1}},
+		{spec: "error_rate=1", want: FaultSettings{ErrorRate: 1}},
+		{spec: "error_rate=2", wantErr: true},
+		{spec: "latency=-1s", wantErr: true},
+		{spec: "latency", wantErr: true},
+		{spec: "timeout=1s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseFaults(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFaults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseFaults() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInjectedFaults(t *testing.T) {
+	storage, err := NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEmailStorage failed: %v", err)
+	}
+	faults, err := NewFaults(FaultSettings{Latency: 20 * time.Millisecond, ErrorRate: 1})
+	if err != nil {
+		t.Fatalf("NewFaults failed: %v", err)
+	}
+	storage.InjectFaults(faults)
+
+	start := time.Now()
+	if _, err := storage.Store(Incoming, "example.com", "john", "hi", []byte("body")); !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("Store() error = %v, want ErrInjectedFault", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Store() took %s, want the injected latency", elapsed)
+	}
+
+	if err := faults.Set(FaultSettings{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if _, err := storage.Store(Incoming, "example.com", "john", "hi", []byte("body")); err != nil {
+		t.Errorf("Store() after clearing faults failed: %v", err)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	storage, err := NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEmailStorage failed: %v", err)
+	}
+	id, err := storage.Store(Incoming, "example.com", "john", "hi", []byte("body"))
+	if err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	stored, err := storage.lookup(id)
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	emailPath := stored.path
+	dir := filepath.Dir(emailPath)
+
+	// Leftovers of a crash: a truncated email, an interrupted metadata
+	// update, metadata of a deleted email and a probe file
+	old := time.Now().Add(-time.Hour)
+	leftovers := map[string]string{
+		filepath.Join(dir, "20240101000000-deadbeef-x.eml.tmp"):    "partial",
+		emailPath + metadataExt + tempExt:                          `{"tags":["kept"]}`,
+		filepath.Join(dir, "20240101000000-cafebabe-y.eml.json"):   `{}`,
+		filepath.Join(storage.Root(), "example.com", ".probe-123"): "probe",
+	}
+	for path, content := range leftovers {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("writing %s failed: %v", path, err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+	recent := filepath.Join(dir, "20240101000000-0badf00d-z.eml.tmp")
+	if err := os.WriteFile(recent, []byte("in progress"), 0644); err != nil {
+		t.Fatalf("writing recent file failed: %v", err)
+	}
+
+	report, err := storage.Recover(10 * time.Minute)
+	if err != nil {
+		t.Fatalf("Recover failed: %v", err)
+	}
+	if len(report.Quarantined) != 2 || report.Completed != 1 || report.Removed != 1 || report.Skipped != 1 {
+		t.Errorf("Recover() = %+v, want 2 quarantined, 1 completed, 1 removed, 1 skipped", report)
+	}
+
+	email, err := storage.Get(id)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !email.Metadata.HasTag("kept") {
+		t.Error("interrupted metadata update was not completed")
+	}
+	if _, err := os.Stat(filepath.Join(storage.QuarantinePath(), "example.com", "john", "IN", "20240101000000-deadbeef-x.eml.tmp")); err != nil {
+		t.Errorf("truncated email was not quarantined: %v", err)
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent file was touched: %v", err)
+	}
+
+	// The quarantine is not scanned again
+	report, err = storage.Recover(10 * time.Minute)
+	if err != nil || len(report.Quarantined) != 0 {
+		t.Errorf("second Recover() = %+v, %v, want nothing quarantined", report, err)
+	}
+}
+
+func TestEmailIDs(t *testing.T) {
+	earlier := newULID(time.UnixMilli(1700000000000))
+	later := newULID(time.UnixMilli(1700000000001))
+	if !isULID(earlier) || !isULID(later) {
+		t.Fatalf("newULID() = %q, %q, want ULIDs", earlier, later)
+	}
+	if earlier[:10] != "01HF7YAT00" || later <= earlier {
+		t.Errorf("newULID() = %q then %q, want a 01HF7YAT00 time prefix sorting in time order", earlier, later)
+	}
+
+	tests := []struct {
+		name   string
+		wantID string
+	}{
+		{"20240101120000-" + earlier + "-from-a@example.com.eml", earlier},
+		{"20240101120000-deadbeef-from-a@example.com.eml", "20240101120000-deadbeef"},
+	}
+	for _, tt := range tests {
+		id, subject, receivedAt, err := parseEmailFilename(tt.name)
+		if err != nil {
+			t.Fatalf("parseEmailFilename(%q) failed: %v", tt.name, err)
+		}
+		if id != tt.wantID || subject != "from-a@example.com" || receivedAt.Hour() != 12 {
+			t.Errorf("parseEmailFilename(%q) = %q, %q, %v, want ID %q", tt.name, id, subject, receivedAt, tt.wantID)
+		}
+	}
+}
+
+func TestListLanguage(t *testing.T) {
+	storage, err := NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	for subject, language := range map[string]string{"rechnung": "de", "invoice": "en", "unknown": ""} {
+		msg := message.Parse(message.Envelope{}, message.Bytes("Subject: "+subject+"\r\n\r\nBody\r\n"))
+		msg.Language = language
+		if _, err := storage.StoreMessage(Incoming, "example.com", "john", subject, msg); err != nil {
+			t.Fatalf("Failed to store email: %v", err)
+		}
+	}
+
+	found, err := storage.List(ListFilter{Language: "DE"})
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(found) != 1 || found[0].Subject != "rechnung" || found[0].Metadata.Language != "de" {
+		t.Errorf("List(language de) = %+v, want rechnung", found)
+	}
+}
+
+func TestListFolder(t *testing.T) {
+	storage, err := NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	for subject, folder := range map[string]string{"offer": "Junk", "invoice": ""} {
+		msg := message.Parse(message.Envelope{}, message.Bytes("Subject: "+subject+"\r\n\r\nBody\r\n"))
+		msg.Folder = folder
+		if _, err := storage.StoreMessage(Incoming, "example.com", "john", subject, msg); err != nil {
+			t.Fatalf("Failed to store email: %v", err)
+		}
+		if _, err := storage.StoreMessage(Outgoing, "example.org", "jane", subject, msg); err != nil {
+			t.Fatalf("Failed to store email: %v", err)
+		}
+	}
+
+	tests := []struct {
+		folder string
+		want   []string
+	}{
+		{"junk", []string{"offer"}},
+		{FolderInbox, []string{"invoice", "invoice", "offer"}}, // Sent copies are never junk
+		{"", []string{"invoice", "invoice", "offer", "offer"}},
+	}
+	for _, tt := range tests {
+		found, err := storage.List(ListFilter{Folder: tt.folder})
+		if err != nil {
+			t.Fatalf("List() error = %v", err)
+		}
+		var subjects []string
+		for _, email := range found {
+			subjects = append(subjects, email.Subject)
+		}
+		sort.Strings(subjects)
+		if !slices.Equal(subjects, tt.want) {
+			t.Errorf("List(folder %q) = %v, want %v", tt.folder, subjects, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"crypto/rand"
+	"time"
+)
+
+// ulidLength is the length of an encoded ULID.
+const ulidLength = 26
+
+// crockford is the base32 alphabet of ULIDs, without I, L, O and U.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID for t: 48 bits of milliseconds followed by 80
+// random bits, encoded in 26 characters that sort in time order.
+func newULID(t time.Time) string {
+	var data [16]byte
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		data[i] = byte(ms)
+		ms >>= 8
+	}
+	rand.Read(data[6:])
+
+	// 128 bits are encoded from the most significant end, the first
+	// character holding only the top 3 bits
+	var out [ulidLength]byte
+	for i := ulidLength - 1; i >= 0; i-- {
+		out[i] = crockford[data[15]&0x1f]
+		shiftRight5(&data)
+	}
+	return string(out[:])
+}
+
+// shiftRight5 shifts a 128-bit big-endian number right by 5 bits.
+func shiftRight5(data *[16]byte) {
+	for i := len(data) - 1; i > 0; i-- {
+		data[i] = data[i]>>5 | data[i-1]<<3
+	}
+	data[0] >>= 5
+}
+
+// isULID reports whether s is an encoded ULID.
+func isULID(s string) bool {
+	if len(s) != ulidLength || s[0] > '7' {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isCrockford(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isCrockford reports whether c belongs to the ULID alphabet.
+func isCrockford(c byte) bool {
+	switch {
+	case c >= '0' && c <= '9':
+		return true
+	case c >= 'A' && c <= 'Z':
+		return c != 'I' && c != 'L' && c != 'O' && c != 'U'
+	}
+	return false
+}
@@ -0,0 +1,274 @@
+// Package update replaces the running binary with the latest GitHub release.
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultRepository is the GitHub repository releases are fetched from.
+	DefaultRepository = "nathabonfim59/gargantua-sink"
+	// ChecksumsAsset is the release asset listing the SHA-256 of every binary.
+	ChecksumsAsset = "checksums.txt"
+	// SignatureAsset is the base64 encoded ed25519 signature of ChecksumsAsset.
+	SignatureAsset = "checksums.txt.sig"
+
+	defaultAPIURL = "https://api.github.com"
+)
+
+// ErrNoAsset is returned when a release has no binary for the current platform.
+var ErrNoAsset = errors.New("release has no binary for this platform")
+
+// Release describes a GitHub release.
+type Release struct {
+	TagName string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset describes a file attached to a GitHub release.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// Updater fetches releases and replaces the binary.
+type Updater struct {
+	APIURL     string            // GitHub API base URL
+	Repository string            // owner/name of the repository
+	PublicKey  ed25519.PublicKey // Optional key required to sign the checksums
+	HTTPClient *http.Client
+}
+
+// NewUpdater creates an updater for the given repository.
+func NewUpdater(repository string) *Updater {
+	return &Updater{
+		APIURL:     defaultAPIURL,
+		Repository: repository,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// ParsePublicKey decodes a base64 encoded ed25519 public key.
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decoding public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, got %d", ed25519.PublicKeySize, len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// AssetName returns the release binary name for a platform.
+func AssetName(goos, goarch string) string {
+	name := fmt.Sprintf("gargantua-sink_%s_%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// Latest returns the most recent published release.
+func (updater *Updater) Latest(ctx context.Context) (*Release, error) {
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimRight(updater.APIURL, "/"), updater.Repository)
+
+	body, err := updater.fetch(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("fetching latest release: %w", err)
+	}
+
+	var release Release
+	if err := json.Unmarshal(body, &release); err != nil {
+		return nil, fmt.Errorf("decoding latest release: %w", err)
+	}
+	return &release, nil
+}
+
+// Apply downloads the release binary for the current platform, verifies it
+// against the release checksums and atomically replaces the file at exePath.
+func (updater *Updater) Apply(ctx context.Context, release *Release, exePath string) error {
+	binary, err := updater.download(ctx, release, AssetName(runtime.GOOS, runtime.GOARCH))
+	if err != nil {
+		return err
+	}
+	if err := updater.verify(ctx, release, binary); err != nil {
+		return err
+	}
+
+	return replaceFile(exePath, binary)
+}
+
+// verify checks the binary against the release checksums and, when a public
+// key is configured, the checksums against their signature.
+func (updater *Updater) verify(ctx context.Context, release *Release, binary []byte) error {
+	checksums, err := updater.download(ctx, release, ChecksumsAsset)
+	if err != nil {
+		return err
+	}
+
+	if updater.PublicKey != nil {
+		signature, err := updater.download(ctx, release, SignatureAsset)
+		if err != nil {
+			return err
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+		if err != nil {
+			return fmt.Errorf("decoding checksums signature: %w", err)
+		}
+		if !ed25519.Verify(updater.PublicKey, checksums, decoded) {
+			return errors.New("checksums signature verification failed")
+		}
+	}
+
+	name := AssetName(runtime.GOOS, runtime.GOARCH)
+	want, err := findChecksum(checksums, name)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(binary)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+	return nil
+}
+
+// download fetches the named asset of a release.
+func (updater *Updater) download(ctx context.Context, release *Release, name string) ([]byte, error) {
+	for _, asset := range release.Assets {
+		if asset.Name == name {
+			body, err := updater.fetch(ctx, asset.URL)
+			if err != nil {
+				return nil, fmt.Errorf("downloading %s: %w", name, err)
+			}
+			return body, nil
+		}
+	}
+
+	if name == AssetName(runtime.GOOS, runtime.GOARCH) {
+		return nil, fmt.Errorf("%w (%s)", ErrNoAsset, name)
+	}
+	return nil, fmt.Errorf("release %s has no %s asset", release.TagName, name)
+}
+
+// fetch performs a GET request and returns the response body.
+func (updater *Updater) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json, application/octet-stream")
+
+	resp, err := updater.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// findChecksum extracts the hex digest for name from a sha256sum formatted file.
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum listed for %s", name)
+}
+
+// replaceFile atomically swaps the file at path with content, keeping its permissions.
+func replaceFile(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("inspecting current binary: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".gargantua-sink-update-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary binary: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temporary binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing temporary binary: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("setting binary permissions: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing binary: %w", err)
+	}
+	return nil
+}
+
+// IsNewer reports whether the release tag is a newer semantic version than current.
+// Development builds are always considered older than any release.
+func IsNewer(tag, current string) bool {
+	latest, ok := parseSemver(tag)
+	if !ok {
+		return false
+	}
+	installed, ok := parseSemver(current)
+	if !ok {
+		return true
+	}
+
+	for i := range latest {
+		if latest[i] != installed[i] {
+			return latest[i] > installed[i]
+		}
+	}
+	return false
+}
+
+// parseSemver parses "v1.2.3" style versions, ignoring pre-release and build suffixes.
+func parseSemver(v string) ([3]int, bool) {
+	var parts [3]int
+
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+
+	fields := strings.Split(v, ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
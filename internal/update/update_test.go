@@ -0,0 +1,168 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// newReleaseServer serves a fake GitHub release containing binary, with the
+// given checksum listed and, when signature is set, a signature asset.
+func newReleaseServer(t *testing.T, binary []byte, checksum string, signature []byte) *httptest.Server {
+	t.Helper()
+
+	name := AssetName(runtime.GOOS, runtime.GOARCH)
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/repos/owner/repo/releases/latest", func(w http.ResponseWriter, r *http.Request) {
+		release := Release{
+			TagName: "v9.9.9",
+			Assets: []Asset{
+				{Name: name, URL: server.URL + "/download/" + name},
+				{Name: ChecksumsAsset, URL: server.URL + "/download/" + ChecksumsAsset},
+			},
+		}
+		if signature != nil {
+			release.Assets = append(release.Assets, Asset{Name: SignatureAsset, URL: server.URL + "/download/" + SignatureAsset})
+		}
+		json.NewEncoder(w).Encode(release)
+	})
+	mux.HandleFunc("/download/"+name, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary)
+	})
+	mux.HandleFunc("/download/"+ChecksumsAsset, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s  %s\n", checksum, name)
+	})
+	mux.HandleFunc("/download/"+SignatureAsset, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(base64.StdEncoding.EncodeToString(signature)))
+	})
+
+	return server
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestApply(t *testing.T) {
+	newBinary := []byte("new binary")
+	exePath := filepath.Join(t.TempDir(), "gargantua-sink")
+	if err := os.WriteFile(exePath, []byte("old binary"), 0755); err != nil {
+		t.Fatalf("writing binary failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		checksum string
+		wantErr  bool
+	}{
+		{name: "checksum_mismatch", checksum: sha256Hex([]byte("tampered")), wantErr: true},
+		{name: "valid_checksum", checksum: sha256Hex(newBinary), wantErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newReleaseServer(t, newBinary, tt.checksum, nil)
+			updater := NewUpdater("owner/repo")
+			updater.APIURL = server.URL
+
+			release, err := updater.Latest(context.Background())
+			if err != nil {
+				t.Fatalf("Latest() error = %v", err)
+			}
+
+			err = updater.Apply(context.Background(), release, exePath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			content, err := os.ReadFile(exePath)
+			if err != nil {
+				t.Fatalf("reading binary failed: %v", err)
+			}
+			if !tt.wantErr && string(content) != string(newBinary) {
+				t.Errorf("binary content = %q, want %q", content, newBinary)
+			}
+			if tt.wantErr && string(content) != "old binary" {
+				t.Error("binary replaced despite failed verification")
+			}
+		})
+	}
+}
+
+func TestApplySignature(t *testing.T) {
+	newBinary := []byte("signed binary")
+	checksum := sha256Hex(newBinary)
+	checksums := []byte(fmt.Sprintf("%s  %s\n", checksum, AssetName(runtime.GOOS, runtime.GOARCH)))
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generating key failed: %v", err)
+	}
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+
+	tests := []struct {
+		name      string
+		signature []byte
+		wantErr   bool
+	}{
+		{name: "valid_signature", signature: ed25519.Sign(privateKey, checksums), wantErr: false},
+		{name: "foreign_signature", signature: ed25519.Sign(otherKey, checksums), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exePath := filepath.Join(t.TempDir(), "gargantua-sink")
+			if err := os.WriteFile(exePath, []byte("old binary"), 0755); err != nil {
+				t.Fatalf("writing binary failed: %v", err)
+			}
+
+			server := newReleaseServer(t, newBinary, checksum, tt.signature)
+			updater := NewUpdater("owner/repo")
+			updater.APIURL = server.URL
+			updater.PublicKey = publicKey
+
+			release, err := updater.Latest(context.Background())
+			if err != nil {
+				t.Fatalf("Latest() error = %v", err)
+			}
+			if err := updater.Apply(context.Background(), release, exePath); (err != nil) != tt.wantErr {
+				t.Errorf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsNewer(t *testing.T) {
+	tests := []struct {
+		tag     string
+		current string
+		want    bool
+	}{
+		{tag: "v0.2.0", current: "0.1.0", want: true},
+		{tag: "v0.1.0", current: "0.1.0", want: false},
+		{tag: "v0.1.10", current: "0.1.9", want: true},
+		{tag: "v1.0.0", current: "dev", want: true},
+		{tag: "v0.1.0", current: "1.0.0", want: false},
+		{tag: "nightly", current: "0.1.0", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := IsNewer(tt.tag, tt.current); got != tt.want {
+			t.Errorf("IsNewer(%q, %q) = %v, want %v", tt.tag, tt.current, got, tt.want)
+		}
+	}
+}
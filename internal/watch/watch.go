@@ -0,0 +1,298 @@
+// Package watch lets short-lived end-to-end tests subscribe to the next
+// email matching some criteria: the email is posted to a callback URL as
+// soon as it is stored, so tests get push semantics without polling the
+// API or keeping a connection open.
+package watch
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+)
+
+// DefaultTTL is how long a watch waits for an email when its request sets
+// no expiry.
+const DefaultTTL = 60 * time.Second
+
+// MaxTTL bounds how long a watch may wait for an email.
+const MaxTTL = time.Hour
+
+// maxWatches bounds the watches waiting at once.
+const maxWatches = 1000
+
+// requestTimeout bounds each callback request.
+const requestTimeout = 10 * time.Second
+
+// ErrTooManyWatches is returned when maxWatches watches are already waiting.
+var ErrTooManyWatches = errors.New("too many active watches")
+
+// Request describes the email a watch waits for and where to post it.
+type Request struct {
+	rules.Match
+	URL string        // Callback receiving an Event
+	TTL time.Duration // DefaultTTL when zero
+}
+
+// Watch is a one-shot subscription: the next email matching it is posted to
+// its callback, after which it is removed. A watch that expires first posts
+// an expired event instead.
+type Watch struct {
+	ID        string      `json:"id"`
+	Match     rules.Match `json:"match"`
+	URL       string      `json:"url"`
+	CreatedAt time.Time   `json:"created_at"`
+	ExpiresAt time.Time   `json:"expires_at"`
+
+	timer *time.Timer
+}
+
+// Event is the JSON payload posted to the callback of a watch.
+type Event struct {
+	Event string `json:"event"` // email or expired
+	Watch string `json:"watch"`
+	Email *Email `json:"email,omitempty"`
+}
+
+// Email describes the email of an email event.
+type Email struct {
+	ID         string    `json:"id"`            // Stored copy, a recipient copy when there is one
+	URL        string    `json:"url,omitempty"` // Link to the copy, when api.public_url is set
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Registry holds the watches waiting for an email.
+type Registry struct {
+	publicURL string
+	client    *http.Client
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	watches map[string]*Watch
+}
+
+// NewRegistry creates an empty registry. Posted emails link to the API at
+// publicURL, unless it is empty.
+func NewRegistry(publicURL string) *Registry {
+	return &Registry{
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		client:    &http.Client{Timeout: requestTimeout},
+		watches:   make(map[string]*Watch),
+	}
+}
+
+// Add registers a watch for req.
+func (registry *Registry) Add(req Request) (Watch, error) {
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return Watch{}, fmt.Errorf("invalid callback URL %q", req.URL)
+	}
+	if err := req.Match.Validate(); err != nil {
+		return Watch{}, fmt.Errorf("invalid match: %w", err)
+	}
+	ttl := req.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < 0 || ttl > MaxTTL {
+		return Watch{}, fmt.Errorf("invalid expiry %s (want at most %s)", ttl, MaxTTL)
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if len(registry.watches) >= maxWatches {
+		return Watch{}, ErrTooManyWatches
+	}
+	now := time.Now()
+	watch := &Watch{
+		ID:        newID(),
+		Match:     req.Match,
+		URL:       req.URL,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	watch.timer = time.AfterFunc(ttl, func() { registry.expire(watch.ID) })
+	registry.watches[watch.ID] = watch
+	return *watch, nil
+}
+
+// List returns the waiting watches, oldest first.
+func (registry *Registry) List() []Watch {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	list := make([]Watch, 0, len(registry.watches))
+	for _, watch := range registry.watches {
+		list = append(list, *watch)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Cancel removes a waiting watch without posting anything. It reports
+// whether the watch was waiting.
+func (registry *Registry) Cancel(id string) bool {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	watch, ok := registry.watches[id]
+	if ok {
+		watch.timer.Stop()
+		delete(registry.watches, id)
+	}
+	return ok
+}
+
+// Middleware returns an ingest middleware posting stored emails to the
+// watches they match. It must be registered at the notify stage.
+func (registry *Registry) Middleware() pipeline.Middleware {
+	return func(next pipeline.Handler) pipeline.Handler {
+		return func(ctx context.Context, delivery *pipeline.Delivery) error {
+			if err := next(ctx, delivery); err != nil {
+				return err
+			}
+			registry.Notify(delivery)
+			return nil
+		}
+	}
+}
+
+// Notify posts a stored email to every watch it matches in the background
+// and removes those watches.
+func (registry *Registry) Notify(delivery *pipeline.Delivery) {
+	msg := delivery.Message
+
+	registry.mu.Lock()
+	var matched []*Watch
+	for id, watch := range registry.watches {
+		if watch.Match.Matches(msg) {
+			watch.timer.Stop()
+			delete(registry.watches, id)
+			matched = append(matched, watch)
+		}
+	}
+	registry.mu.Unlock()
+	if len(matched) == 0 {
+		return
+	}
+
+	email := &Email{
+		ID:         mainCopy(delivery.Stored),
+		From:       msg.Envelope.From,
+		To:         msg.Envelope.To,
+		Subject:    msg.Subject,
+		ReceivedAt: msg.ReceivedAt,
+	}
+	if registry.publicURL != "" && email.ID != "" {
+		email.URL = client.MessageURL(registry.publicURL, email.ID)
+	}
+	for _, watch := range matched {
+		registry.post(watch, Event{Event: "email", Watch: watch.ID, Email: email})
+	}
+}
+
+// mainCopy returns the ID of the first recipient copy, or of the sender
+// copy when no recipient copy was stored.
+func mainCopy(stored []pipeline.StoredCopy) string {
+	for _, item := range stored {
+		if item.Direction == storage.Incoming {
+			return item.ID
+		}
+	}
+	if len(stored) > 0 {
+		return stored[0].ID
+	}
+	return ""
+}
+
+// expire removes a watch whose expiry was reached and tells its callback.
+func (registry *Registry) expire(id string) {
+	registry.mu.Lock()
+	watch, ok := registry.watches[id]
+	delete(registry.watches, id)
+	registry.mu.Unlock()
+
+	if ok {
+		registry.post(watch, Event{Event: "expired", Watch: id})
+	}
+}
+
+// post sends event to the callback of watch in the background.
+func (registry *Registry) post(watch *Watch, event Event) {
+	registry.wg.Add(1)
+	go func() {
+		defer registry.wg.Done()
+		if err := registry.send(watch.URL, event); err != nil {
+			slog.Warn("Watch callback failed", "watch", watch.ID, "event", event.Event, "error", err)
+		}
+	}()
+}
+
+// send posts event to callback.
+func (registry *Registry) send(callback string, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding event: %w", err)
+	}
+
+	resp, err := registry.client.Post(callback, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// The URL may embed a credential, so only the error cause is kept
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("posting event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("posting event: callback replied %s", resp.Status)
+	}
+	return nil
+}
+
+// Wait blocks until the callbacks being posted are sent or ctx expires.
+// Watches still waiting are left alone.
+func (registry *Registry) Wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		registry.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newID returns a random watch ID.
+func newID() string {
+	var data [8]byte
+	rand.Read(data[:])
+	return hex.EncodeToString(data[:])
+}
@@ -0,0 +1,131 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/rules"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// callback starts a server receiving watch events.
+func callback(t *testing.T) (string, chan Event) {
+	t.Helper()
+
+	events := make(chan Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decoding event failed: %v", err)
+		}
+		events <- event
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, events
+}
+
+// delivery returns a stored delivery of an email to recipient.
+func delivery(recipient, subject string) *pipeline.Delivery {
+	return &pipeline.Delivery{
+		Message: &message.Message{
+			Envelope: message.Envelope{From: "app@example.org", To: []string{recipient}},
+			Subject:  subject,
+		},
+		Stored: []pipeline.StoredCopy{
+			{ID: "sent", Direction: storage.Outgoing},
+			{ID: "received", Direction: storage.Incoming},
+		},
+	}
+}
+
+func TestNotify(t *testing.T) {
+	url, events := callback(t)
+	registry := NewRegistry("http://sink:8080/")
+
+	watch, err := registry.Add(Request{Match: rules.Match{To: "john@*"}, URL: url})
+	if err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	if got := watch.ExpiresAt.Sub(watch.CreatedAt); got != DefaultTTL {
+		t.Errorf("expiry = %s, want %s", got, DefaultTTL)
+	}
+
+	registry.Notify(delivery("jane@example.com", "Not for john"))
+	registry.Notify(delivery("john@example.com", "Welcome"))
+	registry.Notify(delivery("john@example.com", "Second"))
+	if err := registry.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() failed: %v", err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("posted %d events, want 1", len(events))
+	}
+	event := <-events
+	if event.Event != "email" || event.Watch != watch.ID || event.Email == nil {
+		t.Fatalf("event = %+v, want the email of watch %s", event, watch.ID)
+	}
+	if event.Email.Subject != "Welcome" || event.Email.ID != "received" || event.Email.URL != "http://sink:8080/m/received" {
+		t.Errorf("email = %+v, want the recipient copy of Welcome", event.Email)
+	}
+	if len(registry.List()) != 0 {
+		t.Errorf("List() = %+v, want the watch removed", registry.List())
+	}
+}
+
+func TestExpire(t *testing.T) {
+	url, events := callback(t)
+	registry := NewRegistry("")
+
+	watch, err := registry.Add(Request{URL: url, TTL: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	select {
+	case event := <-events:
+		if event.Event != "expired" || event.Watch != watch.ID || event.Email != nil {
+			t.Errorf("event = %+v, want watch %s expired", event, watch.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no expired event")
+	}
+
+	canceled, err := registry.Add(Request{URL: url, TTL: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Add() failed: %v", err)
+	}
+	if !registry.Cancel(canceled.ID) || registry.Cancel(canceled.ID) {
+		t.Error("Cancel() did not remove the watch exactly once")
+	}
+	select {
+	case event := <-events:
+		t.Errorf("canceled watch posted %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAddInvalid(t *testing.T) {
+	registry := NewRegistry("")
+
+	tests := []struct {
+		name string
+		req  Request
+	}{
+		{"missing_url", Request{}},
+		{"not_http", Request{URL: "ftp://example.com/hook"}},
+		{"bad_glob", Request{URL: "http://example.com/hook", Match: rules.Match{To: "[john"}}},
+		{"expiry_too_long", Request{URL: "http://example.com/hook", TTL: 2 * time.Hour}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := registry.Add(tt.req); err == nil {
+				t.Error("Add() succeeded, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Package worker runs background jobs, such as webhook posts, on a fixed
+// number of goroutines fed by a bounded queue, so a slow endpoint under load
+// drops work instead of piling up goroutines and sockets.
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Pool runs the submitted jobs on its workers. It is safe for concurrent use.
+type Pool struct {
+	jobs    chan func()
+	pending sync.WaitGroup // Jobs queued or running
+	dropped atomic.Int64
+}
+
+// NewPool starts workers goroutines running the jobs of a queue holding up
+// to queueSize jobs waiting for a worker.
+func NewPool(workers, queueSize int) *Pool {
+	pool := &Pool{jobs: make(chan func(), queueSize)}
+	for range workers {
+		go pool.work()
+	}
+	return pool
+}
+
+// work runs queued jobs.
+func (pool *Pool) work() {
+	for job := range pool.jobs {
+		job()
+		pool.pending.Done()
+	}
+}
+
+// Submit queues job, or drops it and reports false when the queue is full.
+func (pool *Pool) Submit(job func()) bool {
+	pool.pending.Add(1)
+	select {
+	case pool.jobs <- job:
+		return true
+	default:
+		pool.pending.Done()
+		pool.dropped.Add(1)
+		return false
+	}
+}
+
+// Dropped returns the number of jobs dropped because the queue was full.
+func (pool *Pool) Dropped() int64 {
+	return pool.dropped.Load()
+}
+
+// Wait blocks until the queued and running jobs are done or ctx expires.
+func (pool *Pool) Wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		pool.pending.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
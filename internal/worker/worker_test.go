@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	pool := NewPool(1, 2)
+	release := make(chan struct{})
+	var ran atomic.Int64
+	job := func() {
+		<-release
+		ran.Add(1)
+	}
+
+	// One job runs, two wait in the queue, the others are dropped
+	if !pool.Submit(job) {
+		t.Fatal("first Submit dropped the job")
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		pool.Submit(job)
+	}
+	if got := pool.Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pool.Wait(ctx); err == nil {
+		t.Error("Wait returned while jobs were blocked")
+	}
+
+	close(release)
+	if err := pool.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	if got := ran.Load(); got != 3 {
+		t.Errorf("ran %d jobs, want 3", got)
+	}
+}
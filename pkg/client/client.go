@@ -0,0 +1,391 @@
+// Package client is a Go client for the Gargantua Sink HTTP API, meant for
+// test suites that assert on the emails sent by the application under test.
+//
+//	sink := client.New("http://localhost:8080")
+//	msg, err := sink.WaitFor(ctx, client.ListOptions{User: "john"}, nil)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPollInterval is the delay between listings in WaitFor.
+const DefaultPollInterval = 250 * time.Millisecond
+
+// ErrNotFound is matched by errors for emails that do not exist.
+var ErrNotFound = errors.New("not found")
+
+// Error is a non-successful API response.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("gargantua api: %d %s", err.StatusCode, err.Message)
+}
+
+// Is reports 404 responses as ErrNotFound.
+func (err *Error) Is(target error) bool {
+	return target == ErrNotFound && err.StatusCode == http.StatusNotFound
+}
+
+// Message describes a stored email.
+type Message struct {
+	ID         string    `json:"id"`
+	Domain     string    `json:"domain"`
+	User       string    `json:"user"`
+	Direction  string    `json:"direction"` // IN or OUT
+	Subject    string    `json:"subject"`
+	Size       int64     `json:"size"`
+	ReceivedAt time.Time `json:"received_at"`
+	Metadata   Metadata  `json:"metadata"`
+	Held       bool      `json:"held,omitempty"` // On legal hold, itself or its mailbox; not deletable
+}
+
+// Metadata holds the tags and check verdicts of a stored email.
+type Metadata struct {
+	Tags     []string  `json:"tags,omitempty"`
+	Verdicts []Verdict `json:"verdicts,omitempty"`
+	Folder   string    `json:"folder,omitempty"` // e.g. Junk; empty for the inbox
+}
+
+// Verdict is the outcome of a check run on an email.
+type Verdict struct {
+	Check  string `json:"check"`
+	Result string `json:"result"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Parsed is a stored email with its parsed headers and parts.
+type Parsed struct {
+	Message
+	Header     map[string][]string `json:"header"`
+	Parts      []Part              `json:"parts"`
+	ParseError string              `json:"parse_error,omitempty"`
+}
+
+// Part describes a body part. Its content is available from Raw.
+type Part struct {
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename,omitempty"`
+	Inline      bool   `json:"inline"`
+	Size        int64  `json:"size"`
+}
+
+// ListOptions filters ListMessages. Zero values match everything.
+type ListOptions struct {
+	Domain    string
+	User      string
+	Direction string // IN or OUT
+	Tag       string
+	Language  string // ISO 639-1 code of the body language, e.g. de
+	Folder    string // Inbox or Junk
+	Query     string // Text searched in the subject, addresses and body
+	Limit     int
+}
+
+// BatchResult is the outcome of a batch action for one email.
+type BatchResult struct {
+	ID    string `json:"id"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// RetentionReport describes what the retention policy of the sink would
+// delete.
+type RetentionReport struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Messages    int               `json:"messages"`
+	Bytes       int64             `json:"bytes"`
+	Domains     []RetentionDomain `json:"domains"`
+}
+
+// RetentionDomain is the part of a RetentionReport about one domain.
+type RetentionDomain struct {
+	Domain   string     `json:"domain"`
+	MaxAge   string     `json:"max_age,omitempty"` // Empty when kept forever
+	Messages int        `json:"messages"`
+	Bytes    int64      `json:"bytes"`
+	Oldest   *time.Time `json:"oldest,omitempty"`
+	Newest   *time.Time `json:"newest,omitempty"`
+	Held     int        `json:"held"` // Expired emails kept because of a hold or pending approval
+	Kept     int        `json:"kept"` // Emails younger than the max age
+}
+
+// LatencyReport describes how long the emails of a test run took from the
+// end of DATA to being listed by the API, in milliseconds. Only emails
+// received with smtp.timeline on are measured.
+type LatencyReport struct {
+	Messages        int            `json:"messages"`
+	WithoutTimeline int            `json:"without_timeline"`
+	P50             float64        `json:"p50_ms"`
+	P95             float64        `json:"p95_ms"`
+	Max             float64        `json:"max_ms"`
+	Entries         []LatencyEntry `json:"entries"`
+}
+
+// LatencyEntry is the latency of the copy of one recipient.
+type LatencyEntry struct {
+	ID         string    `json:"id"`
+	Recipient  string    `json:"recipient"`
+	ReceivedAt time.Time `json:"received_at"`
+	StoredAt   time.Time `json:"stored_at"`
+	Latency    float64   `json:"latency_ms"`
+}
+
+// Client calls the API of one Gargantua Sink server.
+type Client struct {
+	baseURL string
+
+	// HTTPClient sends the requests; http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Token is sent as a bearer token when the API requires authentication
+	Token string
+	// PollInterval is the delay between listings in WaitFor
+	PollInterval time.Duration
+}
+
+// New creates a client for the API at baseURL, e.g. http://localhost:8080.
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// MessageURL returns the canonical link to an email on the server at
+// baseURL, e.g. http://localhost:8080/m/01HF7YAT00ABCDEFGHJKMNPQRS. It stays
+// valid as long as the email is kept.
+func MessageURL(baseURL, id string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/m/" + url.PathEscape(id)
+}
+
+// MessageURL returns the canonical link to an email on the server.
+func (client *Client) MessageURL(id string) string {
+	return MessageURL(client.baseURL, id)
+}
+
+// ListMessages returns the stored emails matching opts, newest first.
+func (client *Client) ListMessages(ctx context.Context, opts ListOptions) ([]Message, error) {
+	query := opts.filter()
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	var list struct {
+		Messages []Message `json:"messages"`
+	}
+	if err := client.do(ctx, http.MethodGet, "/api/v1/messages?"+query.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Messages, nil
+}
+
+// filter returns the query parameters selecting the emails of opts.
+func (opts ListOptions) filter() url.Values {
+	query := url.Values{}
+	for key, value := range map[string]string{
+		"domain":    opts.Domain,
+		"user":      opts.User,
+		"direction": opts.Direction,
+		"tag":       opts.Tag,
+		"language":  opts.Language,
+		"folder":    opts.Folder,
+		"q":         opts.Query,
+	} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	return query
+}
+
+// GetParsed returns a stored email with its parsed headers and parts.
+func (client *Client) GetParsed(ctx context.Context, id string) (*Parsed, error) {
+	var detail struct {
+		Message
+		Parsed *struct {
+			Header     map[string][]string `json:"header"`
+			Parts      []Part              `json:"parts"`
+			ParseError string              `json:"parse_error,omitempty"`
+		} `json:"message"`
+	}
+	if err := client.do(ctx, http.MethodGet, "/api/v1/messages/"+url.PathEscape(id), nil, &detail); err != nil {
+		return nil, err
+	}
+
+	parsed := &Parsed{Message: detail.Message}
+	if detail.Parsed != nil {
+		parsed.Header = detail.Parsed.Header
+		parsed.Parts = detail.Parsed.Parts
+		parsed.ParseError = detail.Parsed.ParseError
+	}
+	return parsed, nil
+}
+
+// Raw returns the raw content of a stored email.
+func (client *Client) Raw(ctx context.Context, id string) ([]byte, error) {
+	resp, err := client.send(ctx, http.MethodGet, "/api/v1/messages/"+url.PathEscape(id)+"/raw", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// WaitFor polls until an email matching opts and match is stored, or ctx
+// is done. A nil match accepts any email matching opts.
+func (client *Client) WaitFor(ctx context.Context, opts ListOptions, match func(Message) bool) (Message, error) {
+	interval := client.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		messages, err := client.ListMessages(ctx, opts)
+		if err != nil {
+			return Message{}, err
+		}
+		for _, msg := range messages {
+			if match == nil || match(msg) {
+				return msg, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return Message{}, fmt.Errorf("waiting for email: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Delete removes a stored email.
+func (client *Client) Delete(ctx context.Context, id string) error {
+	return client.do(ctx, http.MethodDelete, "/api/v1/messages/"+url.PathEscape(id), nil, nil)
+}
+
+// Release relays emails through the forwarding server configured on the
+// sink, to their original recipients or to when set.
+func (client *Client) Release(ctx context.Context, ids []string, to ...string) ([]BatchResult, error) {
+	request := struct {
+		IDs []string `json:"ids"`
+		To  []string `json:"to,omitempty"`
+	}{IDs: ids, To: to}
+
+	var response struct {
+		Results []BatchResult `json:"results"`
+	}
+	if err := client.do(ctx, http.MethodPost, "/api/v1/messages/batch/release", request, &response); err != nil {
+		return nil, err
+	}
+	return response.Results, nil
+}
+
+// Retention reports what the retention policy of the sink would delete,
+// without deleting anything. A non-zero maxAge is tried for every domain
+// instead of the configured policy.
+func (client *Client) Retention(ctx context.Context, maxAge time.Duration) (RetentionReport, error) {
+	path := "/api/v1/retention"
+	if maxAge > 0 {
+		path += "?max_age=" + url.QueryEscape(maxAge.String())
+	}
+
+	var report RetentionReport
+	if err := client.do(ctx, http.MethodGet, path, nil, &report); err != nil {
+		return RetentionReport{}, err
+	}
+	return report, nil
+}
+
+// Latency reports the delivery latency of the emails matching opts that
+// were received since since, e.g. the start of a test run; a zero since
+// selects every email. Incoming copies are measured unless opts sets a
+// direction, and opts.Limit is ignored.
+func (client *Client) Latency(ctx context.Context, opts ListOptions, since time.Time) (LatencyReport, error) {
+	query := opts.filter()
+	if !since.IsZero() {
+		query.Set("since", since.Format(time.RFC3339Nano))
+	}
+
+	var report LatencyReport
+	if err := client.do(ctx, http.MethodGet, "/api/v1/latency?"+query.Encode(), nil, &report); err != nil {
+		return LatencyReport{}, err
+	}
+	return report, nil
+}
+
+// do sends a JSON request and decodes the JSON response into out, if set.
+func (client *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	resp, err := client.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// send performs a request, turning error responses into *Error.
+func (client *Client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, client.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if client.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+client.Token)
+	}
+
+	httpClient := client.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	apiErr := &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&payload) == nil && payload.Error != "" {
+		apiErr.Message = payload.Error
+	}
+	return nil, apiErr
+}
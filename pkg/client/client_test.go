@@ -0,0 +1,131 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathabonfim59/gargantua-sink/internal/api"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+	"github.com/nathabonfim59/gargantua-sink/pkg/client"
+)
+
+// fakeRelay records relayed emails.
+type fakeRelay struct {
+	to []string
+}
+
+func (relay *fakeRelay) Relay(from string, to []string, content []byte) error {
+	relay.to = to
+	return nil
+}
+
+func newTestClient(t *testing.T) (*client.Client, *storage.EmailStorage, *fakeRelay) {
+	t.Helper()
+
+	emailStorage, err := storage.NewEmailStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+
+	relay := &fakeRelay{}
+	server := api.NewServer("", api.Options{
+		Storages: func() []*storage.EmailStorage { return []*storage.EmailStorage{emailStorage} },
+		Relay:    relay,
+	})
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+
+	apiClient := client.New(httpServer.URL + "/")
+	apiClient.PollInterval = 10 * time.Millisecond
+	return apiClient, emailStorage, relay
+}
+
+func TestClient(t *testing.T) {
+	apiClient, emailStorage, relay := newTestClient(t)
+	ctx := context.Background()
+
+	content := "From: app@example.com\r\nTo: john@example.com\r\nSubject: Welcome\r\n\r\nHello John\r\n"
+	if err := emailStorage.StoreEmail(storage.Incoming, "example.com", "john", "welcome", []byte(content)); err != nil {
+		t.Fatalf("storing email failed: %v", err)
+	}
+
+	messages, err := apiClient.ListMessages(ctx, client.ListOptions{User: "john", Direction: "IN"})
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("ListMessages() = %v, %v; want one email", messages, err)
+	}
+	id := messages[0].ID
+
+	if found, err := apiClient.ListMessages(ctx, client.ListOptions{Query: "hello john"}); err != nil || len(found) != 1 {
+		t.Errorf("ListMessages(query) = %v, %v; want the welcome email", found, err)
+	}
+
+	parsed, err := apiClient.GetParsed(ctx, id)
+	if err != nil {
+		t.Fatalf("GetParsed() failed: %v", err)
+	}
+	if parsed.Header["Subject"][0] != "Welcome" || len(parsed.Parts) != 1 {
+		t.Errorf("GetParsed() = %+v, want the parsed email", parsed)
+	}
+
+	raw, err := apiClient.Raw(ctx, id)
+	if err != nil || !strings.Contains(string(raw), "Hello John") {
+		t.Errorf("Raw() = %q, %v; want the raw content", raw, err)
+	}
+
+	results, err := apiClient.Release(ctx, []string{id}, "qa@example.com")
+	if err != nil || len(results) != 1 || !results[0].OK {
+		t.Fatalf("Release() = %+v, %v; want one released email", results, err)
+	}
+	if len(relay.to) != 1 || relay.to[0] != "qa@example.com" {
+		t.Errorf("relayed to %v, want [qa@example.com]", relay.to)
+	}
+
+	report, err := apiClient.Retention(ctx, time.Nanosecond)
+	if err != nil || report.Messages != 1 || len(report.Domains) != 1 || report.Domains[0].Domain != "example.com" {
+		t.Errorf("Retention() = %+v, %v; want the email expired", report, err)
+	}
+
+	latency, err := apiClient.Latency(ctx, client.ListOptions{Domain: "example.com"}, time.Time{})
+	if err != nil || latency.Messages != 0 || latency.WithoutTimeline != 1 {
+		t.Errorf("Latency() = %+v, %v; want the email without timeline", latency, err)
+	}
+
+	if err := apiClient.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete() failed: %v", err)
+	}
+	if _, err := apiClient.GetParsed(ctx, id); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetParsed() after delete error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	apiClient, emailStorage, _ := newTestClient(t)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		emailStorage.StoreEmail(storage.Incoming, "example.com", "jane", "reset", []byte("Subject: Reset\r\n\r\nLink\r\n"))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg, err := apiClient.WaitFor(ctx, client.ListOptions{User: "jane"}, func(msg client.Message) bool {
+		return msg.Subject == "reset"
+	})
+	if err != nil {
+		t.Fatalf("WaitFor() failed: %v", err)
+	}
+	if msg.User != "jane" {
+		t.Errorf("WaitFor() = %+v, want jane's email", msg)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := apiClient.WaitFor(ctx, client.ListOptions{User: "nobody"}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitFor() without match error = %v, want deadline exceeded", err)
+	}
+}
@@ -0,0 +1,80 @@
+// Package sink embeds the Gargantua Sink SMTP server in another program, such
+// as an integration test harness, and extends its ingest pipeline with
+// middlewares.
+//
+//	store, err := sink.NewStorage(t.TempDir())
+//	server := sink.NewServer(sink.DefaultConfig(), store)
+//	server.Use(sink.StageFilter, func(next sink.Handler) sink.Handler {
+//		return func(ctx context.Context, delivery *sink.Delivery) error {
+//			if delivery.Message.Subject == "" {
+//				return errors.New("subject required")
+//			}
+//			return next(ctx, delivery)
+//		}
+//	})
+//	go server.Serve(listener)
+package sink
+
+import (
+	"github.com/nathabonfim59/gargantua-sink/internal/config"
+	"github.com/nathabonfim59/gargantua-sink/internal/message"
+	"github.com/nathabonfim59/gargantua-sink/internal/pipeline"
+	"github.com/nathabonfim59/gargantua-sink/internal/smtp"
+	"github.com/nathabonfim59/gargantua-sink/internal/storage"
+)
+
+// Server is the SMTP server. Use registers middlewares; Serve, Start,
+// Shutdown and Stop control it.
+type Server = smtp.Server
+
+// Config holds the SMTP listener settings, as in the smtp section of the
+// configuration file.
+type Config = config.SMTPConfig
+
+// Storage stores emails in the domain/user/IN|OUT directory layout.
+type Storage = storage.EmailStorage
+
+// ListFilter selects the emails returned by Storage.List.
+type ListFilter = storage.ListFilter
+
+// Pipeline types, see Server.Use.
+type (
+	Stage      = pipeline.Stage
+	Delivery   = pipeline.Delivery
+	StoredCopy = pipeline.StoredCopy
+	Handler    = pipeline.Handler
+	Middleware = pipeline.Middleware
+)
+
+// Message types carried by a Delivery.
+type (
+	Message  = message.Message
+	Envelope = message.Envelope
+	Verdict  = message.Verdict
+	Metadata = storage.Metadata
+)
+
+// Stages in execution order.
+const (
+	StageAuth   = pipeline.StageAuth
+	StageFilter = pipeline.StageFilter
+	StageEnrich = pipeline.StageEnrich
+	StageStore  = pipeline.StageStore
+	StageNotify = pipeline.StageNotify
+)
+
+// DefaultConfig returns the default SMTP settings.
+func DefaultConfig() Config {
+	return config.Default().SMTP
+}
+
+// NewStorage creates a storage rooted at path.
+func NewStorage(path string) (*Storage, error) {
+	return storage.NewEmailStorage(path)
+}
+
+// NewServer creates a server storing emails in store. Every domain is
+// accepted unless restricted with AddDomain.
+func NewServer(cfg Config, store *Storage) *Server {
+	return smtp.NewServerFromConfig(cfg, store)
+}
@@ -0,0 +1,74 @@
+package sink_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	"github.com/nathabonfim59/gargantua-sink/pkg/sink"
+)
+
+func TestEmbeddedServer(t *testing.T) {
+	store, err := sink.NewStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("creating storage failed: %v", err)
+	}
+	server := sink.NewServer(sink.DefaultConfig(), store)
+	server.Use(sink.StageFilter, func(next sink.Handler) sink.Handler {
+		return func(ctx context.Context, delivery *sink.Delivery) error {
+			if strings.Contains(delivery.Message.Subject, "spam") {
+				return &smtp.SMTPError{Code: 554, EnhancedCode: smtp.EnhancedCode{5, 7, 1}, Message: "Rejected by test filter"}
+			}
+			return next(ctx, delivery)
+		}
+	})
+	server.Use(sink.StageEnrich, func(next sink.Handler) sink.Handler {
+		return func(ctx context.Context, delivery *sink.Delivery) error {
+			delivery.Message.Tags = append(delivery.Message.Tags, "embedded")
+			return next(ctx, delivery)
+		}
+	})
+	var stored []sink.StoredCopy
+	server.Use(sink.StageNotify, func(next sink.Handler) sink.Handler {
+		return func(ctx context.Context, delivery *sink.Delivery) error {
+			err := next(ctx, delivery)
+			stored = append(stored, delivery.Stored...)
+			return err
+		}
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening failed: %v", err)
+	}
+	go server.Serve(listener)
+	defer server.Stop()
+
+	send := func(subject string) error {
+		client, err := smtp.Dial(listener.Addr().String())
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		return client.SendMail("app@example.com", []string{"john@example.org"}, strings.NewReader("Subject: "+subject+"\r\n\r\nhi\r\n"))
+	}
+	if err := send("buy spam"); err == nil || !strings.Contains(err.Error(), "554") {
+		t.Errorf("sending filtered email error = %v, want 554", err)
+	}
+	if err := send("hello"); err != nil {
+		t.Fatalf("sending email failed: %v", err)
+	}
+
+	if len(stored) != 2 {
+		t.Fatalf("stored copies = %d, want the IN and OUT copies", len(stored))
+	}
+	emails, err := store.List(sink.ListFilter{Domain: "example.org"})
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("listing emails = %d, %v; want one", len(emails), err)
+	}
+	if tags := emails[0].Metadata.Tags; len(tags) != 1 || tags[0] != "embedded" {
+		t.Errorf("tags = %v, want the enrich middleware tag", tags)
+	}
+}